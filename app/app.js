@@ -1,4 +1,20 @@
 document.addEventListener('DOMContentLoaded', async () => {
+  // OAuth logins redirect back with the tokens in the URL fragment
+  const fragment = new URLSearchParams(window.location.hash.slice(1));
+  if (fragment.get('token')) {
+    localStorage.setItem('token', fragment.get('token'));
+    history.replaceState(null, '', window.location.pathname);
+  }
+  if (fragment.get('mfa_token')) {
+    history.replaceState(null, '', window.location.pathname);
+    await completeSecondFactor(fragment.get('mfa_token'));
+    return;
+  }
+  if (fragment.get('reset_token')) {
+    history.replaceState(null, '', window.location.pathname);
+    await resetPassword(fragment.get('reset_token'));
+  }
+
   const token = localStorage.getItem('token');
 
   if (token) {
@@ -8,9 +24,76 @@ document.addEventListener('DOMContentLoaded', async () => {
   } else {
     document.getElementById('auth-section').style.display = 'block';
     document.getElementById('video-section').style.display = 'none';
+    await getOAuthProviders();
+    await getCaptchaConfig();
   }
 });
 
+let captchaProvider = '';
+
+async function getCaptchaConfig() {
+  try {
+    const res = await fetch('/api/captcha');
+    if (!res.ok) {
+      return;
+    }
+    const config = await res.json();
+    if (!config.provider) {
+      return;
+    }
+    captchaProvider = config.provider;
+
+    const widget = document.getElementById('captcha');
+    widget.className = captchaProvider === 'turnstile' ? 'cf-turnstile' : 'h-captcha';
+    widget.dataset.sitekey = config.site_key;
+
+    const script = document.createElement('script');
+    script.src =
+      captchaProvider === 'turnstile'
+        ? 'https://challenges.cloudflare.com/turnstile/v0/api.js'
+        : 'https://js.hcaptcha.com/1/api.js';
+    script.async = true;
+    document.head.appendChild(script);
+  } catch (error) {
+    console.error(error);
+  }
+}
+
+// captchaToken returns the response of the rendered widget, if any
+function captchaToken() {
+  const name = captchaProvider === 'turnstile' ? 'cf-turnstile-response' : 'h-captcha-response';
+  const input = document.querySelector(`#captcha [name="${name}"]`);
+  return input ? input.value : '';
+}
+
+function resetCaptcha() {
+  if (captchaProvider === 'turnstile' && window.turnstile) {
+    window.turnstile.reset();
+  } else if (captchaProvider === 'hcaptcha' && window.hcaptcha) {
+    window.hcaptcha.reset();
+  }
+}
+
+async function getOAuthProviders() {
+  try {
+    const res = await fetch('/api/oauth/providers');
+    if (!res.ok) {
+      return;
+    }
+    const providers = await res.json();
+    const container = document.getElementById('oauth-providers');
+    container.innerHTML = '';
+    for (const provider of providers) {
+      const link = document.createElement('a');
+      link.href = `/api/oauth/${encodeURIComponent(provider)}/login`;
+      link.textContent = `Login with ${provider}`;
+      container.appendChild(link);
+    }
+  } catch (error) {
+    console.error(error);
+  }
+}
+
 document.getElementById('video-draft-form').addEventListener('submit', async (event) => {
   event.preventDefault();
   await createVideoDraft();
@@ -59,27 +142,113 @@ async function login() {
       headers: {
         'Content-Type': 'application/json',
       },
-      body: JSON.stringify({ email, password }),
+      body: JSON.stringify({ email, password, captcha_token: captchaToken() }),
     });
     const data = await res.json();
+    resetCaptcha();
+    if (data.captcha_required) {
+      throw new Error('Please complete the captcha and try again.');
+    }
+    if (data.two_factor_required) {
+      await completeSecondFactor(data.mfa_token);
+      return;
+    }
     if (!res.ok) {
       throw new Error(`Failed to login: ${data.error}`);
     }
 
-    if (data.token) {
-      localStorage.setItem('token', data.token);
-      document.getElementById('auth-section').style.display = 'none';
-      document.getElementById('video-section').style.display = 'block';
-      await getVideos();
-    } else {
-      alert('Login failed. Please check your credentials.');
+    await finishLogin(data);
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+async function completeSecondFactor(mfaToken) {
+  const code = prompt('Enter the code from your authenticator app or a recovery code');
+  if (!code) {
+    return;
+  }
+
+  try {
+    const res = await fetch('/api/login/2fa', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ mfa_token: mfaToken, code }),
+    });
+    const data = await res.json();
+    if (!res.ok) {
+      throw new Error(`Failed to login: ${data.error}`);
     }
+
+    await finishLogin(data);
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
 }
 
-async function signup() {
+async function finishLogin(data) {
+  if (data.token) {
+    localStorage.setItem('token', data.token);
+    document.getElementById('auth-section').style.display = 'none';
+    document.getElementById('video-section').style.display = 'block';
+    await getVideos();
+  } else {
+    alert('Login failed. Please check your credentials.');
+  }
+}
+
+async function forgotPassword() {
+  const email = document.getElementById('email').value;
+  if (!email) {
+    alert('Enter your email address first.');
+    return;
+  }
+
+  try {
+    const res = await fetch('/api/password_reset', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ email }),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(`Failed to request reset: ${data.error}`);
+    }
+    alert('If that account exists, a reset link is on its way.');
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+async function resetPassword(token) {
+  const password = prompt('Choose a new password');
+  if (!password) {
+    return;
+  }
+
+  try {
+    const res = await fetch('/api/password_reset/confirm', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ token, password }),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(`Failed to reset password: ${data.error}`);
+    }
+    alert('Password updated. You can now log in.');
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+async function signup(inviteCode) {
   const email = document.getElementById('email').value;
   const password = document.getElementById('password').value;
 
@@ -89,12 +258,26 @@ async function signup() {
       headers: {
         'Content-Type': 'application/json',
       },
-      body: JSON.stringify({ email, password }),
+      body: JSON.stringify({
+        email,
+        password,
+        captcha_token: captchaToken(),
+        invite_code: inviteCode,
+      }),
     });
     if (!res.ok) {
       const data = await res.json();
+      if (data.invite_required && !inviteCode) {
+        const code = prompt('Signup is invite-only. Enter your invite code');
+        if (code) {
+          await signup(code);
+        }
+        return;
+      }
+      resetCaptcha();
       throw new Error(`Failed to create user: ${data.error}`);
     }
+    resetCaptcha();
     console.log('User created!');
     await login();
   } catch (error) {
@@ -188,18 +371,26 @@ const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
   try {
-    const res = await fetch('/api/videos', {
-      method: 'GET',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
-    });
-    if (!res.ok) {
-      const data = await res.json();
-      throw new Error(`Failed to get videos. Error: ${data.error}`);
-    }
+    const videos = [];
+    let cursor = null;
+    do {
+      const query = cursor ? `?cursor=${encodeURIComponent(cursor)}` : '';
+      const res = await fetch(`/api/videos${query}`, {
+        method: 'GET',
+        headers: {
+          Authorization: `Bearer ${localStorage.getItem('token')}`,
+        },
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to get videos. Error: ${data.error}`);
+      }
+
+      const page = await res.json();
+      videos.push(...page.videos);
+      cursor = page.next_cursor;
+    } while (cursor);
 
-    const videos = await res.json();
     const videoList = document.getElementById('video-list');
     videoList.innerHTML = '';
     for (const video of videos) {
@@ -261,6 +452,7 @@ function viewVideo(video) {
     thumbnailImg.style.display = 'none';
   } else {
     thumbnailImg.style.display = 'block';
+    thumbnailImg.style.backgroundColor = video.thumbnail_color || '';
     thumbnailImg.src = video.thumbnail_url;
   }
 
@@ -270,9 +462,45 @@ function viewVideo(video) {
       videoPlayer.style.display = 'none';
     } else {
       videoPlayer.style.display = 'block';
-      videoPlayer.src = video.video_url;
-      videoPlayer.load();
+      loadPlayback(video.id, videoPlayer);
+    }
+  }
+}
+
+// loadPlayback points the player at a presigned URL obtained with a
+// short-lived playback token, so the access token never reaches it.
+async function loadPlayback(videoID, videoPlayer) {
+  try {
+    const tokenRes = await fetch(`/api/videos/${videoID}/playback_token`, {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+    });
+    if (!tokenRes.ok) {
+      throw new Error('Failed to get playback token.');
     }
+    const { token } = await tokenRes.json();
+
+    const urlRes = await fetch(`/api/playback/${videoID}`, {
+      headers: { 'X-Playback-Token': token },
+    });
+    if (!urlRes.ok) {
+      const data = await urlRes.json().catch(() => ({}));
+      if (data.status === 'restoring') {
+        const eta = data.eta ? ` (expected by ${new Date(data.eta).toLocaleString()})` : '';
+        throw new Error(`This video is being restored from the archive${eta}.`);
+      }
+      if (data.status === 'archived') {
+        throw new Error('This video is archived and must be restored before it can be played.');
+      }
+      throw new Error('Failed to get playback URL.');
+    }
+    const { url } = await urlRes.json();
+    videoPlayer.src = url;
+    videoPlayer.load();
+  } catch (error) {
+    alert(`Error: ${error.message}`);
   }
 }
 
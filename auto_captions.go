@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcribe"
+	"github.com/google/uuid"
+)
+
+const (
+	// autoCaptionBatch caps how many caption jobs are run per pass.
+	// Speech recognition is slow, so jobs run one at a time.
+	autoCaptionBatch = 10
+	// autoCaptionMaxAttempts is how many times a caption job is tried
+	// before it is given up on
+	autoCaptionMaxAttempts = 3
+)
+
+// autoCaptionPolicy configures generating draft captions for uploaded
+// videos. A nil transcriber turns it off.
+type autoCaptionPolicy struct {
+	transcriber transcribe.Transcriber
+	// language is what videos are assumed to be spoken in unless their
+	// owner asks for another
+	language string
+	// interval is how often pending jobs are looked for
+	interval time.Duration
+	// timeout bounds each transcription
+	timeout time.Duration
+}
+
+// loadAutoCaptionPolicy reads CAPTIONS_AUTO, which is "whisper" to run
+// the whisper command locally, "api" to post audio to CAPTIONS_API_URL,
+// or empty to generate no captions.
+func loadAutoCaptionPolicy() (autoCaptionPolicy, error) {
+	policy := autoCaptionPolicy{
+		language: os.Getenv("CAPTIONS_AUTO_LANGUAGE"),
+		interval: envDuration("CAPTIONS_AUTO_INTERVAL", time.Minute),
+		timeout:  envDuration("CAPTIONS_AUTO_TIMEOUT", 30*time.Minute),
+	}
+	if policy.language == "" {
+		policy.language = "en"
+	}
+	language, err := normalizeLanguageTag(policy.language)
+	if err != nil {
+		return autoCaptionPolicy{}, fmt.Errorf("CAPTIONS_AUTO_LANGUAGE: %w", err)
+	}
+	policy.language = language
+
+	switch mode := os.Getenv("CAPTIONS_AUTO"); mode {
+	case "":
+		return policy, nil
+	case "whisper":
+		policy.transcriber = transcribe.Whisper{
+			Path:  os.Getenv("WHISPER_PATH"),
+			Model: os.Getenv("WHISPER_MODEL"),
+		}
+	case "api":
+		endpoint := os.Getenv("CAPTIONS_API_URL")
+		if endpoint == "" {
+			return autoCaptionPolicy{}, errors.New("CAPTIONS_AUTO=api needs CAPTIONS_API_URL")
+		}
+		model := os.Getenv("CAPTIONS_API_MODEL")
+		if model == "" {
+			model = "whisper-1"
+		}
+		policy.transcriber = transcribe.API{
+			Endpoint: endpoint,
+			APIKey:   os.Getenv("CAPTIONS_API_KEY"),
+			Model:    model,
+			Client:   &http.Client{Timeout: policy.timeout},
+		}
+	default:
+		return autoCaptionPolicy{}, fmt.Errorf("CAPTIONS_AUTO must be whisper, api or empty, not %q", mode)
+	}
+	if policy.interval <= 0 || policy.timeout <= 0 {
+		return autoCaptionPolicy{}, errors.New("CAPTIONS_AUTO_INTERVAL and CAPTIONS_AUTO_TIMEOUT must be positive")
+	}
+	return policy, nil
+}
+
+// handlerAutoCaptionsCreate asks for draft captions to be generated for a
+// video, in the language given as {"language": "de"} or the default one.
+// They are made in the background; the job can be followed with
+// handlerAutoCaptionsGet.
+func (cfg *apiConfig) handlerAutoCaptionsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Language string `json:"language"`
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+	if cfg.autoCaptions.transcriber == nil {
+		respondWithError(w, http.StatusNotImplemented, "Automatic captions aren't enabled", nil)
+		return
+	}
+	if video.Status != database.VideoStatusReady || video.QuarantineKey != nil {
+		respondWithError(w, http.StatusConflict, "Captions can only be generated for a playable video", nil)
+		return
+	}
+
+	params := parameters{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+	language := cfg.autoCaptions.language
+	if params.Language != "" {
+		var err error
+		language, err = normalizeLanguageTag(params.Language)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+
+	job, err := cfg.db.QueueCaptionJob(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue captions", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// handlerAutoCaptionsGet reports on the video's latest caption job.
+func (cfg *apiConfig) handlerAutoCaptionsGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.editableVideo(w, r, contextPrincipal(r))
+	if !ok {
+		return
+	}
+	job, err := cfg.db.GetCaptionJob(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get caption job", err)
+		return
+	}
+	if job.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No captions have been generated for this video", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, job)
+}
+
+// queueAutoCaptions asks for draft captions for a video whose file was
+// just stored, if automatic captions are enabled.
+func (cfg *apiConfig) queueAutoCaptions(video database.Video) {
+	if cfg.autoCaptions.transcriber == nil || video.QuarantineKey != nil {
+		return
+	}
+	if _, err := cfg.db.QueueCaptionJob(video.ID, cfg.autoCaptions.language); err != nil {
+		log.Printf("Couldn't queue captions for video %s: %v", video.ID, err)
+	}
+}
+
+// runAutoCaptions works through pending caption jobs every interval until
+// ctx is done.
+func (cfg *apiConfig) runAutoCaptions(ctx context.Context) {
+	ticker := time.NewTicker(cfg.autoCaptions.interval)
+	defer ticker.Stop()
+	for {
+		jobs, err := cfg.db.GetPendingCaptionJobs(autoCaptionBatch)
+		if err != nil {
+			log.Printf("Couldn't list caption jobs: %v", err)
+		}
+		for _, job := range jobs {
+			cfg.runCaptionJob(ctx, job)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) runCaptionJob(ctx context.Context, job database.CaptionJob) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.autoCaptions.timeout)
+	defer cancel()
+
+	video, err := cfg.generateCaptions(ctx, job)
+	if err != nil {
+		log.Printf("Couldn't generate captions for video %s: %v", job.VideoID, err)
+		maxAttempts := autoCaptionMaxAttempts
+		// Trying again won't make a caption uploaded by hand go away
+		if errors.Is(err, database.ErrCaptionExists) || errors.Is(err, errNotCaptionable) {
+			maxAttempts = 0
+		}
+		if err := cfg.db.FailCaptionJob(job.ID, err.Error(), maxAttempts); err != nil {
+			log.Printf("Couldn't record failed caption job for video %s: %v", job.VideoID, err)
+		}
+		return
+	}
+	if err := cfg.db.CompleteCaptionJob(job.ID); err != nil {
+		log.Printf("Couldn't record caption job for video %s: %v", job.VideoID, err)
+	}
+	event := database.AuditEvent{
+		Action:     auditCaptionsGenerated,
+		TargetType: "video",
+		TargetID:   video.ID.String(),
+		Details:    map[string]string{"language": job.Language},
+	}
+	if err := cfg.db.CreateAuditEvent(event); err != nil {
+		log.Printf("Couldn't record audit event %s: %v", auditCaptionsGenerated, err)
+	}
+}
+
+// errNotCaptionable is returned for caption jobs whose video has no file
+// to listen to.
+var errNotCaptionable = errors.New("video has no playable file")
+
+// generateCaptions fetches a job's video from storage, transcribes its
+// audio and stores the result as a draft caption.
+func (cfg *apiConfig) generateCaptions(ctx context.Context, job database.CaptionJob) (database.Video, error) {
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	store, key := cfg.videoFile(video)
+	if video.ID == uuid.Nil || video.Status != database.VideoStatusReady || key == nil || video.QuarantineKey != nil {
+		return database.Video{}, errNotCaptionable
+	}
+
+	dir, err := os.MkdirTemp("", "tubely-captions-")
+	if err != nil {
+		return database.Video{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	obj, err := store.GetObject(ctx, *key)
+	if err != nil {
+		return database.Video{}, err
+	}
+	videoPath := filepath.Join(dir, "video.mp4")
+	videoFile, err := os.Create(videoPath)
+	if err != nil {
+		obj.Close()
+		return database.Video{}, err
+	}
+	_, err = io.Copy(videoFile, obj)
+	obj.Close()
+	if closeErr := videoFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	audioPath := filepath.Join(dir, "audio"+transcribe.AudioExtension)
+	if err := transcribe.ExtractAudio(ctx, cfg.mediaTools.ffmpeg, videoPath, audioPath); err != nil {
+		return database.Video{}, err
+	}
+	transcript, err := cfg.autoCaptions.transcriber.Transcribe(ctx, audioPath, job.Language)
+	if err != nil {
+		return database.Video{}, err
+	}
+	vtt, err := captions.ToWebVTT(transcript)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if _, err := cfg.storeCaption(ctx, video, job.Language, vtt, true); err != nil {
+		return database.Video{}, err
+	}
+	return video, nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// backupTarget copies video metadata and media to a second location for
+// disaster recovery. Each run writes a manifest of every video under
+// backups/<time>/videos.json. Video files are kept under
+// objects/<bucket>/<key>, or objects/local/<key> for the local backend,
+// and thumbnails under thumbnails/<key>. Keys never hold different
+// content, so files already in the backup aren't copied again.
+type backupTarget struct {
+	store storage.Store
+	// prefix starts every key written, e.g. "tubely/"
+	prefix string
+
+	mu      sync.Mutex
+	running bool
+	last    *backupRun
+}
+
+// backupRun reports on a backup.
+type backupRun struct {
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Manifest   string     `json:"manifest"`
+	Videos     int        `json:"videos"`
+	Copied     int        `json:"copied"`
+	Skipped    int        `json:"skipped"`
+	Failed     int        `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// backupVideo is a video's entry in a backup manifest.
+type backupVideo struct {
+	database.Video
+	File      *backupFile `json:"file"`
+	Thumbnail *backupFile `json:"thumbnail"`
+}
+
+// backupFile says where a file was stored and where its copy is kept in
+// the backup. The copy is missing if it failed to copy.
+type backupFile struct {
+	Provider    string  `json:"provider,omitempty"`
+	Bucket      *string `json:"bucket,omitempty"`
+	Key         string  `json:"key"`
+	Quarantined bool    `json:"quarantined,omitempty"`
+	BackupKey   string  `json:"backup_key"`
+}
+
+// loadBackupTarget reads BACKUP_DESTINATION, either an S3 location such as
+// "s3://tubely-dr/prod" or a local directory. S3 backups are written with
+// the app's AWS credentials, in BACKUP_S3_REGION or S3_REGION. Media
+// encrypted client-side stays encrypted with the same keys in the backup.
+// It returns nil if backups aren't configured.
+func loadBackupTarget(primary storage.Store) (*backupTarget, error) {
+	destination := os.Getenv("BACKUP_DESTINATION")
+	if destination == "" {
+		return nil, nil
+	}
+
+	target := &backupTarget{}
+	if location, ok := strings.CutPrefix(destination, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(location, "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("BACKUP_DESTINATION %q names no bucket", destination)
+		}
+		if prefix = strings.Trim(prefix, "/"); prefix != "" {
+			target.prefix = prefix + "/"
+		}
+		if _, primaryBucket := storeLocation(primary); primaryBucket != nil && *primaryBucket == bucket && target.prefix == "" {
+			return nil, fmt.Errorf("BACKUP_DESTINATION must be another bucket or a prefix")
+		}
+		region := os.Getenv("BACKUP_S3_REGION")
+		if region == "" {
+			region = os.Getenv("S3_REGION")
+		}
+		awsCfg, err := loadAWSConfig(region)
+		if err != nil {
+			return nil, err
+		}
+		target.store = storage.NewS3Store(newS3Client(awsCfg), bucket)
+	} else {
+		store, err := storage.NewLocalStore(destination, "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create backup directory: %w", err)
+		}
+		target.store = store
+	}
+
+	if encrypted, ok := primary.(storage.EncryptedStore); ok {
+		target.store = storage.EncryptedStore{Store: target.store, Keys: encrypted.Keys}
+	}
+	return target, nil
+}
+
+// start begins a backup in the background, returning false if one is
+// already running.
+func (b *backupTarget) start(cfg *apiConfig) (backupRun, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running {
+		return *b.last, false
+	}
+	now := time.Now().UTC()
+	run := &backupRun{
+		StartedAt: now,
+		Manifest:  b.prefix + "backups/" + now.Format("20060102T150405Z") + "/videos.json",
+	}
+	b.running, b.last = true, run
+	go func() {
+		b.run(context.Background(), cfg, run)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		finished := time.Now().UTC()
+		run.FinishedAt = &finished
+		b.running = false
+		log.Printf("Backup %s finished: %d videos, %d files copied, %d skipped, %d failed %s",
+			run.Manifest, run.Videos, run.Copied, run.Skipped, run.Failed, run.Error)
+	}()
+	return *run, true
+}
+
+// status returns the running or most recent backup, if there has been one.
+func (b *backupTarget) status() (backupRun, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.last == nil {
+		return backupRun{}, false
+	}
+	return *b.last, true
+}
+
+// record updates a running backup's counts under the lock status reads
+// them with.
+func (b *backupTarget) record(update func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	update()
+}
+
+func (b *backupTarget) run(ctx context.Context, cfg *apiConfig, run *backupRun) {
+	fail := func(err error) {
+		b.record(func() { run.Error = err.Error() })
+	}
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		fail(fmt.Errorf("couldn't list videos: %w", err))
+		return
+	}
+	existing := map[string]bool{}
+	if lister, ok := b.store.(storage.Lister); ok {
+		err := lister.ListObjects(ctx, b.prefix, func(obj storage.ObjectInfo) error {
+			existing[obj.Key] = true
+			return nil
+		})
+		if err != nil {
+			fail(fmt.Errorf("couldn't list backed up objects: %w", err))
+			return
+		}
+	}
+
+	manifest := []backupVideo{}
+	for _, video := range videos {
+		entry := backupVideo{Video: video}
+		if store, key := cfg.videoFile(video); key != nil {
+			provider, bucket := storeLocation(store)
+			source := provider
+			if bucket != nil {
+				source = *bucket
+			}
+			entry.File = &backupFile{
+				Provider:    provider,
+				Bucket:      bucket,
+				Key:         *key,
+				Quarantined: video.QuarantineKey != nil,
+				BackupKey:   b.prefix + "objects/" + source + "/" + *key,
+			}
+			if !b.copyFile(ctx, run, existing, entry.File, func() (io.ReadCloser, error) {
+				return store.GetObject(ctx, *key)
+			}) {
+				entry.File.BackupKey = ""
+			}
+		}
+		if key := storedThumbnailKey(video); key != nil {
+			entry.Thumbnail = &backupFile{Key: *key, BackupKey: b.prefix + "thumbnails/" + *key}
+			if !b.copyFile(ctx, run, existing, entry.Thumbnail, func() (io.ReadCloser, error) {
+				return os.Open(filepath.Join(cfg.assetsRoot, *key))
+			}) {
+				entry.Thumbnail.BackupKey = ""
+			}
+		}
+		manifest = append(manifest, entry)
+		b.record(func() { run.Videos++ })
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		fail(err)
+		return
+	}
+	err = b.store.PutObject(ctx, run.Manifest, bytes.NewReader(data), storage.PutOptions{ContentType: "application/json"})
+	if err != nil {
+		fail(fmt.Errorf("couldn't write manifest: %w", err))
+	}
+}
+
+// copyFile copies a file into the backup unless it is already there,
+// reporting whether the backup has it.
+func (b *backupTarget) copyFile(ctx context.Context, run *backupRun, existing map[string]bool, file *backupFile, open func() (io.ReadCloser, error)) bool {
+	if existing[file.BackupKey] {
+		b.record(func() { run.Skipped++ })
+		return true
+	}
+	err := b.copy(ctx, file.BackupKey, open)
+	if err != nil {
+		log.Printf("Backup: couldn't copy %s: %v", file.Key, err)
+		b.record(func() { run.Failed++ })
+		return false
+	}
+	existing[file.BackupKey] = true
+	b.record(func() { run.Copied++ })
+	return true
+}
+
+// copy spools a file to disk before writing it to the backup, since
+// uploads to S3 need to know their length.
+func (b *backupTarget) copy(ctx context.Context, key string, open func() (io.ReadCloser, error)) error {
+	body, err := open()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	tempFile, err := os.CreateTemp("", "tubely-backup")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	if _, err := io.Copy(tempFile, body); err != nil {
+		return err
+	}
+	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return b.store.PutObject(ctx, key, tempFile, storage.PutOptions{})
+}
+
+// handlerBackupStart starts a backup of every video's metadata and media
+// to BACKUP_DESTINATION. It runs in the background; poll
+// handlerBackupStatus to see how it went.
+func (cfg *apiConfig) handlerBackupStart(w http.ResponseWriter, r *http.Request) {
+	if cfg.backups == nil {
+		respondWithError(w, http.StatusNotFound, "Backups aren't configured", nil)
+		return
+	}
+	run, started := cfg.backups.start(cfg)
+	if !started {
+		respondWithError(w, http.StatusConflict, "A backup is already running", errors.New("backup started at "+run.StartedAt.Format(time.RFC3339)))
+		return
+	}
+	cfg.audit(r, contextPrincipal(r).UserID, auditBackupStarted, "backup", run.Manifest, nil)
+	respondWithJSON(w, http.StatusAccepted, run)
+}
+
+// handlerBackupStatus reports on the running or most recent backup.
+func (cfg *apiConfig) handlerBackupStatus(w http.ResponseWriter, r *http.Request) {
+	if cfg.backups == nil {
+		respondWithError(w, http.StatusNotFound, "Backups aren't configured", nil)
+		return
+	}
+	run, ok := cfg.backups.status()
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "No backup has run since the app started", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, run)
+}
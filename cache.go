@@ -1,6 +1,14 @@
 package main
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
 
 func noCacheMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -8,3 +16,48 @@ func noCacheMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// cacheMiddleware lets browsers and CDNs cache files served from root.
+// Asset names are random and versioned, so URLs carrying a version
+// parameter are marked immutable. An ETag derived from the file's size and
+// modification time is set so the file server can answer conditional
+// requests with 304s.
+func cacheMiddleware(root string, maxAge time.Duration, next http.Handler) http.Handler {
+	if maxAge <= 0 {
+		return noCacheMiddleware(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cacheControl := fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+		if r.URL.Query().Get("v") != "" {
+			cacheControl += ", immutable"
+		}
+		w.Header().Set("Cache-Control", cacheControl)
+
+		name := filepath.Join(root, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		if info, err := os.Stat(name); err == nil && !info.IsDir() {
+			w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notModified reports whether a GET's conditional headers show the client
+// already has the version of a resource with etag, last changed at
+// modified, so it can be answered with a 304. If-None-Match takes
+// precedence over If-Modified-Since, and ETags are compared weakly.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if header := strings.Join(r.Header.Values("If-None-Match"), ","); header != "" {
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
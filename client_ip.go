@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// clientIPMiddleware resolves the client's address once per request. When
+// the connection comes from a trusted proxy, the address is taken from
+// header instead: for X-Forwarded-For, the rightmost entry that isn't
+// itself a trusted proxy, since anything further left is client-supplied.
+func clientIPMiddleware(trusted []netip.Prefix, header string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := resolveClientIP(r, trusted, header)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
+
+func resolveClientIP(r *http.Request, trusted []netip.Prefix, header string) string {
+	peer := remoteHost(r)
+	if !isTrustedProxy(peer, trusted) {
+		return peer
+	}
+
+	values := r.Header.Values(header)
+	if len(values) == 0 {
+		return peer
+	}
+	if !strings.EqualFold(header, "X-Forwarded-For") {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(values[len(values)-1])); err == nil {
+			return addr.Unmap().String()
+		}
+		return peer
+	}
+
+	hops := strings.Split(strings.Join(values, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return peer
+		}
+		ip := addr.Unmap().String()
+		if !isTrustedProxy(ip, trusted) {
+			return ip
+		}
+	}
+	return peer
+}
+
+func isTrustedProxy(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses TRUSTED_PROXIES, a list of addresses or CIDR
+// ranges of the load balancers in front of us.
+func parseTrustedProxies(list []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", item, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", item, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// clientIP returns the address of the client, as resolved by
+// clientIPMiddleware, without its port.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteHost(r)
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
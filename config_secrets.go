@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/secrets"
+)
+
+// secretEnv is the environment variables whose values were read from
+// Secrets Manager or SSM, and how to read them again.
+type secretEnv struct {
+	resolver secrets.Resolver
+	// refs maps each variable to its reference, and values to the value
+	// it was resolved to
+	refs   map[string]string
+	values map[string]string
+}
+
+// resolveSecretEnv replaces every environment variable whose value is a
+// reference like "secretsmanager:tubely/prod#jwt_secret" or
+// "ssm:/tubely/prod/s3-bucket" with the value it points at, so the rest
+// of startup reads it like any other setting. AWS credentials come from
+// the usual default chain, for the region in SECRETS_REGION or AWS_REGION.
+func resolveSecretEnv() (secretEnv, error) {
+	env := secretEnv{refs: map[string]string{}, values: map[string]string{}}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if secrets.IsRef(value) {
+			env.refs[name] = value
+		}
+	}
+	if len(env.refs) == 0 {
+		return env, nil
+	}
+
+	region := os.Getenv("SECRETS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		return secretEnv{}, fmt.Errorf("SECRETS_REGION or AWS_REGION must be set to read secretsmanager: and ssm: settings")
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return secretEnv{}, fmt.Errorf("error loading aws configuration: %w", err)
+	}
+	env.resolver = secrets.Resolver{
+		Region:      region,
+		Credentials: awsCfg.Credentials,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		Endpoint:    os.Getenv("SECRETS_ENDPOINT"),
+	}
+
+	for name, ref := range env.refs {
+		value, err := env.resolver.Resolve(context.TODO(), ref)
+		if err != nil {
+			return secretEnv{}, fmt.Errorf("couldn't read %s from %s: %w", name, ref, err)
+		}
+		os.Setenv(name, value)
+		env.values[name] = value
+	}
+	log.Printf("Read %d setting(s) from Secrets Manager and SSM", len(env.refs))
+	return env, nil
+}
+
+// watch reads the referenced values again every interval until ctx is
+// done, and calls changed once any of them differs from what startup
+// read. Settings are only read at startup, so applying new values, such
+// as a rotated JWT secret, means restarting.
+func (env secretEnv) watch(ctx context.Context, interval time.Duration, changed func(name string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for name, ref := range env.refs {
+			value, err := env.resolver.Resolve(ctx, ref)
+			if err != nil {
+				log.Printf("Config refresh: couldn't read %s from %s: %v", name, ref, err)
+				continue
+			}
+			if value != env.values[name] {
+				changed(name)
+				return
+			}
+		}
+	}
+}
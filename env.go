@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envInt reads an optional positive integer environment variable, falling
+// back to def when it is unset.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("%s must be a positive integer", name)
+	}
+	return n
+}
+
+// envNonNegativeInt is envInt for settings where 0 means "off".
+func envNonNegativeInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Fatalf("%s must be a non-negative integer", name)
+	}
+	return n
+}
+
+// envList reads an optional comma-separated environment variable, falling
+// back to def when it is unset. Blank entries are ignored.
+func envList(name string, def []string) []string {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	list := []string{}
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envDuration reads an optional Go duration (e.g. "90s", "24h")
+// environment variable, falling back to def when it is unset.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatalf("%s must be a non-negative duration", name)
+	}
+	return d
+}
+
+// envFloat reads an optional floating point environment variable, falling
+// back to def when it is unset.
+func envFloat(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatalf("%s must be a number", name)
+	}
+	return f
+}
+
+// envBool reads an optional boolean environment variable ("true", "1",
+// "false", ...), falling back to def when it is unset.
+func envBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("%s must be true or false", name)
+	}
+	return b
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var errLastOrgOwner = errors.New("user is the last owner of an organization with other members")
+
+// handlerUsersDeleteMe deletes the caller's account and everything stored
+// for it. Like disabling two-factor, it asks for the account's credentials
+// again so a stolen access token isn't enough.
+func (cfg *apiConfig) handlerUsersDeleteMe(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	p := contextPrincipal(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	// Accounts provisioned through OAuth have no password to confirm
+	if user.Password != "" {
+		if err := auth.CheckPasswordHash(params.Password, user.Password); err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+			return
+		}
+	}
+	totp, err := cfg.db.GetUserTOTP(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if totp.Enabled {
+		if cfg.verifySecondFactor(w, p.UserID, totp, params.Code, "") != nil {
+			return
+		}
+	}
+
+	cfg.respondAccountDeleted(w, r, p.UserID, p.UserID)
+}
+
+func (cfg *apiConfig) handlerAdminUserDelete(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	cfg.respondAccountDeleted(w, r, contextPrincipal(r).UserID, userID)
+}
+
+func (cfg *apiConfig) respondAccountDeleted(w http.ResponseWriter, r *http.Request, actor, userID uuid.UUID) {
+	err := cfg.deleteAccount(r.Context(), userID)
+	if errors.Is(err, errLastOrgOwner) {
+		respondWithError(w, http.StatusConflict, "Transfer ownership of your organizations before deleting the account", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+	cfg.audit(r, actor, auditUserDeleted, "user", userID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteAccount revokes a user's sessions, deletes the account and its
+// personal videos, then removes their stored media. Organization videos
+// stay with the organization, unless the user is its only member: then
+// nobody is left to manage it, so the organization and its videos are
+// deleted too.
+func (cfg *apiConfig) deleteAccount(ctx context.Context, userID uuid.UUID) error {
+	orgs, err := cfg.db.GetOrganizationsForUser(userID)
+	if err != nil {
+		return err
+	}
+	soleOrgs := []uuid.UUID{}
+	for _, org := range orgs {
+		members, err := cfg.db.GetOrgMemberships(org.ID)
+		if err != nil {
+			return err
+		}
+		if len(members) == 1 {
+			soleOrgs = append(soleOrgs, org.ID)
+			continue
+		}
+		if org.Role != database.OrgRoleOwner {
+			continue
+		}
+		owners, err := cfg.db.CountOrgOwners(org.ID)
+		if err != nil {
+			return err
+		}
+		if owners == 1 {
+			return errLastOrgOwner
+		}
+	}
+
+	videos, err := cfg.db.GetVideos(userID)
+	if err != nil {
+		return err
+	}
+	trashed, err := cfg.db.GetTrashedVideos(userID)
+	if err != nil {
+		return err
+	}
+	videos = append(videos, trashed...)
+	orgVideos := []database.Video{}
+	for _, orgID := range soleOrgs {
+		v, err := cfg.db.GetAllOrgVideos(orgID)
+		if err != nil {
+			return err
+		}
+		orgVideos = append(orgVideos, v...)
+	}
+
+	// Revoke first so outstanding access tokens stop working even if the
+	// rest fails part way
+	err = cfg.revokeAllSessions(userID)
+	if err != nil {
+		return err
+	}
+	for _, orgID := range soleOrgs {
+		if err := cfg.db.DeleteOrganization(orgID); err != nil {
+			return err
+		}
+	}
+	err = cfg.db.DeleteUser(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, video := range videos {
+		if video.OrgID != nil {
+			continue
+		}
+		cfg.removeVideoMedia(ctx, video)
+	}
+	for _, video := range orgVideos {
+		cfg.removeVideoMedia(ctx, video)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestDeleteAccountOrganizations(t *testing.T) {
+	tests := []struct {
+		name        string
+		otherRole   string // role of a second member, if any
+		wantErr     error
+		wantOrgGone bool
+	}{
+		{name: "only member", wantOrgGone: true},
+		{name: "another owner", otherRole: database.OrgRoleOwner},
+		{name: "last owner with members", otherRole: database.OrgRoleViewer, wantErr: errLastOrgOwner},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			user := newTestUser(t, cfg)
+			org, err := cfg.db.CreateOrganization("acme", user.ID)
+			if err != nil {
+				t.Fatalf("CreateOrganization() error = %v", err)
+			}
+			if tt.otherRole != "" {
+				other := newTestUser(t, cfg)
+				if err := cfg.db.PutOrgMembership(org.ID, other.ID, tt.otherRole); err != nil {
+					t.Fatalf("PutOrgMembership() error = %v", err)
+				}
+			}
+			video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "org video", UserID: user.ID, OrgID: &org.ID})
+			if err != nil {
+				t.Fatalf("CreateVideo() error = %v", err)
+			}
+
+			err = cfg.deleteAccount(context.Background(), user.ID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("deleteAccount() error = %v, want %v", err, tt.wantErr)
+			}
+
+			got, err := cfg.db.GetOrganization(org.ID)
+			if err != nil {
+				t.Fatalf("GetOrganization() error = %v", err)
+			}
+			if gone := got.ID != org.ID; gone != tt.wantOrgGone {
+				t.Errorf("organization deleted = %v, want %v", gone, tt.wantOrgGone)
+			}
+			gotVideo, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatalf("GetVideo() error = %v", err)
+			}
+			if gone := gotVideo.ID != video.ID; gone != tt.wantOrgGone {
+				t.Errorf("organization video deleted = %v, want %v", gone, tt.wantOrgGone)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultDuplicateDistance = 4
+	// maxDuplicateDistance caps max_distance. Hashes are only compared
+	// within buckets, which grow as the distance does
+	maxDuplicateDistance = 12
+)
+
+func (cfg *apiConfig) handlerThumbnailDuplicates(w http.ResponseWriter, r *http.Request) {
+	type duplicateVideo struct {
+		ID           uuid.UUID `json:"id"`
+		Title        string    `json:"title"`
+		UserID       uuid.UUID `json:"user_id"`
+		ThumbnailURL *string   `json:"thumbnail_url"`
+	}
+	type duplicateGroup struct {
+		MaxDistance int              `json:"max_distance"`
+		Videos      []duplicateVideo `json:"videos"`
+	}
+
+	maxDistance := defaultDuplicateDistance
+	if v := r.URL.Query().Get("max_distance"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxDuplicateDistance {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("max_distance must be between 0 and %d", maxDuplicateDistance), err)
+			return
+		}
+		maxDistance = n
+	}
+
+	videos, err := cfg.db.GetVideosWithThumbnailHash()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve thumbnails", err)
+		return
+	}
+
+	hashed := make([]database.Video, 0, len(videos))
+	hashes := make([]uint64, 0, len(videos))
+	for _, video := range videos {
+		hash, err := imaging.ParsePHash(*video.ThumbnailPHash)
+		if err != nil {
+			continue
+		}
+		hashed = append(hashed, video)
+		hashes = append(hashes, hash)
+	}
+
+	// Union-find over every pair within maxDistance of each other. Split
+	// into maxDistance+1 blocks, two such hashes must agree on at least one
+	// block, so only hashes sharing a block are compared
+	parent := make([]int, len(hashed))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	groupDistance := map[int]int{}
+	for _, bucket := range phashBuckets(hashes, maxDistance+1) {
+		for a, i := range bucket {
+			for _, j := range bucket[a+1:] {
+				d := imaging.PHashDistance(hashes[i], hashes[j])
+				if d > maxDistance {
+					continue
+				}
+				ri, rj := find(i), find(j)
+				if ri != rj {
+					parent[rj] = ri
+					groupDistance[ri] = max(groupDistance[ri], groupDistance[rj])
+				}
+				groupDistance[ri] = max(groupDistance[ri], d)
+			}
+		}
+	}
+
+	members := map[int][]duplicateVideo{}
+	order := []int{}
+	for i, video := range hashed {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			order = append(order, root)
+		}
+		members[root] = append(members[root], duplicateVideo{
+			ID:           video.ID,
+			Title:        video.Title,
+			UserID:       video.UserID,
+			ThumbnailURL: video.ThumbnailURL,
+		})
+	}
+
+	groups := []duplicateGroup{}
+	for _, root := range order {
+		if len(members[root]) < 2 {
+			continue
+		}
+		groups = append(groups, duplicateGroup{
+			MaxDistance: groupDistance[root],
+			Videos:      members[root],
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, groups)
+}
+
+// phashBuckets splits each hash into blocks contiguous bits and groups
+// the indexes of hashes that have the same value in the same block.
+// Buckets with a single hash are left out.
+func phashBuckets(hashes []uint64, blocks int) [][]int {
+	type blockValue struct {
+		block int
+		value uint64
+	}
+	byBlock := map[blockValue][]int{}
+	for i, hash := range hashes {
+		for b := range blocks {
+			lo, hi := b*64/blocks, (b+1)*64/blocks
+			value := hash >> lo & (1<<(hi-lo) - 1)
+			key := blockValue{b, value}
+			byBlock[key] = append(byBlock[key], i)
+		}
+	}
+	buckets := make([][]int, 0, len(byBlock))
+	for _, bucket := range byBlock {
+		if len(bucket) > 1 {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets
+}
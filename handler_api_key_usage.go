@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+	"github.com/google/uuid"
+)
+
+// apiKeyRateLimit returns the requests per minute a key may make, or 0 for
+// no limit. A per-key override takes precedence over API_KEY_RATE_LIMIT.
+func (cfg *apiConfig) apiKeyRateLimit(p principal) int {
+	if p.APIKeyRateLimit != nil {
+		return *p.APIKeyRateLimit
+	}
+	return cfg.apiKeyRateLimitDefault
+}
+
+// meterAPIKey counts a request made with an API key and enforces the key's
+// rate limit, writing a 429 and returning false when it is exceeded. Other
+// callers pass straight through.
+func (cfg *apiConfig) meterAPIKey(w http.ResponseWriter, p principal) bool {
+	if p.APIKeyID == nil {
+		return true
+	}
+
+	perMinute := cfg.apiKeyRateLimit(p)
+	result := cfg.apiKeyLimiter.Allow(p.APIKeyID.String(), ratelimit.PerMinute(perMinute), time.Now())
+	if err := cfg.db.RecordAPIKeyRequest(*p.APIKeyID, !result.Allowed); err != nil {
+		log.Printf("Couldn't record usage of API key %s: %v", p.APIKeyID, err)
+	}
+	if perMinute == 0 {
+		return true
+	}
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(perMinute))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+		respondWithError(w, http.StatusTooManyRequests, "API key rate limit exceeded", nil)
+		return false
+	}
+	return true
+}
+
+// meterUpload adds bytes uploaded by the request's caller to their API
+// key's usage. Routes without principal middleware, such as guest uploads,
+// aren't metered.
+func (cfg *apiConfig) meterUpload(r *http.Request, bytes int64) {
+	p, ok := r.Context().Value(principalKey{}).(principal)
+	if !ok || p.APIKeyID == nil {
+		return
+	}
+	if err := cfg.db.RecordAPIKeyUpload(*p.APIKeyID, bytes); err != nil {
+		log.Printf("Couldn't record upload by API key %s: %v", p.APIKeyID, err)
+	}
+}
+
+// handlerAPIKeyUsage reports a key's daily request and upload counts over
+// a range of days (the last 30 by default). Owners can see their own keys;
+// staff with report access can see anyone's.
+func (cfg *apiConfig) handlerAPIKeyUsage(w http.ResponseWriter, r *http.Request) {
+	type totals struct {
+		Requests    int64 `json:"requests"`
+		RateLimited int64 `json:"rate_limited"`
+		UploadBytes int64 `json:"upload_bytes"`
+	}
+	type response struct {
+		APIKeyID           uuid.UUID              `json:"api_key_id"`
+		RateLimitPerMinute *int                   `json:"rate_limit_per_minute"`
+		Since              string                 `json:"since"`
+		Until              string                 `json:"until"`
+		Days               []database.APIKeyUsage `json:"days"`
+		Totals             totals                 `json:"totals"`
+	}
+
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	until := time.Now().UTC()
+	since := until.AddDate(0, 0, -29)
+	for name, dst := range map[string]*time.Time{"since": &since, "until": &until} {
+		if v := r.URL.Query().Get(name); v != "" {
+			t, err := time.Parse(time.DateOnly, v)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, name+" must be a date (YYYY-MM-DD)", err)
+				return
+			}
+			*dst = t
+		}
+	}
+
+	p := contextPrincipal(r)
+	key, err := cfg.db.GetAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return
+	}
+	if key.ID == uuid.Nil || (key.UserID != p.UserID && !p.can(auth.PermAdminReports)) {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+
+	days, err := cfg.db.GetAPIKeyUsage(key.ID, since, until)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key usage", err)
+		return
+	}
+
+	resp := response{
+		APIKeyID: key.ID,
+		Since:    since.Format(time.DateOnly),
+		Until:    until.Format(time.DateOnly),
+		Days:     days,
+	}
+	if limit := cfg.apiKeyRateLimit(principal{APIKeyRateLimit: key.RateLimit}); limit > 0 {
+		resp.RateLimitPerMinute = &limit
+	}
+	for _, day := range days {
+		resp.Totals.Requests += day.Requests
+		resp.Totals.RateLimited += day.RateLimited
+		resp.Totals.UploadBytes += day.UploadBytes
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerAPIKeyRateLimitUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// RequestsPerMinute overrides the default limit; null restores it
+		// and 0 removes the limit
+		RequestsPerMinute *int `json:"requests_per_minute"`
+	}
+
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.RequestsPerMinute != nil && *params.RequestsPerMinute < 0 {
+		respondWithError(w, http.StatusBadRequest, "requests_per_minute can't be negative", nil)
+		return
+	}
+
+	key, err := cfg.db.GetAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return
+	}
+	if key.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+
+	err = cfg.db.SetAPIKeyRateLimit(keyID, params.RequestsPerMinute)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update rate limit", err)
+		return
+	}
+	limit := "default"
+	if params.RequestsPerMinute != nil {
+		limit = strconv.Itoa(*params.RequestsPerMinute)
+	}
+	cfg.audit(r, contextPrincipal(r).UserID, auditAPIKeyLimitChanged, "api_key", keyID.String(), map[string]string{"requests_per_minute": limit})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captcha"
+)
+
+// handlerCaptchaConfig tells the frontend which captcha widget to render,
+// if any.
+func (cfg *apiConfig) handlerCaptchaConfig(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Provider           string `json:"provider"`
+		SiteKey            string `json:"site_key"`
+		LoginAfterFailures int    `json:"login_after_failures"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Provider:           cfg.captcha.Provider(),
+		SiteKey:            cfg.captcha.SiteKey(),
+		LoginAfterFailures: cfg.captchaLoginAfterFailures,
+	})
+}
+
+// verifyCaptcha checks token, writing an error response and returning
+// false if it doesn't pass.
+func (cfg *apiConfig) verifyCaptcha(w http.ResponseWriter, r *http.Request, token string) bool {
+	type response struct {
+		Error           string `json:"error"`
+		CaptchaRequired bool   `json:"captcha_required"`
+	}
+
+	err := cfg.captcha.Verify(r.Context(), token, clientIP(r))
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, captcha.ErrMissingToken) || errors.Is(err, captcha.ErrFailed) {
+		respondWithJSON(w, http.StatusBadRequest, response{
+			Error:           "Captcha verification failed",
+			CaptchaRequired: true,
+		})
+		return false
+	}
+	respondWithError(w, http.StatusBadGateway, "Couldn't verify captcha", err)
+	return false
+}
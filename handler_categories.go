@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var categorySlugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// categoryNode is a category with the categories below it.
+type categoryNode struct {
+	database.Category
+	Children []*categoryNode `json:"children"`
+}
+
+// categoryParameters are the fields a category is created or replaced
+// with. The slug is made from the name when left out.
+type categoryParameters struct {
+	ParentID *uuid.UUID `json:"parent_id"`
+	Name     string     `json:"name"`
+	Slug     string     `json:"slug"`
+	Position int        `json:"position"`
+}
+
+// handlerCategoriesList returns the category tree, top-level categories
+// first and siblings in position order.
+func (cfg *apiConfig) handlerCategoriesList(w http.ResponseWriter, r *http.Request) {
+	categories, err := cfg.db.GetCategories()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve categories", err)
+		return
+	}
+
+	nodes := map[uuid.UUID]*categoryNode{}
+	for _, category := range categories {
+		nodes[category.ID] = &categoryNode{Category: category, Children: []*categoryNode{}}
+	}
+	roots := []*categoryNode{}
+	for _, category := range categories {
+		node := nodes[category.ID]
+		if parent, ok := nodes[derefUUID(category.ParentID)]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	respondWithJSON(w, http.StatusOK, roots)
+}
+
+// handlerCatalog lists public videos for browsing, paged, filtered and
+// sorted like handlerVideosRetrieve. ?category narrows it to a category
+// and the categories below it. Videos that can't be watched from the
+// viewer's region are left out.
+func (cfg *apiConfig) handlerCatalog(w http.ResponseWriter, r *http.Request) {
+	country := cfg.requestCountry(r)
+	cfg.respondWithVideoPage(w, r, func(filter database.VideoFilter, sort database.VideoSort, after *database.VideoCursor, limit int) ([]database.Video, error) {
+		return cfg.db.GetCatalogPage(country, filter, sort, after, limit)
+	})
+}
+
+func (cfg *apiConfig) handlerCategoryCreate(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	params := categoryParameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if status, err := cfg.validateCategory(uuid.Nil, &params); err != nil {
+		respondWithError(w, status, err.Error(), err)
+		return
+	}
+
+	category, err := cfg.db.CreateCategory(params.ParentID, params.Name, params.Slug, params.Position)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create category", err)
+		return
+	}
+	cfg.audit(r, contextPrincipal(r).UserID, auditCategoryCreated, "category", category.ID.String(), map[string]string{"slug": category.Slug})
+
+	respondWithJSON(w, http.StatusCreated, category)
+}
+
+// handlerCategoryUpdate replaces a category's name, slug, parent and
+// position. Moving a category moves the categories below it too.
+func (cfg *apiConfig) handlerCategoryUpdate(w http.ResponseWriter, r *http.Request) {
+	category, ok := cfg.pathCategory(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := categoryParameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if status, err := cfg.validateCategory(category.ID, &params); err != nil {
+		respondWithError(w, status, err.Error(), err)
+		return
+	}
+
+	category.ParentID = params.ParentID
+	category.Name = params.Name
+	category.Slug = params.Slug
+	category.Position = params.Position
+	err = cfg.db.UpdateCategory(category)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update category", err)
+		return
+	}
+	cfg.audit(r, contextPrincipal(r).UserID, auditCategoryUpdated, "category", category.ID.String(), map[string]string{"slug": category.Slug})
+
+	category, err = cfg.db.GetCategory(category.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get category", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, category)
+}
+
+// handlerCategoryDelete deletes a category, leaving its videos
+// uncategorized. Categories below it must be moved or deleted first.
+func (cfg *apiConfig) handlerCategoryDelete(w http.ResponseWriter, r *http.Request) {
+	category, ok := cfg.pathCategory(w, r)
+	if !ok {
+		return
+	}
+
+	categories, err := cfg.db.GetCategories()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve categories", err)
+		return
+	}
+	for _, other := range categories {
+		if derefUUID(other.ParentID) == category.ID {
+			respondWithError(w, http.StatusConflict, "Move or delete the categories below this one first", nil)
+			return
+		}
+	}
+
+	err = cfg.db.DeleteCategory(category.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete category", err)
+		return
+	}
+	cfg.audit(r, contextPrincipal(r).UserID, auditCategoryDeleted, "category", category.ID.String(), map[string]string{"slug": category.Slug})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerVideoCategoryUpdate files a video under a category, or takes it
+// out of its category when category_id is null.
+func (cfg *apiConfig) handlerVideoCategoryUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		CategoryID *uuid.UUID `json:"category_id"`
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	slug := "none"
+	if params.CategoryID != nil {
+		category, err := cfg.db.GetCategory(*params.CategoryID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get category", err)
+			return
+		}
+		if category.ID == uuid.Nil {
+			respondWithError(w, http.StatusBadRequest, "Unknown category", nil)
+			return
+		}
+		slug = category.Slug
+	}
+
+	video.CategoryID = params.CategoryID
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditVideoCategoryChanged, "video", video.ID.String(), map[string]string{"category": slug})
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// pathCategory loads the category named in the path, writing an error
+// response and returning false if there isn't one.
+func (cfg *apiConfig) pathCategory(w http.ResponseWriter, r *http.Request) (database.Category, bool) {
+	categoryID, err := uuid.Parse(r.PathValue("categoryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Category{}, false
+	}
+	category, err := cfg.db.GetCategory(categoryID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get category", err)
+		return database.Category{}, false
+	}
+	if category.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Category not found", nil)
+		return database.Category{}, false
+	}
+	return category, true
+}
+
+// validateCategory normalizes the parameters for the category with id,
+// uuid.Nil for a new one, returning the status to respond with if they
+// can't be used.
+func (cfg *apiConfig) validateCategory(id uuid.UUID, params *categoryParameters) (int, error) {
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		return http.StatusBadRequest, errors.New("name is required")
+	}
+	if params.Slug == "" {
+		params.Slug = slugify(params.Name)
+	}
+	if !categorySlugPattern.MatchString(params.Slug) {
+		return http.StatusBadRequest, errors.New("slug must be lower-case letters and digits separated by hyphens")
+	}
+
+	categories, err := cfg.db.GetCategories()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	parents := map[uuid.UUID]*uuid.UUID{}
+	for _, category := range categories {
+		if category.Slug == params.Slug && category.ID != id {
+			return http.StatusConflict, errors.New("another category has this slug")
+		}
+		parents[category.ID] = category.ParentID
+	}
+	if params.ParentID != nil {
+		if _, ok := parents[*params.ParentID]; !ok {
+			return http.StatusBadRequest, errors.New("unknown parent category")
+		}
+		// A category can't be moved below itself
+		for ancestor := params.ParentID; ancestor != nil; ancestor = parents[*ancestor] {
+			if *ancestor == id {
+				return http.StatusBadRequest, errors.New("a category can't be below itself")
+			}
+		}
+	}
+	return 0, nil
+}
+
+// slugify makes a slug from a category name, e.g. "Sci-Fi & Fantasy"
+// becomes "sci-fi-fantasy".
+func slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
+func derefUUID(id *uuid.UUID) uuid.UUID {
+	if id == nil {
+		return uuid.Nil
+	}
+	return *id
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// embedPage is the player third-party sites put in an iframe. The video
+// is loaded from a stream link, so the page never holds a presigned URL,
+// and when its playback token has expired the page reloads itself for a
+// new one, resuming from ?t.
+var embedPage = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html{{with .Lang}} lang="{{.}}"{{end}}>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+{{with .OEmbedURL}}<link rel="alternate" type="application/json+oembed" href="{{.}}" title="{{$.Title}}">{{end}}
+<style>
+html, body { margin: 0; height: 100%; background: #000; }
+video { display: block; width: 100%; height: 100%; }
+p { margin: 0; padding-top: 40vh; color: #fff; font: 16px system-ui, sans-serif; text-align: center; }
+</style>
+</head>
+<body>
+{{if .Message}}<p>{{.Message}}</p>{{else}}<video controls playsinline preload="metadata" src="{{.StreamURL}}"{{with .Poster}} poster="{{.}}"{{end}}>
+{{range .Captions}}<track kind="subtitles" srclang="{{.Language}}" label="{{.Language}}" src="{{.URL}}">
+{{end}}</video>
+<script nonce="{{.Nonce}}">
+(function () {
+  var video = document.querySelector('video');
+  var start = parseFloat(new URLSearchParams(location.search).get('t'));
+  if (start > 0) {
+    video.addEventListener('loadedmetadata', function () {
+      video.currentTime = start;
+      video.play().catch(function () {});
+    }, { once: true });
+  }
+  video.addEventListener('error', function () {
+    if (Date.now() >= {{.ExpiresAt}}) {
+      location.replace(location.pathname + '?t=' + Math.floor(video.currentTime));
+    }
+  });
+})();
+</script>
+{{end}}
+</body>
+</html>
+`))
+
+// embedPageData fills in embedPage. Message replaces the player when the
+// video can't be shown.
+type embedPageData struct {
+	Lang      string
+	Title     string
+	Message   string
+	OEmbedURL string
+	StreamURL string
+	Poster    string
+	Captions  []playbackCaption
+	// ExpiresAt is when the playback token expires, in milliseconds since
+	// the epoch
+	ExpiresAt int64
+	Nonce     string
+}
+
+// handlerEmbed serves the embeddable player for a public or unlisted
+// video, with a fresh playback token for the anonymous viewer. Private
+// videos can't be embedded, since the iframe has no way to sign in.
+func (cfg *apiConfig) handlerEmbed(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		cfg.respondWithEmbedMessage(w, http.StatusNotFound, "This video doesn't exist.")
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		log.Printf("Couldn't get video %s to embed: %v", videoID, err)
+		cfg.respondWithEmbedMessage(w, http.StatusInternalServerError, "This video can't be played right now.")
+		return
+	}
+	if video.ID == uuid.Nil {
+		cfg.respondWithEmbedMessage(w, http.StatusNotFound, "This video doesn't exist.")
+		return
+	}
+	if code, _ := cfg.videoAccessError(r, principal{}, video); code == http.StatusForbidden {
+		cfg.respondWithEmbedMessage(w, code, "This video isn't available in your region.")
+		return
+	} else if code != 0 {
+		cfg.respondWithEmbedMessage(w, http.StatusNotFound, "This video doesn't exist.")
+		return
+	}
+	if _, key := cfg.videoFile(video); key == nil || video.QuarantineKey != nil || video.Status != database.VideoStatusReady {
+		cfg.respondWithEmbedMessage(w, http.StatusNotFound, "This video can't be played.")
+		return
+	}
+	status, archived, err := cfg.videoArchiveStatus(r.Context(), video)
+	if err != nil {
+		log.Printf("Couldn't check archive status of video %s to embed: %v", video.ID, err)
+		cfg.respondWithEmbedMessage(w, http.StatusInternalServerError, "This video can't be played right now.")
+		return
+	}
+	if archived && status.Status != archiveStatusRestored {
+		cfg.respondWithEmbedMessage(w, http.StatusConflict, "This video is archived and can't be played right now.")
+		return
+	}
+
+	token, expiresAt, err := cfg.issuePlaybackToken(r, principal{}, video)
+	if err != nil {
+		log.Printf("Couldn't create playback token to embed video %s: %v", video.ID, err)
+		cfg.respondWithEmbedMessage(w, http.StatusInternalServerError, "This video can't be played right now.")
+		return
+	}
+
+	localized := localizeVideo(r, video)
+	data := embedPageData{
+		Title:     localized.Title,
+		OEmbedURL: cfg.baseURL + "/oembed?" + url.Values{"url": {cfg.embedURL(video.ID)}}.Encode(),
+		StreamURL: cfg.playbackStreamURL(video.ID, token),
+		Captions:  cfg.playbackCaptions(token, video),
+		ExpiresAt: expiresAt.UnixMilli(),
+	}
+	if localized.Locale != nil {
+		data.Lang = *localized.Locale
+	}
+	if video.ThumbnailURL != nil {
+		data.Poster = *video.ThumbnailURL
+	}
+	cfg.respondWithEmbedPage(w, http.StatusOK, data)
+}
+
+func (cfg *apiConfig) respondWithEmbedMessage(w http.ResponseWriter, code int, msg string) {
+	cfg.respondWithEmbedPage(w, code, embedPageData{Title: "Tubely", Message: msg})
+}
+
+// respondWithEmbedPage renders embedPage. Any site may frame it, but it
+// runs no script but its own, and it sends no Referer so the token in its
+// stream link doesn't leak to storage. Each page holds its own playback
+// token, so it is never cached.
+func (cfg *apiConfig) respondWithEmbedPage(w http.ResponseWriter, code int, data embedPageData) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		log.Printf("Couldn't make script nonce: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	data.Nonce = base64.StdEncoding.EncodeToString(nonce)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'nonce-"+data.Nonce+"'; style-src 'unsafe-inline'; "+
+		"img-src https: http: data: 'self'; media-src https: http: 'self'; frame-ancestors *")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(code)
+	if err := embedPage.Execute(w, data); err != nil {
+		log.Printf("Couldn't render embed page: %v", err)
+	}
+}
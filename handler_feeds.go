@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// channelFeedLimit caps how many of the newest videos a feed lists
+	channelFeedLimit = 50
+	// channelFeedTTL is how long feed readers should wait before fetching
+	// a feed again
+	channelFeedTTL = 30 * time.Minute
+)
+
+// rssFeed is an RSS 2.0 document with Media RSS elements, see
+// https://www.rssboard.org/media-rss.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	MediaNS string     `xml:"xmlns:media,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	AtomLink      rssLink   `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	TTL           int       `xml:"ttl"`
+	Items         []rssItem `xml:"item"`
+}
+
+// rssLink is the feed's own address, which readers use to keep following
+// it.
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string          `xml:"title"`
+	Link        string          `xml:"link"`
+	Description string          `xml:"description"`
+	GUID        rssGUID         `xml:"guid"`
+	PubDate     string          `xml:"pubDate"`
+	Enclosure   rssEnclosure    `xml:"enclosure"`
+	Content     mediaContent    `xml:"media:content"`
+	Thumbnail   *mediaThumbnail `xml:"media:thumbnail"`
+	Keywords    string          `xml:"media:keywords,omitempty"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type mediaContent struct {
+	URL      string `xml:"url,attr"`
+	FileSize int64  `xml:"fileSize,attr,omitempty"`
+	Type     string `xml:"type,attr"`
+	Medium   string `xml:"medium,attr"`
+	Duration int    `xml:"duration,attr,omitempty"`
+	Width    int    `xml:"width,attr,omitempty"`
+	Height   int    `xml:"height,attr,omitempty"`
+	Title    string `xml:"media:title"`
+}
+
+type mediaThumbnail struct {
+	URL    string `xml:"url,attr"`
+	Width  int    `xml:"width,attr,omitempty"`
+	Height int    `xml:"height,attr,omitempty"`
+}
+
+// handlerChannelFeed serves an RSS feed of a user's newest public videos
+// so podcast apps and feed readers can subscribe to their channel. Each
+// item links to the embeddable player and encloses the video file, through
+// handlerVideoEnclosure since feeds outlive presigned URLs.
+func (cfg *apiConfig) handlerChannelFeed(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	videos, err := cfg.db.GetChannelVideos(user.ID, channelFeedLimit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	feedURL := cfg.baseURL + "/api/users/" + user.ID.String() + "/feed.rss"
+	feed := rssFeed{
+		Version: "2.0",
+		MediaNS: "http://search.yahoo.com/mrss/",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       "Tubely channel",
+			Link:        cfg.baseURL,
+			Description: "The newest public videos of a Tubely channel",
+			AtomLink:    rssLink{Href: feedURL, Rel: "self", Type: "application/rss+xml"},
+			TTL:         int(channelFeedTTL.Minutes()),
+			Items:       make([]rssItem, 0, len(videos)),
+		},
+	}
+	for _, video := range videos {
+		feed.Channel.Items = append(feed.Channel.Items, cfg.feedItem(video))
+	}
+	if len(videos) > 0 {
+		feed.Channel.LastBuildDate = videos[0].CreatedAt.UTC().Format(time.RFC1123Z)
+	}
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build feed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(channelFeedTTL.Seconds())))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(append([]byte(xml.Header), data...)); err != nil {
+		log.Printf("Couldn't write feed of user %s: %v", user.ID, err)
+	}
+}
+
+func (cfg *apiConfig) feedItem(video database.Video) rssItem {
+	enclosureURL := cfg.baseURL + "/api/videos/" + video.ID.String() + "/enclosure"
+	item := rssItem{
+		Title:       video.Title,
+		Link:        cfg.embedURL(video.ID),
+		Description: video.Description,
+		GUID:        rssGUID{Value: "urn:uuid:" + video.ID.String()},
+		PubDate:     video.CreatedAt.UTC().Format(time.RFC1123Z),
+		Enclosure:   rssEnclosure{URL: enclosureURL, Type: storedVideoType},
+		Content: mediaContent{
+			URL:    enclosureURL,
+			Type:   storedVideoType,
+			Medium: "video",
+			Title:  video.Title,
+		},
+		Keywords: strings.Join(video.Tags, ", "),
+	}
+	// RSS requires a length; 0 is the convention when it isn't known
+	if video.VideoSize != nil {
+		item.Enclosure.Length = *video.VideoSize
+		item.Content.FileSize = *video.VideoSize
+	}
+	if video.DurationSeconds != nil {
+		item.Content.Duration = int(*video.DurationSeconds + 0.5)
+	}
+	if video.Width != nil && video.Height != nil {
+		item.Content.Width = *video.Width
+		item.Content.Height = *video.Height
+	}
+	if video.ThumbnailURL != nil {
+		item.Thumbnail = &mediaThumbnail{URL: *video.ThumbnailURL}
+		if video.ThumbnailWidth != nil && video.ThumbnailHeight != nil {
+			item.Thumbnail.Width = *video.ThumbnailWidth
+			item.Thumbnail.Height = *video.ThumbnailHeight
+		}
+	}
+	return item
+}
+
+// handlerVideoEnclosure is the lasting link feeds enclose a video with.
+// It redirects anyone who may watch the video without signing in to its
+// file, the way a stream link does for a playback token. Videos whose
+// playback tokens are bound can only be watched in a player.
+func (cfg *apiConfig) handlerVideoEnclosure(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.Visibility == videoVisibilityPrivate {
+		respondWithError(w, http.StatusNotFound, "No video was returned", nil)
+		return
+	}
+	if !cfg.viewableVideo(w, r, principal{}, video) {
+		return
+	}
+	if binding := cfg.playbackBindings[video.Visibility]; binding.IP || binding.Session {
+		respondWithError(w, http.StatusForbidden, "This video can only be watched in a player", nil)
+		return
+	}
+	if !cfg.playableArchive(w, r, video) {
+		return
+	}
+
+	// Feed readers fetch the file once per episode, so each download is
+	// a view
+	if r.Method == http.MethodGet {
+		if err := cfg.db.IncrementVideoViews(video.ID); err != nil {
+			log.Printf("Couldn't count view of video %s: %v", video.ID, err)
+		}
+	}
+	cfg.streamVideo(w, r, video)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// claimTokenHeader carries a guest upload's claim token when uploading to
+// it, since guests have no bearer credential.
+const claimTokenHeader = "X-Claim-Token"
+
+// handlerGuestUploadsCreate opens an upload slot for someone without an
+// account. The video stays private and ownerless until it is claimed.
+func (cfg *apiConfig) handlerGuestUploadsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title        string `json:"title"`
+		Description  string `json:"description"`
+		CaptchaToken string `json:"captcha_token"`
+	}
+	type response struct {
+		database.GuestUpload
+		ClaimToken string `json:"claim_token"`
+	}
+
+	if !cfg.guestUploads {
+		respondWithError(w, http.StatusNotFound, "Guest uploads are disabled", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Title == "" {
+		respondWithError(w, http.StatusBadRequest, "Title is required", nil)
+		return
+	}
+
+	if !cfg.verifyCaptcha(w, r, params.CaptchaToken) {
+		return
+	}
+
+	claimToken, err := auth.MakeClaimToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create claim token", err)
+		return
+	}
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:       params.Title,
+		Description: params.Description,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+	video.Visibility = videoVisibilityPrivate
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	upload, err := cfg.db.CreateGuestUpload(video.ID, auth.HashToken(claimToken), time.Now().Add(cfg.guestUploadTTL))
+	if err != nil {
+		cfg.db.DeleteVideo(video.ID)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create guest upload", err)
+		return
+	}
+
+	// The claim token is only ever returned here
+	respondWithJSON(w, http.StatusCreated, response{
+		GuestUpload: upload,
+		ClaimToken:  claimToken,
+	})
+}
+
+func (cfg *apiConfig) handlerGuestUploadVideo(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	claimToken := r.Header.Get(claimTokenHeader)
+	if claimToken == "" {
+		respondWithError(w, http.StatusUnauthorized, "Missing claim token", nil)
+		return
+	}
+	slotVideoID, err := cfg.db.GetOpenGuestUpload(auth.HashToken(claimToken))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check claim token", err)
+		return
+	}
+	if slotVideoID == uuid.Nil || slotVideoID != videoID {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired claim token", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	cfg.ingestVideo(w, r, uuid.Nil, video)
+}
+
+// handlerGuestUploadsClaim attaches a guest upload to the caller's account.
+func (cfg *apiConfig) handlerGuestUploadsClaim(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ClaimToken string `json:"claim_token"`
+	}
+
+	p := contextPrincipal(r)
+	if !p.can(auth.PermVideoCreate) {
+		respondWithError(w, http.StatusForbidden, "Your role doesn't allow creating videos", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.ClaimToken == "" {
+		respondWithError(w, http.StatusBadRequest, "claim_token is required", nil)
+		return
+	}
+
+	quota, err := cfg.storageQuota(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage quota", err)
+		return
+	}
+	videoID, err := cfg.db.ClaimGuestUpload(auth.HashToken(params.ClaimToken), p.UserID, quota)
+	if errors.Is(err, database.ErrStorageQuotaExceeded) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Claiming this upload would exceed your storage quota", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't claim upload", err)
+		return
+	}
+	if videoID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Invalid, expired or already claimed token", nil)
+		return
+	}
+
+	cfg.audit(r, p.UserID, auditVideoClaimed, "video", videoID.String(), nil)
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
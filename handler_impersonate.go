@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+const (
+	// impersonationDefaultTTL and impersonationMaxTTL bound how long an
+	// impersonation token lasts. There is no refresh token; support asks
+	// for a new one.
+	impersonationDefaultTTL = 15 * time.Minute
+	impersonationMaxTTL     = time.Hour
+)
+
+// handlerAdminImpersonate issues a short-lived access token acting as
+// another user, so support can reproduce problems without their password.
+// The token carries the admin's ID, only has the video scopes, and shows
+// up in the user's session list where they can revoke it.
+func (cfg *apiConfig) handlerAdminImpersonate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason    string `json:"reason"`
+		ExpiresIn string `json:"expires_in"`
+	}
+	type response struct {
+		Token     string    `json:"token"`
+		SessionID uuid.UUID `json:"session_id"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Reason == "" {
+		respondWithError(w, http.StatusBadRequest, "A reason is required", nil)
+		return
+	}
+	ttl := impersonationDefaultTTL
+	if params.ExpiresIn != "" {
+		ttl, err = time.ParseDuration(params.ExpiresIn)
+		if err != nil || ttl <= 0 || ttl > impersonationMaxTTL {
+			respondWithError(w, http.StatusBadRequest, "expires_in must be a duration of at most "+impersonationMaxTTL.String(), err)
+			return
+		}
+	}
+
+	p := contextPrincipal(r)
+	if userID == p.UserID {
+		respondWithError(w, http.StatusBadRequest, "You can't impersonate yourself", nil)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	// Impersonating staff would let an admin borrow their privileges
+	if auth.RoleHasPermission(user.Role, auth.PermUserManage) || auth.RoleHasPermission(user.Role, auth.PermVideoTakedown) {
+		respondWithError(w, http.StatusForbidden, "Staff accounts can't be impersonated", nil)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(ttl)
+	session, err := cfg.db.CreateSession(userID, "Support impersonation", clientIP(r), expiresAt)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+	token, err := auth.MakeImpersonationJWT(userID, session.ID, p.UserID, cfg.jwtKeys, ttl)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create impersonation token", err)
+		return
+	}
+
+	cfg.audit(r, p.UserID, auditUserImpersonated, "user", userID.String(), map[string]string{
+		"reason":     params.Reason,
+		"session_id": session.ID.String(),
+		"expires_at": expiresAt.Format(time.RFC3339),
+	})
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Token:     token,
+		SessionID: session.ID,
+		ExpiresAt: expiresAt,
+	})
+}
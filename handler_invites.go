@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerInvitesCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MaxUses int `json:"max_uses"`
+		// ExpiresIn is a Go duration such as "72h"; empty never expires
+		ExpiresIn string `json:"expires_in"`
+	}
+	type response struct {
+		database.InviteCode
+		Code string `json:"code"`
+	}
+
+	p := contextPrincipal(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.MaxUses == 0 {
+		params.MaxUses = 1
+	}
+	if params.MaxUses < 0 {
+		respondWithError(w, http.StatusBadRequest, "max_uses must be positive", nil)
+		return
+	}
+	var expiresAt *time.Time
+	if params.ExpiresIn != "" {
+		d, err := time.ParseDuration(params.ExpiresIn)
+		if err != nil || d <= 0 {
+			respondWithError(w, http.StatusBadRequest, "expires_in must be a positive duration", err)
+			return
+		}
+		t := time.Now().UTC().Add(d)
+		expiresAt = &t
+	}
+
+	code, err := auth.MakeInviteCode()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create invite code", err)
+		return
+	}
+
+	invite, err := cfg.db.CreateInviteCode(database.CreateInviteCodeParams{
+		CreatedBy: p.UserID,
+		CodeHash:  auth.HashToken(code),
+		Prefix:    auth.InviteCodeDisplayPrefix(code),
+		MaxUses:   params.MaxUses,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save invite code", err)
+		return
+	}
+
+	cfg.audit(r, p.UserID, auditInviteCreated, "invite", invite.ID.String(), map[string]string{"max_uses": strconv.Itoa(invite.MaxUses)})
+
+	// Like API keys, the plaintext code is only ever returned here
+	respondWithJSON(w, http.StatusCreated, response{
+		InviteCode: invite,
+		Code:       code,
+	})
+}
+
+func (cfg *apiConfig) handlerInvitesList(w http.ResponseWriter, r *http.Request) {
+	invites, err := cfg.db.GetInviteCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get invite codes", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, invites)
+}
+
+func (cfg *apiConfig) handlerInvitesRevoke(w http.ResponseWriter, r *http.Request) {
+	inviteID, err := uuid.Parse(r.PathValue("inviteID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	invite, err := cfg.db.GetInviteCode(inviteID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get invite code", err)
+		return
+	}
+	if invite.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Invite code not found", nil)
+		return
+	}
+
+	err = cfg.db.RevokeInviteCode(inviteID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke invite code", err)
+		return
+	}
+	cfg.audit(r, contextPrincipal(r).UserID, auditInviteRevoked, "invite", inviteID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requiresInvite reports whether signing up with email needs an invite
+// code. ADMIN_EMAILS can always sign up so a fresh deployment can be
+// bootstrapped.
+func (cfg *apiConfig) requiresInvite(email string) bool {
+	return cfg.inviteOnly && !slices.Contains(cfg.adminEmails, email)
+}
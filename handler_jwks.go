@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// loadJWTKeys builds the key set from configuration. JWT_SECRET signs
+// tokens unless JWT_SIGNING_KEY_FILE provides an RSA or Ed25519 key, in
+// which case the secret is kept for verification only so existing
+// sessions survive the switch. Retired keys stay verifiable through
+// JWT_PREVIOUS_SECRETS and JWT_VERIFY_KEY_FILES.
+func loadJWTKeys(secret string) (*auth.KeySet, error) {
+	active := auth.NewHMACKey(secret)
+	var verifyOnly []*auth.SigningKey
+
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		key, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !key.CanSign() {
+			return nil, fmt.Errorf("JWT_SIGNING_KEY_FILE %s does not contain a private key", path)
+		}
+		verifyOnly = append(verifyOnly, active)
+		active = key
+	}
+
+	for _, previous := range envList("JWT_PREVIOUS_SECRETS", nil) {
+		verifyOnly = append(verifyOnly, auth.NewHMACKey(previous))
+	}
+	for _, path := range envList("JWT_VERIFY_KEY_FILES", nil) {
+		key, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		verifyOnly = append(verifyOnly, key)
+	}
+
+	return auth.NewKeySet(active, verifyOnly...)
+}
+
+func readKeyFile(path string) (*auth.SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := auth.ParseKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.jwtKeys.JWKS())
+}
@@ -2,22 +2,41 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
+// unknownUserHash is a hash of a random password, checked against when
+// there is no real one so every failed login costs the same.
+var unknownUserHash = sync.OnceValue(func() string {
+	hash, err := auth.HashPassword(uuid.NewString())
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+type loginResponse struct {
+	database.User
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Password string `json:"password"`
 		Email    string `json:"email"`
-	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+		// Code is the TOTP or recovery code of users with two-factor
+		// authentication, for clients that collect it up front
+		Code         string `json:"code"`
+		CaptchaToken string `json:"captcha_token"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -28,47 +47,106 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if cfg.loginFailures.count(emailFailureKey(params.Email)) >= cfg.captchaLoginAfterFailures {
+		if !cfg.verifyCaptcha(w, r, params.CaptchaToken) {
+			return
+		}
+	}
+
 	user, err := cfg.db.GetUserByEmail(params.Email)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, user.Password)
+	// Unknown emails and accounts without a password fail as slowly as
+	// wrong passwords do, and count the same, so neither gives away which
+	// emails are registered
+	hash := user.Password
+	if hash == "" {
+		hash = unknownUserHash()
+	}
+	err = auth.CheckPasswordHash(params.Password, hash)
+	if err == nil && user.Password == "" {
+		err = errors.New("account has no password")
+	}
 	if err != nil {
+		cfg.loginFailures.record(emailFailureKey(params.Email))
+		cfg.audit(r, uuid.Nil, auditLoginFailed, "user", params.Email, map[string]string{"reason": "password"})
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
+	totp, err := cfg.db.GetUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if totp.Enabled {
+		if params.Code == "" {
+			cfg.respondTwoFactorRequired(w, user.ID)
+			return
+		}
+		if !cfg.loginSecondFactor(w, r, user, totp, params.Code, "") {
+			return
+		}
+	}
+
+	// Only a complete login clears the count, so wrong second factors
+	// aren't forgotten by getting the password right again
+	cfg.loginFailures.reset(emailFailureKey(params.Email))
+	cfg.respondWithSession(w, r, user)
+}
+
+// respondWithSession completes a login by starting a session for user.
+func (cfg *apiConfig) respondWithSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	accessToken, refreshToken, err := cfg.issueTokens(r, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, loginResponse{
+		User:         user,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	})
+}
+
+// issueTokens starts a session for userID on the requesting device and
+// returns its access JWT and refresh token.
+func (cfg *apiConfig) issueTokens(r *http.Request, userID uuid.UUID) (string, string, error) {
+	expiresAt := time.Now().UTC().Add(cfg.tokenTTLs.refresh)
+	session, err := cfg.db.CreateSession(userID, r.UserAgent(), clientIP(r), expiresAt)
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't create session: %w", err)
+	}
+
 	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour*24*30,
+		userID,
+		session.ID,
+		cfg.jwtKeys,
+		cfg.tokenTTLs.loginAccess,
 	)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
-		return
+		return "", "", fmt.Errorf("couldn't create access JWT: %w", err)
 	}
 
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
-		return
+		return "", "", fmt.Errorf("couldn't create refresh token: %w", err)
 	}
 
 	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
-		UserID:    user.ID,
+		UserID:    userID,
+		SessionID: &session.ID,
 		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		ExpiresAt: expiresAt,
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
-		return
+		return "", "", fmt.Errorf("couldn't save refresh token: %w", err)
 	}
 
-	respondWithJSON(w, http.StatusOK, response{
-		User:         user,
-		Token:        accessToken,
-		RefreshToken: refreshToken,
-	})
+	cfg.audit(r, userID, auditLoginSucceeded, "session", session.ID.String(), nil)
+	return accessToken, refreshToken, nil
 }
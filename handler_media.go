@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// handlerLocalMedia serves objects from the local storage backend, standing
+// in for the CDN. Like the CDN, ordinary objects are public; quarantined
+// ones are only reachable through a presigned link.
+func (cfg *apiConfig) handlerLocalMedia(w http.ResponseWriter, r *http.Request) {
+	store, ok := cfg.store.(storage.LocalStore)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	key := r.PathValue("key")
+
+	signature := r.URL.Query().Get("signature")
+	if signature != "" || strings.HasPrefix(key, "quarantine/") {
+		err := store.Verify(key, r.URL.Query().Get("expires"), signature, time.Now())
+		if err != nil {
+			respondWithError(w, http.StatusForbidden, "Invalid or expired media link", err)
+			return
+		}
+	}
+
+	http.ServeFile(w, r, store.Path(key))
+}
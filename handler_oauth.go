@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oauth"
+	"github.com/google/uuid"
+)
+
+const (
+	oauthStateCookie = "tubely_oauth_state"
+	oauthLinkCookie  = "tubely_oauth_link"
+	oauthStateTTL    = 10 * time.Minute
+)
+
+// errIdentityNeedsLink means an identity's verified email belongs to a
+// password account. The address was never verified for the account, so
+// whoever signed up with it might not own it: its owner has to sign in
+// and link the identity explicitly.
+var errIdentityNeedsLink = errors.New("an account with this email already exists")
+
+func (cfg *apiConfig) handlerOAuthProviders(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(cfg.oauthProviders))
+	for name := range cfg.oauthProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	respondWithJSON(w, http.StatusOK, names)
+}
+
+func (cfg *apiConfig) handlerOAuthLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oauthProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider", nil)
+		return
+	}
+
+	authURL, _, err := cfg.startOAuthLogin(w, provider)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+	// A plain login abandons any linking started earlier
+	http.SetCookie(w, &http.Cookie{
+		Name:   oauthLinkCookie,
+		Path:   "/api/oauth/" + provider.Name,
+		MaxAge: -1,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handlerOAuthLink starts linking an identity provider account to the
+// caller's account. It returns the provider's login URL rather than
+// redirecting, since the browser has to send the caller's credentials to
+// start it; the app navigates there itself.
+func (cfg *apiConfig) handlerOAuthLink(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	provider, ok := cfg.oauthProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider", nil)
+		return
+	}
+
+	authURL, state, err := cfg.startOAuthLogin(w, provider)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start linking", err)
+		return
+	}
+	linkToken, err := auth.MakeOAuthLinkToken(p.UserID, state, cfg.jwtKeys, oauthStateTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start linking", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthLinkCookie,
+		Value:    linkToken,
+		Path:     "/api/oauth/" + provider.Name,
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	type response struct {
+		URL string `json:"url"`
+	}
+	respondWithJSON(w, http.StatusOK, response{URL: authURL})
+}
+
+// startOAuthLogin sets the cookie carrying a new login flow's state and
+// returns the provider URL that starts it, along with the state.
+func (cfg *apiConfig) startOAuthLogin(w http.ResponseWriter, provider *oauth.Provider) (string, string, error) {
+	state, err := oauth.RandomToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oauth.RandomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	// The state and PKCE verifier live in a short-lived cookie scoped to
+	// the callback, so no server-side storage is needed between redirects
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state + "." + verifier,
+		Path:     "/api/oauth/" + provider.Name,
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return provider.AuthCodeURL(state, verifier), state, nil
+}
+
+func (cfg *apiConfig) handlerOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oauthProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider", nil)
+		return
+	}
+
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		respondWithError(w, http.StatusUnauthorized, "Identity provider denied login: "+errCode, nil)
+		return
+	}
+
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Missing login state", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   oauthStateCookie,
+		Path:   "/api/oauth/" + provider.Name,
+		MaxAge: -1,
+	})
+	state, verifier, ok := strings.Cut(cookie.Value, ".")
+	if !ok || state == "" || state != r.URL.Query().Get("state") {
+		respondWithError(w, http.StatusBadRequest, "Invalid login state", nil)
+		return
+	}
+	linkUserID := uuid.Nil
+	if linkCookie, err := r.Cookie(oauthLinkCookie); err == nil {
+		http.SetCookie(w, &http.Cookie{
+			Name:   oauthLinkCookie,
+			Path:   "/api/oauth/" + provider.Name,
+			MaxAge: -1,
+		})
+		linkUserID, err = auth.ValidateOAuthLinkToken(linkCookie.Value, state, cfg.jwtKeys)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid link state", err)
+			return
+		}
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing authorization code", nil)
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), code, verifier)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't complete login", err)
+		return
+	}
+
+	if linkUserID != uuid.Nil {
+		if err := cfg.linkIdentity(provider.Name, identity, linkUserID); err != nil {
+			respondWithError(w, http.StatusConflict, "Couldn't link account", err)
+			return
+		}
+		http.Redirect(w, r, "/app/#"+url.Values{"linked": {provider.Name}}.Encode(), http.StatusFound)
+		return
+	}
+
+	user, err := cfg.userForIdentity(provider.Name, identity)
+	if errors.Is(err, errIdentityNeedsLink) {
+		msg := fmt.Sprintf("An account with this email already exists. Log in with your password and link %s to it from your account.", provider.Name)
+		respondWithError(w, http.StatusConflict, msg, err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't complete login", err)
+		return
+	}
+
+	// Enrolled users still need their second factor; the app collects it
+	// and finishes the login through /api/login/2fa
+	totp, err := cfg.db.GetUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if totp.Enabled {
+		mfaToken, err := auth.MakeMFAToken(user.ID, cfg.jwtKeys, mfaTokenTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create login challenge", err)
+			return
+		}
+		http.Redirect(w, r, "/app/#"+url.Values{"mfa_token": {mfaToken}}.Encode(), http.StatusFound)
+		return
+	}
+
+	accessToken, refreshToken, err := cfg.issueTokens(r, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+
+	// Tokens go in the fragment so they never reach server logs or referers
+	fragment := url.Values{
+		"token":         {accessToken},
+		"refresh_token": {refreshToken},
+	}
+	http.Redirect(w, r, "/app/#"+fragment.Encode(), http.StatusFound)
+}
+
+// userForIdentity returns the local user linked to an external identity.
+// Unknown identities are linked to an existing passwordless account with
+// the same verified email, or provisioned as a new passwordless user
+// unless registration is invite-only. Password accounts never verified
+// their email, so they return errIdentityNeedsLink instead.
+func (cfg *apiConfig) userForIdentity(providerName string, identity oauth.Identity) (database.User, error) {
+	link, err := cfg.db.GetUserIdentity(providerName, identity.Subject)
+	if err != nil {
+		return database.User{}, err
+	}
+	if link.Subject != "" {
+		user, err := cfg.db.GetUser(link.UserID)
+		if err != nil {
+			return database.User{}, err
+		}
+		if user == nil {
+			return database.User{}, fmt.Errorf("linked user %s no longer exists", link.UserID)
+		}
+		return *user, nil
+	}
+
+	// Linking on an unverified address would let anyone claim an account
+	if identity.Email == "" || !identity.EmailVerified {
+		return database.User{}, fmt.Errorf("%s account has no verified email address", providerName)
+	}
+
+	user, err := cfg.db.GetUserByEmail(identity.Email)
+	if err != nil {
+		return database.User{}, err
+	}
+	if user.Password != "" {
+		return database.User{}, errIdentityNeedsLink
+	}
+	if user.Email == "" {
+		// There's nowhere to enter an invite code in the provider flow, so
+		// invite-only deployments only link existing accounts
+		if cfg.requiresInvite(identity.Email) {
+			return database.User{}, fmt.Errorf("registration for %s requires an invite", identity.Email)
+		}
+		// An empty password hash never matches, so the account can only be
+		// reached through the identity provider
+		created, err := cfg.db.CreateUser(database.CreateUserParams{
+			Email:    identity.Email,
+			Password: "",
+			Role:     cfg.initialRole(identity.Email, identity.EmailVerified),
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		user = *created
+	}
+
+	err = cfg.db.CreateUserIdentity(database.UserIdentity{
+		Provider: providerName,
+		Subject:  identity.Subject,
+		UserID:   user.ID,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
+
+// linkIdentity links an external identity to userID, who started linking
+// it while signed in. The identity's email is only recorded if the
+// provider verified it, since recorded emails count as verified.
+func (cfg *apiConfig) linkIdentity(providerName string, identity oauth.Identity, userID uuid.UUID) error {
+	link, err := cfg.db.GetUserIdentity(providerName, identity.Subject)
+	if err != nil {
+		return err
+	}
+	if link.Subject != "" {
+		if link.UserID != userID {
+			return fmt.Errorf("%s account is linked to another user", providerName)
+		}
+		return nil
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errUnknownUser
+	}
+
+	email := ""
+	if identity.EmailVerified {
+		email = identity.Email
+	}
+	return cfg.db.CreateUserIdentity(database.UserIdentity{
+		Provider: providerName,
+		Subject:  identity.Subject,
+		UserID:   userID,
+		Email:    email,
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oauth"
+)
+
+func TestUserForIdentity(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string // of an existing account with the identity's email
+		existing bool
+		verified bool
+		wantErr  bool
+		wantLink bool // whether the error asks for an explicit link
+		wantNew  bool
+	}{
+		{name: "new user", verified: true, wantNew: true},
+		{name: "passwordless account is linked", existing: true, verified: true},
+		{name: "password account needs explicit link", existing: true, password: "hash", verified: true, wantErr: true, wantLink: true},
+		{name: "unverified email", existing: true, verified: false, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			var existing *database.User
+			if tt.existing {
+				var err error
+				existing, err = cfg.db.CreateUser(database.CreateUserParams{
+					Email:    "victim@example.com",
+					Password: tt.password,
+					Role:     auth.DefaultRole,
+				})
+				if err != nil {
+					t.Fatalf("CreateUser() error = %v", err)
+				}
+			}
+
+			identity := oauth.Identity{Subject: "42", Email: "victim@example.com", EmailVerified: tt.verified}
+			user, err := cfg.userForIdentity("google", identity)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("userForIdentity() = %s, want an error", user.ID)
+				}
+				if errors.Is(err, errIdentityNeedsLink) != tt.wantLink {
+					t.Fatalf("userForIdentity() error = %v, asks for a link = %v, want %v", err, !tt.wantLink, tt.wantLink)
+				}
+				link, err := cfg.db.GetUserIdentity("google", identity.Subject)
+				if err != nil {
+					t.Fatalf("GetUserIdentity() error = %v", err)
+				}
+				if link.Subject != "" {
+					t.Errorf("identity was linked to %s", link.UserID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("userForIdentity() error = %v", err)
+			}
+			if !tt.wantNew && user.ID != existing.ID {
+				t.Errorf("userForIdentity() = %s, want existing account %s", user.ID, existing.ID)
+			}
+			link, err := cfg.db.GetUserIdentity("google", identity.Subject)
+			if err != nil {
+				t.Fatalf("GetUserIdentity() error = %v", err)
+			}
+			if link.UserID != user.ID {
+				t.Errorf("identity linked to %s, want %s", link.UserID, user.ID)
+			}
+		})
+	}
+}
+
+func TestLinkIdentity(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := newTestUser(t, cfg)
+	other := newTestUser(t, cfg)
+	identity := oauth.Identity{Subject: "42", Email: "unverified@example.com"}
+
+	if err := cfg.linkIdentity("google", identity, owner.ID); err != nil {
+		t.Fatalf("linkIdentity() error = %v", err)
+	}
+	link, err := cfg.db.GetUserIdentity("google", identity.Subject)
+	if err != nil {
+		t.Fatalf("GetUserIdentity() error = %v", err)
+	}
+	if link.UserID != owner.ID {
+		t.Errorf("identity linked to %s, want %s", link.UserID, owner.ID)
+	}
+	if link.Email != "" {
+		t.Errorf("recorded unverified email %q", link.Email)
+	}
+
+	if err := cfg.linkIdentity("google", identity, owner.ID); err != nil {
+		t.Errorf("linking again to the same user: error = %v", err)
+	}
+	if err := cfg.linkIdentity("google", identity, other.ID); err == nil {
+		t.Error("linking to another user succeeded, want an error")
+	}
+}
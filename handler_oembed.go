@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// oEmbedWidth and oEmbedHeight size the player when the video's own
+	// size isn't known
+	oEmbedWidth  = 640
+	oEmbedHeight = 360
+	// oEmbedCacheAge is how long consumers may cache a response, in
+	// seconds
+	oEmbedCacheAge = 3600
+)
+
+// oEmbedResponse is an oEmbed "video" response, see https://oembed.com.
+type oEmbedResponse struct {
+	Type            string `json:"type"`
+	Version         string `json:"version"`
+	Title           string `json:"title"`
+	AuthorName      string `json:"author_name,omitempty"`
+	ProviderName    string `json:"provider_name"`
+	ProviderURL     string `json:"provider_url"`
+	CacheAge        int    `json:"cache_age"`
+	ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+	HTML            string `json:"html"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+}
+
+// handlerOEmbed describes the video at ?url so chat apps and CMSes can
+// unfurl links to it, with an iframe of the embeddable player sized to
+// fit ?maxwidth and ?maxheight. Only videos anyone with the link may
+// watch are described; for the rest it responds as if they didn't exist.
+// JSON is the only format served.
+func (cfg *apiConfig) handlerOEmbed(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if format := q.Get("format"); format != "" && format != "json" {
+		respondWithError(w, http.StatusNotImplemented, "format must be json", nil)
+		return
+	}
+	maxWidth, err := oEmbedMaxSize(q, "maxwidth")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	maxHeight, err := oEmbedMaxSize(q, "maxheight")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	videoID, ok := cfg.videoIDFromURL(q.Get("url"))
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "url isn't a link to a video", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// Described as an anonymous viewer sees it, since the response is
+	// shown to whoever sees the unfurled link. Regions aren't checked, as
+	// the consumer fetching it may be anywhere; the player checks them
+	if video.ID == uuid.Nil || video.Status != database.VideoStatusReady || video.VideoURL == nil ||
+		!cfg.canViewVideo(principal{}, video) ||
+		video.ModerationStatus != nil && *video.ModerationStatus == moderationStatusRemoved {
+		respondWithError(w, http.StatusNotFound, "No video was returned", nil)
+		return
+	}
+
+	localized := localizeVideo(r, video)
+	width, height := oEmbedSize(video, maxWidth, maxHeight)
+	embed := oEmbedResponse{
+		Type:         "video",
+		Version:      "1.0",
+		Title:        localized.Title,
+		ProviderName: "Tubely",
+		ProviderURL:  cfg.baseURL,
+		CacheAge:     oEmbedCacheAge,
+		HTML: fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" title="%s" frameborder="0" allow="autoplay; fullscreen; picture-in-picture" allowfullscreen></iframe>`,
+			html.EscapeString(cfg.embedURL(video.ID)), width, height, html.EscapeString(localized.Title)),
+		Width:  width,
+		Height: height,
+	}
+	if video.OrgID != nil {
+		org, err := cfg.db.GetOrganization(*video.OrgID)
+		if err != nil {
+			log.Printf("Couldn't get organization %s of video %s: %v", *video.OrgID, video.ID, err)
+		}
+		embed.AuthorName = org.Name
+	}
+	if video.ThumbnailURL != nil {
+		embed.ThumbnailURL = *video.ThumbnailURL
+		if video.ThumbnailWidth != nil && video.ThumbnailHeight != nil {
+			embed.ThumbnailWidth = *video.ThumbnailWidth
+			embed.ThumbnailHeight = *video.ThumbnailHeight
+		}
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", oEmbedCacheAge))
+	w.Header().Set("Vary", "Accept-Language")
+	respondWithJSON(w, http.StatusOK, embed)
+}
+
+// embedURL is the address of the embeddable player for a video.
+func (cfg *apiConfig) embedURL(videoID uuid.UUID) string {
+	return cfg.baseURL + "/embed/" + videoID.String()
+}
+
+// videoIDFromURL returns the video a link on this server points at: its
+// embeddable player or its API resource.
+func (cfg *apiConfig) videoIDFromURL(raw string) (uuid.UUID, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || raw == "" {
+		return uuid.Nil, false
+	}
+	base, err := url.Parse(cfg.baseURL)
+	if err != nil || !strings.EqualFold(u.Host, base.Host) {
+		return uuid.Nil, false
+	}
+	path := strings.TrimPrefix(u.Path, strings.TrimSuffix(base.Path, "/"))
+	for _, prefix := range []string{"/embed/", "/api/videos/"} {
+		if id, ok := strings.CutPrefix(path, prefix); ok {
+			videoID, err := uuid.Parse(strings.TrimSuffix(id, "/"))
+			return videoID, err == nil
+		}
+	}
+	return uuid.Nil, false
+}
+
+func oEmbedMaxSize(q url.Values, name string) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive number of pixels", name)
+	}
+	return n, nil
+}
+
+// oEmbedSize fits the player to the video's aspect ratio within the
+// largest size the consumer allows, 0 meaning no limit.
+func oEmbedSize(video database.Video, maxWidth, maxHeight int) (int, int) {
+	width, height := oEmbedWidth, oEmbedHeight
+	if video.Width != nil && video.Height != nil && *video.Width > 0 && *video.Height > 0 {
+		// Portrait videos keep the default height rather than width
+		if *video.Height > *video.Width {
+			width = oEmbedHeight * *video.Width / *video.Height
+		} else {
+			height = oEmbedWidth * *video.Height / *video.Width
+		}
+	}
+	if maxWidth > 0 && width > maxWidth {
+		height = height * maxWidth / width
+		width = maxWidth
+	}
+	if maxHeight > 0 && height > maxHeight {
+		width = width * maxHeight / height
+		height = maxHeight
+	}
+	return max(width, 1), max(height, 1)
+}
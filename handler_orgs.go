@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var orgRoleRank = map[string]int{
+	database.OrgRoleViewer: 1,
+	database.OrgRoleEditor: 2,
+	database.OrgRoleOwner:  3,
+}
+
+// orgRoleAtLeast reports whether the caller is a member of orgID with a
+// role at least as privileged as min.
+func (cfg *apiConfig) orgRoleAtLeast(p principal, orgID uuid.UUID, min string) bool {
+	if p.UserID == uuid.Nil {
+		return false
+	}
+	membership, err := cfg.db.GetOrgMembership(orgID, p.UserID)
+	if err != nil {
+		log.Printf("Couldn't look up membership of user %s in organization %s: %v", p.UserID, orgID, err)
+		return false
+	}
+	rank, ok := orgRoleRank[membership.Role]
+	return ok && rank >= orgRoleRank[min]
+}
+
+func (cfg *apiConfig) handlerOrgsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	p := contextPrincipal(r)
+	if !p.can(auth.PermVideoCreate) {
+		respondWithError(w, http.StatusForbidden, "Your role doesn't allow creating organizations", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required", nil)
+		return
+	}
+
+	org, err := cfg.db.CreateOrganization(params.Name, p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create organization", err)
+		return
+	}
+	org.Role = database.OrgRoleOwner
+	respondWithJSON(w, http.StatusCreated, org)
+}
+
+func (cfg *apiConfig) handlerOrgsList(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+
+	orgs, err := cfg.db.GetOrganizationsForUser(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve organizations", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, orgs)
+}
+
+func (cfg *apiConfig) handlerOrgMembersList(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	org, ok := cfg.memberOrg(w, r, p, database.OrgRoleViewer)
+	if !ok {
+		return
+	}
+
+	memberships, err := cfg.db.GetOrgMemberships(org.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, memberships)
+}
+
+func (cfg *apiConfig) handlerOrgMembersPut(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	p := contextPrincipal(r)
+	org, ok := cfg.memberOrg(w, r, p, database.OrgRoleOwner)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if _, ok := orgRoleRank[params.Role]; !ok {
+		respondWithError(w, http.StatusBadRequest, "role must be owner, editor or viewer", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No user with that email", nil)
+		return
+	}
+
+	if params.Role != database.OrgRoleOwner && !cfg.keepsAnOwner(w, org.ID, user.ID) {
+		return
+	}
+
+	err = cfg.db.PutOrgMembership(org.ID, user.ID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save membership", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditOrgMemberChanged, "org", org.ID.String(), map[string]string{
+		"user_id": user.ID.String(),
+		"role":    params.Role,
+	})
+
+	membership, err := cfg.db.GetOrgMembership(org.ID, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve membership", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, membership)
+}
+
+func (cfg *apiConfig) handlerOrgMembersDelete(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	// Members may always leave; removing others takes an owner
+	minRole := database.OrgRoleOwner
+	if userID == p.UserID {
+		minRole = database.OrgRoleViewer
+	}
+	org, ok := cfg.memberOrg(w, r, p, minRole)
+	if !ok {
+		return
+	}
+	if !cfg.keepsAnOwner(w, org.ID, userID) {
+		return
+	}
+
+	err = cfg.db.DeleteOrgMembership(org.ID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove member", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditOrgMemberRemoved, "org", org.ID.String(), map[string]string{"user_id": userID.String()})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerOrgVideosList(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	org, ok := cfg.memberOrg(w, r, p, database.OrgRoleViewer)
+	if !ok {
+		return
+	}
+
+	videos, err := cfg.db.GetOrgVideos(org.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+// memberOrg loads the organization named in the path and checks that p is
+// a member with at least minRole, writing an error response and returning
+// false otherwise. Non-members get a 404 so organizations can't be probed.
+func (cfg *apiConfig) memberOrg(w http.ResponseWriter, r *http.Request, p principal, minRole string) (database.Organization, bool) {
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Organization{}, false
+	}
+
+	org, err := cfg.db.GetOrganization(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return database.Organization{}, false
+	}
+	isAdmin := p.can(auth.PermVideoManageAny)
+	if org.ID == uuid.Nil || (!isAdmin && !cfg.orgRoleAtLeast(p, orgID, database.OrgRoleViewer)) {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return database.Organization{}, false
+	}
+	if !isAdmin && !cfg.orgRoleAtLeast(p, orgID, minRole) {
+		respondWithError(w, http.StatusForbidden, "Your organization role doesn't allow this action", nil)
+		return database.Organization{}, false
+	}
+	return org, true
+}
+
+// keepsAnOwner checks that demoting or removing userID would not leave the
+// organization without an owner.
+func (cfg *apiConfig) keepsAnOwner(w http.ResponseWriter, orgID, userID uuid.UUID) bool {
+	membership, err := cfg.db.GetOrgMembership(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get membership", err)
+		return false
+	}
+	if membership.Role != database.OrgRoleOwner {
+		return true
+	}
+	owners, err := cfg.db.CountOrgOwners(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count owners", err)
+		return false
+	}
+	if owners <= 1 {
+		respondWithError(w, http.StatusConflict, "An organization must keep at least one owner", nil)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+// handlerPasswordResetRequest emails a reset link. It responds the same way
+// whether or not the email belongs to an account, so it can't be used to
+// discover which addresses are registered.
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID != uuid.Nil {
+		token, err := auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create reset token", err)
+			return
+		}
+		err = cfg.db.CreatePasswordResetToken(auth.HashToken(token), user.ID, time.Now().Add(cfg.passwordResetTTL))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save reset token", err)
+			return
+		}
+
+		// Sent in the background so response timing doesn't reveal
+		// whether the account exists
+		msg := mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your Tubely password",
+			Body: fmt.Sprintf("Someone asked to reset the password for your Tubely account.\n\n"+
+				"To choose a new password, open this link within %s:\n%s/app/#reset_token=%s\n\n"+
+				"If this wasn't you, you can ignore this email.\n",
+				cfg.passwordResetTTL, cfg.baseURL, token),
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := cfg.mailer.Send(ctx, msg); err != nil {
+				log.Printf("Couldn't send password reset email to user %s: %v", user.ID, err)
+			}
+		}()
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Token == "" || params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Token and password are required", nil)
+		return
+	}
+
+	// Hash before consuming the token so a bcrypt failure doesn't burn it
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	userID, err := cfg.db.ConsumePasswordResetToken(auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check reset token", err)
+		return
+	}
+	if userID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Reset link is invalid or has expired", nil)
+		return
+	}
+
+	err = cfg.db.UpdateUserPassword(userID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+
+	cfg.audit(r, uuid.Nil, auditPasswordReset, "user", userID.String(), nil)
+
+	// Whoever knew the old password shouldn't stay signed in
+	err = cfg.revokeAllSessions(userID)
+	if err != nil {
+		log.Printf("Couldn't revoke sessions for user %s after password reset: %v", userID, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
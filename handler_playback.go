@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	// playbackTokenTTL is how long a player may keep requesting playback
+	// URLs before it has to get a new token
+	playbackTokenTTL = 10 * time.Minute
+	// playbackURLTTL is how long a presigned video URL stays valid unless
+	// the caller asks for something else with expires_in
+	playbackURLTTL = 15 * time.Minute
+	// playbackURLMinTTL is the shortest expires_in a caller may ask for
+	playbackURLMinTTL = time.Minute
+	// playbackStreamURLTTL is how long the presigned URL a bound stream
+	// link redirects to stays valid. It only has to outlive the redirect.
+	playbackStreamURLTTL = time.Minute
+)
+
+// playbackBinding says what playback tokens for videos of one visibility
+// level are tied to. Bound tokens stop working for anyone else, so a
+// shared link is useless outside the viewer's network or session. Tokens
+// bound to a session are only redeemed along with an access token for
+// it, so players have to send their credentials when fetching the video.
+type playbackBinding struct {
+	IP      bool
+	Session bool
+}
+
+// parsePlaybackBinding parses a PLAYBACK_BIND_* list of "ip" and
+// "session".
+func parsePlaybackBinding(name string) playbackBinding {
+	binding := playbackBinding{}
+	for _, item := range envList(name, nil) {
+		switch item {
+		case "ip":
+			binding.IP = true
+		case "session":
+			binding.Session = true
+		default:
+			log.Fatalf("%s contains unknown binding %q", name, item)
+		}
+	}
+	return binding
+}
+
+// handlerPlaybackToken issues a playback token for a video the caller may
+// watch. Players and embeds use it in place of the caller's access token.
+func (cfg *apiConfig) handlerPlaybackToken(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No video was returned", nil)
+		return
+	}
+
+	p := contextPrincipal(r)
+	if !cfg.viewableVideo(w, r, p, video) {
+		return
+	}
+
+	token, expiresAt, err := cfg.issuePlaybackToken(r, p, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playback token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// issuePlaybackToken makes a playback token for p to watch video, bound
+// as the video's visibility requires, and counts a view.
+func (cfg *apiConfig) issuePlaybackToken(r *http.Request, p principal, video database.Video) (string, time.Time, error) {
+	playback := auth.PlaybackToken{VideoID: video.ID, ViewerID: p.UserID}
+	binding := cfg.playbackBindings[video.Visibility]
+	if binding.IP {
+		playback.IP = clientIP(r)
+	}
+	if binding.Session {
+		// Callers without a session (anonymous viewers, API keys) can
+		// only be bound by address
+		if p.SessionID != uuid.Nil {
+			playback.SessionID = p.SessionID
+		} else {
+			playback.IP = clientIP(r)
+		}
+	}
+
+	expiresAt := time.Now().UTC().Add(playbackTokenTTL)
+	token, err := auth.MakePlaybackToken(playback, cfg.jwtKeys, playbackTokenTTL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	// A viewer asks for a token each time they start watching
+	if err := cfg.db.IncrementVideoViews(video.ID); err != nil {
+		log.Printf("Couldn't count view of video %s: %v", video.ID, err)
+	}
+	return token, expiresAt, nil
+}
+
+// handlerPlaybackURL exchanges a playback token for a URL to the video
+// file, along with links to its caption tracks. Unbound tokens get a
+// presigned URL directly; bound ones get a stream link that checks the
+// binding each time it is opened, since a presigned URL works for whoever
+// holds it. Stores that can't presign (client-side encryption) and
+// proxied playback always get a stream link.
+func (cfg *apiConfig) handlerPlaybackURL(w http.ResponseWriter, r *http.Request) {
+	expiresIn, err := cfg.playbackURLExpiry(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	token, video, ok := cfg.playbackVideo(w, r)
+	if !ok {
+		return
+	}
+
+	streamLink := func() (string, time.Time) {
+		if _, key := cfg.videoFile(video); key == nil || video.QuarantineKey != nil {
+			return "", time.Time{}
+		}
+		return cfg.playbackStreamURL(video.ID, playbackTokenString(r)), token.ExpiresAt
+	}
+
+	var link string
+	var expiresAt time.Time
+	if token.IP != "" || token.SessionID != uuid.Nil {
+		link, expiresAt = streamLink()
+	} else if store, _ := cfg.videoFile(video); cfg.cdnPlayback.cookies && cfg.inPrimaryBucket(store) {
+		// Files in tenant buckets aren't behind the CDN, so they get
+		// presigned URLs below instead
+		var err error
+		link, expiresAt, err = cfg.setCDNCookies(w, video, expiresIn)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create playback URL", err)
+			return
+		}
+	} else {
+		var err error
+		link, expiresAt, err = cfg.presignVideo(r, video, expiresIn)
+		if errors.Is(err, storage.ErrPresignUnsupported) {
+			link, expiresAt = streamLink()
+		} else if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create playback URL", err)
+			return
+		}
+	}
+	if link == "" {
+		respondWithError(w, http.StatusNotFound, "Video has no playable file", nil)
+		return
+	}
+	// Stream links are counted when they're followed
+	if !strings.HasPrefix(link, cfg.baseURL+"/api/playback/") {
+		cfg.recordEgress(video, cfg.linkEgressSource(link))
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		URL       string            `json:"url"`
+		ExpiresAt time.Time         `json:"expires_at"`
+		Captions  []playbackCaption `json:"captions"`
+	}{
+		URL:       link,
+		ExpiresAt: expiresAt,
+		Captions:  cfg.playbackCaptions(playbackTokenString(r), video),
+	})
+}
+
+// playbackStreamURL is the stream link for a video and playback token.
+func (cfg *apiConfig) playbackStreamURL(videoID uuid.UUID, token string) string {
+	return cfg.baseURL + "/api/playback/" + videoID.String() + "/stream?" + url.Values{"token": {token}}.Encode()
+}
+
+// handlerPlaybackStream redirects a stream link to a presigned URL that
+// expires almost immediately, or streams the file through the app when
+// the store can't presign or playback is proxied.
+func (cfg *apiConfig) handlerPlaybackStream(w http.ResponseWriter, r *http.Request) {
+	_, video, ok := cfg.playbackVideo(w, r)
+	if !ok {
+		return
+	}
+	cfg.streamVideo(w, r, video)
+}
+
+// streamVideo is handlerPlaybackStream once the caller has been allowed
+// to watch video.
+func (cfg *apiConfig) streamVideo(w http.ResponseWriter, r *http.Request, video database.Video) {
+	link, _, err := cfg.presignVideo(r, video, playbackStreamURLTTL)
+	if errors.Is(err, storage.ErrPresignUnsupported) {
+		cfg.proxyVideo(w, r, video)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playback URL", err)
+		return
+	}
+	if link == "" {
+		respondWithError(w, http.StatusNotFound, "Video has no playable file", nil)
+		return
+	}
+	cfg.recordEgress(video, cfg.linkEgressSource(link))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, link, http.StatusFound)
+}
+
+// proxyVideo streams video's file from the store through the app,
+// answering Range requests so players can seek. Each request's bytes are
+// recorded as app egress of the video.
+func (cfg *apiConfig) proxyVideo(w http.ResponseWriter, r *http.Request, video database.Video) {
+	if _, key := cfg.videoFile(video); key == nil || video.QuarantineKey != nil {
+		respondWithError(w, http.StatusNotFound, "Video has no playable file", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", storedVideoType)
+	w.Header().Set("Cache-Control", "no-store")
+	cfg.serveVideoFile(w, r, video)
+}
+
+// playbackURLExpiry reads the optional expires_in query parameter, so an
+// embedded player can ask for a short-lived URL and a download link for a
+// long one.
+func (cfg *apiConfig) playbackURLExpiry(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("expires_in")
+	if v == "" {
+		return playbackURLTTL, nil
+	}
+	expiresIn, err := time.ParseDuration(v)
+	if err != nil || expiresIn < playbackURLMinTTL || expiresIn > cfg.playbackURLMaxTTL {
+		return 0, fmt.Errorf("expires_in must be a duration between %s and %s", playbackURLMinTTL, cfg.playbackURLMaxTTL)
+	}
+	return expiresIn, nil
+}
+
+func playbackTokenString(r *http.Request) string {
+	if token := r.Header.Get("X-Playback-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// playbackVideo validates the request's playback token and its binding,
+// and loads the video it is for. Access is checked again so revoked
+// grants and takedowns apply immediately, and archived videos are refused
+// until they are restored. It writes an error response and
+// returns false on failure.
+func (cfg *apiConfig) playbackVideo(w http.ResponseWriter, r *http.Request) (auth.PlaybackToken, database.Video, bool) {
+	token, err := auth.ValidatePlaybackToken(playbackTokenString(r), cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid playback token", err)
+		return auth.PlaybackToken{}, database.Video{}, false
+	}
+	if r.PathValue("videoID") != token.VideoID.String() {
+		respondWithError(w, http.StatusForbidden, "Playback token is for a different video", nil)
+		return auth.PlaybackToken{}, database.Video{}, false
+	}
+	if token.IP != "" && token.IP != clientIP(r) {
+		respondWithError(w, http.StatusForbidden, "Playback token was issued to someone else", nil)
+		return auth.PlaybackToken{}, database.Video{}, false
+	}
+	if token.SessionID != uuid.Nil {
+		if cfg.sessionDenylist.Contains(token.SessionID) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid playback token", auth.ErrSessionRevoked)
+			return auth.PlaybackToken{}, database.Video{}, false
+		}
+		// Stream links carry the token in their URL, so holding it isn't
+		// enough: the caller must be signed in to the session it is for
+		p, err := cfg.authenticate(r)
+		if err != nil || p.SessionID != token.SessionID {
+			respondWithError(w, http.StatusForbidden, "Playback token was issued to someone else", err)
+			return auth.PlaybackToken{}, database.Video{}, false
+		}
+	}
+
+	video, err := cfg.db.GetVideo(token.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return auth.PlaybackToken{}, database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No video was returned", nil)
+		return auth.PlaybackToken{}, database.Video{}, false
+	}
+
+	// A video made stricter since the token was issued needs a new,
+	// bound token
+	binding := cfg.playbackBindings[video.Visibility]
+	if (binding.IP || binding.Session) && token.IP == "" && token.SessionID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "Playback token is no longer valid for this video", nil)
+		return auth.PlaybackToken{}, database.Video{}, false
+	}
+
+	viewer := principal{}
+	if token.ViewerID != uuid.Nil {
+		role, err := cfg.userRole(token.ViewerID)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Invalid playback token", err)
+			return auth.PlaybackToken{}, database.Video{}, false
+		}
+		viewer = principal{UserID: token.ViewerID, Role: role}
+	}
+	if !cfg.viewableVideo(w, r, viewer, video) {
+		return auth.PlaybackToken{}, database.Video{}, false
+	}
+	if !cfg.playableArchive(w, r, video) {
+		return auth.PlaybackToken{}, database.Video{}, false
+	}
+	return token, video, true
+}
+
+// presignVideo returns a presigned GET URL for video's file, or "" if it
+// has none that can be played (it was never uploaded or is quarantined).
+// With a CloudFront signer configured the URL goes through the CDN, unless
+// the file is in a tenant bucket. URLs
+// are cached, so the one returned may expire sooner than expiresIn. When
+// playback is proxied it returns storage.ErrPresignUnsupported, as for
+// stores that can't presign, so callers fall back to a stream link.
+func (cfg *apiConfig) presignVideo(r *http.Request, video database.Video, expiresIn time.Duration) (string, time.Time, error) {
+	if video.Status != database.VideoStatusReady || video.VideoURL == nil || video.QuarantineKey != nil {
+		return "", time.Time{}, nil
+	}
+	store, key := cfg.videoFile(video)
+	if key == nil {
+		return "", time.Time{}, nil
+	}
+	if cfg.playbackProxy {
+		return "", time.Time{}, storage.ErrPresignUnsupported
+	}
+
+	// The CDN and replicas only front the primary bucket, so files in
+	// tenant buckets are presigned from their own
+	cacheKey := *key
+	useCDN := false
+	if cfg.inPrimaryBucket(store) {
+		var region string
+		store, region = cfg.playbackStore(r, video)
+		cacheKey += "|" + region
+		useCDN = cfg.cdnPlayback.signer != nil
+	} else if _, bucket := storeLocation(store); bucket != nil {
+		cacheKey += "|s3://" + *bucket
+	}
+
+	// The signing call is shared with other waiting requests, so it
+	// mustn't be cancelled along with this one
+	ctx := context.WithoutCancel(r.Context())
+	return cfg.presignCache.Get(cacheKey+"|"+expiresIn.String(), expiresIn, func() (string, error) {
+		if useCDN {
+			return cfg.cdnPlayback.signer.SignURL(cfg.s3CfDistribution+"/"+*key, time.Now().Add(expiresIn))
+		}
+		return store.Presign(ctx, *key, expiresIn)
+	})
+}
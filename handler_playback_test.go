@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+func TestPlaybackVideoBindings(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := newTestUser(t, cfg)
+	video := newTestVideo(t, cfg, user.ID)
+	session := uuid.New()
+	revoked := uuid.New()
+	cfg.sessionDenylist.Add(revoked, time.Now().Add(time.Hour))
+
+	accessToken := func(sessionID uuid.UUID) string {
+		token, err := auth.MakeJWT(user.ID, sessionID, cfg.jwtKeys, time.Hour)
+		if err != nil {
+			t.Fatalf("MakeJWT() error = %v", err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name        string
+		videoID     uuid.UUID
+		ip          string
+		sessionID   uuid.UUID
+		remoteAddr  string
+		accessToken string
+		wantStatus  int
+	}{
+		{name: "unbound", videoID: video.ID, wantStatus: http.StatusOK},
+		{name: "different video", videoID: uuid.New(), wantStatus: http.StatusForbidden},
+		{name: "ip matches", videoID: video.ID, ip: "192.0.2.1", remoteAddr: "192.0.2.1:1234", wantStatus: http.StatusOK},
+		{name: "ip differs", videoID: video.ID, ip: "192.0.2.1", remoteAddr: "198.51.100.7:1234", wantStatus: http.StatusForbidden},
+		{name: "session without credentials", videoID: video.ID, sessionID: session, wantStatus: http.StatusForbidden},
+		{name: "session with another session", videoID: video.ID, sessionID: session, accessToken: accessToken(uuid.New()), wantStatus: http.StatusForbidden},
+		{name: "session matches", videoID: video.ID, sessionID: session, accessToken: accessToken(session), wantStatus: http.StatusOK},
+		{name: "session revoked", videoID: video.ID, sessionID: revoked, accessToken: accessToken(revoked), wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := auth.MakePlaybackToken(auth.PlaybackToken{
+				VideoID:   tt.videoID,
+				ViewerID:  user.ID,
+				IP:        tt.ip,
+				SessionID: tt.sessionID,
+			}, cfg.jwtKeys, time.Minute)
+			if err != nil {
+				t.Fatalf("MakePlaybackToken() error = %v", err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID.String()+"/stream", nil)
+			r.SetPathValue("videoID", video.ID.String())
+			r.Header.Set("X-Playback-Token", token)
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			if tt.accessToken != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.accessToken)
+			}
+			w := httptest.NewRecorder()
+
+			_, got, ok := cfg.playbackVideo(w, r)
+			if tt.wantStatus == http.StatusOK {
+				if !ok {
+					t.Fatalf("playbackVideo() failed with %d: %s", w.Code, w.Body)
+				}
+				if got.ID != video.ID {
+					t.Errorf("playbackVideo() video = %s, want %s", got.ID, video.ID)
+				}
+				return
+			}
+			if ok {
+				t.Fatalf("playbackVideo() succeeded, want status %d", tt.wantStatus)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("playbackVideo() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxPlaylistVideos caps how many videos a playlist can hold.
+const maxPlaylistVideos = 500
+
+// playlistParameters are the fields a playlist is created or updated
+// with. Playlists are private unless made public.
+type playlistParameters struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"`
+}
+
+func (params *playlistParameters) validate() error {
+	params.Title = strings.TrimSpace(params.Title)
+	if params.Title == "" {
+		return errors.New("title is required")
+	}
+	if params.Visibility == "" {
+		params.Visibility = videoVisibilityPrivate
+	}
+	if params.Visibility != videoVisibilityPublic && params.Visibility != videoVisibilityPrivate {
+		return errors.New("visibility must be public or private")
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerPlaylistsCreate(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	decoder := json.NewDecoder(r.Body)
+	params := playlistParameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := params.validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	playlist, err := cfg.db.CreatePlaylist(p.UserID, params.Title, params.Description, params.Visibility)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playlist", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, playlist)
+}
+
+// handlerPlaylistsList lists the caller's playlists, newest first.
+func (cfg *apiConfig) handlerPlaylistsList(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	playlists, err := cfg.db.GetPlaylistsForUser(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlists", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlists)
+}
+
+func (cfg *apiConfig) handlerPlaylistGet(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.pathPlaylist(w, r, contextPrincipal(r), false)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistUpdate(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.pathPlaylist(w, r, contextPrincipal(r), true)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := playlistParameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := params.validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	playlist.Title = params.Title
+	playlist.Description = params.Description
+	playlist.Visibility = params.Visibility
+	err = cfg.db.UpdatePlaylist(playlist)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update playlist", err)
+		return
+	}
+	cfg.respondWithPlaylist(w, playlist.ID)
+}
+
+func (cfg *apiConfig) handlerPlaylistDelete(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.pathPlaylist(w, r, contextPrincipal(r), true)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.DeletePlaylist(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete playlist", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPlaylistVideos lists a playlist's videos in order, each with a
+// playback URL like handlerVideosRetrieve. Videos the caller couldn't
+// open on their own, such as private or region-blocked ones, are left
+// out.
+func (cfg *apiConfig) handlerPlaylistVideos(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	playlist, ok := cfg.pathPlaylist(w, r, p, false)
+	if !ok {
+		return
+	}
+	expiresIn, err := cfg.playbackURLExpiry(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	videos, err := cfg.db.GetPlaylistVideos(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	visible := []database.Video{}
+	for _, video := range videos {
+		if code, _ := cfg.videoAccessError(r, p, video); code == 0 {
+			visible = append(visible, video)
+		}
+	}
+	respondWithJSON(w, http.StatusOK, cfg.presignVideos(r, visible, expiresIn))
+}
+
+// handlerPlaylistItemAdd appends a video the caller can watch to the end
+// of a playlist.
+func (cfg *apiConfig) handlerPlaylistItemAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoID uuid.UUID `json:"video_id"`
+	}
+
+	p := contextPrincipal(r)
+	playlist, ok := cfg.pathPlaylist(w, r, p, true)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if playlist.VideoCount >= maxPlaylistVideos {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("a playlist can hold at most %d videos", maxPlaylistVideos), nil)
+		return
+	}
+	video, err := cfg.db.GetVideo(params.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No video was returned", nil)
+		return
+	}
+	if !cfg.viewableVideo(w, r, p, video) {
+		return
+	}
+
+	added, err := cfg.db.AddPlaylistItem(playlist.ID, video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add video", err)
+		return
+	}
+	if !added {
+		respondWithError(w, http.StatusConflict, "The video is already in this playlist", nil)
+		return
+	}
+	cfg.respondWithPlaylist(w, playlist.ID)
+}
+
+// handlerPlaylistReorder puts a playlist's videos in the order given. The
+// list must hold every video in the playlist exactly once.
+func (cfg *apiConfig) handlerPlaylistReorder(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+
+	playlist, ok := cfg.pathPlaylist(w, r, contextPrincipal(r), true)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	current, err := cfg.db.GetPlaylistVideoIDs(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	ordered := slices.Clone(params.VideoIDs)
+	slices.SortFunc(current, compareUUIDs)
+	slices.SortFunc(ordered, compareUUIDs)
+	if !slices.Equal(current, ordered) {
+		respondWithError(w, http.StatusBadRequest, "video_ids must list every video in the playlist once", nil)
+		return
+	}
+
+	err = cfg.db.ReorderPlaylist(playlist.ID, params.VideoIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reorder playlist", err)
+		return
+	}
+	cfg.respondWithPlaylist(w, playlist.ID)
+}
+
+func (cfg *apiConfig) handlerPlaylistItemRemove(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.pathPlaylist(w, r, contextPrincipal(r), true)
+	if !ok {
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	removed, err := cfg.db.RemovePlaylistItem(playlist.ID, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove video", err)
+		return
+	}
+	if !removed {
+		respondWithError(w, http.StatusNotFound, "The video isn't in this playlist", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pathPlaylist loads the playlist named in the path and checks that p may
+// see it, or manage it if manage is set, writing an error response and
+// returning false otherwise. Private playlists get a 404 so they can't be
+// probed.
+func (cfg *apiConfig) pathPlaylist(w http.ResponseWriter, r *http.Request, p principal, manage bool) (database.Playlist, bool) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Playlist{}, false
+	}
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return database.Playlist{}, false
+	}
+
+	canManage := p.UserID != uuid.Nil && (playlist.UserID == p.UserID || p.can(auth.PermVideoManageAny))
+	if playlist.ID == uuid.Nil || (playlist.Visibility != videoVisibilityPublic && !canManage) {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return database.Playlist{}, false
+	}
+	if manage && !canManage {
+		respondWithError(w, http.StatusForbidden, "You can't manage this playlist", nil)
+		return database.Playlist{}, false
+	}
+	return playlist, true
+}
+
+// respondWithPlaylist responds with a playlist as it is after a change.
+func (cfg *apiConfig) respondWithPlaylist(w http.ResponseWriter, id uuid.UUID) {
+	playlist, err := cfg.db.GetPlaylist(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+func compareUUIDs(a, b uuid.UUID) int {
+	return bytes.Compare(a[:], b[:])
+}
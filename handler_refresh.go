@@ -1,15 +1,43 @@
 package main
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
+// Default token lifetimes, overridable with REFRESH_TOKEN_TTL,
+// ACCESS_TOKEN_TTL and REFRESH_ACCESS_TOKEN_TTL. Access tokens from a
+// refresh are short-lived; those handed out at login last much longer.
+const (
+	defaultRefreshTokenTTL       = time.Hour * 24 * 60
+	defaultRefreshAccessTokenTTL = time.Hour
+	defaultLoginAccessTokenTTL   = time.Hour * 24 * 30
+)
+
+// tokenTTLs are the configured token lifetimes.
+type tokenTTLs struct {
+	refresh       time.Duration
+	refreshAccess time.Duration
+	loginAccess   time.Duration
+}
+
+// longestAccess bounds how long an access token can outlive the
+// revocation of its session, and so how long revocations must be
+// remembered in the denylist.
+func (t tokenTTLs) longestAccess() time.Duration {
+	return max(t.loginAccess, t.refreshAccess, impersonationMaxTTL)
+}
+
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,27 +46,105 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	stored, err := cfg.db.GetRefreshToken(refreshToken)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if stored.Token == "" {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+	// A rotated token being presented again means it was replayed, so it
+	// has probably been stolen. End every session for the user to lock out
+	// whoever holds the newer token. Tokens revoked by signing out are
+	// just refused, since stale clients still hold them.
+	if stored.RevokedAt != nil {
+		if stored.RotatedAt != nil {
+			cfg.handleRefreshTokenReuse(r, stored)
+		}
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has been revoked", nil)
+		return
+	}
+	if time.Now().UTC().After(stored.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has expired", nil)
 		return
 	}
 
+	// Tokens issued before sessions were tracked keep working without one
+	sessionID := uuid.Nil
+	if stored.SessionID != nil {
+		session, err := cfg.db.GetSession(*stored.SessionID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get session", err)
+			return
+		}
+		if session.ID == uuid.Nil || session.RevokedAt != nil {
+			respondWithError(w, http.StatusUnauthorized, "Session has been revoked", nil)
+			return
+		}
+		sessionID = session.ID
+	}
+
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+	expiresAt := time.Now().UTC().Add(cfg.tokenTTLs.refresh)
+	_, err = cfg.db.RotateRefreshToken(refreshToken, database.CreateRefreshTokenParams{
+		UserID:    stored.UserID,
+		SessionID: stored.SessionID,
+		Token:     newRefreshToken,
+		ExpiresAt: expiresAt,
+	})
+	if errors.Is(err, database.ErrRefreshTokenRotated) {
+		cfg.handleRefreshTokenReuse(r, stored)
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has been revoked", err)
+		return
+	}
+	if errors.Is(err, database.ErrRefreshTokenRevoked) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has been revoked", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token", err)
+		return
+	}
+
+	if sessionID != uuid.Nil {
+		if err := cfg.db.TouchSession(sessionID, expiresAt); err != nil {
+			log.Printf("Couldn't update session %s: %v", sessionID, err)
+		}
+	}
+
 	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
+		stored.UserID,
+		sessionID,
+		cfg.jwtKeys,
+		cfg.tokenTTLs.refreshAccess,
 	)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
 		return
 	}
 
+	cfg.audit(r, stored.UserID, auditTokenRefreshed, "session", sessionID.String(), nil)
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
+func (cfg *apiConfig) handleRefreshTokenReuse(r *http.Request, stored database.RefreshToken) {
+	log.Printf("Refresh token reuse detected for user %s, revoking all sessions", stored.UserID)
+	cfg.audit(r, uuid.Nil, auditSessionsRevoked, "user", stored.UserID.String(), map[string]string{"reason": "refresh_token_reuse"})
+	err := cfg.revokeAllSessions(stored.UserID)
+	if err != nil {
+		log.Printf("Couldn't revoke sessions for user %s: %v", stored.UserID, err)
+	}
+}
+
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -46,11 +152,23 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(refreshToken)
+	stored, err := cfg.db.GetRefreshToken(refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get refresh token", err)
+		return
+	}
+	if stored.SessionID != nil {
+		err = cfg.revokeSession(*stored.SessionID)
+	} else {
+		err = cfg.db.RevokeRefreshToken(refreshToken)
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
 		return
 	}
+	if stored.SessionID != nil {
+		cfg.audit(r, stored.UserID, auditSessionRevoked, "session", stored.SessionID.String(), nil)
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
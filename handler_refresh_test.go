@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestRefreshRevokedToken(t *testing.T) {
+	tests := []struct {
+		name string
+		// revoke ends the first session's token, returning the token to
+		// present
+		revoke          func(t *testing.T, cfg *apiConfig, session uuid.UUID, token string) string
+		wantOtherActive bool
+	}{
+		{
+			name: "signed out",
+			revoke: func(t *testing.T, cfg *apiConfig, session uuid.UUID, token string) string {
+				if err := cfg.db.RevokeSession(session); err != nil {
+					t.Fatalf("RevokeSession() error = %v", err)
+				}
+				return token
+			},
+			wantOtherActive: true,
+		},
+		{
+			name: "rotated and replayed",
+			revoke: func(t *testing.T, cfg *apiConfig, session uuid.UUID, token string) string {
+				w := httptest.NewRecorder()
+				cfg.handlerRefresh(w, refreshRequest(token))
+				if w.Code != http.StatusOK {
+					t.Fatalf("first refresh status = %d, want %d", w.Code, http.StatusOK)
+				}
+				return token
+			},
+			wantOtherActive: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			user := newTestUser(t, cfg)
+			var sessions []uuid.UUID
+			var tokens []string
+			for range 2 {
+				expiresAt := time.Now().UTC().Add(time.Hour)
+				session, err := cfg.db.CreateSession(user.ID, "test", "192.0.2.1", expiresAt)
+				if err != nil {
+					t.Fatalf("CreateSession() error = %v", err)
+				}
+				token := uuid.NewString()
+				_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
+					Token:     token,
+					UserID:    user.ID,
+					SessionID: &session.ID,
+					ExpiresAt: expiresAt,
+				})
+				if err != nil {
+					t.Fatalf("CreateRefreshToken() error = %v", err)
+				}
+				sessions = append(sessions, session.ID)
+				tokens = append(tokens, token)
+			}
+
+			token := tt.revoke(t, cfg, sessions[0], tokens[0])
+			w := httptest.NewRecorder()
+			cfg.handlerRefresh(w, refreshRequest(token))
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+
+			other, err := cfg.db.GetSession(sessions[1])
+			if err != nil {
+				t.Fatalf("GetSession() error = %v", err)
+			}
+			if active := other.RevokedAt == nil; active != tt.wantOtherActive {
+				t.Errorf("other session active = %v, want %v", active, tt.wantOtherActive)
+			}
+		})
+	}
+}
+
+func refreshRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerSessionsList(w http.ResponseWriter, r *http.Request) {
+	type session struct {
+		database.Session
+		Current bool `json:"current"`
+	}
+
+	p := contextPrincipal(r)
+
+	sessions, err := cfg.db.GetActiveSessions(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve sessions", err)
+		return
+	}
+
+	resp := make([]session, 0, len(sessions))
+	for _, s := range sessions {
+		resp = append(resp, session{Session: s, Current: s.ID == p.SessionID})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerSessionsRevoke(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID", err)
+		return
+	}
+
+	session, err := cfg.db.GetSession(sessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get session", err)
+		return
+	}
+	if session.ID == uuid.Nil || session.UserID != p.UserID {
+		respondWithError(w, http.StatusNotFound, "Session not found", nil)
+		return
+	}
+
+	err = cfg.revokeSession(session.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditSessionRevoked, "session", session.ID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerSessionsRevokeAll signs the user out everywhere, including the
+// device making the request.
+func (cfg *apiConfig) handlerSessionsRevokeAll(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+
+	err := cfg.revokeAllSessions(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditSessionsRevoked, "user", p.UserID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeSession ends a session and denies its outstanding access tokens.
+func (cfg *apiConfig) revokeSession(sessionID uuid.UUID) error {
+	err := cfg.db.RevokeSession(sessionID)
+	if err != nil {
+		return err
+	}
+	cfg.sessionDenylist.Add(sessionID, time.Now().Add(cfg.tokenTTLs.longestAccess()))
+	return nil
+}
+
+func (cfg *apiConfig) revokeAllSessions(userID uuid.UUID) error {
+	revoked, err := cfg.db.RevokeAllSessionsForUser(userID)
+	if err != nil {
+		return err
+	}
+	until := time.Now().Add(cfg.tokenTTLs.longestAccess())
+	for _, sessionID := range revoked {
+		cfg.sessionDenylist.Add(sessionID, until)
+	}
+	return nil
+}
+
+// loadSessionDenylist restores revocations that may still have live access
+// tokens, so a restart doesn't resurrect revoked sessions.
+func loadSessionDenylist(db database.Client, ttls tokenTTLs) (*auth.Denylist, error) {
+	denylist := auth.NewDenylist()
+	since := time.Now().Add(-ttls.longestAccess())
+	revoked, err := db.GetSessionsRevokedSince(since)
+	if err != nil {
+		return nil, err
+	}
+	for _, sessionID := range revoked {
+		denylist.Add(sessionID, time.Now().Add(ttls.longestAccess()))
+	}
+	return denylist, nil
+}
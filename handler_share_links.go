@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	shareLinkDefaultTTL = 48 * time.Hour
+	shareLinkMaxTTL     = 30 * 24 * time.Hour
+)
+
+// handlerShareLinksCreate mints a link that lets anyone holding it watch
+// the video, private or not, until it expires or has been opened
+// max_views times.
+func (cfg *apiConfig) handlerShareLinksCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresIn string `json:"expires_in"`
+		MaxViews  *int   `json:"max_views"`
+	}
+	type response struct {
+		database.ShareLink
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.managedVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	ttl := shareLinkDefaultTTL
+	if params.ExpiresIn != "" {
+		ttl, err = time.ParseDuration(params.ExpiresIn)
+		if err != nil || ttl < time.Minute || ttl > shareLinkMaxTTL {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("expires_in must be a duration between 1m and %s", shareLinkMaxTTL), err)
+			return
+		}
+	}
+	if params.MaxViews != nil && *params.MaxViews < 1 {
+		respondWithError(w, http.StatusBadRequest, "max_views must be at least 1", nil)
+		return
+	}
+
+	token, err := auth.MakeShareToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share link", err)
+		return
+	}
+	link, err := cfg.db.CreateShareLink(video.ID, p.UserID, auth.HashToken(token), time.Now().Add(ttl), params.MaxViews)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save share link", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditShareLinkCreated, "video", video.ID.String(), map[string]string{"share_link_id": link.ID.String()})
+
+	// The plaintext token is only ever returned here
+	respondWithJSON(w, http.StatusCreated, response{
+		ShareLink: link,
+		Token:     token,
+		URL:       cfg.baseURL + "/api/share/" + token,
+	})
+}
+
+func (cfg *apiConfig) handlerShareLinksList(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.managedVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	links, err := cfg.db.GetShareLinks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve share links", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, links)
+}
+
+func (cfg *apiConfig) handlerShareLinksRevoke(w http.ResponseWriter, r *http.Request) {
+	linkID, err := uuid.Parse(r.PathValue("linkID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.managedVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	link, err := cfg.db.GetShareLink(linkID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+	if link.ID == uuid.Nil || link.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return
+	}
+
+	err = cfg.db.RevokeShareLink(link.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke share link", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditShareLinkRevoked, "video", video.ID.String(), map[string]string{"share_link_id": link.ID.String()})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerShareLinkOpen responds with the video a share link is for and a
+// presigned URL for its file that lasts no longer than the link. Opening
+// the link counts against its view limit, and the link is checked in
+// full before anything is signed: it must still be live, its creator must
+// still manage the video, and the video mustn't be taken down, blocked in
+// the viewer's region or archived. A view isn't counted if the video
+// can't be played.
+func (cfg *apiConfig) handlerShareLinkOpen(w http.ResponseWriter, r *http.Request) {
+	link, err := cfg.db.RedeemShareLink(auth.HashToken(r.PathValue("token")))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't redeem share link", err)
+		return
+	}
+	if link.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Share link not found or no longer valid", nil)
+		return
+	}
+	played := false
+	defer func() {
+		if played {
+			return
+		}
+		if err := cfg.db.ReleaseShareLink(link.ID); err != nil {
+			log.Printf("Couldn't release share link %s: %v", link.ID, err)
+		}
+	}()
+
+	video, err := cfg.db.GetVideo(link.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	creator := principal{}
+	if role, err := cfg.userRole(link.CreatedBy); err == nil {
+		creator = principal{UserID: link.CreatedBy, Role: role}
+	}
+	if video.ID == uuid.Nil || !cfg.canManageVideo(creator, video) {
+		respondWithError(w, http.StatusNotFound, "Share link not found or no longer valid", nil)
+		return
+	}
+	// The link stands in for a grant, so it gets past the video's
+	// visibility but not moderation or region blocks
+	if video.ModerationStatus != nil && *video.ModerationStatus == moderationStatusRemoved {
+		respondWithError(w, http.StatusNotFound, "No video was returned", nil)
+		return
+	}
+	if !geoAllowed(video, cfg.requestCountry(r)) {
+		respondWithError(w, http.StatusForbidden, "This video isn't available in your region", nil)
+		return
+	}
+	if !cfg.playableArchive(w, r, video) {
+		return
+	}
+
+	expiresIn := min(playbackURLTTL, time.Until(link.ExpiresAt).Truncate(time.Second))
+	if expiresIn < time.Second {
+		respondWithError(w, http.StatusNotFound, "Share link not found or no longer valid", nil)
+		return
+	}
+	url, expiresAt, err := cfg.presignVideo(r, video, expiresIn)
+	if errors.Is(err, storage.ErrPresignUnsupported) {
+		respondWithError(w, http.StatusNotImplemented, "This video's storage can't be shared by link", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playback URL", err)
+		return
+	}
+	if url == "" {
+		respondWithError(w, http.StatusNotFound, "Video has no playable file", nil)
+		return
+	}
+	played = true
+	cfg.recordEgress(video, cfg.linkEgressSource(url))
+	if err := cfg.db.IncrementVideoViews(video.ID); err != nil {
+		log.Printf("Couldn't count view of video %s: %v", video.ID, err)
+	}
+
+	respondWithJSON(w, http.StatusOK, signedVideo{
+		Video:                video,
+		PlaybackURL:          &url,
+		PlaybackURLExpiresAt: &expiresAt,
+	})
+}
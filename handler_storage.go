@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// storageQuota returns the bytes of video a user may store, or 0 for no
+// limit. A per-user override takes precedence over STORAGE_QUOTA_BYTES.
+func (cfg *apiConfig) storageQuota(userID uuid.UUID) (int64, error) {
+	override, err := cfg.db.GetUserStorageQuota(userID)
+	if err != nil {
+		return 0, err
+	}
+	if override != nil {
+		return *override, nil
+	}
+	return cfg.storageQuotaBytes, nil
+}
+
+// checkStorageQuota reports whether replacing video's file with one of
+// incoming bytes keeps its owner within quota, writing an error response
+// if not. Ownerless guest uploads are all accounted to the nil user, so
+// together they are held to GUEST_STORAGE_QUOTA_BYTES; the claimant's
+// quota is checked when one is claimed.
+func (cfg *apiConfig) checkStorageQuota(w http.ResponseWriter, video database.Video, incoming int64) bool {
+	quota := cfg.guestStorageQuotaBytes
+	if video.UserID != uuid.Nil {
+		var err error
+		quota, err = cfg.storageQuota(video.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get storage quota", err)
+			return false
+		}
+	}
+	if quota == 0 {
+		return true
+	}
+	used, _, err := cfg.db.GetUserStorageUsage(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return false
+	}
+	if video.VideoSize != nil {
+		used -= *video.VideoSize
+	}
+	if used+incoming > quota {
+		msg := fmt.Sprintf("Storage quota exceeded: %d of %d bytes used", used, quota)
+		respondWithError(w, http.StatusRequestEntityTooLarge, msg, nil)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handlerStorageUsage(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		UsedBytes  int64  `json:"used_bytes"`
+		LimitBytes *int64 `json:"limit_bytes"`
+		VideoCount int    `json:"video_count"`
+	}
+
+	p := contextPrincipal(r)
+
+	used, count, err := cfg.db.GetUserStorageUsage(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	quota, err := cfg.storageQuota(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage quota", err)
+		return
+	}
+
+	resp := response{UsedBytes: used, VideoCount: count}
+	if quota > 0 {
+		resp.LimitBytes = &quota
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerStorageUsageRollup reports the storage used across all users and
+// the users using the most.
+func (cfg *apiConfig) handlerStorageUsageRollup(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.StorageUsageRollup
+		Users []database.UserStorageUsage `json:"users"`
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 1000", err)
+			return
+		}
+		limit = n
+	}
+
+	rollup, users, err := cfg.db.GetStorageUsageRollup(limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{StorageUsageRollup: rollup, Users: users})
+}
+
+func (cfg *apiConfig) handlerUserStorageQuotaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// QuotaBytes overrides the default quota; null restores it
+		QuotaBytes *int64 `json:"quota_bytes"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.QuotaBytes != nil && *params.QuotaBytes <= 0 {
+		respondWithError(w, http.StatusBadRequest, "quota_bytes must be positive", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = cfg.db.SetUserStorageQuota(userID, params.QuotaBytes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update storage quota", err)
+		return
+	}
+	quota := "default"
+	if params.QuotaBytes != nil {
+		quota = strconv.FormatInt(*params.QuotaBytes, 10)
+	}
+	cfg.audit(r, contextPrincipal(r).UserID, auditUserQuotaChanged, "user", userID.String(), map[string]string{"quota_bytes": quota})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckStorageQuota(t *testing.T) {
+	raised, lowered := int64(500), int64(120)
+	tests := []struct {
+		name       string
+		guest      bool
+		stored     int64  // bytes of the owner's other videos
+		replaced   int64  // bytes of the file being replaced, if any
+		override   *int64 // the owner's own quota
+		incoming   int64
+		quota      int64
+		guestQuota int64
+		want       bool
+	}{
+		{name: "within quota", stored: 100, incoming: 50, quota: 200, want: true},
+		{name: "over quota", stored: 180, incoming: 50, quota: 200},
+		{name: "replacing a file frees its space", stored: 100, replaced: 80, incoming: 90, quota: 200, want: true},
+		{name: "unlimited", stored: 1000, incoming: 1000, want: true},
+		{name: "override raises quota", stored: 180, incoming: 50, quota: 200, override: &raised, want: true},
+		{name: "override lowers quota", stored: 100, incoming: 50, quota: 200, override: &lowered},
+		{name: "guest within guest quota", guest: true, stored: 100, incoming: 50, quota: 10, guestQuota: 200, want: true},
+		{name: "guest over guest quota", guest: true, stored: 180, incoming: 50, quota: 1000, guestQuota: 200},
+		{name: "guest unlimited", guest: true, stored: 1000, incoming: 1000, quota: 10, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.storageQuotaBytes = tt.quota
+			cfg.guestStorageQuotaBytes = tt.guestQuota
+			owner := uuid.Nil
+			if !tt.guest {
+				owner = newTestUser(t, cfg).ID
+				if err := cfg.db.SetUserStorageQuota(owner, tt.override); err != nil {
+					t.Fatalf("SetUserStorageQuota() error = %v", err)
+				}
+			}
+
+			other := newTestVideo(t, cfg, owner)
+			other.VideoSize = &tt.stored
+			if err := cfg.db.UpdateVideo(other); err != nil {
+				t.Fatalf("UpdateVideo() error = %v", err)
+			}
+			video := newTestVideo(t, cfg, owner)
+			if tt.replaced > 0 {
+				video.VideoSize = &tt.replaced
+				if err := cfg.db.UpdateVideo(video); err != nil {
+					t.Fatalf("UpdateVideo() error = %v", err)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			if got := cfg.checkStorageQuota(w, video, tt.incoming); got != tt.want {
+				t.Fatalf("checkStorageQuota() = %v, want %v", got, tt.want)
+			}
+			if !tt.want && w.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+			}
+		})
+	}
+}
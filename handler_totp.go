@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer        = "Tubely"
+	recoveryCodeCount = 10
+	// mfaTokenTTL is how long a user has to enter their code after the
+	// first factor succeeds
+	mfaTokenTTL = 5 * time.Minute
+	// mfaTokenMaxFailures is how many wrong codes an MFA token survives
+	mfaTokenMaxFailures = 5
+	// mfaAccountMaxFailures is how many wrong codes an account may get
+	// within loginFailures' window before its second factor is locked
+	mfaAccountMaxFailures = 10
+)
+
+var (
+	errRecoveryCodeUsed = errors.New("recovery code is invalid or already used")
+	// errSecondFactorLocked means too many wrong codes were entered to
+	// check another one
+	errSecondFactorLocked = errors.New("too many wrong two-factor codes")
+)
+
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+
+	p := contextPrincipal(r)
+
+	user, err := cfg.db.GetUser(p.UserID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	totp, err := cfg.db.GetUserTOTP(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if totp.Enabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate secret", err)
+		return
+	}
+	err = cfg.db.StartTOTPEnrollment(p.UserID, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// handlerTOTPActivate confirms enrollment with a code from the
+// authenticator, which proves the secret was stored correctly before the
+// second factor starts being required.
+func (cfg *apiConfig) handlerTOTPActivate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	p := contextPrincipal(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if totp.Enabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+	if totp.Secret == "" {
+		respondWithError(w, http.StatusBadRequest, "Start enrollment first", nil)
+		return
+	}
+
+	step, err := auth.ValidateTOTP(totp.Secret, params.Code, time.Now(), totp.LastStep)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid two-factor code", err)
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate recovery codes", err)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashToken(code)
+	}
+
+	err = cfg.db.EnableTOTP(p.UserID, step, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	cfg.audit(r, p.UserID, auditTOTPEnabled, "user", p.UserID.String(), nil)
+
+	// Recovery codes are only ever shown here
+	respondWithJSON(w, http.StatusOK, response{RecoveryCodes: codes})
+}
+
+func (cfg *apiConfig) handlerTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	p := contextPrincipal(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if !totp.Enabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is not enabled", nil)
+		return
+	}
+
+	// A stolen access token alone must not be enough to remove the factor
+	if cfg.verifySecondFactor(w, p.UserID, totp, params.Code, "") != nil {
+		return
+	}
+
+	err = cfg.db.DisableTOTP(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditTOTPDisabled, "user", p.UserID.String(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerLoginSecondFactor completes a login that stopped at the second
+// factor, exchanging the MFA token and a code for a session.
+func (cfg *apiConfig) handlerLoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, mfaTokenID, err := auth.ValidateMFAToken(params.MFAToken, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Login has expired, sign in again", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Login has expired, sign in again", nil)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor settings", err)
+		return
+	}
+	if totp.Enabled && !cfg.loginSecondFactor(w, r, *user, totp, params.Code, mfaTokenID) {
+		return
+	}
+
+	cfg.loginFailures.reset(emailFailureKey(user.Email))
+	cfg.respondWithSession(w, r, *user)
+}
+
+// loginSecondFactor checks the second factor of a login, writing an error
+// response and returning false if it fails. Wrong codes also count as
+// failed logins for the user's email.
+func (cfg *apiConfig) loginSecondFactor(w http.ResponseWriter, r *http.Request, user database.User, totp database.UserTOTP, code, mfaTokenID string) bool {
+	err := cfg.verifySecondFactor(w, user.ID, totp, code, mfaTokenID)
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, errSecondFactorLocked) {
+		cfg.loginFailures.record(emailFailureKey(user.Email))
+		cfg.audit(r, uuid.Nil, auditLoginFailed, "user", user.ID.String(), map[string]string{"reason": "second_factor"})
+	}
+	return false
+}
+
+// verifySecondFactor checks a second factor, writing an error response
+// and returning the error if it fails. Wrong codes are counted against the
+// account wherever they are entered, and against the MFA token when the
+// code came with one, so codes can't be guessed by spreading attempts
+// over many tokens, addresses or endpoints.
+func (cfg *apiConfig) verifySecondFactor(w http.ResponseWriter, userID uuid.UUID, totp database.UserTOTP, code, mfaTokenID string) error {
+	accountKey := mfaAccountFailureKey(userID)
+	tokenKey := mfaTokenFailureKey(mfaTokenID)
+	if cfg.loginFailures.count(accountKey) >= mfaAccountMaxFailures {
+		respondWithError(w, http.StatusTooManyRequests, "Too many wrong two-factor codes, try again later", nil)
+		return errSecondFactorLocked
+	}
+	if mfaTokenID != "" && cfg.loginFailures.count(tokenKey) >= mfaTokenMaxFailures {
+		respondWithError(w, http.StatusUnauthorized, "Login has expired, sign in again", nil)
+		return errSecondFactorLocked
+	}
+
+	if err := cfg.checkSecondFactor(userID, totp, code); err != nil {
+		cfg.loginFailures.record(accountKey)
+		if mfaTokenID != "" {
+			cfg.loginFailures.record(tokenKey)
+		}
+		respondWithError(w, http.StatusUnauthorized, "Invalid two-factor code", err)
+		return err
+	}
+	cfg.loginFailures.reset(accountKey)
+	return nil
+}
+
+// respondTwoFactorRequired stops a login after the first factor, handing
+// out the MFA token the client presents with the second.
+func (cfg *apiConfig) respondTwoFactorRequired(w http.ResponseWriter, userID uuid.UUID) {
+	type response struct {
+		Error             string `json:"error"`
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		MFAToken          string `json:"mfa_token"`
+	}
+
+	mfaToken, err := auth.MakeMFAToken(userID, cfg.jwtKeys, mfaTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login challenge", err)
+		return
+	}
+	respondWithJSON(w, http.StatusUnauthorized, response{
+		Error:             "Two-factor code required",
+		TwoFactorRequired: true,
+		MFAToken:          mfaToken,
+	})
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused
+// recovery code.
+func (cfg *apiConfig) checkSecondFactor(userID uuid.UUID, totp database.UserTOTP, code string) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return auth.ErrInvalidTOTPCode
+	}
+
+	if !strings.ContainsAny(code, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-") {
+		step, err := auth.ValidateTOTP(totp.Secret, code, time.Now(), totp.LastStep)
+		if err != nil {
+			return err
+		}
+		recorded, err := cfg.db.RecordTOTPStep(userID, step)
+		if err != nil {
+			return fmt.Errorf("couldn't record two-factor code: %w", err)
+		}
+		if !recorded {
+			return auth.ErrTOTPCodeReused
+		}
+		return nil
+	}
+
+	used, err := cfg.db.UseRecoveryCode(userID, auth.HashToken(auth.NormalizeRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("couldn't check recovery code: %w", err)
+	}
+	if !used {
+		return errRecoveryCodeUsed
+	}
+	return nil
+}
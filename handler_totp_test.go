@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestVerifySecondFactorLocksAccount(t *testing.T) {
+	tests := []struct {
+		name       string
+		mfaTokenID string // of the attempt made once the account is locked
+	}{
+		{name: "another account endpoint"},
+		{name: "login", mfaTokenID: uuid.NewString()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			user := newTestUser(t, cfg)
+			totp := database.UserTOTP{Enabled: true}
+
+			// Wrong codes entered while signed in, e.g. when disabling the
+			// factor or deleting the account, carry no MFA token
+			for range mfaAccountMaxFailures {
+				w := httptest.NewRecorder()
+				if err := cfg.verifySecondFactor(w, user.ID, totp, "wrong-code", ""); err == nil {
+					t.Fatal("verifySecondFactor() accepted a wrong code")
+				}
+				if w.Code != http.StatusUnauthorized {
+					t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			err := cfg.verifySecondFactor(w, user.ID, totp, "wrong-code", tt.mfaTokenID)
+			if !errors.Is(err, errSecondFactorLocked) {
+				t.Fatalf("verifySecondFactor() error = %v, want %v", err, errSecondFactorLocked)
+			}
+			if w.Code != http.StatusTooManyRequests {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+			}
+		})
+	}
+}
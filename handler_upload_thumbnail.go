@@ -1,122 +1,282 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
-	"github.com/google/uuid"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
 )
 
+// supportedThumbnailTypes are the types the thumbnail pipeline can decode;
+// THUMBNAIL_ALLOWED_TYPES may only narrow this set.
+var supportedThumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/heic": true,
+	"image/heif": true,
+}
+
+var defaultThumbnailTypes = []string{"image/jpeg", "image/png", "image/heic", "image/heif"}
+
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
 		return
 	}
+	fmt.Println("uploading thumbnail for video", video.ID, "by user", p.UserID)
 
-	token, err := auth.GetBearerToken(r.Header)
+	file, contentType, err := streamFormFile(w, r, "thumbnail", int64(cfg.thumbnailMaxBytes))
+	if errors.Is(err, errUploadTooLarge) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Thumbnail is too large", err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusBadRequest, "Error parsing thumbnail", err)
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if info, err := file.Stat(); err == nil {
+		cfg.meterUpload(r, info.Size())
+	}
+
+	if contentType == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing Content-Type for thumbnail", nil)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusBadRequest, "unable to determine file type", err)
+		return
+	}
+	if !cfg.thumbnailAllowedTypes[mediaType] {
+		respondWithError(w, http.StatusBadRequest, "invalid file type", nil)
 		return
 	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
-
-	// Set file upload size limit
-	maxMemory := 10 << 20
-	r.ParseMultipartForm(int64(maxMemory))
+	// HEIC is converted server-side and stored as JPEG
+	var src io.ReadSeeker = file
+	decodeType := mediaType
+	storedType := mediaType
+	if isHEICMediaType(mediaType) {
+		src, err = imaging.ConvertHEIC(r.Context(), cfg.mediaTools.ffmpeg, file, cfg.thumbnailMaxPixels)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "unable to convert HEIC thumbnail", err)
+			return
+		}
+		decodeType = "image/png"
+		storedType = "image/jpeg"
+	}
 
-	file, header, err := r.FormFile("thumbnail")
+	img, err := imaging.Decode(src, decodeType, cfg.thumbnailMaxPixels)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Error parsing thumbnail", err)
+		respondWithError(w, http.StatusBadRequest, "thumbnail is not a valid image", err)
 		return
 	}
-	defer file.Close()
+	if aspectW, aspectH, ok := cfg.thumbnailCropAspect(video); ok {
+		img = imaging.CropToAspect(img, aspectW, aspectH, cfg.thumbnailCropMode)
+	}
+	img = imaging.Fit(img, cfg.thumbnailMaxWidth, cfg.thumbnailMaxHeight)
 
-	contentType := header.Header.Get("Content-Type")
-	if contentType == "" {
-		respondWithError(w, http.StatusBadRequest, "Missing Content-Type for thumbnail", nil)
+	blurHash, err := imaging.BlurHash(img, 4, 3)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "unable to generate thumbnail placeholder", err)
+		return
 	}
+	dominantColor := imaging.HexColor(imaging.DominantColor(img))
 
-	// rawImage, err := io.ReadAll(file)
-	// if err != nil {
-	// 	respondWithError(w, http.StatusInternalServerError, "Unable to read the image", err)
-	// 	return
-	// }
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	phash := imaging.FormatPHash(imaging.PHash(img))
 
-	video, err := cfg.db.GetVideo(videoID)
+	// Re-encode rather than copying the upload so EXIF metadata is stripped
+	var encoded bytes.Buffer
+	if err := imaging.Encode(&encoded, img, storedType); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "unable to encode thumbnail", err)
+		return
+	}
+	digest := sha256.Sum256(encoded.Bytes())
+	contentHash := hex.EncodeToString(digest[:])
+
+	// Reuse the stored file when a byte-identical thumbnail already
+	// exists. Perceptual hashes only say images look alike, so they are
+	// left to the duplicates report
+	duplicate, err := cfg.db.FindThumbnailDuplicate(video.ID, contentHash)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Video couldn't be found", err)
+		respondWithError(w, http.StatusInternalServerError, "unable to check for duplicate thumbnails", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "User not authorized to access video", nil)
+	previousKey := storedThumbnailKey(video)
+	var thumbnailURL string
+	var thumbnailKey *string
+	if duplicate.ThumbnailURL != nil {
+		thumbnailURL = *duplicate.ThumbnailURL
+		thumbnailKey = storedThumbnailKey(duplicate)
+	} else {
+		fileName, err := cfg.saveThumbnail(encoded.Bytes(), storedType)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "unable to save thumbnail", err)
+			return
+		}
+		thumbnailURL = cfg.thumbnailURL(fileName, contentHash)
+		thumbnailKey = &fileName
+	}
+
+	setThumbnail := func(video *database.Video) {
+		video.ThumbnailURL = &thumbnailURL
+		video.ThumbnailKey = thumbnailKey
+		video.ThumbnailWidth = &width
+		video.ThumbnailHeight = &height
+		video.ThumbnailBlurHash = &blurHash
+		video.ThumbnailColor = &dominantColor
+		video.ThumbnailPHash = &phash
+		video.ThumbnailSHA256 = &contentHash
+	}
+	video, err = cfg.saveVideoChanges(video.ID, setThumbnail, func(video database.Video, ifVersion int64) error {
+		return cfg.db.UpdateVideosWithTags([]database.VideoUpdate{{Video: video, IfVersion: ifVersion}})
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
 
-	mediaType, _, err := mime.ParseMediaType(contentType)
+	if previousKey != nil && (thumbnailKey == nil || *previousKey != *thumbnailKey) {
+		cfg.removeThumbnailIfUnused(*previousKey)
+	}
+	cfg.audit(r, p.UserID, auditThumbnailUploaded, "video", video.ID.String(), nil)
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// storedThumbnailKey returns the asset file name backing a video's
+// thumbnail. Older rows predate thumbnail_key, so it falls back to the
+// file name in the thumbnail URL.
+func storedThumbnailKey(video database.Video) *string {
+	if video.ThumbnailKey != nil {
+		return video.ThumbnailKey
+	}
+	if video.ThumbnailURL == nil {
+		return nil
+	}
+	u, err := url.Parse(*video.ThumbnailURL)
+	if err != nil || !strings.HasPrefix(u.Path, "/assets/") {
+		return nil
+	}
+	key := path.Base(u.Path)
+	return &key
+}
+
+// removeThumbnailIfUnused deletes a thumbnail file from the assets
+// directory once no video references it any more.
+func (cfg *apiConfig) removeThumbnailIfUnused(key string) {
+	count, err := cfg.db.CountVideosWithThumbnailKey(key)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "unable to determine file type", err)
+		log.Printf("Couldn't check references to thumbnail %s: %v", key, err)
 		return
 	}
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "invalid file type", nil)
+	if count > 0 {
 		return
 	}
+	err = os.Remove(filepath.Join(cfg.assetsRoot, filepath.Base(key)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Couldn't remove thumbnail %s: %v", key, err)
+	}
+	cfg.assetsCDN.Invalidate("/assets/" + filepath.Base(key))
+}
 
+// saveThumbnail writes an encoded thumbnail to a randomly named file in
+// the assets directory and returns the file name.
+func (cfg *apiConfig) saveThumbnail(data []byte, mediaType string) (string, error) {
 	extensions, err := mime.ExtensionsByType(mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "unable to determine file type", err)
-		return
+		return "", err
 	}
 	if len(extensions) == 0 {
-		respondWithError(w, http.StatusBadRequest, "no file extension found for media type", nil)
-		return
+		return "", fmt.Errorf("no file extension found for media type %s", mediaType)
 	}
 
 	fileExtension := extensions[0]
 	key := make([]byte, 32)
 	_, err = rand.Read(key)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "error randomizing key", err)
-		return
+		return "", err
 	}
 	rawFileName := base64.RawURLEncoding.EncodeToString(key)
 	fileName := fmt.Sprintf("%s.%s", rawFileName, fileExtension)
 	filePath := filepath.Join(cfg.assetsRoot, fileName)
-	fileDst, err := os.Create(filePath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "unable to create image file", err)
-		return
-	}
-	_, err = io.Copy(fileDst, file)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "unable to write file", err)
-		return
+	if err := os.WriteFile(filePath, data, 0o644); err != nil {
+		os.Remove(filePath)
+		return "", err
 	}
+	return fileName, nil
+}
 
-	thumbnailURL := fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, fileName)
-	video.ThumbnailURL = &thumbnailURL
+// thumbnailURL builds the public URL for a stored thumbnail. The content
+// hash is appended as a version parameter so caches never serve a stale
+// image when a thumbnail is replaced, even if a path were ever reused.
+func (cfg *apiConfig) thumbnailURL(fileName, contentHash string) string {
+	return fmt.Sprintf("%s/assets/%s?v=%s", cfg.baseURL, fileName, contentHash[:12])
+}
 
-	err = cfg.db.UpdateVideo(video)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+// thumbnailCropAspect returns the aspect ratio thumbnails for video should
+// be cropped to, if cropping is enabled.
+func (cfg *apiConfig) thumbnailCropAspect(video database.Video) (int, int, bool) {
+	switch cfg.thumbnailCrop {
+	case "":
+		return 0, 0, false
+	case "video":
+		if video.AspectClass == nil {
+			return 16, 9, true
+		}
+		switch *video.AspectClass {
+		case "landscape":
+			return 16, 9, true
+		case "portrait":
+			return 9, 16, true
+		default:
+			return 0, 0, false
+		}
+	default:
+		aspectW, aspectH, err := parseAspectRatio(cfg.thumbnailCrop)
+		return aspectW, aspectH, err == nil
 	}
+}
 
-	respondWithJSON(w, http.StatusOK, video)
+// parseAspectRatio parses ratios of the form "16:9".
+func parseAspectRatio(s string) (int, int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("aspect ratio %q must be in the form W:H", s)
+	}
+	w, err := strconv.Atoi(parts[0])
+	if err != nil || w <= 0 {
+		return 0, 0, fmt.Errorf("invalid aspect ratio width in %q", s)
+	}
+	h, err := strconv.Atoi(parts[1])
+	if err != nil || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid aspect ratio height in %q", s)
+	}
+	return w, h, nil
+}
+
+func isHEICMediaType(mediaType string) bool {
+	return mediaType == "image/heic" || mediaType == "image/heif"
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// uploadTokenHeader carries a delegated upload token in place of the
+// uploader's own credentials.
+const uploadTokenHeader = "X-Upload-Token"
+
+const (
+	uploadTokenDefaultTTL = 24 * time.Hour
+	uploadTokenMaxTTL     = 7 * 24 * time.Hour
+)
+
+// handlerUploadTokensCreate mints a token that authorizes exactly one
+// upload to the video, for handing to an editor or a rendering service
+// without sharing the caller's credentials.
+func (cfg *apiConfig) handlerUploadTokensCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresIn string `json:"expires_in"`
+	}
+	type response struct {
+		database.UploadToken
+		Token string `json:"token"`
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	ttl := uploadTokenDefaultTTL
+	if params.ExpiresIn != "" {
+		ttl, err = time.ParseDuration(params.ExpiresIn)
+		if err != nil || ttl <= 0 || ttl > uploadTokenMaxTTL {
+			respondWithError(w, http.StatusBadRequest, "expires_in must be a duration of at most "+uploadTokenMaxTTL.String(), err)
+			return
+		}
+	}
+
+	token, err := auth.MakeUploadToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload token", err)
+		return
+	}
+	uploadToken, err := cfg.db.CreateUploadToken(video.ID, p.UserID, auth.HashToken(token), time.Now().Add(ttl))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload token", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditUploadTokenCreated, "video", video.ID.String(), map[string]string{"upload_token_id": uploadToken.ID.String()})
+
+	// The plaintext token is only ever returned here
+	respondWithJSON(w, http.StatusCreated, response{
+		UploadToken: uploadToken,
+		Token:       token,
+	})
+}
+
+func (cfg *apiConfig) handlerUploadTokensList(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	tokens, err := cfg.db.GetUploadTokens(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve upload tokens", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+func (cfg *apiConfig) handlerUploadTokensRevoke(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	token, err := cfg.db.GetUploadToken(tokenID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload token", err)
+		return
+	}
+	if token.ID == uuid.Nil || token.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Upload token not found", nil)
+		return
+	}
+
+	err = cfg.db.RevokeUploadToken(token.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke upload token", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditUploadTokenRevoked, "video", video.ID.String(), map[string]string{"upload_token_id": token.ID.String()})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadWithToken handles a video upload authorized by a delegated upload
+// token. The token is redeemed before the upload starts so it can't be
+// used twice at once, and released again if the upload fails. The token's
+// creator must still be able to edit the video.
+func (cfg *apiConfig) uploadWithToken(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := cfg.db.RedeemUploadToken(auth.HashToken(r.Header.Get(uploadTokenHeader)), videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't redeem upload token", err)
+		return
+	}
+	if token.ID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid upload token", nil)
+		return
+	}
+	release := func() {
+		if err := cfg.db.ReleaseUploadToken(token.ID); err != nil {
+			log.Printf("Couldn't release upload token %s: %v", token.ID, err)
+		}
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		release()
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	creator := principal{}
+	if role, err := cfg.userRole(token.CreatedBy); err == nil {
+		creator = principal{UserID: token.CreatedBy, Role: role}
+	}
+	if video.ID == uuid.Nil || !cfg.canEditVideo(creator, video) {
+		release()
+		respondWithError(w, http.StatusForbidden, "Upload token is no longer valid for this video", nil)
+		return
+	}
+
+	if !cfg.ingestVideo(w, r, token.CreatedBy, video) {
+		release()
+	}
+}
@@ -3,60 +3,140 @@ package main
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"mime"
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/antivirus"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 	"github.com/google/uuid"
 )
 
+// Schemes for naming uploaded video files, chosen with VIDEO_KEY_SCHEME.
+const (
+	// videoKeysRandom names files with random bytes
+	videoKeysRandom = "random"
+	// videoKeysContent names files after the SHA-256 of their content
+	videoKeysContent = "content"
+)
+
+// supportedVideoTypes are the containers ffmpeg can remux to MP4 without
+// re-encoding; VIDEO_ALLOWED_TYPES may only narrow this set.
+var supportedVideoTypes = map[string]bool{
+	"video/mp4":       true,
+	"video/quicktime": true,
+	"video/x-m4v":     true,
+}
+
+var defaultVideoTypes = []string{"video/mp4"}
+
+// storedVideoType is the type of every stored video file, whatever it was
+// uploaded as.
+const storedVideoType = "video/mp4"
+
+// handlerUploadVideo accepts either the uploader's own credentials or a
+// delegated upload token in the X-Upload-Token header.
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+	if r.Header.Get(uploadTokenHeader) != "" {
+		cfg.uploadWithToken(w, r)
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	p := contextPrincipal(r)
+	if p.UserID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate credentials", nil)
 		return
 	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
 		return
 	}
+	fmt.Println("uploading video", video.ID, "by user", p.UserID)
 
-	fmt.Println("uploading video", videoID, "by user", userID)
+	cfg.ingestVideo(w, r, p.UserID, video)
+}
 
-	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Video couldn't be found", err)
+// handlerVideoFileReplace uploads a new file for a ready video through the
+// same pipeline as handlerUploadVideo. The video keeps its ID, metadata
+// and view count. The new file is swapped in with a single update, after
+// which the old one is removed from storage and evicted from the CDN; if
+// the upload fails the old file stays in place.
+func (cfg *apiConfig) handlerVideoFileReplace(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "User not authorized to access video", nil)
+	if video.Status != database.VideoStatusReady {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Can't replace the file of a video that is %s", video.Status), nil)
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<30)
+	cfg.ingestVideo(w, r, p.UserID, video)
+}
+
+// ingestVideo reads the "video" form file from r, processes and moderates
+// it, stores it in S3 and attaches it to video, writing the response and
+// reporting whether the upload succeeded.
+// Callers are responsible for checking the uploader, audited as actor, may
+// replace video's file.
+// The video is uploading until the file has been received, then
+// processing until it is stored and ready. If the upload fails the video
+// goes back to ready if it still has its old file, and to failed if not.
+func (cfg *apiConfig) ingestVideo(w http.ResponseWriter, r *http.Request, actor uuid.UUID, video database.Video) (stored bool) {
+	// Don't spend ffmpeg time on a file that can't be stored
+	if cfg.storageBreaker.Open() {
+		respondStorageUnavailable(w, cfg.storageBreaker, storage.ErrUnavailable)
+		return false
+	}
+
+	previousStatus := video.Status
+	err := cfg.setVideoStatus(&video, database.VideoStatusUploading)
+	if errors.Is(err, database.ErrInvalidVideoTransition) {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Can't upload a file to a video that is %s", previousStatus), err)
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return false
+	}
+	defer func() {
+		if stored {
+			return
+		}
+		status := database.VideoStatusFailed
+		if previousStatus == database.VideoStatusReady {
+			status = database.VideoStatusReady
+		}
+		if err := cfg.db.SetVideoStatus(video.ID, status); err != nil {
+			log.Printf("Couldn't mark video %s %s after a failed upload: %v", video.ID, status, err)
+		}
+	}()
+
+	// Leave room for the rest of the form
+	r.Body = http.MaxBytesReader(w, r.Body, int64(cfg.videoMaxBytes)+1<<20)
+	previous := video
+	previousStore, previousKey := cfg.videoFile(video)
 
 	file, header, err := r.FormFile("video")
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Error parsing video", err)
-		return
+		return false
 	}
 	defer file.Close()
 
@@ -68,56 +148,73 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "unable to determine file type", err)
-		return
+		return false
 	}
-	if mediaType != "video/mp4" {
+	if !cfg.videoAllowedTypes[mediaType] {
 		respondWithError(w, http.StatusBadRequest, "invalid file type", nil)
-		return
+		return false
 	}
 
 	// Create a temporary copy of the uploaded file locally
 	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "unable to create temp file location", err)
-		return
+		return false
 	}
 	defer os.Remove(tempFile.Name())
 	defer tempFile.Close()
 	written, err := io.Copy(tempFile, file)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "unable to write video to disk at temp location", err)
-		return
+		return false
+	}
+	fmt.Println("Video", video.ID, "wrote", written, "bytes to", tempFile)
+	if written > int64(cfg.videoMaxBytes) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("video must be at most %d bytes", cfg.videoMaxBytes), nil)
+		return false
+	}
+	cfg.meterUpload(r, written)
+
+	// Check the quota now rather than after ffmpeg has done its work
+	if !cfg.checkStorageQuota(w, video, written) {
+		return false
+	}
+	if err := cfg.setVideoStatus(&video, database.VideoStatusProcessing); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return false
+	}
+
+	// Scan before ffmpeg or storage touch the file, since either could be
+	// what the malware targets
+	scan, err := cfg.scanner.Scan(r.Context(), tempFile.Name())
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "unable to scan video, try again later", err)
+		return false
+	}
+	if scan.Verdict == antivirus.VerdictInfected {
+		return cfg.handleInfectedUpload(w, r, actor, video, tempFile, scan)
 	}
-	fmt.Println("User", userID, "wrote", written, "bytes to", tempFile)
 
 	tempFile.Seek(0, io.SeekStart)
 
-	extensions, err := mime.ExtensionsByType(mediaType)
+	extensions, err := mime.ExtensionsByType(storedVideoType)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "unable to determine file type", err)
-		return
+		return false
 	}
 	if len(extensions) == 0 {
 		respondWithError(w, http.StatusBadRequest, "no file extension found for media type", nil)
-		return
+		return false
 	}
 
-	// Create the file key for AWS
 	fileExtension := extensions[0]
-	key := make([]byte, 32)
-	_, err = rand.Read(key)
+	probe, err := probeVideo(cfg.mediaTools.ffprobe, tempFile.Name())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "error randomizing key", err)
-		return
-	}
-	rawFileKey := base64.RawURLEncoding.EncodeToString(key)
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "unable to determine aspect ratio", err)
-		return
+		respondWithError(w, http.StatusInternalServerError, "unable to probe video", err)
+		return false
 	}
 	aspectRatioSchema := ""
-	switch aspectRatio {
+	switch probe.AspectRatio {
 	case "16:9":
 		aspectRatioSchema = "landscape"
 	case "9:16":
@@ -126,97 +223,274 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		aspectRatioSchema = "other"
 	}
 
-	fileKey := fmt.Sprintf("%s/%s.%s", aspectRatioSchema, rawFileKey, fileExtension)
-	processedVideoFilePath, err := processVideoForFastStart(tempFile.Name())
+	processedVideoFilePath, err := processVideoForFastStart(cfg.mediaTools.ffmpeg, tempFile.Name())
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "unable to process video for fast start", err)
-		return
+		return false
 	}
 	processedVideo, err := os.Open(processedVideoFilePath)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "unable to read processed video file", err)
-		return
+		return false
 	}
 	defer os.Remove(processedVideoFilePath)
 	defer processedVideo.Close()
+	// Hash what will be uploaded, so storage can reject a transfer that
+	// was corrupted on the way and the file can be audited later
+	hasher := sha256.New()
+	videoSize, err := io.Copy(hasher, processedVideo)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "unable to read processed video file", err)
+		return false
+	}
+	if _, err := processedVideo.Seek(0, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "unable to read processed video file", err)
+		return false
+	}
+	videoSHA256 := hasher.Sum(nil)
 
-	// Upload the video file to AWS S3 bucket
-	s3PutParams := s3.PutObjectInput{
-		Bucket:      &cfg.s3Bucket,
-		Key:         &fileKey,
-		Body:        processedVideo,
-		ContentType: &mediaType,
+	// Create the file key for AWS. Content-addressed keys make retried
+	// uploads idempotent and let identical files share one object
+	rawFileKey := hex.EncodeToString(videoSHA256)
+	if cfg.videoKeyScheme != videoKeysContent {
+		key := make([]byte, 32)
+		_, err = rand.Read(key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "error randomizing key", err)
+			return false
+		}
+		rawFileKey = base64.RawURLEncoding.EncodeToString(key)
 	}
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3PutParams)
+	fileKey := fmt.Sprintf("%s/%s.%s", aspectRatioSchema, rawFileKey, fileExtension)
+
+	// Moderate before upload so quarantined videos never get a playable URL
+	modResult, err := cfg.moderator.Moderate(r.Context(), processedVideoFilePath)
 	if err != nil {
-		errorMessage := fmt.Sprintf("unable to write  file to s3 bucket: %s", cfg.s3Bucket)
-		respondWithError(w, http.StatusBadRequest, errorMessage, err)
-		return
+		respondWithError(w, http.StatusInternalServerError, "unable to moderate video", err)
+		return false
+	}
+	if modResult.Verdict == moderation.VerdictQuarantined {
+		fileKey = "quarantine/" + fileKey
+	}
+	location := cfg.uploadLocation(video.OrgID)
+	fileKey = location.prefix + fileKey
+
+	// Upload the video file to object storage. A key never holds different
+	// content, so the CDN can cache objects for as long as assets are
+	// cached locally
+	cacheControl := fmt.Sprintf("public, max-age=%d", int(cfg.assetsCacheMaxAge.Seconds()))
+	if cfg.assetsCacheMaxAge <= 0 {
+		cacheControl = "no-store"
+	}
+	err = location.store.PutObject(r.Context(), fileKey, processedVideo, storage.PutOptions{
+		ContentType:  storedVideoType,
+		CacheControl: cacheControl,
+		StorageClass: cfg.storageClasses.upload,
+		SHA256:       videoSHA256,
+	})
+	if errors.Is(err, storage.ErrUnavailable) {
+		respondStorageUnavailable(w, cfg.storageBreaker, err)
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "unable to write file to storage", err)
+		return false
 	}
 
-	// Write the videoURL to our database
-	cdnUrl := fmt.Sprintf("%s/%s", cfg.s3CfDistribution, fileKey)
-	video.VideoURL = &cdnUrl
+	// Write the videoURL to our database. Processing takes a while, so the
+	// fields the upload owns are applied to the video as it is now rather
+	// than to the copy read at the start, keeping metadata edited meanwhile
+	moderationStatus := string(modResult.Verdict)
+	provider, bucket := storeLocation(location.store)
+	storedAt := time.Now().UTC()
+	setFile := func(video *database.Video) {
+		video.ModerationStatus = &moderationStatus
+		video.ModerationScore = &modResult.Score
+		video.ModerationReason = nil
+		if modResult.Reason != "" {
+			video.ModerationReason = &modResult.Reason
+		}
+		video.VideoProvider = &provider
+		video.VideoBucket = bucket
+		video.VideoURL, video.VideoKey = nil, nil
+		video.QuarantineKey = nil
+		if modResult.Verdict == moderation.VerdictQuarantined {
+			video.QuarantineKey = &fileKey
+		} else {
+			cdnUrl := fmt.Sprintf("%s/%s", cfg.s3CfDistribution, fileKey)
+			video.VideoURL = &cdnUrl
+			video.VideoKey = &fileKey
+		}
+		video.AspectClass = &aspectRatioSchema
+		video.DurationSeconds = probe.DurationSeconds
+		video.MediaInfo = probe.MediaInfo
+		video.VideoSize = &videoSize
+		videoDigest := hex.EncodeToString(videoSHA256)
+		video.VideoSHA256 = &videoDigest
+		scanVerdict := string(scan.Verdict)
+		video.ScanVerdict = &scanVerdict
+		video.ScanSignature = nil
+		video.StorageClass = nil
+		if cfg.storageClasses.upload != "" {
+			video.StorageClass = &cfg.storageClasses.upload
+		}
+		video.StoredAt = &storedAt
+		video.RestoreRequestedAt = nil
+		video.RestoreTier = nil
+		video.Status = database.VideoStatusReady
+	}
 
-	err = cfg.db.UpdateVideo(video)
+	// The event is queued with the update, so webhooks hear about every
+	// upload that was saved even if the app dies right after
+	video, err = cfg.saveVideoChanges(video.ID, setFile, func(video database.Video, ifVersion int64) error {
+		event, err := json.Marshal(videoEvent{Type: eventVideoUploaded, CreatedAt: storedAt, Video: video})
+		if err != nil {
+			return err
+		}
+		return cfg.db.UpdateVideoWithEvent(video, ifVersion, eventVideoUploaded, event, cfg.outbox.webhooks)
+	})
+	if err == nil {
+		err = cfg.db.SetVideoStatus(video.ID, database.VideoStatusReady)
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+		return false
+	}
+	if previousKey != nil && (*previousKey != fileKey || !sameLocation(previousStore, provider, bucket)) {
+		cfg.retireVideoFile(r.Context(), previous, previousStore, *previousKey)
 	}
+	cfg.queueAutoCaptions(video)
+	cfg.audit(r, actor, auditVideoUploaded, "video", video.ID.String(), map[string]string{
+		"bytes":      strconv.FormatInt(videoSize, 10),
+		"moderation": moderationStatus,
+		"replaced":   strconv.FormatBool(previousKey != nil),
+	})
 
 	respondWithJSON(w, http.StatusOK, video)
+	return true
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-	fmt.Printf("filePath: %s \r\n", filePath)
+// setVideoStatus moves video to status, both in the database and in
+// video itself.
+func (cfg *apiConfig) setVideoStatus(video *database.Video, status string) error {
+	if err := cfg.db.SetVideoStatus(video.ID, status); err != nil {
+		return err
+	}
+	video.Status = status
+	return nil
+}
+
+// videoProbe is what ffprobe reports about an uploaded video file.
+type videoProbe struct {
+	// AspectRatio is the display aspect ratio, e.g. "16:9", or "other"
+	AspectRatio string
+	// DurationSeconds is nil if ffprobe can't tell
+	DurationSeconds *float64
+	database.MediaInfo
+}
+
+// probeVideo runs ffprobe on a video file once for everything recorded
+// about it: its aspect ratio and duration, the size and frame rate of its
+// first video stream, the codecs of its first video and audio streams, and
+// its overall bitrate.
+func probeVideo(ffprobePath, filePath string) (videoProbe, error) {
+	cmd := exec.Command(ffprobePath, "-v", "error", "-print_format", "json", "-show_streams", "-show_format", filePath)
 	var buffer bytes.Buffer
 	cmd.Stdout = &buffer
-
 	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("ffprobe error: %s", err)
+		return videoProbe{}, fmt.Errorf("ffprobe error: %s", err)
 	}
 
-	var videoProps struct {
+	var output struct {
 		Streams []struct {
-			Width       int    `json:"width"`
-			Height      int    `json:"height"`
-			AspectRatio string `json:"display_aspect_ratio"`
+			CodecType    string `json:"codec_type"`
+			CodecName    string `json:"codec_name"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			AspectRatio  string `json:"display_aspect_ratio"`
+			AvgFrameRate string `json:"avg_frame_rate"`
 		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+			BitRate  string `json:"bit_rate"`
+		} `json:"format"`
 	}
-
-	if err := json.Unmarshal(buffer.Bytes(), &videoProps); err != nil {
-		log.Fatalf("Failed to unmarshal JSON: %v", err)
+	if err := json.Unmarshal(buffer.Bytes(), &output); err != nil {
+		return videoProbe{}, fmt.Errorf("couldn't parse ffprobe output: %w", err)
 	}
 
-	if len(videoProps.Streams) == 0 {
-		return "", errors.New("no video streams found")
+	probe := videoProbe{}
+	foundVideo := false
+	for _, stream := range output.Streams {
+		switch {
+		case stream.CodecType == "video" && !foundVideo:
+			foundVideo = true
+			probe.AspectRatio = aspectRatio(stream.Width, stream.Height, stream.AspectRatio)
+			if stream.Width > 0 && stream.Height > 0 {
+				probe.Width = &stream.Width
+				probe.Height = &stream.Height
+			}
+			if stream.CodecName != "" {
+				probe.VideoCodec = &stream.CodecName
+			}
+			probe.FrameRate = parseFrameRate(stream.AvgFrameRate)
+		case stream.CodecType == "audio" && probe.AudioCodec == nil:
+			if stream.CodecName != "" {
+				probe.AudioCodec = &stream.CodecName
+			}
+		}
+	}
+	if !foundVideo {
+		return videoProbe{}, errors.New("no video streams found")
 	}
 
-	width := videoProps.Streams[0].Width
-	height := videoProps.Streams[0].Height
-	aspectRatio := videoProps.Streams[0].AspectRatio
+	if duration, err := strconv.ParseFloat(output.Format.Duration, 64); err == nil {
+		probe.DurationSeconds = &duration
+	}
+	if bitrate, err := strconv.ParseInt(output.Format.BitRate, 10, 64); err == nil && bitrate > 0 {
+		probe.Bitrate = &bitrate
+	}
+	return probe, nil
+}
 
-	if aspectRatio != "" {
-		fmt.Printf("Display Aspect Ratio: %v", aspectRatio)
-		return aspectRatio, nil
+// aspectRatio returns a stream's display aspect ratio, working it out from
+// its size when ffprobe doesn't report one.
+func aspectRatio(width, height int, displayAspectRatio string) string {
+	if displayAspectRatio != "" && displayAspectRatio != "0:1" {
+		return displayAspectRatio
+	}
+	switch {
+	case width*9 == height*16:
+		return "16:9"
+	case width*16 == height*9:
+		return "9:16"
+	default:
+		return "other"
 	}
+}
 
-	if width*9 == height*16 {
-		fmt.Printf("Width: %d, Height: %d, Ratio: %s \r\n", width*9, height*16, "16:9")
-		return "16:9", nil
-	} else if width*16 == height*9 {
-		fmt.Printf("Width: %d, Height: %d, Ratio: %s \r\n", width*16, height*9, "9:16")
-		return "9:16", nil
-	} else {
-		fmt.Printf("Width: %d, Height: %d, Ratio: %s \r\n", width*16, height*9, "Other")
-		return "other", nil
+// parseFrameRate parses an ffprobe frame rate such as "30000/1001", or
+// returns nil if the stream doesn't have a usable one.
+func parseFrameRate(s string) *float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		return nil
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return nil
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || n <= 0 || d <= 0 {
+		return nil
 	}
+	rate := math.Round(n/d*1000) / 1000
+	return &rate
 }
 
-func processVideoForFastStart(filePath string) (string, error) {
+func processVideoForFastStart(ffmpegPath, filePath string) (string, error) {
 	outputFilePath := filePath + ".processing"
-	cmd := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputFilePath)
+	cmd := exec.Command(ffmpegPath, "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputFilePath)
 	var buffer bytes.Buffer
 	cmd.Stdout = &buffer
 
@@ -225,3 +499,12 @@ func processVideoForFastStart(filePath string) (string, error) {
 	}
 	return outputFilePath, nil
 }
+
+// respondStorageUnavailable tells the client to come back once breaker
+// lets calls through again.
+func respondStorageUnavailable(w http.ResponseWriter, breaker *storage.Breaker, err error) {
+	if breaker != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(breaker.Cooldown.Seconds()))))
+	}
+	respondWithError(w, http.StatusServiceUnavailable, "Storage is temporarily unavailable, try again later", err)
+}
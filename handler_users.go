@@ -2,16 +2,26 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"slices"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+		Password     string `json:"password"`
+		Email        string `json:"email"`
+		CaptchaToken string `json:"captcha_token"`
+		InviteCode   string `json:"invite_code"`
+	}
+	type inviteRequired struct {
+		Error          string `json:"error"`
+		InviteRequired bool   `json:"invite_required"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -27,20 +37,125 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Ask for a missing invite before the captcha token is spent
+	if cfg.requiresInvite(params.Email) && params.InviteCode == "" {
+		respondWithJSON(w, http.StatusForbidden, inviteRequired{
+			Error:          "An invite code is required",
+			InviteRequired: true,
+		})
+		return
+	}
+
+	if !cfg.verifyCaptcha(w, r, params.CaptchaToken) {
+		return
+	}
+
 	hashedPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
 		return
 	}
 
+	inviteID := uuid.Nil
+	if cfg.requiresInvite(params.Email) {
+		inviteID, err = cfg.db.RedeemInviteCode(auth.HashToken(params.InviteCode))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check invite code", err)
+			return
+		}
+		if inviteID == uuid.Nil {
+			respondWithJSON(w, http.StatusForbidden, inviteRequired{
+				Error:          "A valid invite code is required",
+				InviteRequired: true,
+			})
+			return
+		}
+	}
+
 	user, err := cfg.db.CreateUser(database.CreateUserParams{
 		Email:    params.Email,
 		Password: hashedPassword,
+		Role:     cfg.initialRole(params.Email, false),
 	})
 	if err != nil {
+		if inviteID != uuid.Nil {
+			if releaseErr := cfg.db.ReleaseInviteCode(inviteID); releaseErr != nil {
+				log.Printf("Couldn't release invite code %s: %v", inviteID, releaseErr)
+			}
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
 	}
 
+	details := map[string]string{}
+	if inviteID != uuid.Nil {
+		details["invite_id"] = inviteID.String()
+	}
+	cfg.audit(r, user.ID, auditUserCreated, "user", user.ID.String(), details)
+
 	respondWithJSON(w, http.StatusCreated, user)
 }
+
+// initialRole is the role a new account with email starts with. Only a
+// verified address is made an admin, since anyone can sign up with a
+// password before the address's owner does. Password accounts never
+// verify their address, so they stay users until an admin promotes them.
+func (cfg *apiConfig) initialRole(email string, verified bool) string {
+	if verified && slices.Contains(cfg.adminEmails, email) {
+		return auth.RoleAdmin
+	}
+	return auth.DefaultRole
+}
+
+func (cfg *apiConfig) handlerUserRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !auth.ValidRole(params.Role) {
+		respondWithError(w, http.StatusBadRequest, "Unknown role "+params.Role, nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	// Demoting the last admin, themselves included, would leave nobody
+	// able to manage roles
+	err = cfg.db.SetUserRole(userID, params.Role, auth.RoleAdmin)
+	if errors.Is(err, database.ErrLastUserWithRole) {
+		respondWithError(w, http.StatusConflict, "There must be at least one admin", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+		return
+	}
+	cfg.audit(r, contextPrincipal(r).UserID, auditUserRoleChanged, "user", userID.String(), map[string]string{
+		"from": user.Role,
+		"to":   params.Role,
+	})
+	user.Role = params.Role
+
+	respondWithJSON(w, http.StatusOK, user)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestInitialRole(t *testing.T) {
+	cfg := &apiConfig{adminEmails: []string{"admin@example.com"}}
+	tests := []struct {
+		name     string
+		email    string
+		verified bool
+		want     string
+	}{
+		{name: "verified admin email", email: "admin@example.com", verified: true, want: auth.RoleAdmin},
+		{name: "unverified admin email", email: "admin@example.com", verified: false, want: auth.DefaultRole},
+		{name: "verified other email", email: "user@example.com", verified: true, want: auth.DefaultRole},
+		{name: "unverified other email", email: "user@example.com", verified: false, want: auth.DefaultRole},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.initialRole(tt.email, tt.verified); got != tt.want {
+				t.Errorf("initialRole(%q, %v) = %q, want %q", tt.email, tt.verified, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerUserRoleUpdate(t *testing.T) {
+	tests := []struct {
+		name       string
+		admins     int  // admins besides the caller
+		self       bool // whether the caller changes their own role
+		role       string
+		wantStatus int
+	}{
+		{name: "demote the only admin", self: true, role: auth.DefaultRole, wantStatus: http.StatusConflict},
+		{name: "demote self with another admin", admins: 1, self: true, role: auth.DefaultRole, wantStatus: http.StatusOK},
+		{name: "demote another admin", admins: 1, role: auth.DefaultRole, wantStatus: http.StatusOK},
+		{name: "keep the only admin an admin", self: true, role: auth.RoleAdmin, wantStatus: http.StatusOK},
+		{name: "promote a user", role: auth.RoleAdmin, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			newAdmin := func() *database.User {
+				t.Helper()
+				user, err := cfg.db.CreateUser(database.CreateUserParams{
+					Email:    uuid.NewString() + "@example.com",
+					Password: "unused",
+					Role:     auth.RoleAdmin,
+				})
+				if err != nil {
+					t.Fatalf("CreateUser() error = %v", err)
+				}
+				return user
+			}
+			caller := newAdmin()
+			var other *database.User
+			for range tt.admins {
+				other = newAdmin()
+			}
+			target := caller
+			if !tt.self {
+				target = other
+				if target == nil {
+					target = newTestUser(t, cfg)
+				}
+			}
+
+			r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"role":"`+tt.role+`"}`))
+			r.SetPathValue("userID", target.ID.String())
+			r = withContextPrincipal(r, principal{UserID: caller.ID, Role: caller.Role})
+			w := httptest.NewRecorder()
+			cfg.handlerUserRoleUpdate(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			got, err := cfg.db.GetUser(target.ID)
+			if err != nil {
+				t.Fatalf("GetUser() error = %v", err)
+			}
+			want := tt.role
+			if tt.wantStatus != http.StatusOK {
+				want = target.Role
+			}
+			if got.Role != want {
+				t.Errorf("role = %q, want %q", got.Role, want)
+			}
+		})
+	}
+}
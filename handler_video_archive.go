@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// restoreETAs are AWS's typical restore times per archive class and
+// retrieval tier, used to tell viewers roughly when a video will be back.
+var restoreETAs = map[string]map[string]time.Duration{
+	"GLACIER": {
+		"Expedited": 5 * time.Minute,
+		"Standard":  5 * time.Hour,
+		"Bulk":      12 * time.Hour,
+	},
+	"DEEP_ARCHIVE": {
+		"Standard": 12 * time.Hour,
+		"Bulk":     48 * time.Hour,
+	},
+}
+
+const defaultRestoreTier = "Standard"
+
+const (
+	archiveStatusArchived  = "archived"
+	archiveStatusRestoring = "restoring"
+	archiveStatusRestored  = "restored"
+)
+
+// archiveStatus reports whether an archived video can be played.
+type archiveStatus struct {
+	Status string `json:"status"`
+	// ETA is a rough guess at when a restore in progress will finish
+	ETA *time.Time `json:"eta,omitempty"`
+	// ExpiresAt is when a restored copy will be archived again
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func isArchiveClass(class *string) bool {
+	if class == nil {
+		return false
+	}
+	_, ok := restoreETAs[*class]
+	return ok
+}
+
+// videoArchiveStatus checks the restore state of an archived video's
+// file. It returns false if the video isn't archived.
+func (cfg *apiConfig) videoArchiveStatus(ctx context.Context, video database.Video) (archiveStatus, bool, error) {
+	store, key := cfg.videoFile(video)
+	restorer, ok := store.(storage.Restorer)
+	if !ok || key == nil || !isArchiveClass(video.StorageClass) {
+		return archiveStatus{}, false, nil
+	}
+
+	restore, err := restorer.RestoreStatus(ctx, *key)
+	if err != nil {
+		return archiveStatus{}, true, err
+	}
+	switch {
+	case restore.Ongoing:
+		status := archiveStatus{Status: archiveStatusRestoring}
+		if video.RestoreRequestedAt != nil && video.RestoreTier != nil {
+			if eta, ok := restoreETAs[*video.StorageClass][*video.RestoreTier]; ok {
+				at := video.RestoreRequestedAt.Add(eta)
+				status.ETA = &at
+			}
+		}
+		return status, true, nil
+	case !restore.ExpiresAt.IsZero():
+		return archiveStatus{Status: archiveStatusRestored, ExpiresAt: &restore.ExpiresAt}, true, nil
+	default:
+		return archiveStatus{Status: archiveStatusArchived}, true, nil
+	}
+}
+
+// playableArchive writes a 409 with the archive status and returns false
+// if video's file is archived and not currently restored.
+func (cfg *apiConfig) playableArchive(w http.ResponseWriter, r *http.Request, video database.Video) bool {
+	status, archived, err := cfg.videoArchiveStatus(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check archive status", err)
+		return false
+	}
+	if !archived || status.Status == archiveStatusRestored {
+		return true
+	}
+
+	respondWithJSON(w, http.StatusConflict, struct {
+		Error string `json:"error"`
+		archiveStatus
+	}{
+		Error:         "Video is archived",
+		archiveStatus: status,
+	})
+	return false
+}
+
+// handlerVideoArchive moves a video's file to the archive storage class
+// straight away, rather than waiting for a transition.
+func (cfg *apiConfig) handlerVideoArchive(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	store, key := cfg.videoFile(video)
+	if key == nil {
+		respondWithError(w, http.StatusConflict, "Video has no file to archive", nil)
+		return
+	}
+	mover, ok := store.(storage.ClassMover)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Storage doesn't support archiving", nil)
+		return
+	}
+	if isArchiveClass(video.StorageClass) {
+		respondWithError(w, http.StatusConflict, "Video is already archived", nil)
+		return
+	}
+
+	class := cfg.storageClasses.archive
+	err := mover.SetStorageClass(r.Context(), *key, class)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't archive video", err)
+		return
+	}
+	err = cfg.db.SetVideoStorageClass(video.ID, class)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditVideoArchived, "video", video.ID.String(), map[string]string{"storage_class": class})
+
+	video.StorageClass = &class
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerVideoRestore asks for an archived video's file to be made
+// playable again for the configured number of days.
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tier string `json:"tier"`
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Tier == "" {
+		params.Tier = defaultRestoreTier
+	}
+
+	store, key := cfg.videoFile(video)
+	restorer, ok := store.(storage.Restorer)
+	if !ok || key == nil || !isArchiveClass(video.StorageClass) {
+		respondWithError(w, http.StatusConflict, "Video is not archived", nil)
+		return
+	}
+	if _, ok := restoreETAs[*video.StorageClass][params.Tier]; !ok {
+		respondWithError(w, http.StatusBadRequest, "Unsupported restore tier for "+*video.StorageClass, nil)
+		return
+	}
+
+	err = restorer.RestoreObject(r.Context(), *key, cfg.storageClasses.restoreDays, params.Tier)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+	err = cfg.db.SetVideoRestoreRequested(video.ID, params.Tier)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditVideoRestoreRequested, "video", video.ID.String(), map[string]string{"tier": params.Tier})
+
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	status, _, err := cfg.videoArchiveStatus(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check archive status", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, status)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxBatchVideos caps how many videos one batch update can change.
+const maxBatchVideos = 500
+
+// Outcomes of a batch update for each video.
+const (
+	batchUpdated   = "updated"
+	batchUnchanged = "unchanged"
+	// batchSkipped marks a video that was fine but wasn't updated
+	// because another video in the batch failed
+	batchSkipped   = "skipped"
+	batchNotFound  = "not_found"
+	batchForbidden = "forbidden"
+	batchInvalid   = "invalid"
+	// batchModified marks a video that has changed since the ETag sent
+	// for it
+	batchModified = "modified"
+)
+
+// videoBatchPatch is a videoPatch applied to many videos, which can also
+// add tags to and remove tags from each video's own.
+type videoBatchPatch struct {
+	videoPatch
+	AddTags    []string `json:"add_tags"`
+	RemoveTags []string `json:"remove_tags"`
+}
+
+// validate is videoPatch.validate that also normalizes the tags to add
+// and remove.
+func (patch *videoBatchPatch) validate() fieldErrors {
+	errs := patch.videoPatch.validate()
+	for field, tags := range map[string]*[]string{"add_tags": &patch.AddTags, "remove_tags": &patch.RemoveTags} {
+		normalized, err := normalizeTags(*tags)
+		if err != nil {
+			errs[field] = err.Error()
+			continue
+		}
+		*tags = normalized
+	}
+	if patch.Tags != nil && len(patch.AddTags) > 0 {
+		errs["add_tags"] = "send either tags or add_tags, not both"
+	}
+	return errs
+}
+
+type videoBatchResult struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// handlerVideoBatchUpdate applies a patch to many videos at once, such as
+// making them private or adding a tag. Every video is checked first, and
+// the patch is applied in one transaction only if all of them can take
+// it; otherwise nothing changes and the response says which videos
+// failed. Each video is reported on in the order given. Videos can be
+// given ETags in if_match, as with If-Match on handlerVideoMetaPatch, to
+// fail the batch if any of them has changed since.
+func (cfg *apiConfig) handlerVideoBatchUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID          `json:"video_ids"`
+		Patch    videoBatchPatch      `json:"patch"`
+		IfMatch  map[uuid.UUID]string `json:"if_match"`
+	}
+	type response struct {
+		Applied bool               `json:"applied"`
+		Results []videoBatchResult `json:"results"`
+	}
+
+	p := contextPrincipal(r)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.VideoIDs) == 0 || len(params.VideoIDs) > maxBatchVideos {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("video_ids must list between 1 and %d videos", maxBatchVideos), nil)
+		return
+	}
+	if errs := params.Patch.validate(); len(errs) > 0 {
+		respondWithFieldErrors(w, errs)
+		return
+	}
+
+	results := make([]videoBatchResult, 0, len(params.VideoIDs))
+	updates := []database.VideoUpdate{}
+	changedFields := map[uuid.UUID][]string{}
+	failed := false
+	seen := map[uuid.UUID]bool{}
+	for _, id := range params.VideoIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		etag, hasETag := params.IfMatch[id]
+		result, err := cfg.prepareBatchUpdate(p, id, params.Patch, etag, hasETag)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		results = append(results, result.videoBatchResult)
+		if result.Status != batchUpdated && result.Status != batchUnchanged {
+			failed = true
+			continue
+		}
+		if result.Status == batchUpdated {
+			updates = append(updates, result.update)
+			changedFields[id] = result.changed
+		}
+	}
+
+	if failed {
+		for i := range results {
+			if results[i].Status == batchUpdated || results[i].Status == batchUnchanged {
+				results[i].Status = batchSkipped
+			}
+		}
+		respondWithJSON(w, http.StatusUnprocessableEntity, response{Results: results})
+		return
+	}
+	if len(updates) > 0 {
+		err = cfg.db.UpdateVideosWithTags(updates)
+		if errors.Is(err, database.ErrVideoModified) {
+			respondWithError(w, http.StatusPreconditionFailed, "A video has changed since it was loaded", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update videos", err)
+			return
+		}
+	}
+	for _, update := range updates {
+		video := update.Video
+		changed := changedFields[video.ID]
+		cfg.audit(r, p.UserID, auditVideoUpdated, "video", video.ID.String(), map[string]string{
+			"fields": strings.Join(changed, ","),
+			"batch":  "true",
+		})
+		if slices.Contains(changed, "visibility") {
+			cfg.audit(r, p.UserID, auditVideoVisibility, "video", video.ID.String(), map[string]string{"visibility": video.Visibility})
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response{Applied: true, Results: results})
+}
+
+// batchUpdate is what a batch update would do to one video.
+type batchUpdate struct {
+	videoBatchResult
+	update  database.VideoUpdate
+	changed []string
+}
+
+// prepareBatchUpdate checks that p may apply patch to a video, still at
+// etag if hasETag is set, and works out the update without saving it.
+func (cfg *apiConfig) prepareBatchUpdate(p principal, id uuid.UUID, patch videoBatchPatch, etag string, hasETag bool) (batchUpdate, error) {
+	result := batchUpdate{videoBatchResult: videoBatchResult{VideoID: id}}
+	fail := func(status, msg string) (batchUpdate, error) {
+		result.Status, result.Error = status, msg
+		return result, nil
+	}
+
+	video, err := cfg.db.GetVideo(id)
+	if err != nil {
+		return batchUpdate{}, err
+	}
+	// Videos the caller can't see are reported as missing, so a batch
+	// can't be used to probe for them
+	if video.ID == uuid.Nil || !cfg.canViewVideo(p, video) {
+		return fail(batchNotFound, "video not found")
+	}
+	if !cfg.canEditVideo(p, video) {
+		return fail(batchForbidden, "you can't edit this video")
+	}
+	if patch.Visibility != nil && !cfg.canManageVideo(p, video) {
+		return fail(batchForbidden, "you can't change this video's visibility")
+	}
+	var ifVersion int64
+	if hasETag {
+		if etag != "*" && etag != videoETag(video) {
+			return fail(batchModified, "the video has changed since it was loaded")
+		}
+		ifVersion = video.Version
+	}
+
+	single := patch.videoPatch
+	if len(patch.AddTags) > 0 || len(patch.RemoveTags) > 0 {
+		tags := video.Tags
+		if single.Tags != nil {
+			tags = *single.Tags
+		}
+		tags = append(slices.Clone(tags), patch.AddTags...)
+		tags = slices.DeleteFunc(tags, func(tag string) bool {
+			return slices.Contains(patch.RemoveTags, tag)
+		})
+		tags, err := normalizeTags(tags)
+		if err != nil {
+			return fail(batchInvalid, err.Error())
+		}
+		single.Tags = &tags
+	}
+
+	video, tags, changed := single.apply(video)
+	result.Status = batchUnchanged
+	if len(changed) > 0 {
+		result.Status = batchUpdated
+		result.update = database.VideoUpdate{Video: video, Tags: tags, IfVersion: ifVersion}
+		result.changed = changed
+	}
+	return result, nil
+}
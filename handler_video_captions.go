@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// maxCaptionBytes caps the size of an uploaded caption file.
+const maxCaptionBytes = 2 << 20
+
+// playbackCaption is a caption track a player can load alongside the
+// video.
+type playbackCaption struct {
+	Language string `json:"language"`
+	URL      string `json:"url"`
+}
+
+// handlerVideoCaptionPut uploads the caption file for one language of a
+// video, replacing any there was, from the "caption" form file. SRT files
+// are converted to WebVTT, which is what players load. Uploading an edited
+// copy of generated captions makes them the owner's own.
+func (cfg *apiConfig) handlerVideoCaptionPut(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+	language, err := normalizeLanguageTag(r.PathValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	file, _, err := streamFormFile(w, r, "caption", maxCaptionBytes)
+	if errors.Is(err, errUploadTooLarge) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("caption files must be at most %d bytes", maxCaptionBytes), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error parsing caption file", err)
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read caption file", err)
+		return
+	}
+	cfg.meterUpload(r, int64(len(data)))
+	vtt, err := captions.ToWebVTT(data)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	caption, err := cfg.storeCaption(r.Context(), video, language, vtt, false)
+	if errors.Is(err, storage.ErrUnavailable) {
+		respondStorageUnavailable(w, cfg.storageBreaker, err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store caption file", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditVideoCaptionsChanged, "video", video.ID.String(), map[string]string{"language": language})
+
+	respondWithJSON(w, http.StatusOK, caption)
+}
+
+// storeCaption writes a WebVTT file to storage as video's caption in
+// language and records it, removing the file it replaces. Generated
+// captions don't replace ones uploaded by hand, failing with
+// database.ErrCaptionExists instead.
+func (cfg *apiConfig) storeCaption(ctx context.Context, video database.Video, language string, vtt []byte, autoGenerated bool) (database.VideoCaption, error) {
+	// Each upload gets its own key, so a CDN never serves a stale copy
+	suffix := make([]byte, 16)
+	if _, err := rand.Read(suffix); err != nil {
+		return database.VideoCaption{}, err
+	}
+	location := cfg.uploadLocation(video.OrgID)
+	key := fmt.Sprintf("%scaptions/%s/%s-%s.vtt", location.prefix, video.ID, language, base64.RawURLEncoding.EncodeToString(suffix))
+	err := location.store.PutObject(ctx, key, bytes.NewReader(vtt), storage.PutOptions{
+		ContentType:  captions.ContentType,
+		CacheControl: "private, max-age=3600",
+	})
+	if err != nil {
+		return database.VideoCaption{}, err
+	}
+
+	provider, bucket := storeLocation(location.store)
+	caption := database.VideoCaption{
+		VideoID:       video.ID,
+		Language:      language,
+		Size:          int64(len(vtt)),
+		AutoGenerated: autoGenerated,
+		Provider:      provider,
+		Bucket:        bucket,
+		Key:           key,
+	}
+	previous, err := cfg.db.SetVideoCaption(caption)
+	if err != nil {
+		cfg.removeCaptionFile(ctx, video, caption)
+		return database.VideoCaption{}, err
+	}
+	if previous != nil {
+		cfg.removeCaptionFile(ctx, video, *previous)
+	}
+	return cfg.db.GetVideoCaption(video.ID, language)
+}
+
+// handlerVideoCaptionsList lists the caption files of a video the caller
+// may watch.
+func (cfg *apiConfig) handlerVideoCaptionsList(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No video was returned", nil)
+		return
+	}
+	if !cfg.viewableVideo(w, r, contextPrincipal(r), video) {
+		return
+	}
+
+	list, err := cfg.db.GetVideoCaptions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve captions", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, list)
+}
+
+// handlerVideoCaptionDelete removes a video's caption in one language.
+func (cfg *apiConfig) handlerVideoCaptionDelete(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+	language, err := normalizeLanguageTag(r.PathValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	caption, err := cfg.db.DeleteVideoCaption(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete caption", err)
+		return
+	}
+	if caption.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video has no captions in this language", nil)
+		return
+	}
+	cfg.removeCaptionFile(r.Context(), video, caption)
+	cfg.audit(r, p.UserID, auditVideoCaptionsChanged, "video", video.ID.String(), map[string]string{"removed": language})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPlaybackCaption serves a caption file to a player holding a
+// playback token for its video.
+func (cfg *apiConfig) handlerPlaybackCaption(w http.ResponseWriter, r *http.Request) {
+	_, video, ok := cfg.playbackVideo(w, r)
+	if !ok {
+		return
+	}
+	cfg.serveCaption(w, r, video)
+}
+
+// handlerVideoCaptionGet serves a caption file of a video the caller may
+// watch, such as generated captions its owner wants to correct.
+func (cfg *apiConfig) handlerVideoCaptionGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No video was returned", nil)
+		return
+	}
+	if !cfg.viewableVideo(w, r, contextPrincipal(r), video) {
+		return
+	}
+	cfg.serveCaption(w, r, video)
+}
+
+// serveCaption writes video's caption file in the request's {language}.
+// Caption files are small, so they are proxied rather than presigned,
+// which also works for encrypted stores.
+func (cfg *apiConfig) serveCaption(w http.ResponseWriter, r *http.Request, video database.Video) {
+	language, err := normalizeLanguageTag(r.PathValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	caption, err := cfg.db.GetVideoCaption(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get caption", err)
+		return
+	}
+	store := cfg.locationStore(video.OrgID, caption.Provider, caption.Bucket)
+	if caption.ID == uuid.Nil || store == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no captions in this language", nil)
+		return
+	}
+
+	obj, err := store.GetObject(r.Context(), caption.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video has no captions in this language", err)
+		return
+	}
+	if errors.Is(err, storage.ErrUnavailable) {
+		respondStorageUnavailable(w, cfg.storageBreaker, err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open caption file", err)
+		return
+	}
+	defer obj.Close()
+
+	w.Header().Set("Content-Type", captions.ContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	n, err := io.Copy(w, obj)
+	if err != nil {
+		log.Printf("Couldn't serve caption %s of video %s: %v", language, video.ID, err)
+	}
+	cfg.recordEgressBytes(egressApp, n)
+}
+
+// playbackCaptions returns links to video's caption files that work for
+// as long as the playback token.
+func (cfg *apiConfig) playbackCaptions(token string, video database.Video) []playbackCaption {
+	tracks := []playbackCaption{}
+	for _, language := range video.Captions {
+		tracks = append(tracks, playbackCaption{
+			Language: language,
+			URL: cfg.baseURL + "/api/playback/" + video.ID.String() + "/captions/" + language + "?" +
+				url.Values{"token": {token}}.Encode(),
+		})
+	}
+	return tracks
+}
+
+// removeCaptionFile deletes a caption's file from storage. Failures are
+// logged, since the caption has already been forgotten.
+func (cfg *apiConfig) removeCaptionFile(ctx context.Context, video database.Video, caption database.VideoCaption) {
+	store := cfg.locationStore(video.OrgID, caption.Provider, caption.Bucket)
+	if store == nil {
+		return
+	}
+	if err := store.Delete(ctx, caption.Key); err != nil {
+		log.Printf("Couldn't delete caption file %s: %v", caption.Key, err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerVideoDownload streams a video's original file, the only one
+// stored for it, as an attachment named after its title, for anyone who
+// may watch it. Range requests are answered where the store can read
+// ranges, so interrupted downloads can resume.
+func (cfg *apiConfig) handlerVideoDownload(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No video was returned", nil)
+		return
+	}
+	p := contextPrincipal(r)
+	if !cfg.viewableVideo(w, r, p, video) {
+		return
+	}
+
+	// Quarantined files are never handed out, not even to their owner, and
+	// neither are files of videos that are still processing or in the trash
+	if _, key := cfg.videoFile(video); key == nil || video.QuarantineKey != nil || video.VideoURL == nil || video.Status != database.VideoStatusReady {
+		respondWithError(w, http.StatusNotFound, "Video has no playable file", nil)
+		return
+	}
+	if !cfg.playableArchive(w, r, video) {
+		return
+	}
+
+	w.Header().Set("Content-Type", storedVideoType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": downloadFilename(video.Title) + ".mp4",
+	}))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	cfg.serveVideoFile(w, r, video)
+}
+
+// downloadFilename turns a video title into a file name, dropping the
+// characters file systems or headers would choke on.
+func downloadFilename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return -1
+		}
+		return r
+	}, title)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "video"
+	}
+	return name
+}
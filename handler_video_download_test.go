@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func TestHandlerVideoDownload(t *testing.T) {
+	content := []byte("0123456789abcdef")
+	tests := []struct {
+		name        string
+		statuses    []string // the video moves through these after its upload starts
+		quarantined bool
+		wantStatus  int
+	}{
+		{name: "ready", statuses: []string{database.VideoStatusReady}, wantStatus: http.StatusOK},
+		{name: "uploading", wantStatus: http.StatusNotFound},
+		{name: "processing", statuses: []string{database.VideoStatusProcessing}, wantStatus: http.StatusNotFound},
+		{name: "trashed", statuses: []string{database.VideoStatusReady, database.VideoStatusTrashed}, wantStatus: http.StatusNotFound},
+		{name: "quarantined", statuses: []string{database.VideoStatusReady}, quarantined: true, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			owner := newTestUser(t, cfg)
+			video := newTestVideo(t, cfg, owner.ID)
+			key, provider, url := "videos/test.mp4", "local", "http://localhost/videos/test.mp4"
+			err := cfg.store.PutObject(context.Background(), key, bytes.NewReader(content), storage.PutOptions{})
+			if err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			video.VideoKey = &key
+			video.VideoProvider = &provider
+			video.VideoURL = &url
+			if tt.quarantined {
+				video.QuarantineKey = &key
+			}
+			if err := cfg.db.UpdateVideo(video); err != nil {
+				t.Fatalf("UpdateVideo() error = %v", err)
+			}
+			for _, status := range append([]string{database.VideoStatusUploading}, tt.statuses...) {
+				if err := cfg.db.SetVideoStatus(video.ID, status); err != nil {
+					t.Fatalf("SetVideoStatus(%q) error = %v", status, err)
+				}
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.SetPathValue("videoID", video.ID.String())
+			r = withContextPrincipal(r, principal{UserID: owner.ID, Role: owner.Role})
+			w := httptest.NewRecorder()
+			cfg.handlerVideoDownload(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == http.StatusOK && !bytes.Equal(w.Body.Bytes(), content) {
+				t.Errorf("body = %q, want %q", w.Body, content)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerVideoFileVersionsList lists the files a video had before they
+// were replaced, most recent first. Up to VIDEO_FILE_VERSIONS are kept.
+func (cfg *apiConfig) handlerVideoFileVersionsList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.editableVideo(w, r, contextPrincipal(r))
+	if !ok {
+		return
+	}
+
+	versions, err := cfg.db.GetVideoFileVersions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve versions", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, versions)
+}
+
+// handlerVideoFileVersionRestore makes a previous file the video's
+// current one again. The file it replaces becomes a version in its place,
+// so a restore can itself be undone. Taken-down videos and videos in the
+// middle of an upload can't be restored.
+func (cfg *apiConfig) handlerVideoFileVersionRestore(w http.ResponseWriter, r *http.Request) {
+	versionID, err := uuid.Parse(r.PathValue("versionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+	if video.Status != database.VideoStatusReady {
+		respondWithError(w, http.StatusConflict, "Can't restore a version of a video that is "+video.Status, nil)
+		return
+	}
+	if video.ModerationStatus != nil && *video.ModerationStatus == moderationStatusRemoved {
+		respondWithError(w, http.StatusConflict, "Can't restore a version of a video that has been taken down", nil)
+		return
+	}
+
+	version, err := cfg.db.GetVideoFileVersion(versionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get version", err)
+		return
+	}
+	if version.ID == uuid.Nil || version.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Version not found", nil)
+		return
+	}
+
+	restored := withFileVersion(video, version)
+	err = cfg.db.RestoreVideoFileVersion(version.ID, video, restored)
+	if errors.Is(err, database.ErrVideoModified) {
+		respondWithError(w, http.StatusConflict, "The video changed while restoring, try again", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore version", err)
+		return
+	}
+	// Only playable files are kept as versions
+	if video.QuarantineKey != nil {
+		if store, key := cfg.videoFile(video); key != nil {
+			cfg.removeVideoObject(r.Context(), store, *key)
+		}
+	}
+	cfg.audit(r, p.UserID, auditVideoVersionRestored, "video", video.ID.String(), map[string]string{"version_id": version.ID.String()})
+
+	respondWithJSON(w, http.StatusOK, restored)
+}
+
+// withFileVersion returns video with its file, and what was recorded
+// about it at upload, swapped for version's.
+func withFileVersion(video database.Video, version database.VideoFileVersion) database.Video {
+	video.VideoURL = &version.VideoURL
+	video.VideoProvider = version.VideoProvider
+	video.VideoBucket = version.VideoBucket
+	video.VideoKey = &version.VideoKey
+	video.QuarantineKey = nil
+	video.AspectClass = version.AspectClass
+	video.DurationSeconds = version.DurationSeconds
+	video.MediaInfo = version.MediaInfo
+	video.VideoSize = version.VideoSize
+	video.VideoSHA256 = version.VideoSHA256
+	video.StorageClass = version.StorageClass
+	video.StoredAt = version.StoredAt
+	video.ModerationStatus = version.ModerationStatus
+	video.ModerationScore = version.ModerationScore
+	video.ModerationReason = nil
+	video.ScanVerdict = version.ScanVerdict
+	video.ScanSignature = nil
+	video.RestoreRequestedAt = nil
+	video.RestoreTier = nil
+	return video
+}
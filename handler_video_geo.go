@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func (cfg *apiConfig) handlerVideoGeoUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		AllowedCountries []string `json:"allowed_countries"`
+		BlockedCountries []string `json:"blocked_countries"`
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	allowed, err := normalizeCountryCodes(params.AllowedCountries)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	blocked, err := normalizeCountryCodes(params.BlockedCountries)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video.AllowedCountries = allowed
+	video.BlockedCountries = blocked
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// normalizeCountryCodes validates and upper-cases ISO 3166-1 alpha-2 codes.
+func normalizeCountryCodes(codes []string) ([]string, error) {
+	normalized := []string{}
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return nil, fmt.Errorf("invalid country code %q", code)
+		}
+		if !slices.Contains(normalized, code) {
+			normalized = append(normalized, code)
+		}
+	}
+	return normalized, nil
+}
+
+// requestCountry returns the viewer's country as reported by the CDN or
+// proxy in front of the app, or "" if it is unknown. The header is only
+// believed from TRUSTED_PROXIES, since any client can send it.
+func (cfg *apiConfig) requestCountry(r *http.Request) string {
+	if !isTrustedProxy(remoteHost(r), cfg.trustedProxies) {
+		return ""
+	}
+	return strings.ToUpper(strings.TrimSpace(r.Header.Get(cfg.geoCountryHeader)))
+}
+
+// geoAllowed reports whether video may be played in country. When an
+// allowlist is set, viewers from an unknown country are refused.
+func geoAllowed(video database.Video, country string) bool {
+	if country != "" && slices.Contains(video.BlockedCountries, country) {
+		return false
+	}
+	if len(video.AllowedCountries) > 0 {
+		return country != "" && slices.Contains(video.AllowedCountries, country)
+	}
+	return true
+}
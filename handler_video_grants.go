@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Visibility levels of a video. Public videos are listed in the catalog,
+// unlisted ones can be watched by anyone with the link, and private ones
+// only by those canViewVideo lets in.
+const (
+	videoVisibilityPublic   = "public"
+	videoVisibilityUnlisted = "unlisted"
+	videoVisibilityPrivate  = "private"
+)
+
+func validVideoVisibility(visibility string) bool {
+	return visibility == videoVisibilityPublic || visibility == videoVisibilityUnlisted || visibility == videoVisibilityPrivate
+}
+
+func (cfg *apiConfig) handlerVideoGrantsList(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.managedVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	grants, err := cfg.db.GetVideoGrants(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve grants", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, grants)
+}
+
+func (cfg *apiConfig) handlerVideoGrantsPut(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email  string `json:"email"`
+		Access string `json:"access"`
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.managedVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Access != database.GrantAccessView && params.Access != database.GrantAccessEdit {
+		respondWithError(w, http.StatusBadRequest, "access must be view or edit", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No user with that email", nil)
+		return
+	}
+	if user.ID == video.UserID {
+		respondWithError(w, http.StatusBadRequest, "The owner already has full access", nil)
+		return
+	}
+
+	err = cfg.db.PutVideoGrant(video.ID, user.ID, params.Access)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save grant", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditGrantChanged, "video", video.ID.String(), map[string]string{
+		"user_id": user.ID.String(),
+		"access":  params.Access,
+	})
+
+	grant, err := cfg.db.GetVideoGrant(video.ID, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve grant", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, grant)
+}
+
+func (cfg *apiConfig) handlerVideoGrantsDelete(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.managedVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	err = cfg.db.DeleteVideoGrant(video.ID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete grant", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditGrantRevoked, "video", video.ID.String(), map[string]string{"user_id": userID.String()})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerVideoVisibilityUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Visibility string `json:"visibility"`
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.managedVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !validVideoVisibility(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, "visibility must be public, unlisted or private", nil)
+		return
+	}
+
+	video.Visibility = params.Visibility
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditVideoVisibility, "video", video.ID.String(), map[string]string{"visibility": video.Visibility})
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// presignConcurrency bounds how many URLs presignVideos signs at once.
+const presignConcurrency = 8
+
+// signedVideo is a video with a ready-to-play URL for its file.
+type signedVideo struct {
+	database.Video
+	PlaybackURL          *string    `json:"playback_url"`
+	PlaybackURLExpiresAt *time.Time `json:"playback_url_expires_at"`
+}
+
+// presignVideos signs playback URLs for a page of videos concurrently.
+// Videos that can't be handed a plain presigned URL are left unsigned:
+// those without a playable file, archived ones, ones whose visibility
+// binds playback to a viewer, and all of them when the store can't
+// presign. Signing failures are logged and leave that video unsigned.
+func (cfg *apiConfig) presignVideos(r *http.Request, videos []database.Video, expiresIn time.Duration) []signedVideo {
+	signed := make([]signedVideo, len(videos))
+	sem := make(chan struct{}, presignConcurrency)
+	var wg sync.WaitGroup
+	for i, video := range videos {
+		signed[i].Video = video
+		binding := cfg.playbackBindings[video.Visibility]
+		if binding.IP || binding.Session || isArchiveClass(video.StorageClass) {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			link, expiresAt, err := cfg.presignVideo(r, video, expiresIn)
+			if err != nil {
+				if !errors.Is(err, storage.ErrPresignUnsupported) {
+					log.Printf("Couldn't presign video %s: %v", video.ID, err)
+				}
+				return
+			}
+			if link != "" {
+				signed[i].PlaybackURL = &link
+				signed[i].PlaybackURLExpiresAt = &expiresAt
+			}
+		}()
+	}
+	wg.Wait()
+	return signed
+}
+
+// handlerVideosRetrieveSigned lists all of the caller's videos at once,
+// each with a playback URL. Clients that may have many videos should page
+// through handlerVideosRetrieve instead.
+func (cfg *apiConfig) handlerVideosRetrieveSigned(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	expiresIn, err := cfg.playbackURLExpiry(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	videos, err := cfg.db.GetVideos(p.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.presignVideos(r, videos, expiresIn))
+}
+
+// videoPage is a page of the caller's videos. NextCursor is nil on the
+// last page.
+type videoPage struct {
+	Videos     []signedVideo `json:"videos"`
+	NextCursor *string       `json:"next_cursor"`
+}
+
+// handlerVideosRetrieve lists the caller's videos a page at a time, each
+// with a playback URL so a list page can play videos without a round trip
+// per row. ?limit sets the page size, and ?cursor, the next_cursor of the
+// previous page, picks up where it ended. The list can be filtered by
+// ?status (draft, uploading, processing, ready, failed or quarantined),
+// ?aspect_class, ?has_thumbnail, ?tag, ?category (a category slug,
+// including the categories below it) and a created_at range of ?since
+// and ?until, and sorted by ?sort
+// (created_at, title, duration or views) in ?order (asc or desc). Lists
+// are newest first by default, and in title order when sorted by title.
+// A cursor only makes sense with the filters and sort it was issued for.
+func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	cfg.respondWithVideoPage(w, r, func(filter database.VideoFilter, sort database.VideoSort, after *database.VideoCursor, limit int) ([]database.Video, error) {
+		return cfg.db.GetVideosPage(p.UserID, filter, sort, after, limit)
+	})
+}
+
+// videoPageLister fetches up to limit videos of a list, as
+// database.Client.GetVideosPage does.
+type videoPageLister func(filter database.VideoFilter, sort database.VideoSort, after *database.VideoCursor, limit int) ([]database.Video, error)
+
+// respondWithVideoPage responds with the page of a video list asked for by
+// the paging, filter and sort parameters handlerVideosRetrieve takes.
+func (cfg *apiConfig) respondWithVideoPage(w http.ResponseWriter, r *http.Request, list videoPageLister) {
+	expiresIn, err := cfg.playbackURLExpiry(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	limit := cfg.videoPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > cfg.videoPageMaxSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", cfg.videoPageMaxSize), err)
+			return
+		}
+		limit = n
+	}
+	filter, err := cfg.parseVideoFilter(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	sort, err := parseVideoSort(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	var after *database.VideoCursor
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		cursor, err := decodeVideoCursor(v, sort.Field)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		after = &cursor
+	}
+
+	// One extra video says whether there is another page
+	videos, err := list(filter, sort, after, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	page := videoPage{}
+	if len(videos) > limit {
+		videos = videos[:limit]
+		last := videos[limit-1]
+		next := encodeVideoCursor(sort.Field, database.NewVideoCursor(last, sort.Field))
+		page.NextCursor = &next
+	}
+	page.Videos = cfg.presignVideos(r, videos, expiresIn)
+
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+func parseVideoSort(r *http.Request) (database.VideoSort, error) {
+	sort := database.VideoSort{Field: r.URL.Query().Get("sort")}
+	switch sort.Field {
+	case "":
+		sort.Field = database.VideoSortCreatedAt
+	case database.VideoSortCreatedAt, database.VideoSortTitle, database.VideoSortDuration, database.VideoSortViews:
+	default:
+		return database.VideoSort{}, errors.New("sort must be created_at, title, duration or views")
+	}
+	switch order := r.URL.Query().Get("order"); order {
+	case "":
+		sort.Ascending = sort.Field == database.VideoSortTitle
+	case "asc", "desc":
+		sort.Ascending = order == "asc"
+	default:
+		return database.VideoSort{}, errors.New("order must be asc or desc")
+	}
+	return sort, nil
+}
+
+func (cfg *apiConfig) parseVideoFilter(r *http.Request) (database.VideoFilter, error) {
+	q := r.URL.Query()
+	filter := database.VideoFilter{
+		Status:      q.Get("status"),
+		AspectClass: q.Get("aspect_class"),
+	}
+	switch filter.Status {
+	case "", database.VideoStatusDraft, database.VideoStatusUploading, database.VideoStatusProcessing,
+		database.VideoStatusReady, database.VideoStatusFailed, database.VideoStatusQuarantined:
+	default:
+		return database.VideoFilter{}, errors.New("status must be draft, uploading, processing, ready, failed or quarantined")
+	}
+	switch filter.AspectClass {
+	case "", "landscape", "portrait", "other":
+	default:
+		return database.VideoFilter{}, errors.New("aspect_class must be landscape, portrait or other")
+	}
+	for name, dst := range map[string]**time.Time{"since": &filter.CreatedSince, "until": &filter.CreatedUntil} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return database.VideoFilter{}, errors.New(name + " must be an RFC 3339 timestamp")
+		}
+		*dst = &t
+	}
+	if v := q.Get("has_thumbnail"); v != "" {
+		hasThumbnail, err := strconv.ParseBool(v)
+		if err != nil {
+			return database.VideoFilter{}, errors.New("has_thumbnail must be true or false")
+		}
+		filter.HasThumbnail = &hasThumbnail
+	}
+	if v := q.Get("tag"); v != "" {
+		tag, err := normalizeTag(v)
+		if err != nil {
+			return database.VideoFilter{}, err
+		}
+		filter.Tag = tag
+	}
+	if slug := q.Get("category"); slug != "" {
+		category, err := cfg.db.GetCategoryBySlug(slug)
+		if err != nil {
+			return database.VideoFilter{}, err
+		}
+		if category.ID == uuid.Nil {
+			return database.VideoFilter{}, fmt.Errorf("unknown category %q", slug)
+		}
+		filter.CategoryID = &category.ID
+	}
+	return filter, nil
+}
+
+// videoCursor is what a video list cursor holds: the sort it was issued
+// for, and the sorted value and ID of the last video on the page.
+type videoCursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v"`
+	ID    uuid.UUID       `json:"id"`
+}
+
+// encodeVideoCursor packs a cursor into an opaque string, so clients
+// don't come to depend on what it holds.
+func encodeVideoCursor(sort string, cursor database.VideoCursor) string {
+	value := cursor.Value
+	if t, ok := value.(time.Time); ok {
+		value = t.Unix()
+	}
+	rawValue, _ := json.Marshal(value)
+	raw, _ := json.Marshal(videoCursor{Sort: sort, Value: rawValue, ID: cursor.ID})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeVideoCursor unpacks a cursor issued for a list sorted by sort.
+func decodeVideoCursor(s, sort string) (database.VideoCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return database.VideoCursor{}, err
+	}
+	var c videoCursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return database.VideoCursor{}, err
+	}
+	if c.Sort != sort {
+		return database.VideoCursor{}, errors.New("cursor is for another sort")
+	}
+
+	cursor := database.VideoCursor{ID: c.ID}
+	switch sort {
+	case database.VideoSortTitle:
+		var title string
+		err = json.Unmarshal(c.Value, &title)
+		cursor.Value = title
+	case database.VideoSortDuration:
+		var duration float64
+		err = json.Unmarshal(c.Value, &duration)
+		cursor.Value = duration
+	case database.VideoSortViews:
+		var views int64
+		err = json.Unmarshal(c.Value, &views)
+		cursor.Value = views
+	default:
+		var unix int64
+		err = json.Unmarshal(c.Value, &unix)
+		cursor.Value = time.Unix(unix, 0)
+	}
+	return cursor, err
+}
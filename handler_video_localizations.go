@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// localizedVideo is a video with its title and description in the
+// language the viewer asked for. Locale names the localization used, nil
+// if the video's own title and description are shown.
+type localizedVideo struct {
+	database.Video
+	Locale *string `json:"locale"`
+}
+
+// handlerVideoLocalizationPut sets a video's title and description in
+// the {locale} language, for viewers whose Accept-Language prefers it.
+func (cfg *apiConfig) handlerVideoLocalizationPut(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+	locale, err := normalizeLanguageTag(r.PathValue("locale"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Title = strings.TrimSpace(params.Title)
+	errs := fieldErrors{}
+	if params.Title == "" {
+		errs["title"] = "title can't be empty"
+	} else if utf8.RuneCountInString(params.Title) > maxVideoTitleLength {
+		errs["title"] = fmt.Sprintf("title can be at most %d characters", maxVideoTitleLength)
+	}
+	if utf8.RuneCountInString(params.Description) > maxVideoDescriptionLength {
+		errs["description"] = fmt.Sprintf("description can be at most %d characters", maxVideoDescriptionLength)
+	}
+	if len(errs) > 0 {
+		respondWithFieldErrors(w, errs)
+		return
+	}
+
+	localization := database.VideoLocalization{Title: params.Title, Description: params.Description}
+	err = cfg.db.SetVideoLocalization(video.ID, locale, localization)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditVideoUpdated, "video", video.ID.String(), map[string]string{"locale": locale})
+
+	video.Localizations[locale] = localization
+	video.Version++
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerVideoLocalizationDelete removes a video's title and description
+// in one language.
+func (cfg *apiConfig) handlerVideoLocalizationDelete(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+	locale, err := normalizeLanguageTag(r.PathValue("locale"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	removed, err := cfg.db.DeleteVideoLocalization(video.ID, locale)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	if !removed {
+		respondWithError(w, http.StatusNotFound, "Video has no title in this language", nil)
+		return
+	}
+	cfg.audit(r, p.UserID, auditVideoUpdated, "video", video.ID.String(), map[string]string{"removed_locale": locale})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// localizeVideo returns video with the title and description of the
+// localization r's Accept-Language prefers, if it has one.
+func localizeVideo(r *http.Request, video database.Video) localizedVideo {
+	locales := make([]string, 0, len(video.Localizations))
+	for locale := range video.Localizations {
+		locales = append(locales, locale)
+	}
+	slices.Sort(locales)
+	locale := preferredLanguage(r, locales)
+	if locale == "" {
+		return localizedVideo{Video: video}
+	}
+	localization := video.Localizations[locale]
+	video.Title = localization.Title
+	video.Description = localization.Description
+	return localizedVideo{Video: video, Locale: &locale}
+}
+
+// localizedVideoETag is the ETag of video as shown in locale. It differs
+// from the video's own ETag, so caches keep each language apart, but
+// still names the video's version for If-Match.
+func localizedVideoETag(video database.Video, locale *string) string {
+	if locale == nil {
+		return videoETag(video)
+	}
+	return strings.TrimSuffix(videoETag(video), `"`) + ";" + *locale + `"`
+}
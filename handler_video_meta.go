@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 	"github.com/google/uuid"
 )
 
@@ -15,25 +19,24 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	p := contextPrincipal(r)
+	if !p.can(auth.PermVideoCreate) {
+		respondWithError(w, http.StatusForbidden, "Your role doesn't allow creating videos", nil)
 		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
-	params.UserID = userID
+	params.UserID = p.UserID
+	if params.OrgID != nil && !cfg.orgRoleAtLeast(p, *params.OrgID, database.OrgRoleEditor) {
+		respondWithError(w, http.StatusForbidden, "You can't create videos for this organization", nil)
+		return
+	}
 
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
@@ -41,48 +44,166 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
+	cfg.audit(r, p.UserID, auditVideoCreated, "video", video.ID.String(), nil)
 
 	respondWithJSON(w, http.StatusCreated, video)
 }
 
+// handlerVideoMetaDelete moves a video to the trash. It disappears
+// everywhere but its file is kept until VIDEO_TRASH_RETENTION has passed,
+// and until then it can be restored. With ?permanent=true the video and
+// its files are deleted right away instead.
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+	p := contextPrincipal(r)
+	video, ok := cfg.managedVideo(w, r, p)
+	if !ok {
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	if r.URL.Query().Get("permanent") == "true" {
+		err := cfg.db.DeleteVideo(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+			return
+		}
+		cfg.removeVideoMedia(r.Context(), video)
+		cfg.audit(r, p.UserID, auditVideoDeleted, "video", video.ID.String(), map[string]string{"title": video.Title})
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	err := cfg.db.TrashVideo(video.ID)
+	if errors.Is(err, database.ErrInvalidVideoTransition) {
+		respondWithError(w, http.StatusConflict, "Can't delete a video that is "+video.Status, err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	cfg.audit(r, p.UserID, auditVideoTrashed, "video", video.ID.String(), map[string]string{"title": video.Title})
 
-	video, err := cfg.db.GetVideo(videoID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// videoFile returns the store holding a video's file and its key there,
+// or a nil key if the video has no file that can be reached.
+func (cfg *apiConfig) videoFile(video database.Video) (storage.Store, *string) {
+	key := video.VideoKey
+	if video.QuarantineKey != nil {
+		key = video.QuarantineKey
+	}
+	if key == nil {
+		return nil, nil
+	}
+	if video.VideoProvider == nil {
+		// Quarantined files were written before their location was
+		// recorded, always to the primary store
+		if video.QuarantineKey != nil {
+			return cfg.store, key
+		}
+		return nil, nil
+	}
+	// A file in a bucket that isn't configured can't be reached
+	store := cfg.locationStore(video.OrgID, *video.VideoProvider, video.VideoBucket)
+	if store == nil {
+		return nil, nil
+	}
+	return store, key
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// removeVideoMedia deletes a video's stored file, its previous versions,
+// its captions and its thumbnail once its row is gone. Failures are logged rather than
+// returned, since the video itself has already been deleted.
+func (cfg *apiConfig) removeVideoMedia(ctx context.Context, video database.Video) {
+	versions, err := cfg.db.DeleteVideoFileVersions(video.ID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		log.Printf("Couldn't delete file versions of video %s: %v", video.ID, err)
+	}
+	if store, key := cfg.videoFile(video); key != nil {
+		cfg.removeVideoObject(ctx, store, *key)
+	}
+	cfg.removeVersionFiles(ctx, video, versions)
+	captions, err := cfg.db.DeleteVideoCaptions(video.ID)
+	if err != nil {
+		log.Printf("Couldn't delete captions of video %s: %v", video.ID, err)
+	}
+	for _, caption := range captions {
+		cfg.removeCaptionFile(ctx, video, caption)
+	}
+	if key := storedThumbnailKey(video); key != nil {
+		cfg.removeThumbnailIfUnused(*key)
+	}
+}
+
+// retireVideoFile deals with the file previous had before it was replaced
+// by one at another key. A playable file is kept as a version, dropping
+// the oldest beyond videoFileVersions; anything else is removed.
+func (cfg *apiConfig) retireVideoFile(ctx context.Context, previous database.Video, store storage.Store, key string) {
+	if cfg.videoFileVersions == 0 || previous.VideoURL == nil || previous.VideoKey == nil || previous.QuarantineKey != nil {
+		cfg.removeVideoObject(ctx, store, key)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+	dropped, err := cfg.db.AddVideoFileVersion(previous, cfg.videoFileVersions)
+	if err != nil {
+		log.Printf("Couldn't keep previous file of video %s: %v", previous.ID, err)
+		cfg.removeVideoObject(ctx, store, key)
 		return
 	}
+	cfg.removeVersionFiles(ctx, previous, dropped)
+}
+
+// removeVersionFiles deletes the files of versions of video that have
+// been forgotten.
+func (cfg *apiConfig) removeVersionFiles(ctx context.Context, video database.Video, versions []database.VideoFileVersion) {
+	for _, version := range versions {
+		if store, key := cfg.videoFile(withFileVersion(video, version)); key != nil {
+			cfg.removeVideoObject(ctx, store, *key)
+		}
+	}
+}
 
-	err = cfg.db.DeleteVideo(videoID)
+// removeVideoObject deletes a video file from store if it is no longer
+// referenced and evicts it from the CDN cache. Copies of files in the
+// primary bucket are deleted from the replicas too, as S3 replication
+// doesn't carry deletes over unless the bucket is set up to.
+func (cfg *apiConfig) removeVideoObject(ctx context.Context, store storage.Store, key string) {
+	// Videos with the same content share a file under content-addressed
+	// keys
+	count, err := cfg.db.CountVideosWithFileKey(key)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		log.Printf("Couldn't check references to video object %s: %v", key, err)
 		return
 	}
-
-	w.WriteHeader(http.StatusNoContent)
+	if count > 0 {
+		return
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		log.Printf("Couldn't delete video object %s: %v", key, err)
+	}
+	if cfg.inPrimaryBucket(store) {
+		for _, region := range cfg.replicas.regions {
+			if err := cfg.replicas.stores[region].Delete(ctx, key); err != nil {
+				log.Printf("Couldn't delete video object %s in %s: %v", key, region, err)
+			}
+		}
+		cfg.videoCDN.Invalidate("/" + key)
+	}
 }
 
+// handlerVideoGet responds with a video's metadata, along with its ETag
+// and when it last changed so clients can revalidate their copy with
+// If-None-Match or If-Modified-Since. View counts aren't part of the ETag
+// and may lag, as they do in the video cache. The title and description
+// are in the language Accept-Language prefers, if the video has them in
+// it.
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -99,30 +220,57 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 
 	if video.ID == uuid.Nil {
 		respondWithError(w, http.StatusNotFound, "No video was returned", nil)
-
+		return
 	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	if !cfg.viewableVideo(w, r, contextPrincipal(r), video) {
+		return
+	}
 
+	// Access is checked on every request, so clients may keep a copy but
+	// must revalidate it, which costs them only a 304 if it hasn't changed
+	localized := localizeVideo(r, video)
+	etag := localizedVideoETag(video, localized.Locale)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Vary", "Accept-Language")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", video.UpdatedAt.UTC().Format(http.TimeFormat))
+	if localized.Locale != nil {
+		w.Header().Set("Content-Language", *localized.Locale)
+	}
+	if notModified(r, etag, video.UpdatedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, localized)
 }
 
-func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
+// viewableVideo checks that caller may watch video from where the request
+// came from, writing an error response and returning false otherwise.
+func (cfg *apiConfig) viewableVideo(w http.ResponseWriter, r *http.Request, caller principal, video database.Video) bool {
+	if code, msg := cfg.videoAccessError(r, caller, video); code != 0 {
+		respondWithError(w, code, msg, nil)
+		return false
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
+	return true
+}
+
+// videoAccessError is viewableVideo without the response: it returns the
+// status and message to refuse caller with, or 0 if they may watch video.
+func (cfg *apiConfig) videoAccessError(r *http.Request, caller principal, video database.Video) (int, string) {
+	if !cfg.canViewVideo(caller, video) {
+		return http.StatusNotFound, "No video was returned"
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
-		return
+	// Taken-down videos are hidden from everyone but staff and the owner
+	canManage := cfg.canEditVideo(caller, video) || caller.can(auth.PermVideoTakedown)
+	if video.ModerationStatus != nil && *video.ModerationStatus == moderationStatusRemoved && !canManage {
+		return http.StatusNotFound, "No video was returned"
 	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	// Owners can always see their own videos regardless of region
+	if !geoAllowed(video, cfg.requestCountry(r)) && !canManage {
+		return http.StatusForbidden, "This video isn't available in your region"
+	}
+	return 0, ""
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxVideoTitleLength       = 200
+	maxVideoDescriptionLength = 5000
+)
+
+// videoPatch is a partial update of a video's metadata. Fields left out
+// are kept as they are.
+type videoPatch struct {
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Visibility  *string   `json:"visibility"`
+	Tags        *[]string `json:"tags"`
+}
+
+// fieldErrors maps each invalid field of a request to what is wrong with
+// it.
+type fieldErrors map[string]string
+
+// respondWithFieldErrors responds with a 400 listing the invalid fields.
+func respondWithFieldErrors(w http.ResponseWriter, errs fieldErrors) {
+	type errorResponse struct {
+		Error  string      `json:"error"`
+		Fields fieldErrors `json:"fields"`
+	}
+	respondWithJSON(w, http.StatusBadRequest, errorResponse{
+		Error:  "Some fields are invalid",
+		Fields: errs,
+	})
+}
+
+// validate normalizes the patch's fields, returning what is wrong with
+// any it can't use.
+func (patch *videoPatch) validate() fieldErrors {
+	errs := fieldErrors{}
+	if patch.Title != nil {
+		title := strings.TrimSpace(*patch.Title)
+		patch.Title = &title
+		if title == "" {
+			errs["title"] = "title can't be empty"
+		} else if utf8.RuneCountInString(title) > maxVideoTitleLength {
+			errs["title"] = fmt.Sprintf("title can be at most %d characters", maxVideoTitleLength)
+		}
+	}
+	if patch.Description != nil && utf8.RuneCountInString(*patch.Description) > maxVideoDescriptionLength {
+		errs["description"] = fmt.Sprintf("description can be at most %d characters", maxVideoDescriptionLength)
+	}
+	if patch.Visibility != nil && !validVideoVisibility(*patch.Visibility) {
+		errs["visibility"] = "visibility must be public, unlisted or private"
+	}
+	if patch.Tags != nil {
+		tags, err := normalizeTags(*patch.Tags)
+		if err != nil {
+			errs["tags"] = err.Error()
+		} else {
+			patch.Tags = &tags
+		}
+	}
+	return errs
+}
+
+// apply returns video with the patch applied, the tags to set on it (nil
+// to leave them alone) and the names of the fields it changed.
+func (patch videoPatch) apply(video database.Video) (database.Video, []string, []string) {
+	changed := []string{}
+	if patch.Title != nil && *patch.Title != video.Title {
+		video.Title = *patch.Title
+		changed = append(changed, "title")
+	}
+	if patch.Description != nil && *patch.Description != video.Description {
+		video.Description = *patch.Description
+		changed = append(changed, "description")
+	}
+	if patch.Visibility != nil && *patch.Visibility != video.Visibility {
+		video.Visibility = *patch.Visibility
+		changed = append(changed, "visibility")
+	}
+	var tags []string
+	if patch.Tags != nil && !slices.Equal(*patch.Tags, video.Tags) {
+		tags = *patch.Tags
+		video.Tags = tags
+		changed = append(changed, "tags")
+	}
+	return video, tags, changed
+}
+
+// videoETag is the ETag of a video's metadata: its quoted version.
+func videoETag(video database.Video) string {
+	return `"` + strconv.FormatInt(video.Version, 10) + `"`
+}
+
+// ifMatchVersion checks the request's If-Match header against video,
+// returning the version an update must be conditional on. It writes an
+// error response and returns false if the header is missing or doesn't
+// match, so an edit based on an out of date copy is refused rather than
+// silently overwriting someone else's.
+func ifMatchVersion(w http.ResponseWriter, r *http.Request, video database.Video) (int64, bool) {
+	header := strings.Join(r.Header.Values("If-Match"), ",")
+	if header == "" {
+		respondWithError(w, http.StatusPreconditionRequired, "If-Match is required; send the ETag the video was loaded with", nil)
+		return 0, false
+	}
+	for _, etag := range strings.Split(header, ",") {
+		etag = strings.TrimSpace(etag)
+		// A localized ETag names the same version
+		if before, _, ok := strings.Cut(etag, ";"); ok {
+			etag = before + `"`
+		}
+		if etag == "*" || etag == videoETag(video) {
+			return video.Version, true
+		}
+	}
+	respondWithError(w, http.StatusPreconditionFailed, "The video has changed since it was loaded", nil)
+	return 0, false
+}
+
+// errVideoGone means a video was deleted while it was being worked on.
+var errVideoGone = errors.New("video no longer exists")
+
+// saveVideoChangesAttempts is how many times saveVideoChanges reapplies
+// changes to a video that keeps changing under it.
+const saveVideoChangesAttempts = 5
+
+// saveVideoChanges applies change to the video with id as it is now and
+// saves it with save, which must only save it at ifVersion. Paths that
+// work on a video for a long time, like uploads, use it so they don't
+// revert metadata edited in the meantime. If the video changes between
+// reading and saving, the change is applied again to the newer copy. It
+// returns the saved video.
+func (cfg *apiConfig) saveVideoChanges(id uuid.UUID, change func(*database.Video), save func(video database.Video, ifVersion int64) error) (database.Video, error) {
+	for attempt := 1; ; attempt++ {
+		video, err := cfg.db.GetVideo(id)
+		if err != nil {
+			return database.Video{}, err
+		}
+		if video.ID == uuid.Nil {
+			return database.Video{}, errVideoGone
+		}
+		version := video.Version
+		change(&video)
+		err = save(video, version)
+		if errors.Is(err, database.ErrVideoModified) && attempt < saveVideoChangesAttempts {
+			continue
+		}
+		if err != nil {
+			return database.Video{}, err
+		}
+		video.Version++
+		return video, nil
+	}
+}
+
+// handlerVideoMetaPatch updates any of a video's title, description,
+// visibility and tags; fields left out keep their values. Invalid fields
+// are listed in the error response, and nothing is changed unless every
+// field is valid. Changing visibility needs the same rights as
+// handlerVideoVisibilityUpdate. The request must send the video's ETag
+// in If-Match, and the response carries the new one.
+func (cfg *apiConfig) handlerVideoMetaPatch(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(w, r, video)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	patch := videoPatch{}
+	err := decoder.Decode(&patch)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if errs := patch.validate(); len(errs) > 0 {
+		respondWithFieldErrors(w, errs)
+		return
+	}
+	if patch.Visibility != nil && !cfg.canManageVideo(p, video) {
+		respondWithError(w, http.StatusForbidden, "You can't change this video's visibility", nil)
+		return
+	}
+
+	video, tags, changed := patch.apply(video)
+	if len(changed) == 0 {
+		w.Header().Set("ETag", videoETag(video))
+		respondWithJSON(w, http.StatusOK, video)
+		return
+	}
+	err = cfg.db.UpdateVideosWithTags([]database.VideoUpdate{{Video: video, Tags: tags, IfVersion: version}})
+	if errors.Is(err, database.ErrVideoModified) {
+		respondWithError(w, http.StatusPreconditionFailed, "The video has changed since it was loaded", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	video.Version++
+	cfg.audit(r, p.UserID, auditVideoUpdated, "video", video.ID.String(), map[string]string{"fields": strings.Join(changed, ",")})
+	if slices.Contains(changed, "visibility") {
+		cfg.audit(r, p.UserID, auditVideoVisibility, "video", video.ID.String(), map[string]string{"visibility": video.Visibility})
+	}
+
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestSaveVideoChangesKeepsConcurrentEdits(t *testing.T) {
+	cfg := newTestConfig(t)
+	video := newTestVideo(t, cfg, newTestUser(t, cfg).ID)
+
+	thumbnail := "http://localhost/assets/thumb.png"
+	saves := 0
+	saved, err := cfg.saveVideoChanges(video.ID, func(v *database.Video) {
+		v.ThumbnailURL = &thumbnail
+	}, func(v database.Video, ifVersion int64) error {
+		saves++
+		if saves == 1 {
+			// A PATCH lands while the upload is being processed
+			edited := video
+			edited.Title = "edited"
+			err := cfg.db.UpdateVideosWithTags([]database.VideoUpdate{{Video: edited, IfVersion: video.Version}})
+			if err != nil {
+				t.Fatalf("UpdateVideosWithTags() error = %v", err)
+			}
+		}
+		return cfg.db.UpdateVideosWithTags([]database.VideoUpdate{{Video: v, IfVersion: ifVersion}})
+	})
+	if err != nil {
+		t.Fatalf("saveVideoChanges() error = %v", err)
+	}
+	if saves != 2 {
+		t.Errorf("saved %d times, want 2", saves)
+	}
+
+	got, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo() error = %v", err)
+	}
+	if got.Title != "edited" {
+		t.Errorf("title = %q, want the concurrent edit kept", got.Title)
+	}
+	if got.ThumbnailURL == nil || *got.ThumbnailURL != thumbnail {
+		t.Errorf("thumbnail = %v, want %q", got.ThumbnailURL, thumbnail)
+	}
+	if saved.Version != got.Version {
+		t.Errorf("returned version %d, want %d", saved.Version, got.Version)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+// reportReasons are the categories a viewer can report a video under.
+var reportReasons = []string{"spam", "harassment", "violence", "sexual", "copyright", "other"}
+
+// Moderator resolutions for a reported video. Unlisting takes a public
+// video out of the catalog and feeds, leaving it to those with the link;
+// videos that are already unlisted or private are left as they are.
+const (
+	reportActionDismiss  = "dismiss"
+	reportActionUnlist   = "unlist"
+	reportActionTakeDown = "take_down"
+)
+
+// maxReportDetails caps the free-text part of a report.
+const maxReportDetails = 2000
+
+// handlerVideoReportCreate files a viewer's report against a video they
+// can see. Anonymous reports are allowed; each reporter (or address, when
+// anonymous) gets one open report per video.
+func (cfg *apiConfig) handlerVideoReportCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !slices.Contains(reportReasons, params.Reason) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("reason must be one of %v", reportReasons), nil)
+		return
+	}
+	if len(params.Details) > maxReportDetails {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("details must be at most %d characters", maxReportDetails), nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No video was returned", nil)
+		return
+	}
+	p := contextPrincipal(r)
+	if !cfg.viewableVideo(w, r, p, video) {
+		return
+	}
+
+	var reporterID *uuid.UUID
+	if p.UserID != uuid.Nil {
+		reporterID = &p.UserID
+	}
+	reported, err := cfg.db.HasOpenVideoReport(video.ID, reporterID, clientIP(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check existing reports", err)
+		return
+	}
+	if reported {
+		respondWithError(w, http.StatusConflict, "You've already reported this video", nil)
+		return
+	}
+
+	report, err := cfg.db.CreateVideoReport(database.CreateVideoReportParams{
+		VideoID:    video.ID,
+		ReporterID: reporterID,
+		ReporterIP: clientIP(r),
+		Reason:     params.Reason,
+		Details:    params.Details,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save report", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditVideoReported, "video", video.ID.String(), map[string]string{"reason": params.Reason})
+
+	respondWithJSON(w, http.StatusCreated, report)
+}
+
+// handlerModerationQueue lists reported videos awaiting review.
+func (cfg *apiConfig) handlerModerationQueue(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 500 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 500", err)
+			return
+		}
+		limit = n
+	}
+
+	queue, err := cfg.db.GetModerationQueue(limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get moderation queue", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, queue)
+}
+
+// handlerModerationVideoReports lists every report on one video,
+// including resolved ones, for a moderator reviewing it.
+func (cfg *apiConfig) handlerModerationVideoReports(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	reports, err := cfg.db.GetVideoReports(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get reports", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, reports)
+}
+
+// handlerModerationResolve acts on a reported video and closes its open
+// reports. Unlisting and takedowns notify the owner by email.
+func (cfg *apiConfig) handlerModerationResolve(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+	type response struct {
+		Video    database.Video `json:"video"`
+		Resolved int64          `json:"resolved_reports"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	switch params.Action {
+	case reportActionDismiss, reportActionUnlist:
+	case reportActionTakeDown:
+		if params.Note == "" {
+			respondWithError(w, http.StatusBadRequest, "A note is required to take a video down", nil)
+			return
+		}
+	default:
+		respondWithError(w, http.StatusBadRequest, "action must be dismiss, unlist or take_down", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	p := contextPrincipal(r)
+	switch params.Action {
+	case reportActionUnlist:
+		if video.Visibility != videoVisibilityPublic {
+			break
+		}
+		video.Visibility = videoVisibilityUnlisted
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+		cfg.audit(r, p.UserID, auditVideoVisibility, "video", video.ID.String(), map[string]string{"visibility": video.Visibility})
+		cfg.notifyVideoOwner(video, "Your video was unlisted",
+			fmt.Sprintf("After reviewing reports from viewers, a moderator unlisted your video %q. "+
+				"It no longer appears in the catalog or feeds, but anyone with its link can still watch it.\n", video.Title), params.Note)
+	case reportActionTakeDown:
+		video, err = cfg.takeDownVideo(r, video, params.Note)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+		cfg.notifyVideoOwner(video, "Your video was taken down",
+			fmt.Sprintf("After reviewing reports from viewers, a moderator took down your video %q.\n", video.Title), params.Note)
+	}
+
+	resolved, err := cfg.db.ResolveVideoReports(video.ID, p.UserID, params.Action)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve reports", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditReportsResolved, "video", video.ID.String(), map[string]string{
+		"action":   params.Action,
+		"resolved": strconv.FormatInt(resolved, 10),
+	})
+
+	respondWithJSON(w, http.StatusOK, response{Video: video, Resolved: resolved})
+}
+
+// notifyVideoOwner emails a video's owner about a moderation decision in
+// the background. Ownerless guest uploads have nobody to tell.
+func (cfg *apiConfig) notifyVideoOwner(video database.Video, subject, body, note string) {
+	if video.UserID == uuid.Nil {
+		return
+	}
+	owner, err := cfg.db.GetUser(video.UserID)
+	if err != nil || owner == nil {
+		log.Printf("Couldn't look up owner of video %s to notify: %v", video.ID, err)
+		return
+	}
+	if note != "" {
+		body += "\nModerator's note: " + note + "\n"
+	}
+	msg := mailer.Message{To: owner.Email, Subject: subject, Body: body}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := cfg.mailer.Send(ctx, msg); err != nil {
+			log.Printf("Couldn't send moderation notice for video %s: %v", video.ID, err)
+		}
+	}()
+}
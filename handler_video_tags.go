@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	// maxVideoTags caps how many tags a video can have
+	maxVideoTags = 20
+	// maxTagLength caps a tag's length in bytes
+	maxTagLength = 50
+)
+
+// handlerVideoTagsPut replaces a video's tags. Tags are trimmed and
+// lower-cased, so "Travel" and "travel " are the same tag.
+func (cfg *apiConfig) handlerVideoTagsPut(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Tags []string `json:"tags"`
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	tags, err := normalizeTags(params.Tags)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.db.SetVideoTags(video.ID, tags)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update tags", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditVideoTagsChanged, "video", video.ID.String(), map[string]string{"tags": strings.Join(tags, ",")})
+
+	video.Tags = tags
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerVideoTagDelete takes one tag off a video.
+func (cfg *apiConfig) handlerVideoTagDelete(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.editableVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	tag, err := normalizeTag(r.PathValue("tag"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	removed, err := cfg.db.RemoveVideoTag(video.ID, tag)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove tag", err)
+		return
+	}
+	if !removed {
+		respondWithError(w, http.StatusNotFound, "Video doesn't have this tag", nil)
+		return
+	}
+	cfg.audit(r, p.UserID, auditVideoTagsChanged, "video", video.ID.String(), map[string]string{"removed": tag})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// normalizeTags normalizes a video's tags, dropping duplicates and sorting
+// them.
+func normalizeTags(raw []string) ([]string, error) {
+	tags := []string{}
+	for _, v := range raw {
+		tag, err := normalizeTag(v)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxVideoTags {
+		return nil, fmt.Errorf("a video can have at most %d tags", maxVideoTags)
+	}
+	slices.Sort(tags)
+	return tags, nil
+}
+
+// normalizeTag trims and lower-cases a tag, checking it can be stored.
+// Commas aren't allowed since tags are read back as a comma-separated
+// list.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", errors.New("tags can't be empty")
+	}
+	if len(tag) > maxTagLength {
+		return "", fmt.Errorf("tags can be at most %d characters", maxTagLength)
+	}
+	if strings.ContainsAny(tag, ",\n\r\t") {
+		return "", fmt.Errorf("invalid tag %q", tag)
+	}
+	return tag, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// moderationStatusRemoved marks a video a moderator has taken down. Unlike
+// the automated verdicts it is only ever set by hand.
+const moderationStatusRemoved = "removed"
+
+func (cfg *apiConfig) handlerVideoTakedown(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Reason == "" {
+		respondWithError(w, http.StatusBadRequest, "A reason is required", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	video, err = cfg.takeDownVideo(r, video, params.Reason)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// takeDownVideo removes video from everyone but staff and its owner.
+func (cfg *apiConfig) takeDownVideo(r *http.Request, video database.Video, reason string) (database.Video, error) {
+	status := moderationStatusRemoved
+	video.ModerationStatus = &status
+	video.ModerationReason = &reason
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		return database.Video{}, err
+	}
+	cfg.audit(r, contextPrincipal(r).UserID, auditVideoTakenDown, "video", video.ID.String(), map[string]string{"reason": reason})
+	return video, nil
+}
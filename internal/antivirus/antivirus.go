@@ -0,0 +1,33 @@
+package antivirus
+
+import (
+	"context"
+)
+
+type Verdict string
+
+const (
+	VerdictClean    Verdict = "clean"
+	VerdictInfected Verdict = "infected"
+	// VerdictUnscanned is recorded when no scanner is configured.
+	VerdictUnscanned Verdict = "unscanned"
+)
+
+type Result struct {
+	Verdict Verdict
+	// Signature names the malware found, e.g. "Eicar-Test-Signature".
+	Signature string
+}
+
+// Scanner checks an uploaded file for malware before anything else reads
+// it.
+type Scanner interface {
+	Scan(ctx context.Context, path string) (Result, error)
+}
+
+// Noop scans nothing. It is used when no scanner is configured.
+type Noop struct{}
+
+func (Noop) Scan(ctx context.Context, path string) (Result, error) {
+	return Result{Verdict: VerdictUnscanned}, nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"strings"
+)
+
+const (
+	ScopeVideoRead   = "video:read"
+	ScopeVideoWrite  = "video:write"
+	ScopeVideoUpload = "video:upload"
+	// ScopeAccount covers account management (sessions, API keys). It is
+	// only held by interactive logins and can never be granted to a key.
+	ScopeAccount = "account"
+)
+
+// APIKeyScopes are the scopes that may be granted to an API key.
+var APIKeyScopes = []string{ScopeVideoRead, ScopeVideoWrite, ScopeVideoUpload}
+
+const apiKeyPrefix = "tbk_"
+
+// MakeAPIKey returns a new random API key. Only its hash should be stored.
+func MakeAPIKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(key), nil
+}
+
+// IsAPIKey reports whether a bearer credential looks like an API key
+// rather than a JWT.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// HashAPIKey returns the lookup hash stored for an API key. Keys are long
+// and random, so a fast unsalted hash is sufficient.
+func HashAPIKey(key string) string {
+	return HashToken(key)
+}
+
+// APIKeyDisplayPrefix is the non-secret leading part of a key shown in
+// listings so users can tell their keys apart.
+func APIKeyDisplayPrefix(key string) string {
+	if len(key) < len(apiKeyPrefix)+8 {
+		return key
+	}
+	return key[:len(apiKeyPrefix)+8]
+}
+
+// ValidAPIKeyScope reports whether scope may be granted to an API key.
+func ValidAPIKeyScope(scope string) bool {
+	return slices.Contains(APIKeyScopes, scope)
+}
+
+const inviteCodePrefix = "tbi_"
+
+// MakeInviteCode returns a new random invite code. Only its hash should be
+// stored.
+func MakeInviteCode() (string, error) {
+	code := make([]byte, 16)
+	_, err := rand.Read(code)
+	if err != nil {
+		return "", err
+	}
+	return inviteCodePrefix + hex.EncodeToString(code), nil
+}
+
+// InviteCodeDisplayPrefix is the non-secret leading part of an invite code
+// shown in listings.
+func InviteCodeDisplayPrefix(code string) string {
+	if len(code) < len(inviteCodePrefix)+6 {
+		return code
+	}
+	return code[:len(inviteCodePrefix)+6]
+}
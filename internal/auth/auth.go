@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -20,7 +21,27 @@ const (
 	TokenTypeAccess TokenType = "tubely-access"
 )
 
-var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
+var (
+	ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
+	ErrSessionRevoked       = errors.New("session has been revoked")
+)
+
+// AccessClaims are the claims carried by our access tokens.
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid,omitempty"`
+	// Actor is the admin acting as the subject in an impersonation token
+	Actor string `json:"act,omitempty"`
+}
+
+// AccessToken is a validated access token. SessionID is uuid.Nil for
+// tokens issued before sessions were tracked. ImpersonatorID is set for
+// impersonation tokens.
+type AccessToken struct {
+	UserID         uuid.UUID
+	SessionID      uuid.UUID
+	ImpersonatorID uuid.UUID
+}
 
 func HashPassword(password string) (string, error) {
 	dat, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -36,48 +57,71 @@ func CheckPasswordHash(password, hash string) error {
 
 func MakeJWT(
 	userID uuid.UUID,
-	tokenSecret string,
+	sessionID uuid.UUID,
+	keys *KeySet,
+	expiresIn time.Duration,
+) (string, error) {
+	return makeAccessJWT(userID, sessionID, "", keys, expiresIn)
+}
+
+// MakeImpersonationJWT returns an access token for userID that records
+// impersonatorID as the admin actually making the requests.
+func MakeImpersonationJWT(
+	userID uuid.UUID,
+	sessionID uuid.UUID,
+	impersonatorID uuid.UUID,
+	keys *KeySet,
 	expiresIn time.Duration,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+	return makeAccessJWT(userID, sessionID, impersonatorID.String(), keys, expiresIn)
+}
+
+func makeAccessJWT(userID, sessionID uuid.UUID, actor string, keys *KeySet, expiresIn time.Duration) (string, error) {
+	key := keys.active
+	token := jwt.NewWithClaims(key.method(), AccessClaims{
+		RegisteredClaims: newClaims(TokenTypeAccess, userID.String(), expiresIn),
+		SessionID:        sessionID.String(),
+		Actor:            actor,
 	})
-	return token.SignedString(signingKey)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signingKey())
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
-	)
+// ValidateJWT checks an access token's signature and claims, and rejects
+// it if its session is on denylist.
+func ValidateJWT(tokenString string, keys *KeySet, denylist *Denylist) (AccessToken, error) {
+	claims := AccessClaims{}
+	err := keys.parse(tokenString, &claims)
 	if err != nil {
-		return uuid.Nil, err
+		return AccessToken{}, err
 	}
 
-	userIDString, err := token.Claims.GetSubject()
-	if err != nil {
-		return uuid.Nil, err
+	if claims.Issuer != string(TokenTypeAccess) {
+		return AccessToken{}, errors.New("invalid issuer")
 	}
 
-	issuer, err := token.Claims.GetIssuer()
+	id, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		return uuid.Nil, err
-	}
-	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+		return AccessToken{}, fmt.Errorf("invalid user ID: %w", err)
 	}
 
-	id, err := uuid.Parse(userIDString)
-	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+	token := AccessToken{UserID: id}
+	if claims.SessionID != "" {
+		token.SessionID, err = uuid.Parse(claims.SessionID)
+		if err != nil {
+			return AccessToken{}, fmt.Errorf("invalid session ID: %w", err)
+		}
+		if denylist != nil && denylist.Contains(token.SessionID) {
+			return AccessToken{}, ErrSessionRevoked
+		}
+	}
+	if claims.Actor != "" {
+		token.ImpersonatorID, err = uuid.Parse(claims.Actor)
+		if err != nil {
+			return AccessToken{}, fmt.Errorf("invalid actor ID: %w", err)
+		}
 	}
-	return id, nil
+	return token, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -102,6 +146,47 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// MakeClaimToken returns a new random token proving possession of a guest
+// upload. Only its hash should be stored.
+func MakeClaimToken() (string, error) {
+	token := make([]byte, 24)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return "tbg_" + hex.EncodeToString(token), nil
+}
+
+// MakeUploadToken returns a new random token authorizing a single upload
+// to one video. Only its hash should be stored.
+func MakeUploadToken() (string, error) {
+	token := make([]byte, 24)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return "tbu_" + hex.EncodeToString(token), nil
+}
+
+// MakeShareToken returns a new random token for a link sharing one video.
+// Only its hash should be stored.
+func MakeShareToken() (string, error) {
+	token := make([]byte, 24)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return "tbs_" + hex.EncodeToString(token), nil
+}
+
+// HashToken returns the hash stored in place of a long random secret such
+// as a password reset token, so a database leak doesn't expose usable
+// tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
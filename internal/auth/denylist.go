@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Denylist remembers revoked session IDs until every access token that
+// could carry them has expired, so revocation takes effect before tokens
+// run out on their own.
+type Denylist struct {
+	mu        sync.Mutex
+	entries   map[uuid.UUID]time.Time
+	lastPrune time.Time
+}
+
+func NewDenylist() *Denylist {
+	return &Denylist{entries: map[uuid.UUID]time.Time{}}
+}
+
+// Add denies sessionID until the given time.
+func (d *Denylist) Add(sessionID uuid.UUID, until time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[sessionID] = until
+	d.prune()
+}
+
+func (d *Denylist) Contains(sessionID uuid.UUID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	until, ok := d.entries[sessionID]
+	return ok && time.Now().Before(until)
+}
+
+// prune periodically drops expired entries so the list only holds recent
+// revocations. Callers hold d.mu.
+func (d *Denylist) prune() {
+	now := time.Now()
+	if now.Sub(d.lastPrune) < time.Minute {
+		return
+	}
+	d.lastPrune = now
+	for id, until := range d.entries {
+		if now.After(until) {
+			delete(d.entries, id)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is a JWT key identified by its kid. Keys without private
+// material can only verify tokens; they are used to keep retired keys
+// valid until the tokens they signed expire.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+
+	secret  []byte
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// NewHMACKey returns an HS256 key. Its kid is derived from the secret so
+// that the same secret always yields the same kid across restarts.
+func NewHMACKey(secret string) *SigningKey {
+	sum := sha256.Sum256([]byte("tubely-kid:" + secret))
+	return &SigningKey{
+		ID:        "hs-" + hex.EncodeToString(sum[:8]),
+		Algorithm: AlgHS256,
+		secret:    []byte(secret),
+	}
+}
+
+// ParseKeyPEM reads an RSA or Ed25519 key in PEM form. Private keys
+// (PKCS#1 or PKCS#8) can sign; public keys (PKIX) can only verify. The kid
+// is the key's RFC 7638 thumbprint.
+func ParseKeyPEM(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm, key.private, key.public = AlgRS256, k, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.Algorithm, key.private, key.public = AlgEdDSA, k, k.Public()
+	case *rsa.PublicKey:
+		key.Algorithm, key.public = AlgRS256, k
+	case ed25519.PublicKey:
+		key.Algorithm, key.public = AlgEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	jwk := key.JWK()
+	// Thumbprint members must be in lexicographic order with no whitespace
+	var canonical string
+	if key.Algorithm == AlgRS256 {
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	} else {
+		canonical = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":%q}`, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	key.ID = base64.RawURLEncoding.EncodeToString(sum[:])
+	return key, nil
+}
+
+// CanSign reports whether the key holds private material.
+func (k *SigningKey) CanSign() bool {
+	return k.secret != nil || k.private != nil
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	switch k.Algorithm {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+func (k *SigningKey) signingKey() any {
+	if k.secret != nil {
+		return k.secret
+	}
+	return k.private
+}
+
+func (k *SigningKey) verificationKey() any {
+	if k.secret != nil {
+		return k.secret
+	}
+	return k.public
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWK returns the public half of an asymmetric key. It is meaningless for
+// HMAC keys, which are never published.
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// KeySet holds the key new tokens are signed with plus any older keys that
+// are still accepted for verification.
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+	// legacy verifies tokens issued before kids were added, which were all
+	// signed with the HMAC secret
+	legacy *SigningKey
+	// clockSkew is how far the exp, nbf and iat claims may be off when
+	// tokens come from (or go to) hosts with slightly different clocks
+	clockSkew time.Duration
+}
+
+// DefaultClockSkew is the clock skew tolerated unless configured.
+const DefaultClockSkew = 30 * time.Second
+
+func NewKeySet(active *SigningKey, verifyOnly ...*SigningKey) (*KeySet, error) {
+	if active == nil || !active.CanSign() {
+		return nil, errors.New("active JWT key must be able to sign")
+	}
+	ks := &KeySet{keys: map[string]*SigningKey{}, clockSkew: DefaultClockSkew}
+	for _, k := range append([]*SigningKey{active}, verifyOnly...) {
+		ks.keys[k.ID] = k
+		if ks.legacy == nil && k.Algorithm == AlgHS256 {
+			ks.legacy = k
+		}
+	}
+	ks.active = active
+	return ks, nil
+}
+
+// SetClockSkew changes the clock skew tolerated when validating tokens.
+func (ks *KeySet) SetClockSkew(skew time.Duration) {
+	ks.clockSkew = skew
+}
+
+// parse verifies a token signed by one of the set's keys and decodes its
+// claims. Tokens must expire, and are rejected if their nbf or iat claims
+// are in the future, allowing for clock skew.
+func (ks *KeySet) parse(tokenString string, claims jwt.Claims) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, ks.lookup,
+		jwt.WithLeeway(ks.clockSkew),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return err
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		return err
+	}
+	if exp == nil {
+		return errors.New("token has no expiry")
+	}
+	return nil
+}
+
+// newClaims returns the registered claims every token we issue carries.
+func newClaims(issuer TokenType, subject string, expiresIn time.Duration) jwt.RegisteredClaims {
+	now := time.Now().UTC()
+	return jwt.RegisteredClaims{
+		Issuer:    string(issuer),
+		Subject:   subject,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+	}
+}
+
+func (ks *KeySet) lookup(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key := ks.keys[kid]
+	if kid == "" {
+		key = ks.legacy
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	// Never let the token pick the algorithm for a key
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.verificationKey(), nil
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the published public keys. HMAC keys are omitted.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range ks.keys {
+		if k.Algorithm != AlgHS256 {
+			set.Keys = append(set.Keys, k.JWK())
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
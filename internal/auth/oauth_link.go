@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const TokenTypeOAuthLink TokenType = "tubely-oauth-link"
+
+// MakeOAuthLinkToken returns a short-lived token recording that userID
+// started linking an identity provider account in the login flow with
+// state. The callback links the identity to userID only if the token's
+// state matches, so it can't be replayed into another flow.
+func MakeOAuthLinkToken(userID uuid.UUID, state string, keys *KeySet, expiresIn time.Duration) (string, error) {
+	key := keys.active
+	claims := newClaims(TokenTypeOAuthLink, userID.String(), expiresIn)
+	claims.ID = state
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signingKey())
+}
+
+// ValidateOAuthLinkToken returns the user an OAuth link token was issued
+// to, checking it was issued for the flow with state.
+func ValidateOAuthLinkToken(tokenString, state string, keys *KeySet) (uuid.UUID, error) {
+	claims := jwt.RegisteredClaims{}
+	err := keys.parse(tokenString, &claims)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if claims.Issuer != string(TokenTypeOAuthLink) {
+		return uuid.Nil, errors.New("invalid issuer")
+	}
+	if claims.ID == "" || claims.ID != state {
+		return uuid.Nil, errors.New("link token is for a different login")
+	}
+	return uuid.Parse(claims.Subject)
+}
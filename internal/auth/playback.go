@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const TokenTypePlayback TokenType = "tubely-playback"
+
+// PlaybackClaims are the claims carried by playback tokens.
+type PlaybackClaims struct {
+	jwt.RegisteredClaims
+	VideoID   string `json:"vid"`
+	IP        string `json:"ip,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+}
+
+// PlaybackToken is a playback token's contents. ViewerID is uuid.Nil for
+// anonymous viewers of public videos. IP and SessionID are set when the
+// token is bound to the viewer who requested it.
+type PlaybackToken struct {
+	VideoID   uuid.UUID
+	ViewerID  uuid.UUID
+	IP        string
+	SessionID uuid.UUID
+	ExpiresAt time.Time
+}
+
+// MakePlaybackToken returns a short-lived token that only lets its holder
+// fetch playback URLs for one video. Players embed it instead of an access
+// token, and it is never accepted as one.
+func MakePlaybackToken(playback PlaybackToken, keys *KeySet, expiresIn time.Duration) (string, error) {
+	key := keys.active
+	subject := ""
+	if playback.ViewerID != uuid.Nil {
+		subject = playback.ViewerID.String()
+	}
+	claims := PlaybackClaims{
+		RegisteredClaims: newClaims(TokenTypePlayback, subject, expiresIn),
+		VideoID:          playback.VideoID.String(),
+		IP:               playback.IP,
+	}
+	if playback.SessionID != uuid.Nil {
+		claims.SessionID = playback.SessionID.String()
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signingKey())
+}
+
+func ValidatePlaybackToken(tokenString string, keys *KeySet) (PlaybackToken, error) {
+	claims := PlaybackClaims{}
+	err := keys.parse(tokenString, &claims)
+	if err != nil {
+		return PlaybackToken{}, err
+	}
+	if claims.Issuer != string(TokenTypePlayback) {
+		return PlaybackToken{}, errors.New("invalid issuer")
+	}
+
+	videoID, err := uuid.Parse(claims.VideoID)
+	if err != nil {
+		return PlaybackToken{}, fmt.Errorf("invalid video ID: %w", err)
+	}
+	token := PlaybackToken{VideoID: videoID, IP: claims.IP}
+	if claims.ExpiresAt != nil {
+		token.ExpiresAt = claims.ExpiresAt.Time
+	}
+	if claims.Subject != "" {
+		token.ViewerID, err = uuid.Parse(claims.Subject)
+		if err != nil {
+			return PlaybackToken{}, fmt.Errorf("invalid viewer ID: %w", err)
+		}
+	}
+	if claims.SessionID != "" {
+		token.SessionID, err = uuid.Parse(claims.SessionID)
+		if err != nil {
+			return PlaybackToken{}, fmt.Errorf("invalid session ID: %w", err)
+		}
+	}
+	return token, nil
+}
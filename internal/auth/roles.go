@@ -0,0 +1,79 @@
+package auth
+
+// Roles, from most to least privileged. New users are creators.
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleCreator   = "creator"
+	RoleViewer    = "viewer"
+	// RoleService is held by trusted internal services authenticated with
+	// signed requests. It can't be assigned to users.
+	RoleService = "service"
+)
+
+const DefaultRole = RoleCreator
+
+type Permission string
+
+const (
+	// PermVideoCreate allows creating and uploading videos the caller owns.
+	PermVideoCreate Permission = "video.create"
+	// PermVideoManageAny allows editing and deleting any user's videos.
+	PermVideoManageAny Permission = "video.manage_any"
+	// PermVideoTakedown allows removing any video from public view.
+	PermVideoTakedown Permission = "video.takedown"
+	// PermUserManage allows changing other users' roles.
+	PermUserManage Permission = "user.manage"
+	// PermAdminReports allows access to the /admin reports.
+	PermAdminReports Permission = "admin.reports"
+	// PermAuditRead allows querying the audit log.
+	PermAuditRead Permission = "audit.read"
+	// PermUserImpersonate allows acting as another user for support.
+	PermUserImpersonate Permission = "user.impersonate"
+	// PermCatalogManage allows editing the catalog's category tree.
+	PermCatalogManage Permission = "catalog.manage"
+	// PermBackupManage allows starting database backups.
+	PermBackupManage Permission = "backup.manage"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermVideoCreate,
+		PermVideoManageAny,
+		PermVideoTakedown,
+		PermUserManage,
+		PermAdminReports,
+		PermAuditRead,
+		PermUserImpersonate,
+		PermCatalogManage,
+		PermBackupManage,
+	},
+	RoleModerator: {
+		PermVideoCreate,
+		PermVideoTakedown,
+		PermAdminReports,
+	},
+	RoleCreator: {
+		PermVideoCreate,
+	},
+	RoleViewer: {},
+	RoleService: {
+		PermVideoManageAny,
+	},
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok && role != RoleService
+}
+
+// RoleHasPermission reports whether role grants perm. Unknown roles grant
+// nothing.
+func RoleHasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// TOTP parameters follow RFC 6238 defaults, which every authenticator app
+// supports: HMAC-SHA1, 30 second steps, 6 digits.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew accepts codes from adjacent steps to tolerate clock drift
+	totpSkew = 1
+
+	TokenTypeMFA TokenType = "tubely-mfa"
+)
+
+var (
+	ErrInvalidTOTPCode = errors.New("invalid two-factor code")
+	// ErrTOTPCodeReused means the code was valid but its time step has
+	// already been used to sign in.
+	ErrTOTPCodeReused = errors.New("two-factor code has already been used")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new base32 encoded 160-bit secret.
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth:// URI authenticator apps scan as a QR code.
+func TOTPURI(issuer, account, secret string) string {
+	v := url.Values{
+		"secret": {secret},
+		"issuer": {issuer},
+		"digits": {fmt.Sprint(totpDigits)},
+		"period": {fmt.Sprint(totpPeriod)},
+	}
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// ValidateTOTP checks code against secret at time now. lastStep is the
+// time step of the last accepted code (0 if none); codes from that step or
+// earlier are rejected so an observed code can't be replayed. On success
+// it returns the step that matched, which the caller must store as the new
+// lastStep.
+func ValidateTOTP(secret, code string, now time.Time, lastStep int64) (int64, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, ErrInvalidTOTPCode
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) != 1 {
+			continue
+		}
+		if step <= lastStep {
+			return 0, ErrTOTPCodeReused
+		}
+		return step, nil
+	}
+	return 0, ErrInvalidTOTPCode
+}
+
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// GenerateRecoveryCodes returns n single-use codes of the form
+// "xxxxx-xxxxx" for signing in without the authenticator.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	codes := make([]string, n)
+	buf := make([]byte, 10)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		for j, c := range buf {
+			if j == 5 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(alphabet[int(c)%len(alphabet)])
+		}
+		codes[i] = b.String()
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode lets users type recovery codes without the dash or
+// in upper case.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	if len(code) == 10 {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
+
+// MakeMFAToken returns a short-lived token proving that userID passed the
+// first login factor. It is exchanged, together with a second factor, for
+// real session tokens and is never accepted as an access token. Each token
+// has its own ID, so wrong codes can be counted against it.
+func MakeMFAToken(userID uuid.UUID, keys *KeySet, expiresIn time.Duration) (string, error) {
+	key := keys.active
+	claims := newClaims(TokenTypeMFA, userID.String(), expiresIn)
+	claims.ID = uuid.NewString()
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signingKey())
+}
+
+// ValidateMFAToken returns the user an MFA token was issued to and the
+// token's ID.
+func ValidateMFAToken(tokenString string, keys *KeySet) (uuid.UUID, string, error) {
+	claims := jwt.RegisteredClaims{}
+	err := keys.parse(tokenString, &claims)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	if claims.Issuer != string(TokenTypeMFA) {
+		return uuid.Nil, "", errors.New("invalid issuer")
+	}
+	if claims.ID == "" {
+		return uuid.Nil, "", errors.New("missing token ID")
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return userID, claims.ID, nil
+}
@@ -0,0 +1,113 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingToken = errors.New("captcha token is missing")
+	ErrFailed       = errors.New("captcha verification failed")
+)
+
+// Verifier checks a token produced by a captcha widget in the browser.
+type Verifier interface {
+	Verify(ctx context.Context, token, remoteIP string) error
+	// Provider and SiteKey tell the frontend which widget to render.
+	Provider() string
+	SiteKey() string
+}
+
+// Noop accepts everything. It is used when no captcha is configured.
+type Noop struct{}
+
+func (Noop) Verify(ctx context.Context, token, remoteIP string) error { return nil }
+func (Noop) Provider() string                                         { return "" }
+func (Noop) SiteKey() string                                          { return "" }
+
+const (
+	ProviderHCaptcha  = "hcaptcha"
+	ProviderTurnstile = "turnstile"
+)
+
+var verifyURLs = map[string]string{
+	ProviderHCaptcha:  "https://api.hcaptcha.com/siteverify",
+	ProviderTurnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// SiteVerify implements the siteverify protocol shared by hCaptcha and
+// Cloudflare Turnstile.
+type SiteVerify struct {
+	provider string
+	siteKey  string
+	secret   string
+	endpoint string
+	client   *http.Client
+}
+
+func New(provider, siteKey, secret string) (*SiteVerify, error) {
+	endpoint, ok := verifyURLs[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown captcha provider %q", provider)
+	}
+	if siteKey == "" || secret == "" {
+		return nil, errors.New("captcha site key and secret are required")
+	}
+	return &SiteVerify{
+		provider: provider,
+		siteKey:  siteKey,
+		secret:   secret,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (v *SiteVerify) Provider() string { return v.provider }
+func (v *SiteVerify) SiteKey() string  { return v.siteKey }
+
+func (v *SiteVerify) Verify(ctx context.Context, token, remoteIP string) error {
+	if token == "" {
+		return ErrMissingToken
+	}
+
+	form := url.Values{
+		"secret":   {v.secret},
+		"response": {token},
+		"sitekey":  {v.siteKey},
+	}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("captcha verification request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("captcha verification responded with status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("couldn't decode captcha verification: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("%w: %s", ErrFailed, strings.Join(result.ErrorCodes, ", "))
+	}
+	return nil
+}
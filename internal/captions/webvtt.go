@@ -0,0 +1,187 @@
+package captions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalid is returned for files that aren't valid SRT or WebVTT.
+var ErrInvalid = errors.New("invalid caption file")
+
+// ContentType is the media type of the files ToWebVTT produces.
+const ContentType = "text/vtt; charset=utf-8"
+
+// timestamp matches a cue time in either format: hours are optional in
+// WebVTT, and SRT separates milliseconds with a comma.
+var timestamp = regexp.MustCompile(`^(?:(\d{2,}):)?([0-5]\d):([0-5]\d)[.,](\d{3})$`)
+
+// Cue is one caption shown between Start and End.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// ToWebVTT checks that data is an SRT or WebVTT file with at least one
+// cue and returns it as WebVTT. WebVTT files are passed through with
+// their line endings normalized, so styling and notes survive; SRT files
+// are rewritten, dropping their cue numbers.
+func ToWebVTT(data []byte) ([]byte, error) {
+	text, err := normalize(data)
+	if err != nil {
+		return nil, err
+	}
+	if isWebVTT(text) {
+		if _, err := parseWebVTT(text); err != nil {
+			return nil, err
+		}
+		return []byte(text), nil
+	}
+	cues, err := parseSRT(text)
+	if err != nil {
+		return nil, err
+	}
+	return Format(cues), nil
+}
+
+// Format writes cues as a WebVTT file.
+func Format(cues []Cue) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		fmt.Fprintf(&buf, "\n%s --> %s\n%s\n", formatTime(cue.Start), formatTime(cue.End), cue.Text)
+	}
+	return buf.Bytes()
+}
+
+func normalize(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("%w: not UTF-8 text", ErrInvalid)
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return strings.TrimRight(text, "\n") + "\n", nil
+}
+
+func isWebVTT(text string) bool {
+	rest, ok := strings.CutPrefix(text, "WEBVTT")
+	return ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n')
+}
+
+// blocks splits text into groups of lines separated by blank lines.
+func blocks(text string) [][]string {
+	result := [][]string{}
+	for _, block := range strings.Split(text, "\n\n") {
+		block = strings.Trim(block, "\n")
+		if block != "" {
+			result = append(result, strings.Split(block, "\n"))
+		}
+	}
+	return result
+}
+
+func parseWebVTT(text string) ([]Cue, error) {
+	cues := []Cue{}
+	// The first block is the header
+	for _, lines := range blocks(text)[1:] {
+		first := lines[0]
+		if strings.HasPrefix(first, "NOTE") || first == "STYLE" || first == "REGION" {
+			continue
+		}
+		// A cue may start with an identifier line
+		if !strings.Contains(first, "-->") && len(lines) > 1 {
+			lines = lines[1:]
+		}
+		cue, err := parseCue(lines)
+		if err != nil {
+			return nil, err
+		}
+		cues = append(cues, cue)
+	}
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("%w: no cues", ErrInvalid)
+	}
+	return cues, nil
+}
+
+func parseSRT(text string) ([]Cue, error) {
+	cues := []Cue{}
+	for _, lines := range blocks(text) {
+		if _, err := strconv.Atoi(strings.TrimSpace(lines[0])); err == nil && len(lines) > 1 {
+			lines = lines[1:]
+		}
+		cue, err := parseCue(lines)
+		if err != nil {
+			return nil, err
+		}
+		cues = append(cues, cue)
+	}
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("%w: no cues", ErrInvalid)
+	}
+	return cues, nil
+}
+
+// parseCue reads a timing line followed by the cue's text. Cue settings
+// after the end time are kept out of the text and otherwise ignored.
+func parseCue(lines []string) (Cue, error) {
+	start, rest, ok := strings.Cut(lines[0], "-->")
+	if !ok {
+		return Cue{}, fmt.Errorf("%w: expected a timing line, got %q", ErrInvalid, lines[0])
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return Cue{}, fmt.Errorf("%w: missing end time in %q", ErrInvalid, lines[0])
+	}
+	from, err := parseTime(strings.TrimSpace(start))
+	if err != nil {
+		return Cue{}, err
+	}
+	to, err := parseTime(fields[0])
+	if err != nil {
+		return Cue{}, err
+	}
+	if to < from {
+		return Cue{}, fmt.Errorf("%w: cue ends before it starts in %q", ErrInvalid, lines[0])
+	}
+	for _, line := range lines[1:] {
+		if strings.Contains(line, "-->") {
+			return Cue{}, fmt.Errorf("%w: unexpected timing line %q", ErrInvalid, line)
+		}
+	}
+	return Cue{Start: from, End: to, Text: strings.Join(lines[1:], "\n")}, nil
+}
+
+func parseTime(s string) (time.Duration, error) {
+	m := timestamp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("%w: invalid time %q", ErrInvalid, s)
+	}
+	var parts [4]int
+	for i, v := range m[1:] {
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid time %q", ErrInvalid, s)
+		}
+		parts[i] = n
+	}
+	return time.Duration(parts[0])*time.Hour +
+		time.Duration(parts[1])*time.Minute +
+		time.Duration(parts[2])*time.Second +
+		time.Duration(parts[3])*time.Millisecond, nil
+}
+
+func formatTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
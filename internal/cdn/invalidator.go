@@ -0,0 +1,150 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// maxInvalidationPaths is CloudFront's limit on paths per invalidation.
+const maxInvalidationPaths = 3000
+
+// Invalidator batches CloudFront cache invalidations. Paths queued with
+// Invalidate are sent together every interval, since CloudFront charges
+// per path and limits how many invalidations can be in progress.
+type Invalidator struct {
+	DistributionID string
+	Credentials    aws.CredentialsProvider
+	Client         *http.Client
+
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+func NewInvalidator(distributionID string, credentials aws.CredentialsProvider) *Invalidator {
+	return &Invalidator{
+		DistributionID: distributionID,
+		Credentials:    credentials,
+		Client:         &http.Client{Timeout: 30 * time.Second},
+		pending:        map[string]bool{},
+	}
+}
+
+// Invalidate queues paths, e.g. "/landscape/abc.mp4", for the next batch.
+// It is safe to call on a nil Invalidator, which does nothing.
+func (inv *Invalidator) Invalidate(paths ...string) {
+	if inv == nil {
+		return
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	for _, path := range paths {
+		inv.pending[path] = true
+	}
+}
+
+// Run sends queued paths every interval until ctx is done, then sends
+// whatever is left.
+func (inv *Invalidator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			inv.Flush(context.Background())
+			return
+		case <-ticker.C:
+			inv.Flush(ctx)
+		}
+	}
+}
+
+// Flush sends the queued paths now. Paths that fail to send are queued
+// again for the next batch.
+func (inv *Invalidator) Flush(ctx context.Context) {
+	inv.mu.Lock()
+	paths := make([]string, 0, len(inv.pending))
+	for path := range inv.pending {
+		paths = append(paths, path)
+	}
+	inv.pending = map[string]bool{}
+	inv.mu.Unlock()
+	sort.Strings(paths)
+
+	for len(paths) > 0 {
+		batch := paths[:min(len(paths), maxInvalidationPaths)]
+		paths = paths[len(batch):]
+		if err := inv.createInvalidation(ctx, batch); err != nil {
+			log.Printf("Couldn't invalidate %d CDN path(s): %v", len(batch), err)
+			inv.Invalidate(batch...)
+			continue
+		}
+		log.Printf("Invalidated %d CDN path(s)", len(batch))
+	}
+}
+
+type invalidationBatch struct {
+	XMLName         xml.Name `xml:"http://cloudfront.amazonaws.com/doc/2020-05-31/ InvalidationBatch"`
+	Quantity        int      `xml:"Paths>Quantity"`
+	Items           []string `xml:"Paths>Items>Path"`
+	CallerReference string   `xml:"CallerReference"`
+}
+
+// createInvalidation calls the CloudFront API directly; the SDK's client
+// isn't worth a dependency for one operation.
+func (inv *Invalidator) createInvalidation(ctx context.Context, paths []string) error {
+	ref := make([]byte, 16)
+	if _, err := rand.Read(ref); err != nil {
+		return err
+	}
+	body, err := xml.Marshal(invalidationBatch{
+		Quantity:        len(paths),
+		Items:           paths,
+		CallerReference: hex.EncodeToString(ref),
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := "https://cloudfront.amazonaws.com/2020-05-31/distribution/" + inv.DistributionID + "/invalidation"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+
+	creds, err := inv.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256.Sum256(body)
+	// CloudFront is a global service signed in us-east-1
+	err = v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), "cloudfront", "us-east-1", time.Now())
+	if err != nil {
+		return err
+	}
+
+	resp, err := inv.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("cloudfront returned %s: %s", resp.Status, data)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyUsage is one key's metered activity on one UTC day.
+type APIKeyUsage struct {
+	Day         string `json:"day"`
+	Requests    int64  `json:"requests"`
+	RateLimited int64  `json:"rate_limited"`
+	UploadBytes int64  `json:"upload_bytes"`
+}
+
+// usageDay is the bucket usage on t is counted in.
+func usageDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// RecordAPIKeyRequest counts one request made with a key, noting whether
+// it was rejected by the key's rate limit.
+func (c Client) RecordAPIKeyRequest(keyID uuid.UUID, rateLimited bool) error {
+	limited := 0
+	if rateLimited {
+		limited = 1
+	}
+	query := `
+	INSERT INTO api_key_usage (api_key_id, day, requests, rate_limited, upload_bytes)
+	VALUES (?, ?, 1, ?, 0)
+	ON CONFLICT (api_key_id, day) DO UPDATE SET
+		requests = requests + 1,
+		rate_limited = rate_limited + excluded.rate_limited
+	`
+	_, err := c.db.Exec(query, keyID.String(), usageDay(time.Now()), limited)
+	return err
+}
+
+// RecordAPIKeyUpload adds bytes uploaded with a key to today's usage.
+func (c Client) RecordAPIKeyUpload(keyID uuid.UUID, bytes int64) error {
+	query := `
+	INSERT INTO api_key_usage (api_key_id, day, requests, rate_limited, upload_bytes)
+	VALUES (?, ?, 0, 0, ?)
+	ON CONFLICT (api_key_id, day) DO UPDATE SET
+		upload_bytes = upload_bytes + excluded.upload_bytes
+	`
+	_, err := c.db.Exec(query, keyID.String(), usageDay(time.Now()), bytes)
+	return err
+}
+
+// GetAPIKeyUsage returns a key's daily usage between since and until
+// inclusive, oldest first.
+func (c Client) GetAPIKeyUsage(keyID uuid.UUID, since, until time.Time) ([]APIKeyUsage, error) {
+	query := `
+	SELECT day, requests, rate_limited, upload_bytes
+	FROM api_key_usage
+	WHERE api_key_id = ? AND day >= ? AND day <= ?
+	ORDER BY day
+	`
+	rows, err := c.db.Query(query, keyID.String(), usageDay(since), usageDay(until))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []APIKeyUsage{}
+	for rows.Next() {
+		var u APIKeyUsage
+		if err := rows.Scan(&u.Day, &u.Requests, &u.RateLimited, &u.UploadBytes); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
@@ -0,0 +1,182 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Prefix     string     `json:"prefix"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	// RateLimit overrides the default requests per minute; nil means the
+	// default applies
+	RateLimit *int `json:"rate_limit_per_minute"`
+	CreateAPIKeyParams
+}
+
+type CreateAPIKeyParams struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	KeyHash string    `json:"-"`
+}
+
+const apiKeyColumns = `
+		id,
+		created_at,
+		user_id,
+		name,
+		prefix,
+		scopes,
+		last_used_at,
+		revoked_at,
+		rate_limit`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var id, userID, scopes string
+	err := row.Scan(
+		&id,
+		&key.CreatedAt,
+		&userID,
+		&key.Name,
+		&key.Prefix,
+		&scopes,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.RateLimit,
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.Scopes = splitList(scopes)
+	key.ID, err = uuid.Parse(id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams, prefix string) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (
+		id,
+		created_at,
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	scopes := ""
+	if joined := joinList(params.Scopes); joined != nil {
+		scopes = *joined
+	}
+	_, err := c.db.Exec(query, id.String(), params.UserID.String(), params.Name, prefix, params.KeyHash, scopes)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return c.GetAPIKey(id)
+}
+
+func (c Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE id = ?
+	`
+	key, err := scanAPIKey(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// GetAPIKeyByHash looks up an active (unrevoked) key by its hash.
+func (c Client) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE key_hash = ? AND revoked_at IS NULL
+	`
+	key, err := scanAPIKey(c.db.QueryRow(query, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (c Client) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (c Client) TouchAPIKey(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET last_used_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
+// SetAPIKeyRateLimit overrides a key's rate limit; nil restores the
+// deployment default.
+func (c Client) SetAPIKeyRateLimit(id uuid.UUID, perMinute *int) error {
+	query := `
+	UPDATE api_keys
+	SET rate_limit = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, perMinute, id.String())
+	return err
+}
+
+func (c Client) RevokeAPIKey(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent records a security-relevant action. Events are append-only:
+// there is no way to update one, and they outlive the accounts they
+// mention.
+type AuditEvent struct {
+	ID         int64             `json:"id"`
+	CreatedAt  time.Time         `json:"created_at"`
+	ActorID    *uuid.UUID        `json:"actor_id"`
+	Action     string            `json:"action"`
+	TargetType string            `json:"target_type"`
+	TargetID   string            `json:"target_id"`
+	IPAddress  string            `json:"ip_address"`
+	RequestID  string            `json:"request_id"`
+	Details    map[string]string `json:"details"`
+}
+
+type AuditEventFilter struct {
+	ActorID    *uuid.UUID
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      *time.Time
+	Until      *time.Time
+	Limit      int
+}
+
+func (c Client) CreateAuditEvent(event AuditEvent) error {
+	var actorID *string
+	if event.ActorID != nil {
+		id := event.ActorID.String()
+		actorID = &id
+	}
+	var details *string
+	if len(event.Details) > 0 {
+		dat, err := json.Marshal(event.Details)
+		if err != nil {
+			return err
+		}
+		s := string(dat)
+		details = &s
+	}
+
+	query := `
+	INSERT INTO audit_events (
+		created_at,
+		actor_id,
+		action,
+		target_type,
+		target_id,
+		ip_address,
+		request_id,
+		details
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), actorID, event.Action, event.TargetType, event.TargetID, event.IPAddress, event.RequestID, details)
+	return err
+}
+
+// GetAuditEvents returns the events matching filter, newest first.
+func (c Client) GetAuditEvents(filter AuditEventFilter) ([]AuditEvent, error) {
+	conditions := []string{}
+	args := []any{}
+	if filter.ActorID != nil {
+		conditions = append(conditions, "actor_id = ?")
+		args = append(args, filter.ActorID.String())
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.TargetType != "" {
+		conditions = append(conditions, "target_type = ?")
+		args = append(args, filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		conditions = append(conditions, "target_id = ?")
+		args = append(args, filter.TargetID)
+	}
+	if filter.Since != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.Until.UTC())
+	}
+
+	query := `
+	SELECT id, created_at, actor_id, action, target_type, target_id, ip_address, request_id, details
+	FROM audit_events
+	`
+	if len(conditions) > 0 {
+		query += "WHERE " + strings.Join(conditions, " AND ") + "\n"
+	}
+	query += "ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var actorID, details sql.NullString
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&actorID,
+			&event.Action,
+			&event.TargetType,
+			&event.TargetID,
+			&event.IPAddress,
+			&event.RequestID,
+			&details,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			id, err := uuid.Parse(actorID.String)
+			if err != nil {
+				return nil, err
+			}
+			event.ActorID = &id
+		}
+		event.Details = map[string]string{}
+		if details.Valid {
+			if err := json.Unmarshal([]byte(details.String), &event.Details); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
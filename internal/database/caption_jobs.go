@@ -0,0 +1,141 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Statuses of a CaptionJob.
+const (
+	CaptionJobPending = "pending"
+	CaptionJobDone    = "done"
+	CaptionJobFailed  = "failed"
+)
+
+// CaptionJob is a request to generate captions for a video with speech
+// recognition. A video has at most one; queuing another replaces it.
+type CaptionJob struct {
+	ID        uuid.UUID `json:"id"`
+	VideoID   uuid.UUID `json:"video_id"`
+	Language  string    `json:"language"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	Error     *string   `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const captionJobColumns = `
+		id,
+		video_id,
+		language,
+		status,
+		attempts,
+		error,
+		created_at,
+		updated_at`
+
+func scanCaptionJob(row rowScanner) (CaptionJob, error) {
+	var job CaptionJob
+	err := row.Scan(
+		&job.ID,
+		&job.VideoID,
+		&job.Language,
+		&job.Status,
+		&job.Attempts,
+		&job.Error,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	return job, err
+}
+
+// QueueCaptionJob asks for captions in language to be generated for a
+// video, replacing any earlier job for it.
+func (c Client) QueueCaptionJob(videoID uuid.UUID, language string) (CaptionJob, error) {
+	now := time.Now().UTC()
+	_, err := c.db.Exec(`
+	INSERT INTO caption_jobs (id, video_id, language, status, attempts, error, created_at, updated_at)
+	VALUES (?, ?, ?, ?, 0, NULL, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		id = excluded.id,
+		language = excluded.language,
+		status = excluded.status,
+		attempts = 0,
+		error = NULL,
+		created_at = excluded.created_at,
+		updated_at = excluded.updated_at
+	`, uuid.New().String(), videoID.String(), language, CaptionJobPending, now, now)
+	if err != nil {
+		return CaptionJob{}, err
+	}
+	return c.GetCaptionJob(videoID)
+}
+
+// GetCaptionJob returns a video's caption job, or an empty CaptionJob if
+// it has none.
+func (c Client) GetCaptionJob(videoID uuid.UUID) (CaptionJob, error) {
+	job, err := scanCaptionJob(c.db.QueryRow(`
+	SELECT`+captionJobColumns+`
+	FROM caption_jobs
+	WHERE video_id = ?
+	`, videoID.String()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return CaptionJob{}, nil
+	}
+	return job, err
+}
+
+// GetPendingCaptionJobs returns caption jobs waiting to run, least
+// recently tried first.
+func (c Client) GetPendingCaptionJobs(limit int) ([]CaptionJob, error) {
+	rows, err := c.db.Query(`
+	SELECT`+captionJobColumns+`
+	FROM caption_jobs
+	WHERE status = ?
+	ORDER BY updated_at
+	LIMIT ?
+	`, CaptionJobPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []CaptionJob{}
+	for rows.Next() {
+		job, err := scanCaptionJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// CompleteCaptionJob marks a caption job done. A job that has been
+// replaced since it started is left alone.
+func (c Client) CompleteCaptionJob(id uuid.UUID) error {
+	_, err := c.db.Exec(`
+	UPDATE caption_jobs SET status = ?, error = NULL, updated_at = ?
+	WHERE id = ?
+	`, CaptionJobDone, time.Now().UTC(), id.String())
+	return err
+}
+
+// FailCaptionJob records a failed attempt at a caption job. It is tried
+// again later until it has been attempted maxAttempts times, then marked
+// failed. A job that has been replaced since it started is left alone.
+func (c Client) FailCaptionJob(id uuid.UUID, reason string, maxAttempts int) error {
+	_, err := c.db.Exec(`
+	UPDATE caption_jobs SET
+		attempts = attempts + 1,
+		error = ?,
+		status = CASE WHEN attempts + 1 >= ? THEN ? ELSE status END,
+		updated_at = ?
+	WHERE id = ?
+	`, reason, maxAttempts, CaptionJobFailed, time.Now().UTC(), id.String())
+	return err
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Category is a node in the tree videos are browsed by in the catalog.
+// Top-level categories have no parent.
+type Category struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ParentID  *uuid.UUID `json:"parent_id"`
+	Name      string     `json:"name"`
+	// Slug names the category in catalog URLs, e.g. "science-fiction"
+	Slug string `json:"slug"`
+	// Position orders a category among its siblings, lowest first
+	Position int `json:"position"`
+}
+
+const categoryColumns = `
+	id, created_at, updated_at, parent_id, name, slug, position`
+
+func scanCategory(row rowScanner) (Category, error) {
+	var category Category
+	err := row.Scan(
+		&category.ID,
+		&category.CreatedAt,
+		&category.UpdatedAt,
+		&category.ParentID,
+		&category.Name,
+		&category.Slug,
+		&category.Position,
+	)
+	return category, err
+}
+
+func (c Client) CreateCategory(parentID *uuid.UUID, name, slug string, position int) (Category, error) {
+	id := uuid.New()
+	_, err := c.db.Exec(`
+	INSERT INTO categories (id, created_at, updated_at, parent_id, name, slug, position)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`, id.String(), parentID, name, slug, position)
+	if err != nil {
+		return Category{}, err
+	}
+	return c.GetCategory(id)
+}
+
+func (c Client) GetCategory(id uuid.UUID) (Category, error) {
+	category, err := scanCategory(c.db.QueryRow(`
+	SELECT`+categoryColumns+`
+	FROM categories
+	WHERE id = ?
+	`, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Category{}, nil
+		}
+		return Category{}, err
+	}
+	return category, nil
+}
+
+func (c Client) GetCategoryBySlug(slug string) (Category, error) {
+	category, err := scanCategory(c.db.QueryRow(`
+	SELECT`+categoryColumns+`
+	FROM categories
+	WHERE slug = ?
+	`, slug))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Category{}, nil
+		}
+		return Category{}, err
+	}
+	return category, nil
+}
+
+// GetCategories returns every category, siblings in position order.
+func (c Client) GetCategories() ([]Category, error) {
+	rows, err := c.db.Query(`
+	SELECT` + categoryColumns + `
+	FROM categories
+	ORDER BY position, name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		category, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+func (c Client) UpdateCategory(category Category) error {
+	_, err := c.db.Exec(`
+	UPDATE categories
+	SET updated_at = CURRENT_TIMESTAMP, parent_id = ?, name = ?, slug = ?, position = ?
+	WHERE id = ?
+	`, category.ParentID, category.Name, category.Slug, category.Position, category.ID.String())
+	return err
+}
+
+// DeleteCategory deletes a category with no categories below it. Its
+// videos become uncategorized.
+func (c Client) DeleteCategory(id uuid.UUID) error {
+	videoIDs, err := c.cachedVideoIDs("category_id = ?", id.String())
+	if err != nil {
+		return err
+	}
+	owners := c.videoOwners(videoIDs...)
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE videos SET category_id = NULL, updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE category_id = ?`, id.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM categories WHERE id = ?`, id.String())
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.invalidateVideos(videoIDs, owners...)
+	return nil
+}
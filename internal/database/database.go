@@ -3,12 +3,17 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+const defaultRole = "creator"
+
 type Client struct {
-	db *sql.DB
+	db       *sql.DB
+	cache    VideoCache
+	cacheTTL time.Duration
 }
 
 func NewClient(pathToDB string) (Client, error) {
@@ -16,7 +21,7 @@ func NewClient(pathToDB string) (Client, error) {
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
+	c := Client{db: db}
 	err = c.autoMigrate()
 	if err != nil {
 		return Client{}, err
@@ -72,18 +77,764 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	apiKeyTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(apiKeyTable)
+	if err != nil {
+		return err
+	}
+
+	userIdentityTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(provider, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userIdentityTable)
+	if err != nil {
+		return err
+	}
+
+	videoGrantTable := `
+	CREATE TABLE IF NOT EXISTS video_grants (
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		access TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, user_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(videoGrantTable)
+	if err != nil {
+		return err
+	}
+
+	organizationTable := `
+	CREATE TABLE IF NOT EXISTS organizations (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(organizationTable)
+	if err != nil {
+		return err
+	}
+
+	orgMembershipTable := `
+	CREATE TABLE IF NOT EXISTS org_memberships (
+		org_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(org_id, user_id),
+		FOREIGN KEY(org_id) REFERENCES organizations(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(orgMembershipTable)
+	if err != nil {
+		return err
+	}
+
+	sessionTable := `
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		user_agent TEXT NOT NULL,
+		ip_address TEXT NOT NULL,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(sessionTable)
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("refresh_tokens", "session_id", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("refresh_tokens", "rotated_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+
+	passwordResetTable := `
+	CREATE TABLE IF NOT EXISTS password_reset_tokens (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(passwordResetTable)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodeTable := `
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		code_hash TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		used_at TIMESTAMP,
+		PRIMARY KEY(user_id, code_hash),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(recoveryCodeTable)
+	if err != nil {
+		return err
+	}
+
+	inviteCodeTable := `
+	CREATE TABLE IF NOT EXISTS invite_codes (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		created_by TEXT NOT NULL,
+		code_hash TEXT UNIQUE NOT NULL,
+		prefix TEXT NOT NULL,
+		max_uses INTEGER NOT NULL,
+		uses INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(created_by) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(inviteCodeTable)
+	if err != nil {
+		return err
+	}
+
+	guestUploadTable := `
+	CREATE TABLE IF NOT EXISTS guest_uploads (
+		video_id TEXT PRIMARY KEY,
+		token_hash TEXT UNIQUE NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		claimed_by TEXT,
+		claimed_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(claimed_by) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(guestUploadTable)
+	if err != nil {
+		return err
+	}
+
+	uploadTokenTable := `
+	CREATE TABLE IF NOT EXISTS upload_tokens (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(created_by) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(uploadTokenTable)
+	if err != nil {
+		return err
+	}
+
+	videoReportTable := `
+	CREATE TABLE IF NOT EXISTS video_reports (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		video_id TEXT NOT NULL,
+		reporter_id TEXT,
+		reporter_ip TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		details TEXT NOT NULL,
+		status TEXT NOT NULL,
+		resolved_at TIMESTAMP,
+		resolved_by TEXT,
+		resolution TEXT,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(reporter_id) REFERENCES users(id),
+		FOREIGN KEY(resolved_by) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS video_reports_open ON video_reports(status, video_id);
+	`
+	_, err = c.db.Exec(videoReportTable)
+	if err != nil {
+		return err
+	}
+
+	apiKeyUsageTable := `
+	CREATE TABLE IF NOT EXISTS api_key_usage (
+		api_key_id TEXT NOT NULL,
+		day TEXT NOT NULL,
+		requests INTEGER NOT NULL,
+		rate_limited INTEGER NOT NULL,
+		upload_bytes INTEGER NOT NULL,
+		PRIMARY KEY(api_key_id, day),
+		FOREIGN KEY(api_key_id) REFERENCES api_keys(id)
+	);
+	`
+	_, err = c.db.Exec(apiKeyUsageTable)
+	if err != nil {
+		return err
+	}
+
+	// Egress is counted per day and source of the playback, e.g. "cdn"
+	egressUsageTable := `
+	CREATE TABLE IF NOT EXISTS egress_usage (
+		day TEXT NOT NULL,
+		source TEXT NOT NULL,
+		requests INTEGER NOT NULL,
+		bytes INTEGER NOT NULL,
+		PRIMARY KEY(day, source)
+	);
+	`
+	_, err = c.db.Exec(egressUsageTable)
+	if err != nil {
+		return err
+	}
+
+	// Tags are shared between videos, so renaming one would relabel
+	// every video with it
+	tagTables := `
+	CREATE TABLE IF NOT EXISTS tags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE
+	);
+	CREATE TABLE IF NOT EXISTS video_tags (
+		video_id TEXT NOT NULL,
+		tag_id INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, tag_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(tag_id) REFERENCES tags(id)
+	);
+	CREATE INDEX IF NOT EXISTS video_tags_tag ON video_tags(tag_id);
+	`
+	_, err = c.db.Exec(tagTables)
+	if err != nil {
+		return err
+	}
+
+	categoryTable := `
+	CREATE TABLE IF NOT EXISTS categories (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		parent_id TEXT,
+		name TEXT NOT NULL,
+		slug TEXT NOT NULL UNIQUE,
+		position INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY(parent_id) REFERENCES categories(id)
+	);
+	`
+	_, err = c.db.Exec(categoryTable)
+	if err != nil {
+		return err
+	}
+
+	playlistTables := `
+	CREATE TABLE IF NOT EXISTS playlists (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		visibility TEXT NOT NULL DEFAULT 'private',
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS playlists_user ON playlists(user_id);
+	CREATE TABLE IF NOT EXISTS playlist_items (
+		playlist_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(playlist_id, video_id),
+		FOREIGN KEY(playlist_id) REFERENCES playlists(id),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(playlistTables)
+	if err != nil {
+		return err
+	}
+
+	videoFileVersionTable := `
+	CREATE TABLE IF NOT EXISTS video_file_versions (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		replaced_at TIMESTAMP NOT NULL,
+		video_url TEXT NOT NULL,
+		video_provider TEXT,
+		video_bucket TEXT,
+		video_key TEXT NOT NULL,
+		aspect_class TEXT,
+		duration_seconds REAL,
+		video_size INTEGER,
+		video_sha256 TEXT,
+		storage_class TEXT,
+		stored_at TIMESTAMP,
+		moderation_status TEXT,
+		moderation_score REAL,
+		scan_verdict TEXT,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS video_file_versions_video ON video_file_versions(video_id, replaced_at);
+	`
+	_, err = c.db.Exec(videoFileVersionTable)
+	if err != nil {
+		return err
+	}
+
+	videoCaptionTable := `
+	CREATE TABLE IF NOT EXISTS video_captions (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		language TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		provider TEXT NOT NULL,
+		bucket TEXT,
+		file_key TEXT NOT NULL,
+		UNIQUE(video_id, language),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(videoCaptionTable)
+	if err != nil {
+		return err
+	}
+
+	videoLocalizationTable := `
+	CREATE TABLE IF NOT EXISTS video_localizations (
+		video_id TEXT NOT NULL,
+		locale TEXT NOT NULL,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, locale),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(videoLocalizationTable)
+	if err != nil {
+		return err
+	}
+
+	captionJobTable := `
+	CREATE TABLE IF NOT EXISTS caption_jobs (
+		id TEXT PRIMARY KEY,
+		video_id TEXT UNIQUE NOT NULL,
+		language TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		error TEXT,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS caption_jobs_status ON caption_jobs(status, updated_at);
+	`
+	_, err = c.db.Exec(captionJobTable)
+	if err != nil {
+		return err
+	}
+
+	shareLinkTable := `
+	CREATE TABLE IF NOT EXISTS share_links (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		max_views INTEGER,
+		view_count INTEGER NOT NULL DEFAULT 0,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(created_by) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(shareLinkTable)
+	if err != nil {
+		return err
+	}
+
+	// Audit events are append-only; the trigger stops anything rewriting
+	// history through the database handle
+	auditEventTable := `
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP NOT NULL,
+		actor_id TEXT,
+		action TEXT NOT NULL,
+		target_type TEXT NOT NULL,
+		target_id TEXT NOT NULL,
+		ip_address TEXT NOT NULL,
+		request_id TEXT NOT NULL,
+		details TEXT
+	);
+	CREATE INDEX IF NOT EXISTS audit_events_actor ON audit_events(actor_id);
+	CREATE INDEX IF NOT EXISTS audit_events_target ON audit_events(target_type, target_id);
+	CREATE TRIGGER IF NOT EXISTS audit_events_no_update
+	BEFORE UPDATE ON audit_events
+	BEGIN
+		SELECT RAISE(ABORT, 'audit events are append-only');
+	END;
+	`
+	_, err = c.db.Exec(auditEventTable)
+	if err != nil {
+		return err
+	}
+
+	// Outbox events are queued in the same transaction as the change
+	// they describe and delivered afterwards, once per destination
+	outboxEventTable := `
+	CREATE TABLE IF NOT EXISTS outbox_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP NOT NULL,
+		event_type TEXT NOT NULL,
+		destination TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NOT NULL,
+		delivered_at TIMESTAMP,
+		failed_at TIMESTAMP,
+		last_error TEXT
+	);
+	CREATE INDEX IF NOT EXISTS outbox_events_due ON outbox_events(next_attempt_at) WHERE delivered_at IS NULL AND failed_at IS NULL;
+	`
+	_, err = c.db.Exec(outboxEventTable)
+	if err != nil {
+		return err
+	}
+
+	// The role default is kept in sync with auth.DefaultRole, so existing
+	// users become creators
+	userColumns := []struct{ name, definition string }{
+		{"role", "TEXT NOT NULL DEFAULT '" + defaultRole + "'"},
+		{"totp_secret", "TEXT"},
+		{"totp_enabled", "INTEGER NOT NULL DEFAULT 0"},
+		{"totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+		{"storage_quota", "INTEGER"},
+	}
+	for _, col := range userColumns {
+		if err := c.addColumnIfMissing("users", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	if err := c.addColumnIfMissing("api_keys", "rate_limit", "INTEGER"); err != nil {
+		return err
+	}
+	if err := c.addColumnIfMissing("video_captions", "auto_generated", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
+
+	videoColumns := []struct{ name, definition string }{
+		{"thumbnail_key", "TEXT"},
+		{"thumbnail_width", "INTEGER"},
+		{"thumbnail_height", "INTEGER"},
+		{"thumbnail_blurhash", "TEXT"},
+		{"thumbnail_color", "TEXT"},
+		{"thumbnail_phash", "TEXT"},
+		{"thumbnail_sha256", "TEXT"},
+		{"aspect_class", "TEXT"},
+		{"moderation_status", "TEXT"},
+		{"moderation_score", "REAL"},
+		{"moderation_reason", "TEXT"},
+		{"quarantine_key", "TEXT"},
+		{"allowed_countries", "TEXT"},
+		{"blocked_countries", "TEXT"},
+		{"visibility", "TEXT NOT NULL DEFAULT 'public'"},
+		{"org_id", "TEXT"},
+		{"video_size", "INTEGER"},
+		{"storage_class", "TEXT"},
+		{"stored_at", "TIMESTAMP"},
+		{"restore_requested_at", "TIMESTAMP"},
+		{"restore_tier", "TEXT"},
+		{"storage_problem", "TEXT"},
+		{"storage_checked_at", "TIMESTAMP"},
+		{"replica_regions", "TEXT"},
+		{"video_provider", "TEXT"},
+		{"video_bucket", "TEXT"},
+		{"video_key", "TEXT"},
+		{"replica_checked_at", "TIMESTAMP"},
+		{"video_sha256", "TEXT"},
+		{"scan_verdict", "TEXT"},
+		{"scan_signature", "TEXT"},
+		{"expires_at", "TIMESTAMP"},
+		{"expiry_reminded_at", "TIMESTAMP"},
+		{"deleted_at", "TIMESTAMP"},
+		{"duration_seconds", "REAL"},
+		{"view_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"category_id", "TEXT"},
+		{"version", "INTEGER NOT NULL DEFAULT 1"},
+		{"status", "TEXT NOT NULL DEFAULT 'draft'"},
+		{"trashed_status", "TEXT"},
+	}
+	for _, col := range videoColumns {
+		if err := c.addColumnIfMissing("videos", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	// Probed media details are kept with file versions too, so restoring
+	// a version brings back the details of its file
+	mediaColumns := []struct{ name, definition string }{
+		{"video_width", "INTEGER"},
+		{"video_height", "INTEGER"},
+		{"video_codec", "TEXT"},
+		{"audio_codec", "TEXT"},
+		{"video_bitrate", "INTEGER"},
+		{"frame_rate", "REAL"},
+	}
+	for _, table := range []string{"videos", "video_file_versions"} {
+		for _, col := range mediaColumns {
+			if err := c.addColumnIfMissing(table, col.name, col.definition); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Videos from before statuses were tracked get one from their file,
+	// and uploads cut off by a restart are settled the way a failed one
+	// would have been: back to ready if the video still has a file
+	videoStatuses := `
+	UPDATE videos SET status = 'ready'
+	WHERE status = 'draft' AND (video_url IS NOT NULL OR quarantine_key IS NOT NULL);
+	UPDATE videos SET status = 'failed'
+	WHERE status = 'ready' AND video_url IS NULL AND scan_verdict = 'infected';
+	UPDATE videos SET status = CASE WHEN video_url IS NOT NULL OR quarantine_key IS NOT NULL THEN 'ready' ELSE 'failed' END
+	WHERE status IN ('uploading', 'processing');
+	UPDATE videos SET status = 'deleted'
+	WHERE deleted_at IS NOT NULL AND status NOT IN ('deleted', 'trashed');
+	`
+	if _, err := c.db.Exec(videoStatuses); err != nil {
+		return err
+	}
+
+	// Expiry times used to be stored with the offset they were set in,
+	// which compares wrongly as text against times in another zone
+	videoExpiryUTC := `
+	UPDATE videos SET expires_at = strftime('%Y-%m-%d %H:%M:%f', expires_at) || '+00:00'
+	WHERE expires_at IS NOT NULL AND expires_at NOT LIKE '%+00:00';
+	`
+	if _, err := c.db.Exec(videoExpiryUTC); err != nil {
+		return err
+	}
+
+	// Per-user storage totals are kept up to date by triggers, so every
+	// write to videos is counted without summing them on each read. Rows
+	// are seeded from the videos table the first time around
+	userStorageTable := `
+	CREATE TABLE IF NOT EXISTS user_storage (
+		user_id TEXT PRIMARY KEY,
+		used_bytes INTEGER NOT NULL DEFAULT 0,
+		video_count INTEGER NOT NULL DEFAULT 0
+	);
+	INSERT OR IGNORE INTO user_storage (user_id, used_bytes, video_count)
+	SELECT user_id, SUM(video_size), COUNT(video_size)
+	FROM videos
+	WHERE video_size IS NOT NULL
+	GROUP BY user_id;
+	CREATE TRIGGER IF NOT EXISTS user_storage_video_insert
+	AFTER INSERT ON videos
+	WHEN NEW.video_size IS NOT NULL
+	BEGIN
+		INSERT INTO user_storage (user_id, used_bytes, video_count)
+		VALUES (NEW.user_id, NEW.video_size, 1)
+		ON CONFLICT(user_id) DO UPDATE SET
+			used_bytes = used_bytes + excluded.used_bytes,
+			video_count = video_count + 1;
+	END;
+	CREATE TRIGGER IF NOT EXISTS user_storage_video_update
+	AFTER UPDATE OF video_size, user_id ON videos
+	BEGIN
+		UPDATE user_storage
+		SET used_bytes = used_bytes - OLD.video_size, video_count = video_count - 1
+		WHERE user_id = OLD.user_id AND OLD.video_size IS NOT NULL;
+		INSERT INTO user_storage (user_id, used_bytes, video_count)
+		SELECT NEW.user_id, NEW.video_size, 1
+		WHERE NEW.video_size IS NOT NULL
+		ON CONFLICT(user_id) DO UPDATE SET
+			used_bytes = used_bytes + excluded.used_bytes,
+			video_count = video_count + 1;
+		DELETE FROM user_storage WHERE user_id = OLD.user_id AND video_count = 0;
+	END;
+	CREATE TRIGGER IF NOT EXISTS user_storage_video_delete
+	AFTER DELETE ON videos
+	WHEN OLD.video_size IS NOT NULL
+	BEGIN
+		UPDATE user_storage
+		SET used_bytes = used_bytes - OLD.video_size, video_count = video_count - 1
+		WHERE user_id = OLD.user_id;
+		DELETE FROM user_storage WHERE user_id = OLD.user_id AND video_count = 0;
+	END;
+	`
+	_, err = c.db.Exec(userStorageTable)
+	return err
+}
+
+// addColumnIfMissing lets autoMigrate evolve tables that were created by an
+// earlier version of the schema.
+func (c *Client) addColumnIfMissing(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
 	return nil
 }
 
 func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM audit_events"); err != nil {
+		return fmt.Errorf("failed to reset table audit_events: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM upload_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table upload_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM share_links"); err != nil {
+		return fmt.Errorf("failed to reset table share_links: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_file_versions"); err != nil {
+		return fmt.Errorf("failed to reset table video_file_versions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_localizations"); err != nil {
+		return fmt.Errorf("failed to reset table video_localizations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM caption_jobs"); err != nil {
+		return fmt.Errorf("failed to reset table caption_jobs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_captions"); err != nil {
+		return fmt.Errorf("failed to reset table video_captions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_reports"); err != nil {
+		return fmt.Errorf("failed to reset table video_reports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM invite_codes"); err != nil {
+		return fmt.Errorf("failed to reset table invite_codes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlist_items"); err != nil {
+		return fmt.Errorf("failed to reset table playlist_items: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlists"); err != nil {
+		return fmt.Errorf("failed to reset table playlists: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_tags"); err != nil {
+		return fmt.Errorf("failed to reset table video_tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM tags"); err != nil {
+		return fmt.Errorf("failed to reset table tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM egress_usage"); err != nil {
+		return fmt.Errorf("failed to reset table egress_usage: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM api_key_usage"); err != nil {
+		return fmt.Errorf("failed to reset table api_key_usage: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM org_memberships"); err != nil {
+		return fmt.Errorf("failed to reset table org_memberships: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organizations"); err != nil {
+		return fmt.Errorf("failed to reset table organizations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM guest_uploads"); err != nil {
+		return fmt.Errorf("failed to reset table guest_uploads: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_grants"); err != nil {
+		return fmt.Errorf("failed to reset table video_grants: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_identities"); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM recovery_codes"); err != nil {
+		return fmt.Errorf("failed to reset table recovery_codes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM password_reset_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table password_reset_tokens: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM sessions"); err != nil {
+		return fmt.Errorf("failed to reset table sessions: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
+	videoIDs, err := c.cachedVideoIDs("1 = 1")
+	if err != nil {
+		return fmt.Errorf("failed to reset table videos: %w", err)
+	}
+	owners := c.videoOwners(videoIDs...)
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	c.invalidateVideos(videoIDs, owners...)
+	if _, err := c.db.Exec("DELETE FROM categories"); err != nil {
+		return fmt.Errorf("failed to reset table categories: %w", err)
+	}
 	return nil
 }
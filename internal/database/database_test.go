@@ -0,0 +1,36 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func newTestClient(t *testing.T) Client {
+	t.Helper()
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { c.db.Close() })
+	return c
+}
+
+func newTestUser(t *testing.T, c Client, email string) *User {
+	t.Helper()
+	user, err := c.CreateUser(CreateUserParams{Email: email, Password: "unused", Role: "user"})
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	return user
+}
+
+func newTestVideo(t *testing.T, c Client, userID uuid.UUID, orgID *uuid.UUID) Video {
+	t.Helper()
+	video, err := c.CreateVideo(CreateVideoParams{Title: "test", UserID: userID, OrgID: orgID})
+	if err != nil {
+		t.Fatalf("CreateVideo() error = %v", err)
+	}
+	return video
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"time"
+)
+
+// EgressUsage is the video served from one source over a period.
+type EgressUsage struct {
+	Source   string `json:"source"`
+	Requests int64  `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// StorageClassUsage is the video stored in one storage class.
+type StorageClassUsage struct {
+	// StorageClass is nil for files stored without one, which S3 keeps
+	// in STANDARD.
+	StorageClass *string `json:"storage_class"`
+	Bytes        int64   `json:"bytes"`
+	VideoCount   int     `json:"video_count"`
+}
+
+// RecordEgress adds one playback of bytes served from source to today's
+// usage.
+func (c Client) RecordEgress(source string, bytes int64) error {
+	query := `
+	INSERT INTO egress_usage (day, source, requests, bytes)
+	VALUES (?, ?, 1, ?)
+	ON CONFLICT (day, source) DO UPDATE SET
+		requests = requests + 1,
+		bytes = bytes + excluded.bytes
+	`
+	_, err := c.db.Exec(query, usageDay(time.Now()), source, bytes)
+	return err
+}
+
+// GetEgressUsage returns the egress of each source between since and
+// until inclusive.
+func (c Client) GetEgressUsage(since, until time.Time) ([]EgressUsage, error) {
+	query := `
+	SELECT source, SUM(requests), SUM(bytes)
+	FROM egress_usage
+	WHERE day >= ? AND day <= ?
+	GROUP BY source
+	ORDER BY source
+	`
+	rows, err := c.db.Query(query, usageDay(since), usageDay(until))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []EgressUsage{}
+	for rows.Next() {
+		var u EgressUsage
+		if err := rows.Scan(&u.Source, &u.Requests, &u.Bytes); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// GetStorageClassUsage returns the bytes of video stored in each storage
+// class.
+func (c Client) GetStorageClassUsage() ([]StorageClassUsage, error) {
+	query := `
+	SELECT storage_class, SUM(video_size), COUNT(*)
+	FROM videos
+	WHERE video_size IS NOT NULL
+	GROUP BY storage_class
+	ORDER BY storage_class
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []StorageClassUsage{}
+	for rows.Next() {
+		var u StorageClassUsage
+		if err := rows.Scan(&u.StorageClass, &u.Bytes, &u.VideoCount); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GuestUpload is an upload slot created without an account. Whoever holds
+// its claim token can upload to the video and later attach it to their
+// account.
+type GuestUpload struct {
+	VideoID   uuid.UUID  `json:"video_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	ClaimedBy *uuid.UUID `json:"claimed_by"`
+	ClaimedAt *time.Time `json:"claimed_at"`
+}
+
+func (c Client) CreateGuestUpload(videoID uuid.UUID, tokenHash string, expiresAt time.Time) (GuestUpload, error) {
+	query := `
+	INSERT INTO guest_uploads (video_id, token_hash, created_at, expires_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP, ?)
+	`
+	_, err := c.db.Exec(query, videoID.String(), tokenHash, expiresAt.UTC())
+	if err != nil {
+		return GuestUpload{}, err
+	}
+	return c.GetGuestUpload(videoID)
+}
+
+func (c Client) GetGuestUpload(videoID uuid.UUID) (GuestUpload, error) {
+	query := `
+	SELECT video_id, created_at, expires_at, claimed_by, claimed_at
+	FROM guest_uploads
+	WHERE video_id = ?
+	`
+	var upload GuestUpload
+	var id string
+	var claimedBy sql.NullString
+	err := c.db.QueryRow(query, videoID.String()).Scan(&id, &upload.CreatedAt, &upload.ExpiresAt, &claimedBy, &upload.ClaimedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return GuestUpload{}, nil
+		}
+		return GuestUpload{}, err
+	}
+	upload.VideoID, err = uuid.Parse(id)
+	if err != nil {
+		return GuestUpload{}, err
+	}
+	if claimedBy.Valid {
+		claimer, err := uuid.Parse(claimedBy.String)
+		if err != nil {
+			return GuestUpload{}, err
+		}
+		upload.ClaimedBy = &claimer
+	}
+	return upload, nil
+}
+
+// GetOpenGuestUpload returns the video an unclaimed, unexpired claim token
+// grants upload access to, or uuid.Nil if there is none.
+func (c Client) GetOpenGuestUpload(tokenHash string) (uuid.UUID, error) {
+	query := `
+	SELECT video_id
+	FROM guest_uploads
+	WHERE token_hash = ? AND claimed_at IS NULL AND expires_at > ?
+	`
+	var id string
+	err := c.db.QueryRow(query, tokenHash, time.Now().UTC()).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, nil
+		}
+		return uuid.Nil, err
+	}
+	return uuid.Parse(id)
+}
+
+// ErrStorageQuotaExceeded is returned when claiming a guest upload would
+// take its claimant over their storage quota.
+var ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+
+// ClaimGuestUpload redeems a claim token, making userID the owner of its
+// video. It returns the video's ID, or uuid.Nil if the token is unknown,
+// expired or already claimed. With a non-zero quota, the claim is refused
+// with ErrStorageQuotaExceeded if the video would take userID over it.
+func (c Client) ClaimGuestUpload(tokenHash string, userID uuid.UUID, quota int64) (uuid.UUID, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRow(`
+		UPDATE guest_uploads
+		SET claimed_by = ?, claimed_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND claimed_at IS NULL AND expires_at > ?
+		RETURNING video_id
+	`, userID.String(), tokenHash, time.Now().UTC()).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, nil
+		}
+		return uuid.Nil, err
+	}
+	videoID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// Checked after the claim is written, so concurrent claims by the same
+	// user wait for this one and see its video counted
+	if quota > 0 {
+		var size, used int64
+		err = tx.QueryRow(`
+			SELECT
+				COALESCE((SELECT video_size FROM videos WHERE id = ?), 0),
+				COALESCE((SELECT used_bytes FROM user_storage WHERE user_id = ?), 0)
+		`, videoID, userID).Scan(&size, &used)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if used+size > quota {
+			return uuid.Nil, ErrStorageQuotaExceeded
+		}
+	}
+
+	owners := c.videoOwners(videoID)
+	_, err = tx.Exec(`
+		UPDATE videos
+		SET user_id = ?, updated_at = CURRENT_TIMESTAMP, version = version + 1
+		WHERE id = ?
+	`, userID, videoID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, err
+	}
+	c.invalidateVideos([]uuid.UUID{videoID}, owners...)
+	return videoID, nil
+}
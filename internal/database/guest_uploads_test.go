@@ -0,0 +1,68 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestClaimGuestUploadQuota(t *testing.T) {
+	tests := []struct {
+		name      string
+		used      int64
+		size      int64
+		quota     int64
+		wantErr   error
+		wantOwner bool
+	}{
+		{name: "fits", used: 100, size: 50, quota: 200, wantOwner: true},
+		{name: "exactly fills quota", used: 150, size: 50, quota: 200, wantOwner: true},
+		{name: "over quota", used: 180, size: 50, quota: 200, wantErr: ErrStorageQuotaExceeded},
+		{name: "unlimited", used: 1000, size: 50, quota: 0, wantOwner: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			user := newTestUser(t, c, "claimant@example.com")
+
+			owned := newTestVideo(t, c, user.ID, nil)
+			owned.VideoSize = &tt.used
+			if err := c.UpdateVideo(owned); err != nil {
+				t.Fatalf("UpdateVideo() error = %v", err)
+			}
+			guest := newTestVideo(t, c, uuid.Nil, nil)
+			guest.VideoSize = &tt.size
+			if err := c.UpdateVideo(guest); err != nil {
+				t.Fatalf("UpdateVideo() error = %v", err)
+			}
+			if _, err := c.CreateGuestUpload(guest.ID, "token-hash", time.Now().Add(time.Hour)); err != nil {
+				t.Fatalf("CreateGuestUpload() error = %v", err)
+			}
+
+			videoID, err := c.ClaimGuestUpload("token-hash", user.ID, tt.quota)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ClaimGuestUpload() error = %v, want %v", err, tt.wantErr)
+			}
+
+			video, err := c.GetVideo(guest.ID)
+			if err != nil {
+				t.Fatalf("GetVideo() error = %v", err)
+			}
+			if owner := video.UserID == user.ID; owner != tt.wantOwner {
+				t.Errorf("video owned by claimant = %v, want %v", owner, tt.wantOwner)
+			}
+			if tt.wantErr != nil {
+				// A rejected claim can be retried once space is freed
+				if _, err := c.ClaimGuestUpload("token-hash", user.ID, 0); err != nil {
+					t.Errorf("retrying ClaimGuestUpload() error = %v", err)
+				}
+				return
+			}
+			if videoID != guest.ID {
+				t.Errorf("ClaimGuestUpload() = %s, want %s", videoID, guest.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type InviteCode struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	CreatedBy uuid.UUID  `json:"created_by"`
+	Prefix    string     `json:"prefix"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+type CreateInviteCodeParams struct {
+	CreatedBy uuid.UUID
+	CodeHash  string
+	Prefix    string
+	MaxUses   int
+	ExpiresAt *time.Time
+}
+
+const inviteCodeColumns = `
+		id,
+		created_at,
+		created_by,
+		prefix,
+		max_uses,
+		uses,
+		expires_at,
+		revoked_at`
+
+func scanInviteCode(row rowScanner) (InviteCode, error) {
+	var invite InviteCode
+	var id, createdBy string
+	err := row.Scan(
+		&id,
+		&invite.CreatedAt,
+		&createdBy,
+		&invite.Prefix,
+		&invite.MaxUses,
+		&invite.Uses,
+		&invite.ExpiresAt,
+		&invite.RevokedAt,
+	)
+	if err != nil {
+		return InviteCode{}, err
+	}
+	invite.ID, err = uuid.Parse(id)
+	if err != nil {
+		return InviteCode{}, err
+	}
+	invite.CreatedBy, err = uuid.Parse(createdBy)
+	if err != nil {
+		return InviteCode{}, err
+	}
+	return invite, nil
+}
+
+func (c Client) CreateInviteCode(params CreateInviteCodeParams) (InviteCode, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO invite_codes (
+		id,
+		created_at,
+		created_by,
+		code_hash,
+		prefix,
+		max_uses,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id.String(), params.CreatedBy.String(), params.CodeHash, params.Prefix, params.MaxUses, params.ExpiresAt)
+	if err != nil {
+		return InviteCode{}, err
+	}
+	return c.GetInviteCode(id)
+}
+
+func (c Client) GetInviteCode(id uuid.UUID) (InviteCode, error) {
+	query := `
+	SELECT` + inviteCodeColumns + `
+	FROM invite_codes
+	WHERE id = ?
+	`
+	invite, err := scanInviteCode(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return InviteCode{}, nil
+		}
+		return InviteCode{}, err
+	}
+	return invite, nil
+}
+
+func (c Client) GetInviteCodes() ([]InviteCode, error) {
+	query := `
+	SELECT` + inviteCodeColumns + `
+	FROM invite_codes
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []InviteCode{}
+	for rows.Next() {
+		invite, err := scanInviteCode(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+func (c Client) RevokeInviteCode(id uuid.UUID) error {
+	query := `
+	UPDATE invite_codes
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
+// RedeemInviteCode uses up one use of a live invite code, returning its ID,
+// or uuid.Nil if the code is unknown, revoked, expired or used up. The
+// check and increment happen in one statement so concurrent signups can't
+// exceed the limit.
+func (c Client) RedeemInviteCode(codeHash string) (uuid.UUID, error) {
+	query := `
+	UPDATE invite_codes
+	SET uses = uses + 1
+	WHERE code_hash = ?
+		AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > ?)
+		AND uses < max_uses
+	RETURNING id
+	`
+	var id string
+	err := c.db.QueryRow(query, codeHash, time.Now().UTC()).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, nil
+		}
+		return uuid.Nil, err
+	}
+	return uuid.Parse(id)
+}
+
+// ReleaseInviteCode gives back a use taken by RedeemInviteCode when the
+// signup it was for didn't go through.
+func (c Client) ReleaseInviteCode(id uuid.UUID) error {
+	query := `
+	UPDATE invite_codes
+	SET uses = uses - 1
+	WHERE id = ? AND uses > 0
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
@@ -0,0 +1,263 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Organization roles, from most to least privileged. Owners manage members
+// and every video of the organization, editors upload and edit its videos,
+// and viewers can watch its private videos.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleEditor = "editor"
+	OrgRoleViewer = "viewer"
+)
+
+type Organization struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name"`
+	// Role is the requesting user's membership role when listing their
+	// organizations
+	Role string `json:"role,omitempty"`
+}
+
+type OrgMembership struct {
+	OrgID     uuid.UUID `json:"org_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateOrganization creates an organization with ownerID as its first
+// owner.
+func (c Client) CreateOrganization(name string, ownerID uuid.UUID) (Organization, error) {
+	id := uuid.New()
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO organizations (id, created_at, updated_at, name)
+		VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?)
+	`, id.String(), name)
+	if err != nil {
+		return Organization{}, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO org_memberships (org_id, user_id, role, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`, id.String(), ownerID.String(), OrgRoleOwner)
+	if err != nil {
+		return Organization{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Organization{}, err
+	}
+	return c.GetOrganization(id)
+}
+
+func (c Client) GetOrganization(id uuid.UUID) (Organization, error) {
+	query := `
+		SELECT id, created_at, updated_at, name
+		FROM organizations
+		WHERE id = ?
+	`
+	var org Organization
+	var orgID string
+	err := c.db.QueryRow(query, id.String()).Scan(&orgID, &org.CreatedAt, &org.UpdatedAt, &org.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Organization{}, nil
+		}
+		return Organization{}, err
+	}
+	org.ID, err = uuid.Parse(orgID)
+	if err != nil {
+		return Organization{}, err
+	}
+	return org, nil
+}
+
+// GetOrganizationsForUser returns every organization userID belongs to,
+// with Role set to their membership role.
+func (c Client) GetOrganizationsForUser(userID uuid.UUID) ([]Organization, error) {
+	query := `
+		SELECT o.id, o.created_at, o.updated_at, o.name, m.role
+		FROM organizations o
+		JOIN org_memberships m ON m.org_id = o.id
+		WHERE m.user_id = ?
+		ORDER BY o.name
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []Organization{}
+	for rows.Next() {
+		var org Organization
+		var orgID string
+		if err := rows.Scan(&orgID, &org.CreatedAt, &org.UpdatedAt, &org.Name, &org.Role); err != nil {
+			return nil, err
+		}
+		org.ID, err = uuid.Parse(orgID)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+// GetOrgMembership returns userID's membership in orgID, or an empty
+// OrgMembership if they are not a member.
+func (c Client) GetOrgMembership(orgID, userID uuid.UUID) (OrgMembership, error) {
+	query := `
+		SELECT m.org_id, m.user_id, u.email, m.role, m.created_at
+		FROM org_memberships m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = ? AND m.user_id = ?
+	`
+	membership, err := scanOrgMembership(c.db.QueryRow(query, orgID.String(), userID.String()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return OrgMembership{}, nil
+	}
+	return membership, err
+}
+
+func (c Client) GetOrgMemberships(orgID uuid.UUID) ([]OrgMembership, error) {
+	query := `
+		SELECT m.org_id, m.user_id, u.email, m.role, m.created_at
+		FROM org_memberships m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = ?
+		ORDER BY m.created_at
+	`
+	rows, err := c.db.Query(query, orgID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []OrgMembership{}
+	for rows.Next() {
+		membership, err := scanOrgMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, membership)
+	}
+	return memberships, rows.Err()
+}
+
+// PutOrgMembership adds a member or changes an existing member's role.
+func (c Client) PutOrgMembership(orgID, userID uuid.UUID, role string) error {
+	query := `
+		INSERT INTO org_memberships (org_id, user_id, role, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(org_id, user_id) DO UPDATE SET role = excluded.role
+	`
+	_, err := c.db.Exec(query, orgID.String(), userID.String(), role)
+	return err
+}
+
+func (c Client) DeleteOrgMembership(orgID, userID uuid.UUID) error {
+	query := `
+		DELETE FROM org_memberships
+		WHERE org_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, orgID.String(), userID.String())
+	return err
+}
+
+// GetAllOrgVideos returns every video belonging to an organization,
+// including trashed and deleted ones, for removing their media along with
+// the organization.
+func (c Client) GetAllOrgVideos(orgID uuid.UUID) ([]Video, error) {
+	return c.queryVideos(`
+	SELECT`+videoColumns+`
+	FROM videos
+	WHERE org_id = ?
+	`, orgID.String())
+}
+
+// DeleteOrganization deletes an organization with its memberships and all
+// of its videos, along with everything referring to them.
+func (c Client) DeleteOrganization(id uuid.UUID) error {
+	videoIDs, err := c.cachedVideoIDs(`org_id = ?`, id.String())
+	if err != nil {
+		return err
+	}
+	owners := c.videoOwners(videoIDs...)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	orgVideos := `SELECT id FROM videos WHERE org_id = ?`
+	statements := []string{
+		`DELETE FROM video_grants WHERE video_id IN (` + orgVideos + `)`,
+		`DELETE FROM guest_uploads WHERE video_id IN (` + orgVideos + `)`,
+		`DELETE FROM video_reports WHERE video_id IN (` + orgVideos + `)`,
+		`DELETE FROM upload_tokens WHERE video_id IN (` + orgVideos + `)`,
+		`DELETE FROM share_links WHERE video_id IN (` + orgVideos + `)`,
+		`DELETE FROM video_tags WHERE video_id IN (` + orgVideos + `)`,
+		`DELETE FROM caption_jobs WHERE video_id IN (` + orgVideos + `)`,
+		`DELETE FROM video_localizations WHERE video_id IN (` + orgVideos + `)`,
+		`DELETE FROM playlist_items WHERE video_id IN (` + orgVideos + `)`,
+		`DELETE FROM videos WHERE org_id = ?`,
+		`DELETE FROM org_memberships WHERE org_id = ?`,
+		`DELETE FROM organizations WHERE id = ?`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, id.String()); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.invalidateVideos(videoIDs, owners...)
+	return nil
+}
+
+// CountOrgOwners is used to stop an organization losing its last owner.
+func (c Client) CountOrgOwners(orgID uuid.UUID) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM org_memberships
+		WHERE org_id = ? AND role = ?
+	`
+	var count int
+	err := c.db.QueryRow(query, orgID.String(), OrgRoleOwner).Scan(&count)
+	return count, err
+}
+
+func scanOrgMembership(row rowScanner) (OrgMembership, error) {
+	var membership OrgMembership
+	var orgID, userID string
+	err := row.Scan(&orgID, &userID, &membership.Email, &membership.Role, &membership.CreatedAt)
+	if err != nil {
+		return OrgMembership{}, err
+	}
+	membership.OrgID, err = uuid.Parse(orgID)
+	if err != nil {
+		return OrgMembership{}, err
+	}
+	membership.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return OrgMembership{}, err
+	}
+	return membership, nil
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboxEvent is a notification waiting to be delivered to one
+// destination, such as a webhook URL.
+type OutboxEvent struct {
+	ID          int64
+	CreatedAt   time.Time
+	Type        string
+	Destination string
+	Payload     []byte
+	Attempts    int
+}
+
+// UpdateVideoWithEvent is UpdateVideo that also queues an eventType
+// event with payload for each of destinations, in the same transaction,
+// so the event is sent if and only if the update happened. With a
+// non-zero ifVersion, the video must still be at that version, or
+// ErrVideoModified is returned and nothing is saved.
+func (c Client) UpdateVideoWithEvent(video Video, ifVersion int64, eventType string, payload []byte, destinations []string) error {
+	owners := c.videoOwners(video.ID)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := updateVideo(tx, video, ifVersion); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, destination := range destinations {
+		_, err := tx.Exec(`
+		INSERT INTO outbox_events (created_at, event_type, destination, payload, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?)
+		`, now, eventType, destination, string(payload), now)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.invalidateVideos([]uuid.UUID{video.ID}, owners...)
+	return nil
+}
+
+// GetDueOutboxEvents returns undelivered events whose next attempt is due,
+// oldest first.
+func (c Client) GetDueOutboxEvents(limit int) ([]OutboxEvent, error) {
+	rows, err := c.db.Query(`
+	SELECT id, created_at, event_type, destination, payload, attempts
+	FROM outbox_events
+	WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?
+	ORDER BY next_attempt_at, id
+	LIMIT ?
+	`, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []OutboxEvent{}
+	for rows.Next() {
+		var event OutboxEvent
+		var payload string
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.Type, &event.Destination, &payload, &event.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		event.Payload = []byte(payload)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (c Client) MarkOutboxEventDelivered(id int64) error {
+	_, err := c.db.Exec(`
+	UPDATE outbox_events
+	SET delivered_at = ?, attempts = attempts + 1, last_error = NULL
+	WHERE id = ?
+	`, time.Now().UTC(), id)
+	return err
+}
+
+// MarkOutboxEventFailed records a failed delivery attempt. The event is
+// retried at nextAttempt, or given up on if nextAttempt is nil.
+func (c Client) MarkOutboxEventFailed(id int64, lastError string, nextAttempt *time.Time) error {
+	now := time.Now().UTC()
+	if nextAttempt == nil {
+		_, err := c.db.Exec(`
+		UPDATE outbox_events
+		SET failed_at = ?, attempts = attempts + 1, last_error = ?
+		WHERE id = ?
+		`, now, lastError, id)
+		return err
+	}
+	_, err := c.db.Exec(`
+	UPDATE outbox_events
+	SET next_attempt_at = ?, attempts = attempts + 1, last_error = ?
+	WHERE id = ?
+	`, nextAttempt.UTC(), lastError, id)
+	return err
+}
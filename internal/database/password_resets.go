@@ -0,0 +1,57 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CreatePasswordResetToken stores the hash of a reset token for userID,
+// invalidating any earlier unused tokens so only the newest link works.
+func (c Client) CreatePasswordResetToken(tokenHash string, userID uuid.UUID, expiresAt time.Time) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE password_reset_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND used_at IS NULL
+	`, userID.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO password_reset_tokens (token_hash, user_id, created_at, expires_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP, ?)
+	`, tokenHash, userID.String(), expiresAt.UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ConsumePasswordResetToken marks a reset token used and returns the user
+// it belongs to. It returns uuid.Nil if the token is unknown, expired, or
+// already used.
+func (c Client) ConsumePasswordResetToken(tokenHash string) (uuid.UUID, error) {
+	query := `
+		UPDATE password_reset_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id
+	`
+	var userID string
+	err := c.db.QueryRow(query, tokenHash, time.Now().UTC()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, nil
+		}
+		return uuid.Nil, err
+	}
+	return uuid.Parse(userID)
+}
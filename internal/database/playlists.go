@@ -0,0 +1,219 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Playlist struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	UserID      uuid.UUID `json:"user_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	// Visibility is "public" for anyone to see or "private" for only its
+	// owner, like a video's
+	Visibility string `json:"visibility"`
+	// VideoCount counts the videos in the playlist, including any the
+	// viewer can't watch
+	VideoCount int `json:"video_count"`
+}
+
+const playlistColumns = `
+	id, created_at, updated_at, user_id, title, description, visibility,
+	(SELECT COUNT(*) FROM playlist_items WHERE playlist_id = playlists.id)`
+
+func scanPlaylist(row rowScanner) (Playlist, error) {
+	var playlist Playlist
+	err := row.Scan(
+		&playlist.ID,
+		&playlist.CreatedAt,
+		&playlist.UpdatedAt,
+		&playlist.UserID,
+		&playlist.Title,
+		&playlist.Description,
+		&playlist.Visibility,
+		&playlist.VideoCount,
+	)
+	return playlist, err
+}
+
+func (c Client) CreatePlaylist(userID uuid.UUID, title, description, visibility string) (Playlist, error) {
+	id := uuid.New()
+	_, err := c.db.Exec(`
+	INSERT INTO playlists (id, created_at, updated_at, user_id, title, description, visibility)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`, id.String(), userID.String(), title, description, visibility)
+	if err != nil {
+		return Playlist{}, err
+	}
+	return c.GetPlaylist(id)
+}
+
+func (c Client) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	playlist, err := scanPlaylist(c.db.QueryRow(`
+	SELECT`+playlistColumns+`
+	FROM playlists
+	WHERE id = ?
+	`, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Playlist{}, nil
+		}
+		return Playlist{}, err
+	}
+	return playlist, nil
+}
+
+// GetPlaylistsForUser returns a user's playlists, newest first.
+func (c Client) GetPlaylistsForUser(userID uuid.UUID) ([]Playlist, error) {
+	rows, err := c.db.Query(`
+	SELECT`+playlistColumns+`
+	FROM playlists
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		playlist, err := scanPlaylist(rows)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists, rows.Err()
+}
+
+func (c Client) UpdatePlaylist(playlist Playlist) error {
+	_, err := c.db.Exec(`
+	UPDATE playlists
+	SET updated_at = CURRENT_TIMESTAMP, title = ?, description = ?, visibility = ?
+	WHERE id = ?
+	`, playlist.Title, playlist.Description, playlist.Visibility, playlist.ID.String())
+	return err
+}
+
+func (c Client) DeletePlaylist(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM playlist_items WHERE playlist_id = ?`, id.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM playlists WHERE id = ?`, id.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPlaylistVideos returns the videos in a playlist in order.
+func (c Client) GetPlaylistVideos(playlistID uuid.UUID) ([]Video, error) {
+	return c.queryVideos(`
+	SELECT`+videoColumns+`
+	FROM videos JOIN playlist_items ON playlist_items.video_id = videos.id
+	WHERE playlist_items.playlist_id = ? AND videos.deleted_at IS NULL
+	ORDER BY playlist_items.position
+	`, playlistID.String())
+}
+
+// GetPlaylistVideoIDs returns the IDs of the videos in a playlist in
+// order.
+func (c Client) GetPlaylistVideoIDs(playlistID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := c.db.Query(`
+	SELECT video_id FROM playlist_items
+	WHERE playlist_id = ?
+	ORDER BY position
+	`, playlistID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// AddPlaylistItem appends a video to the end of a playlist, reporting
+// false if it is already in it.
+func (c Client) AddPlaylistItem(playlistID, videoID uuid.UUID) (bool, error) {
+	result, err := c.db.Exec(`
+	INSERT OR IGNORE INTO playlist_items (playlist_id, video_id, position, added_at)
+	SELECT ?, ?, COALESCE(MAX(position), -1) + 1, CURRENT_TIMESTAMP
+	FROM playlist_items WHERE playlist_id = ?
+	`, playlistID.String(), videoID.String(), playlistID.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		_, err = c.db.Exec(`UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?`, playlistID.String())
+	}
+	return n > 0, err
+}
+
+// RemovePlaylistItem takes a video out of a playlist, reporting whether
+// it was in it.
+func (c Client) RemovePlaylistItem(playlistID, videoID uuid.UUID) (bool, error) {
+	result, err := c.db.Exec(`
+	DELETE FROM playlist_items WHERE playlist_id = ? AND video_id = ?
+	`, playlistID.String(), videoID.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		_, err = c.db.Exec(`UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?`, playlistID.String())
+	}
+	return n > 0, err
+}
+
+// ReorderPlaylist puts a playlist's videos in the order of videoIDs, which
+// should hold every video in it.
+func (c Client) ReorderPlaylist(playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, videoID := range videoIDs {
+		_, err = tx.Exec(`
+		UPDATE playlist_items SET position = ? WHERE playlist_id = ? AND video_id = ?
+		`, i, playlistID.String(), videoID.String())
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?`, playlistID.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
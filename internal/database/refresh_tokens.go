@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,12 +13,16 @@ type RefreshToken struct {
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	RevokedAt *time.Time `json:"revoked_at"`
+	// RotatedAt is set when the token was revoked by being exchanged for
+	// a new one, rather than by signing out
+	RotatedAt *time.Time `json:"rotated_at"`
 }
 
 type CreateRefreshTokenParams struct {
-	Token     string    `json:"token"`
-	UserID    uuid.UUID `json:"user_id"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token     string     `json:"token"`
+	UserID    uuid.UUID  `json:"user_id"`
+	SessionID *uuid.UUID `json:"session_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
 }
 
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
@@ -27,10 +32,11 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 			created_at,
 			updated_at,
 			user_id,
+			session_id,
 			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := c.db.Exec(query, params.Token, params.UserID.String(), sessionIDValue(params.SessionID), params.ExpiresAt)
 	if err != nil {
 		return RefreshToken{}, err
 	}
@@ -48,16 +54,77 @@ func (c Client) RevokeRefreshToken(token string) error {
 	return err
 }
 
+var (
+	ErrRefreshTokenRevoked = errors.New("refresh token has already been revoked")
+	ErrRefreshTokenRotated = errors.New("refresh token has already been rotated")
+)
+
+// RotateRefreshToken revokes oldToken and stores its replacement in a
+// single transaction. If oldToken was already rotated (for example by a
+// concurrent refresh), ErrRefreshTokenRotated is returned and nothing is
+// created; if it was revoked by signing out, ErrRefreshTokenRevoked.
+func (c Client) RotateRefreshToken(oldToken string, params CreateRefreshTokenParams) (RefreshToken, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, rotated_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE token = ? AND revoked_at IS NULL
+	`, oldToken)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if revoked != 1 {
+		var rotatedAt *time.Time
+		err := tx.QueryRow(`SELECT rotated_at FROM refresh_tokens WHERE token = ?`, oldToken).Scan(&rotatedAt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, err
+		}
+		if rotatedAt != nil {
+			return RefreshToken{}, ErrRefreshTokenRotated
+		}
+		return RefreshToken{}, ErrRefreshTokenRevoked
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO refresh_tokens (
+			token,
+			created_at,
+			updated_at,
+			user_id,
+			session_id,
+			expires_at
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`, params.Token, params.UserID.String(), sessionIDValue(params.SessionID), params.ExpiresAt)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return RefreshToken{}, err
+	}
+	return c.GetRefreshToken(params.Token)
+}
+
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, created_at, updated_at, user_id, session_id, expires_at, revoked_at, rotated_at
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
 	var userID string
+	var sessionID sql.NullString
 	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &sessionID, &rt.ExpiresAt, &rt.RevokedAt, &rt.RotatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -69,6 +136,13 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	if err != nil {
 		return RefreshToken{}, err
 	}
+	if sessionID.Valid {
+		id, err := uuid.Parse(sessionID.String)
+		if err != nil {
+			return RefreshToken{}, err
+		}
+		rt.SessionID = &id
+	}
 
 	return rt, nil
 }
@@ -81,3 +155,12 @@ func (c Client) DeleteRefreshToken(token string) error {
 	_, err := c.db.Exec(query, token)
 	return err
 }
+
+// sessionIDValue stores session IDs as strings, like every other ID
+// column. Tokens issued before sessions were tracked have none.
+func sessionIDValue(id *uuid.UUID) any {
+	if id == nil {
+		return nil
+	}
+	return id.String()
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is one signed-in device. Access tokens carry its ID and every
+// refresh token issued to the device is tied to it, so revoking the
+// session signs the device out.
+type Session struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+const sessionColumns = `id, user_id, created_at, last_seen_at, expires_at, user_agent, ip_address, revoked_at`
+
+func (c Client) CreateSession(userID uuid.UUID, userAgent, ipAddress string, expiresAt time.Time) (Session, error) {
+	id := uuid.New()
+	query := `
+		INSERT INTO sessions
+			(id, user_id, created_at, last_seen_at, expires_at, user_agent, ip_address)
+		VALUES
+			(?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id.String(), userID.String(), expiresAt, userAgent, ipAddress)
+	if err != nil {
+		return Session{}, err
+	}
+	return c.GetSession(id)
+}
+
+// GetSession returns the session with id, or an empty Session if there is
+// none.
+func (c Client) GetSession(id uuid.UUID) (Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = ?`
+	session, err := scanSession(c.db.QueryRow(query, id.String()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, nil
+	}
+	return session, err
+}
+
+// GetActiveSessions returns a user's sessions that are neither revoked nor
+// expired, most recently used first.
+func (c Client) GetActiveSessions(userID uuid.UUID) ([]Session, error) {
+	query := `SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_seen_at DESC
+	`
+	rows, err := c.db.Query(query, userID.String(), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchSession records that a session was just used and extends it to
+// expiresAt.
+func (c Client) TouchSession(id uuid.UUID, expiresAt time.Time) error {
+	query := `
+		UPDATE sessions
+		SET last_seen_at = CURRENT_TIMESTAMP, expires_at = ?
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, expiresAt, id.String())
+	return err
+}
+
+// RevokeSession ends a session along with its refresh tokens.
+func (c Client) RevokeSession(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE sessions
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND revoked_at IS NULL
+	`, id.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE session_id = ? AND revoked_at IS NULL
+	`, id.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeAllSessionsForUser signs a user out everywhere, including refresh
+// tokens issued before sessions were tracked. It returns the IDs of the
+// sessions it revoked.
+func (c Client) RevokeAllSessionsForUser(userID uuid.UUID) ([]uuid.UUID, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL
+	`, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, parsed)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE sessions
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	return ids, tx.Commit()
+}
+
+// GetSessionsRevokedSince returns the IDs of sessions revoked after since,
+// used to rebuild the access token denylist on startup.
+func (c Client) GetSessionsRevokedSince(since time.Time) ([]uuid.UUID, error) {
+	rows, err := c.db.Query(`
+		SELECT id FROM sessions
+		WHERE revoked_at IS NOT NULL AND revoked_at > ?
+	`, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, parsed)
+	}
+	return ids, rows.Err()
+}
+
+func scanSession(row rowScanner) (Session, error) {
+	var session Session
+	var id, userID string
+	err := row.Scan(&id, &userID, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt,
+		&session.UserAgent, &session.IPAddress, &session.RevokedAt)
+	if err != nil {
+		return Session{}, err
+	}
+	session.ID, err = uuid.Parse(id)
+	if err != nil {
+		return Session{}, err
+	}
+	session.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
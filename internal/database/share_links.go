@@ -0,0 +1,169 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareLink lets whoever holds it watch one video until it expires or has
+// been opened MaxViews times, whatever the video's visibility.
+type ShareLink struct {
+	ID        uuid.UUID `json:"id"`
+	VideoID   uuid.UUID `json:"video_id"`
+	CreatedBy uuid.UUID `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// MaxViews is how many times the link can be opened, nil for no
+	// limit
+	MaxViews  *int       `json:"max_views"`
+	ViewCount int        `json:"view_count"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+const shareLinkColumns = `
+		id,
+		video_id,
+		created_by,
+		created_at,
+		expires_at,
+		max_views,
+		view_count,
+		revoked_at`
+
+func scanShareLink(row rowScanner) (ShareLink, error) {
+	var link ShareLink
+	var id, videoID, createdBy string
+	err := row.Scan(
+		&id,
+		&videoID,
+		&createdBy,
+		&link.CreatedAt,
+		&link.ExpiresAt,
+		&link.MaxViews,
+		&link.ViewCount,
+		&link.RevokedAt,
+	)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	link.ID, err = uuid.Parse(id)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	link.VideoID, err = uuid.Parse(videoID)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	link.CreatedBy, err = uuid.Parse(createdBy)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	return link, nil
+}
+
+func (c Client) CreateShareLink(videoID, createdBy uuid.UUID, tokenHash string, expiresAt time.Time, maxViews *int) (ShareLink, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO share_links (
+		id,
+		video_id,
+		created_by,
+		token_hash,
+		created_at,
+		expires_at,
+		max_views
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id.String(), videoID.String(), createdBy.String(), tokenHash, time.Now().UTC(), expiresAt.UTC(), maxViews)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	return c.GetShareLink(id)
+}
+
+func (c Client) GetShareLink(id uuid.UUID) (ShareLink, error) {
+	query := `
+	SELECT` + shareLinkColumns + `
+	FROM share_links
+	WHERE id = ?
+	`
+	link, err := scanShareLink(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ShareLink{}, nil
+		}
+		return ShareLink{}, err
+	}
+	return link, nil
+}
+
+// GetShareLinks lists a video's share links, newest first.
+func (c Client) GetShareLinks(videoID uuid.UUID) ([]ShareLink, error) {
+	query := `
+	SELECT` + shareLinkColumns + `
+	FROM share_links
+	WHERE video_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// RedeemShareLink counts a view of an unexpired, unrevoked link that has
+// views left and returns it. The update is atomic so concurrent views
+// can't go over the limit. It returns an empty ShareLink if the link
+// can't be used.
+func (c Client) RedeemShareLink(tokenHash string) (ShareLink, error) {
+	query := `
+	UPDATE share_links
+	SET view_count = view_count + 1
+	WHERE token_hash = ? AND revoked_at IS NULL AND expires_at > ?
+		AND (max_views IS NULL OR view_count < max_views)
+	RETURNING` + shareLinkColumns
+	link, err := scanShareLink(c.db.QueryRow(query, tokenHash, time.Now().UTC()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ShareLink{}, nil
+		}
+		return ShareLink{}, err
+	}
+	return link, nil
+}
+
+// ReleaseShareLink gives back the view counted by RedeemShareLink when the
+// video couldn't be played after all.
+func (c Client) ReleaseShareLink(id uuid.UUID) error {
+	query := `
+	UPDATE share_links
+	SET view_count = view_count - 1
+	WHERE id = ? AND view_count > 0
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
+func (c Client) RevokeShareLink(id uuid.UUID) error {
+	query := `
+	UPDATE share_links
+	SET revoked_at = ?
+	WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), id.String())
+	return err
+}
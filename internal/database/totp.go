@@ -0,0 +1,132 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// UserTOTP is a user's authenticator enrollment. Secret is set as soon as
+// enrollment starts, but the second factor is only enforced once Enabled.
+type UserTOTP struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+func (c Client) GetUserTOTP(userID uuid.UUID) (UserTOTP, error) {
+	query := `
+		SELECT totp_secret, totp_enabled, totp_last_step
+		FROM users
+		WHERE id = ?
+	`
+	var totp UserTOTP
+	var secret sql.NullString
+	err := c.db.QueryRow(query, userID.String()).Scan(&secret, &totp.Enabled, &totp.LastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserTOTP{}, nil
+		}
+		return UserTOTP{}, err
+	}
+	totp.Secret = secret.String
+	return totp, nil
+}
+
+// StartTOTPEnrollment stores a new, not yet enabled secret.
+func (c Client) StartTOTPEnrollment(userID uuid.UUID, secret string) error {
+	query := `
+		UPDATE users
+		SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, secret, userID.String())
+	return err
+}
+
+// EnableTOTP turns on the second factor and replaces the user's recovery
+// codes in one transaction.
+func (c Client) EnableTOTP(userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET totp_enabled = 1, totp_last_step = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, step, userID.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec(`
+			INSERT INTO recovery_codes (code_hash, user_id, created_at)
+			VALUES (?, ?, CURRENT_TIMESTAMP)
+		`, hash, userID.String())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c Client) DisableTOTP(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, userID.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordTOTPStep stores the time step of an accepted code. It returns false
+// if an equal or later step was recorded concurrently, meaning the code was
+// replayed.
+func (c Client) RecordTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	res, err := c.db.Exec(`
+		UPDATE users
+		SET totp_last_step = ?
+		WHERE id = ? AND totp_last_step < ?
+	`, step, userID.String(), step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode consumes a recovery code, returning false if it doesn't
+// exist or was already used.
+func (c Client) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	res, err := c.db.Exec(`
+		UPDATE recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, userID.String(), codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
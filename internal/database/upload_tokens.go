@@ -0,0 +1,163 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadToken lets whoever holds it upload one file to one video on behalf
+// of the user who created it.
+type UploadToken struct {
+	ID        uuid.UUID  `json:"id"`
+	VideoID   uuid.UUID  `json:"video_id"`
+	CreatedBy uuid.UUID  `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+const uploadTokenColumns = `
+		id,
+		video_id,
+		created_by,
+		created_at,
+		expires_at,
+		used_at,
+		revoked_at`
+
+func scanUploadToken(row rowScanner) (UploadToken, error) {
+	var token UploadToken
+	var id, videoID, createdBy string
+	err := row.Scan(
+		&id,
+		&videoID,
+		&createdBy,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+	)
+	if err != nil {
+		return UploadToken{}, err
+	}
+	token.ID, err = uuid.Parse(id)
+	if err != nil {
+		return UploadToken{}, err
+	}
+	token.VideoID, err = uuid.Parse(videoID)
+	if err != nil {
+		return UploadToken{}, err
+	}
+	token.CreatedBy, err = uuid.Parse(createdBy)
+	if err != nil {
+		return UploadToken{}, err
+	}
+	return token, nil
+}
+
+func (c Client) CreateUploadToken(videoID, createdBy uuid.UUID, tokenHash string, expiresAt time.Time) (UploadToken, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO upload_tokens (
+		id,
+		video_id,
+		created_by,
+		token_hash,
+		created_at,
+		expires_at
+	) VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id.String(), videoID.String(), createdBy.String(), tokenHash, time.Now().UTC(), expiresAt.UTC())
+	if err != nil {
+		return UploadToken{}, err
+	}
+	return c.GetUploadToken(id)
+}
+
+func (c Client) GetUploadToken(id uuid.UUID) (UploadToken, error) {
+	query := `
+	SELECT` + uploadTokenColumns + `
+	FROM upload_tokens
+	WHERE id = ?
+	`
+	token, err := scanUploadToken(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UploadToken{}, nil
+		}
+		return UploadToken{}, err
+	}
+	return token, nil
+}
+
+// GetUploadTokens lists a video's upload tokens, newest first.
+func (c Client) GetUploadTokens(videoID uuid.UUID) ([]UploadToken, error) {
+	query := `
+	SELECT` + uploadTokenColumns + `
+	FROM upload_tokens
+	WHERE video_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []UploadToken{}
+	for rows.Next() {
+		token, err := scanUploadToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// RedeemUploadToken marks an unused, unexpired, unrevoked token for
+// videoID as used and returns it. The update is atomic so a token can't be
+// used twice concurrently. It returns an empty UploadToken if the token
+// can't be used.
+func (c Client) RedeemUploadToken(tokenHash string, videoID uuid.UUID) (UploadToken, error) {
+	query := `
+	UPDATE upload_tokens
+	SET used_at = ?
+	WHERE token_hash = ? AND video_id = ? AND used_at IS NULL AND revoked_at IS NULL AND expires_at > ?
+	RETURNING` + uploadTokenColumns
+	now := time.Now().UTC()
+	token, err := scanUploadToken(c.db.QueryRow(query, now, tokenHash, videoID.String(), now))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UploadToken{}, nil
+		}
+		return UploadToken{}, err
+	}
+	return token, nil
+}
+
+// ReleaseUploadToken makes a redeemed token usable again after the upload
+// it was redeemed for failed.
+func (c Client) ReleaseUploadToken(id uuid.UUID) error {
+	query := `
+	UPDATE upload_tokens
+	SET used_at = NULL
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
+func (c Client) RevokeUploadToken(id uuid.UUID) error {
+	query := `
+	UPDATE upload_tokens
+	SET revoked_at = ?
+	WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), id.String())
+	return err
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserIdentity links a user to an account at an external identity provider.
+type UserIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c Client) GetUserIdentity(provider, subject string) (UserIdentity, error) {
+	query := `
+		SELECT provider, subject, user_id, email, created_at
+		FROM user_identities
+		WHERE provider = ? AND subject = ?
+	`
+	var identity UserIdentity
+	var userID string
+	err := c.db.QueryRow(query, provider, subject).Scan(&identity.Provider, &identity.Subject, &userID, &identity.Email, &identity.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserIdentity{}, nil
+		}
+		return UserIdentity{}, err
+	}
+	identity.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return UserIdentity{}, err
+	}
+	return identity, nil
+}
+
+func (c Client) CreateUserIdentity(identity UserIdentity) error {
+	query := `
+		INSERT INTO user_identities
+			(provider, subject, user_id, email, created_at)
+		VALUES
+			(?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, identity.Provider, identity.Subject, identity.UserID.String(), identity.Email)
+	return err
+}
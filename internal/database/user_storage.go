@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// UserStorageUsage is how much video a user is storing.
+type UserStorageUsage struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Email      *string   `json:"email"`
+	UsedBytes  int64     `json:"used_bytes"`
+	VideoCount int       `json:"video_count"`
+}
+
+// StorageUsageRollup totals the storage used across all users.
+type StorageUsageRollup struct {
+	UsedBytes  int64 `json:"used_bytes"`
+	VideoCount int   `json:"video_count"`
+	UserCount  int   `json:"user_count"`
+}
+
+// GetUserStorageUsage returns the bytes of video stored for a user's
+// videos and how many videos that is.
+func (c Client) GetUserStorageUsage(userID uuid.UUID) (int64, int, error) {
+	query := `
+	SELECT used_bytes, video_count
+	FROM user_storage
+	WHERE user_id = ?
+	`
+	var used int64
+	var count int
+	err := c.db.QueryRow(query, userID).Scan(&used, &count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, nil
+	}
+	return used, count, err
+}
+
+// GetStorageUsageRollup returns the storage used across all users, along
+// with the limit users using the most, largest first.
+func (c Client) GetStorageUsageRollup(limit int) (StorageUsageRollup, []UserStorageUsage, error) {
+	var rollup StorageUsageRollup
+	err := c.db.QueryRow(`
+	SELECT COALESCE(SUM(used_bytes), 0), COALESCE(SUM(video_count), 0), COUNT(*)
+	FROM user_storage
+	`).Scan(&rollup.UsedBytes, &rollup.VideoCount, &rollup.UserCount)
+	if err != nil {
+		return StorageUsageRollup{}, nil, err
+	}
+
+	// Guest uploads are accounted to the nil user, who has no email
+	rows, err := c.db.Query(`
+	SELECT s.user_id, u.email, s.used_bytes, s.video_count
+	FROM user_storage s
+	LEFT JOIN users u ON u.id = s.user_id
+	ORDER BY s.used_bytes DESC, s.user_id
+	LIMIT ?
+	`, limit)
+	if err != nil {
+		return StorageUsageRollup{}, nil, err
+	}
+	defer rows.Close()
+
+	users := []UserStorageUsage{}
+	for rows.Next() {
+		var usage UserStorageUsage
+		if err := rows.Scan(&usage.UserID, &usage.Email, &usage.UsedBytes, &usage.VideoCount); err != nil {
+			return StorageUsageRollup{}, nil, err
+		}
+		users = append(users, usage)
+	}
+	return rollup, users, rows.Err()
+}
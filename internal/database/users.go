@@ -3,6 +3,7 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,13 +19,15 @@ type User struct {
 type CreateUserParams struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Role     string `json:"role"`
 }
 
 func (c Client) GetUsers() ([]User, error) {
 	query := `
 		SELECT
 			id,
-			email
+			email,
+			role
 		FROM users
 	`
 
@@ -38,7 +41,7 @@ func (c Client) GetUsers() ([]User, error) {
 	for rows.Next() {
 		var user User
 		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
+		if err := rows.Scan(&id, &user.Email, &user.Role); err != nil {
 			return nil, err
 		}
 		user.ID, err = uuid.Parse(id)
@@ -53,13 +56,13 @@ func (c Client) GetUsers() ([]User, error) {
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, role
 		FROM users
 		WHERE email = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.Role)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
@@ -75,7 +78,7 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT u.id, u.email, u.created_at, u.updated_at, u.password, u.role
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
 		WHERE rt.token = ?
@@ -83,7 +86,7 @@ func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 
 	var user User
 	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password, &user.Role)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -103,11 +106,14 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 	query := `
 		INSERT INTO users
-		    (id, created_at, updated_at, email, password)
+		    (id, created_at, updated_at, email, password, role)
 		VALUES
-		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id.String(), params.Email, params.Password)
+	if params.Role == "" {
+		params.Role = defaultRole
+	}
+	_, err := c.db.Exec(query, id.String(), params.Email, params.Password, params.Role)
 	if err != nil {
 		return nil, err
 	}
@@ -117,13 +123,13 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, role
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.Role)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -137,11 +143,154 @@ func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	return &user, nil
 }
 
-func (c Client) DeleteUser(id uuid.UUID) error {
+func (c Client) UpdateUserPassword(id uuid.UUID, passwordHash string) error {
+	query := `
+		UPDATE users
+		SET password = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, passwordHash, id.String())
+	return err
+}
+
+// ErrLastUserWithRole is returned when changing a user's role would leave
+// nobody with a role that must be kept.
+var ErrLastUserWithRole = errors.New("user is the last one with their role")
+
+// SetUserRole gives a user role, unless they are the last user with the
+// role keep, returning ErrLastUserWithRole then. The check and the change
+// are one statement, so two users can't both give up keep at once. A user
+// that doesn't exist is reported the same way, so look them up first.
+func (c Client) SetUserRole(id uuid.UUID, role, keep string) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (role != ? OR ? = ? OR (SELECT COUNT(*) FROM users WHERE role = ?) > 1)
+	`
+	result, err := c.db.Exec(query, role, id.String(), keep, role, keep, keep)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLastUserWithRole
+	}
+	return nil
+}
+
+// GetUserStorageQuota returns a user's storage quota override in bytes, or
+// nil if the deployment default applies.
+func (c Client) GetUserStorageQuota(id uuid.UUID) (*int64, error) {
+	query := `
+		SELECT storage_quota
+		FROM users
+		WHERE id = ?
+	`
+	var quota *int64
+	err := c.db.QueryRow(query, id.String()).Scan(&quota)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return quota, nil
+}
+
+// SetUserStorageQuota overrides a user's storage quota; nil restores the
+// deployment default.
+func (c Client) SetUserStorageQuota(id uuid.UUID, quota *int64) error {
 	query := `
-		DELETE FROM users
+		UPDATE users
+		SET storage_quota = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id.String())
+	_, err := c.db.Exec(query, quota, id.String())
 	return err
 }
+
+// PromoteVerifiedUsersByEmail gives role to every existing user whose
+// email is in emails and was verified by an identity provider, returning
+// how many accounts changed. Password signups never verify their email,
+// so only accounts linked to an identity with the same address count.
+func (c Client) PromoteVerifiedUsersByEmail(emails []string, role string) (int64, error) {
+	var changed int64
+	for _, email := range emails {
+		res, err := c.db.Exec(`
+			UPDATE users
+			SET role = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE email = ? AND role != ? AND EXISTS (
+				SELECT 1 FROM user_identities
+				WHERE user_identities.user_id = users.id AND user_identities.email = users.email
+			)
+		`, role, email, role)
+		if err != nil {
+			return changed, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return changed, err
+		}
+		changed += n
+	}
+	return changed, nil
+}
+
+// DeleteUser removes a user along with everything that only makes sense
+// while the account exists: credentials, sessions, memberships, grants and
+// their personal videos. Organization videos they created stay with the
+// organization. Stored media is not touched; callers remove it first.
+func (c Client) DeleteUser(id uuid.UUID) error {
+	videoIDs, err := c.cachedVideoIDs(`user_id = ? AND org_id IS NULL`, id.String())
+	if err != nil {
+		return err
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	personalVideos := `SELECT id FROM videos WHERE user_id = ? AND org_id IS NULL`
+	statements := []string{
+		`DELETE FROM video_grants WHERE user_id = ? OR video_id IN (` + personalVideos + `)`,
+		`DELETE FROM guest_uploads WHERE video_id IN (` + personalVideos + `)`,
+		`DELETE FROM video_reports WHERE video_id IN (` + personalVideos + `)`,
+		`DELETE FROM upload_tokens WHERE created_by = ? OR video_id IN (` + personalVideos + `)`,
+		`DELETE FROM share_links WHERE created_by = ? OR video_id IN (` + personalVideos + `)`,
+		`DELETE FROM video_tags WHERE video_id IN (` + personalVideos + `)`,
+		`DELETE FROM caption_jobs WHERE video_id IN (` + personalVideos + `)`,
+		`DELETE FROM video_localizations WHERE video_id IN (` + personalVideos + `)`,
+		`DELETE FROM playlist_items WHERE video_id IN (` + personalVideos + `) OR playlist_id IN (SELECT id FROM playlists WHERE user_id = ?)`,
+		`DELETE FROM playlists WHERE user_id = ?`,
+		`UPDATE video_reports SET reporter_id = NULL WHERE reporter_id = ?`,
+		`UPDATE video_reports SET resolved_by = NULL WHERE resolved_by = ?`,
+		`DELETE FROM videos WHERE user_id = ? AND org_id IS NULL`,
+		`DELETE FROM org_memberships WHERE user_id = ?`,
+		`DELETE FROM api_key_usage WHERE api_key_id IN (SELECT id FROM api_keys WHERE user_id = ?)`,
+		`DELETE FROM api_keys WHERE user_id = ?`,
+		`DELETE FROM refresh_tokens WHERE user_id = ?`,
+		`DELETE FROM sessions WHERE user_id = ?`,
+		`DELETE FROM user_identities WHERE user_id = ?`,
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM password_reset_tokens WHERE user_id = ?`,
+		`DELETE FROM users WHERE id = ?`,
+	}
+	for _, stmt := range statements {
+		args := []any{}
+		for range strings.Count(stmt, "?") {
+			args = append(args, id.String())
+		}
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.invalidateVideos(videoIDs, id)
+	return nil
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDeleteUserRemovesPersonalVideoRows(t *testing.T) {
+	c := newTestClient(t)
+	user := newTestUser(t, c, "owner@example.com")
+	org, err := c.CreateOrganization("org", user.ID)
+	if err != nil {
+		t.Fatalf("CreateOrganization() error = %v", err)
+	}
+	personal := newTestVideo(t, c, user.ID, nil)
+	orgVideo := newTestVideo(t, c, user.ID, &org.ID)
+
+	for _, video := range []Video{personal, orgVideo} {
+		if _, err := c.QueueCaptionJob(video.ID, "en"); err != nil {
+			t.Fatalf("QueueCaptionJob() error = %v", err)
+		}
+		if err := c.SetVideoLocalization(video.ID, "fr", VideoLocalization{Title: "essai"}); err != nil {
+			t.Fatalf("SetVideoLocalization() error = %v", err)
+		}
+	}
+
+	if err := c.DeleteUser(user.ID); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+
+	tests := []struct {
+		table   string
+		videoID uuid.UUID
+		want    int
+	}{
+		{table: "videos", videoID: personal.ID, want: 0},
+		{table: "caption_jobs", videoID: personal.ID, want: 0},
+		{table: "video_localizations", videoID: personal.ID, want: 0},
+		// Organization videos outlive their uploader
+		{table: "caption_jobs", videoID: orgVideo.ID, want: 1},
+		{table: "video_localizations", videoID: orgVideo.ID, want: 1},
+	}
+	for _, tt := range tests {
+		column := "video_id"
+		if tt.table == "videos" {
+			column = "id"
+		}
+		var n int
+		if err := c.db.QueryRow(`SELECT COUNT(*) FROM `+tt.table+` WHERE `+column+` = ?`, tt.videoID.String()).Scan(&n); err != nil {
+			t.Fatalf("counting %s: %v", tt.table, err)
+		}
+		if n != tt.want {
+			t.Errorf("%s rows for video %s = %d, want %d", tt.table, tt.videoID, n, tt.want)
+		}
+	}
+}
+
+func TestPromoteVerifiedUsersByEmail(t *testing.T) {
+	tests := []struct {
+		name          string
+		identityEmail string
+		want          string
+	}{
+		{name: "password signup", want: "user"},
+		{name: "linked identity with the same email", identityEmail: "admin@example.com", want: "admin"},
+		{name: "linked identity with another email", identityEmail: "other@example.com", want: "user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			user := newTestUser(t, c, "admin@example.com")
+			if tt.identityEmail != "" {
+				err := c.CreateUserIdentity(UserIdentity{Provider: "google", Subject: "1", UserID: user.ID, Email: tt.identityEmail})
+				if err != nil {
+					t.Fatalf("CreateUserIdentity() error = %v", err)
+				}
+			}
+
+			if _, err := c.PromoteVerifiedUsersByEmail([]string{"admin@example.com"}, "admin"); err != nil {
+				t.Fatalf("PromoteVerifiedUsersByEmail() error = %v", err)
+			}
+			got, err := c.GetUser(user.ID)
+			if err != nil {
+				t.Fatalf("GetUser() error = %v", err)
+			}
+			if got.Role != tt.want {
+				t.Errorf("role = %q, want %q", got.Role, tt.want)
+			}
+		})
+	}
+}
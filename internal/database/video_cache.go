@@ -0,0 +1,142 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// videoCacheTimeout bounds each cache call, so a slow cache degrades to
+// reading the database rather than stalling requests.
+const videoCacheTimeout = 200 * time.Millisecond
+
+// VideoCache is a shared cache, such as Redis, that GetVideo and
+// GetVideos read through.
+type VideoCache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// videoCacheVersion changes whenever the video columns do, so entries
+// cached by an older build are never read back.
+var videoCacheVersion = func() string {
+	sum := sha256.Sum256([]byte(videoColumns))
+	return hex.EncodeToString(sum[:4])
+}()
+
+// WithVideoCache returns a copy of c that reads videos through cache.
+// Changes made through the client invalidate the entries they affect;
+// ttl bounds how long changes made any other way go unnoticed.
+func (c Client) WithVideoCache(cache VideoCache, ttl time.Duration) Client {
+	c.cache = cache
+	c.cacheTTL = ttl
+	return c
+}
+
+func videoCacheKey(id uuid.UUID) string {
+	return "tubely:video:" + videoCacheVersion + ":" + id.String()
+}
+
+func videoListCacheKey(userID uuid.UUID) string {
+	return "tubely:videos:" + videoCacheVersion + ":" + userID.String()
+}
+
+// cacheGet decodes the entry at key into dst. Cache errors are logged and
+// treated as misses.
+func (c Client) cacheGet(key string, dst any) bool {
+	if c.cache == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), videoCacheTimeout)
+	defer cancel()
+	data, ok, err := c.cache.Get(ctx, key)
+	if err != nil {
+		log.Printf("Video cache: couldn't read %s: %v", key, err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	// Gob keeps the fields JSON leaves out
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(dst); err != nil {
+		log.Printf("Video cache: couldn't decode %s: %v", key, err)
+		return false
+	}
+	return true
+}
+
+func (c Client) cacheSet(key string, value any) {
+	if c.cache == nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		log.Printf("Video cache: couldn't encode %s: %v", key, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), videoCacheTimeout)
+	defer cancel()
+	if err := c.cache.Set(ctx, key, buf.Bytes(), c.cacheTTL); err != nil {
+		log.Printf("Video cache: couldn't write %s: %v", key, err)
+	}
+}
+
+// videoOwners returns the owners of the videos with ids that still exist.
+// Writes that may change or remove a video's owner look them up first,
+// so the old owner's list is invalidated too.
+func (c Client) videoOwners(ids ...uuid.UUID) []uuid.UUID {
+	if c.cache == nil || len(ids) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	rows, err := c.db.Query(`SELECT DISTINCT user_id FROM videos WHERE id IN (`+strings.Join(placeholders, ", ")+`)`, args...)
+	if err != nil {
+		log.Printf("Video cache: couldn't look up video owners: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	owners := []uuid.UUID{}
+	for rows.Next() {
+		var owner uuid.UUID
+		if err := rows.Scan(&owner); err != nil {
+			log.Printf("Video cache: couldn't look up video owners: %v", err)
+			return owners
+		}
+		owners = append(owners, owner)
+	}
+	return owners
+}
+
+// invalidateVideos drops the cached videos with ids, along with the
+// video lists of their current owners and of userIDs.
+func (c Client) invalidateVideos(ids []uuid.UUID, userIDs ...uuid.UUID) {
+	if c.cache == nil {
+		return
+	}
+	keys := []string{}
+	for _, id := range ids {
+		keys = append(keys, videoCacheKey(id))
+	}
+	for _, userID := range append(userIDs, c.videoOwners(ids...)...) {
+		keys = append(keys, videoListCacheKey(userID))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), videoCacheTimeout)
+	defer cancel()
+	if err := c.cache.Delete(ctx, keys...); err != nil {
+		log.Printf("Video cache: couldn't invalidate %d entries: %v", len(keys), err)
+	}
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrCaptionExists is returned when generated captions would replace
+// ones uploaded by hand.
+var ErrCaptionExists = errors.New("video already has captions in this language")
+
+// VideoCaption is a WebVTT caption file for a video in one language.
+type VideoCaption struct {
+	ID        uuid.UUID `json:"id"`
+	VideoID   uuid.UUID `json:"video_id"`
+	Language  string    `json:"language"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// AutoGenerated marks a draft made by speech recognition, which is
+	// replaced when its owner uploads an edited copy.
+	AutoGenerated bool    `json:"auto_generated"`
+	Provider      string  `json:"-"`
+	Bucket        *string `json:"-"`
+	Key           string  `json:"-"`
+}
+
+const videoCaptionColumns = `
+		id,
+		video_id,
+		language,
+		size,
+		created_at,
+		updated_at,
+		auto_generated,
+		provider,
+		bucket,
+		file_key`
+
+func scanVideoCaption(row rowScanner) (VideoCaption, error) {
+	var caption VideoCaption
+	err := row.Scan(
+		&caption.ID,
+		&caption.VideoID,
+		&caption.Language,
+		&caption.Size,
+		&caption.CreatedAt,
+		&caption.UpdatedAt,
+		&caption.AutoGenerated,
+		&caption.Provider,
+		&caption.Bucket,
+		&caption.Key,
+	)
+	return caption, err
+}
+
+func queryVideoCaptions(db queryer, query string, args ...any) ([]VideoCaption, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	captions := []VideoCaption{}
+	for rows.Next() {
+		caption, err := scanVideoCaption(rows)
+		if err != nil {
+			return nil, err
+		}
+		captions = append(captions, caption)
+	}
+	return captions, rows.Err()
+}
+
+// SetVideoCaption stores caption as its video's caption file for its
+// language, replacing any there was. It returns the replaced caption, or
+// nil if the language is new, so its file can be removed. Generated
+// captions never replace ones uploaded by hand; ErrCaptionExists is
+// returned instead.
+func (c Client) SetVideoCaption(caption VideoCaption) (*VideoCaption, error) {
+	owners := c.videoOwners(caption.VideoID)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous *VideoCaption
+	existing, err := scanVideoCaption(tx.QueryRow(`
+	SELECT`+videoCaptionColumns+`
+	FROM video_captions
+	WHERE video_id = ? AND language = ?
+	`, caption.VideoID.String(), caption.Language))
+	if err == nil {
+		previous = &existing
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if previous != nil && caption.AutoGenerated && !previous.AutoGenerated {
+		return nil, ErrCaptionExists
+	}
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`
+	INSERT INTO video_captions (id, video_id, language, size, created_at, updated_at, auto_generated, provider, bucket, file_key)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(video_id, language) DO UPDATE SET
+		size = excluded.size,
+		updated_at = excluded.updated_at,
+		auto_generated = excluded.auto_generated,
+		provider = excluded.provider,
+		bucket = excluded.bucket,
+		file_key = excluded.file_key
+	`,
+		uuid.New().String(),
+		caption.VideoID.String(),
+		caption.Language,
+		caption.Size,
+		now,
+		now,
+		caption.AutoGenerated,
+		caption.Provider,
+		caption.Bucket,
+		caption.Key,
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE videos SET updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = ?`, caption.VideoID.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	c.invalidateVideos([]uuid.UUID{caption.VideoID}, owners...)
+	return previous, nil
+}
+
+// GetVideoCaptions returns a video's captions, ordered by language.
+func (c Client) GetVideoCaptions(videoID uuid.UUID) ([]VideoCaption, error) {
+	return queryVideoCaptions(c.db, `
+	SELECT`+videoCaptionColumns+`
+	FROM video_captions
+	WHERE video_id = ?
+	ORDER BY language
+	`, videoID.String())
+}
+
+// GetVideoCaption returns a video's caption in language, or an empty
+// VideoCaption if it has none.
+func (c Client) GetVideoCaption(videoID uuid.UUID, language string) (VideoCaption, error) {
+	caption, err := scanVideoCaption(c.db.QueryRow(`
+	SELECT`+videoCaptionColumns+`
+	FROM video_captions
+	WHERE video_id = ? AND language = ?
+	`, videoID.String(), language))
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoCaption{}, nil
+	}
+	return caption, err
+}
+
+// DeleteVideoCaption forgets a video's caption in language, returning it
+// so its file can be removed, or an empty VideoCaption if there was none.
+func (c Client) DeleteVideoCaption(videoID uuid.UUID, language string) (VideoCaption, error) {
+	caption, err := c.GetVideoCaption(videoID, language)
+	if err != nil || caption.ID == uuid.Nil {
+		return caption, err
+	}
+	owners := c.videoOwners(videoID)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return VideoCaption{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM video_captions WHERE id = ?`, caption.ID.String())
+	if err != nil {
+		return VideoCaption{}, err
+	}
+	_, err = tx.Exec(`UPDATE videos SET updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = ?`, videoID.String())
+	if err != nil {
+		return VideoCaption{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return VideoCaption{}, err
+	}
+	c.invalidateVideos([]uuid.UUID{videoID}, owners...)
+	return caption, nil
+}
+
+// DeleteVideoCaptions forgets all of a video's captions, returning them
+// so their files can be removed.
+func (c Client) DeleteVideoCaptions(videoID uuid.UUID) ([]VideoCaption, error) {
+	return queryVideoCaptions(c.db, `
+	DELETE FROM video_captions
+	WHERE video_id = ?
+	RETURNING`+videoCaptionColumns, videoID.String())
+}
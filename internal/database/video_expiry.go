@@ -0,0 +1,73 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GetVideosDueExpiryReminder returns videos expiring by cutoff whose
+// owners haven't been reminded yet, soonest first.
+func (c Client) GetVideosDueExpiryReminder(cutoff time.Time, limit int) ([]Video, error) {
+	return c.queryVideos(`
+	SELECT`+videoColumns+`
+	FROM videos
+	WHERE expires_at <= ? AND expiry_reminded_at IS NULL AND deleted_at IS NULL
+	ORDER BY expires_at
+	LIMIT ?
+	`, cutoff.UTC(), limit)
+}
+
+// SetVideoExpiryReminded records that a video's owner has been told it is
+// about to expire. Changing the expiry clears it again.
+func (c Client) SetVideoExpiryReminded(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE videos SET expiry_reminded_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+	return err
+}
+
+// GetExpiredVideos returns videos whose expiry has passed, oldest expiry
+// first.
+func (c Client) GetExpiredVideos(now time.Time, limit int) ([]Video, error) {
+	return c.queryVideos(`
+	SELECT`+videoColumns+`
+	FROM videos
+	WHERE expires_at <= ? AND deleted_at IS NULL
+	ORDER BY expires_at
+	LIMIT ?
+	`, now.UTC(), limit)
+}
+
+// SoftDeleteVideo hides a video as if it were deleted, but keeps its
+// title, owner and reports for the record. Its file and thumbnail are
+// forgotten, so they can be removed from storage afterwards, along with
+// everything granting access to it.
+func (c Client) SoftDeleteVideo(id uuid.UUID) error {
+	for _, table := range []string{"video_grants", "guest_uploads", "upload_tokens", "share_links", "video_tags", "playlist_items", "caption_jobs", "video_localizations"} {
+		if _, err := c.db.Exec(`DELETE FROM `+table+` WHERE video_id = ?`, id.String()); err != nil {
+			return err
+		}
+	}
+
+	query := `
+	UPDATE videos SET
+		deleted_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP,
+		status = 'deleted',
+		thumbnail_url = NULL,
+		thumbnail_key = NULL,
+		thumbnail_phash = NULL,
+		thumbnail_sha256 = NULL,
+		video_url = NULL,
+		video_key = NULL,
+		quarantine_key = NULL,
+		video_size = NULL,
+		replica_regions = NULL
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	owners := c.videoOwners(id)
+	_, err := c.db.Exec(query, id)
+	if err == nil {
+		c.invalidateVideos([]uuid.UUID{id}, owners...)
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetExpiredVideos(t *testing.T) {
+	ahead := time.FixedZone("UTC+5", 5*60*60)
+	behind := time.FixedZone("UTC-8", -8*60*60)
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		now       time.Time
+		legacy    bool // stored with its offset, before times were kept in UTC
+		want      bool
+	}{
+		{name: "expired in a zone ahead", expiresAt: now.Add(-time.Hour).In(ahead), now: now, want: true},
+		{name: "not expired in a zone ahead", expiresAt: now.Add(time.Hour).In(ahead), now: now},
+		{name: "expired in a zone behind", expiresAt: now.Add(-time.Hour).In(behind), now: now, want: true},
+		{name: "not expired in a zone behind", expiresAt: now.Add(time.Hour).In(behind), now: now},
+		{name: "local now against a UTC expiry", expiresAt: now.Add(time.Hour), now: now.In(ahead)},
+		{name: "legacy expired", expiresAt: now.Add(-time.Hour).In(ahead), now: now, legacy: true, want: true},
+		{name: "legacy not expired", expiresAt: now.Add(time.Hour).In(behind), now: now, legacy: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			user := newTestUser(t, c, "owner@example.com")
+			video := newTestVideo(t, c, user.ID, nil)
+			if tt.legacy {
+				stored := tt.expiresAt.Format("2006-01-02 15:04:05.999999999-07:00")
+				if _, err := c.db.Exec(`UPDATE videos SET expires_at = ? WHERE id = ?`, stored, video.ID); err != nil {
+					t.Fatalf("storing legacy expiry: %v", err)
+				}
+				if err := c.autoMigrate(); err != nil {
+					t.Fatalf("autoMigrate() error = %v", err)
+				}
+			} else {
+				video.ExpiresAt = &tt.expiresAt
+				if err := c.UpdateVideo(video); err != nil {
+					t.Fatalf("UpdateVideo() error = %v", err)
+				}
+			}
+
+			videos, err := c.GetExpiredVideos(tt.now, 10)
+			if err != nil {
+				t.Fatalf("GetExpiredVideos() error = %v", err)
+			}
+			if got := len(videos) == 1; got != tt.want {
+				t.Errorf("GetExpiredVideos() returned the video = %v, want %v", got, tt.want)
+			}
+			due, err := c.GetVideosDueExpiryReminder(tt.now, 10)
+			if err != nil {
+				t.Fatalf("GetVideosDueExpiryReminder() error = %v", err)
+			}
+			if got := len(due) == 1; got != tt.want {
+				t.Errorf("GetVideosDueExpiryReminder() returned the video = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
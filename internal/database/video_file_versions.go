@@ -0,0 +1,277 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoFileVersion is a file a video used to have before it was replaced,
+// kept so it can be restored. It carries the details of the file that
+// were recorded when it was uploaded.
+type VideoFileVersion struct {
+	ID               uuid.UUID  `json:"id"`
+	VideoID          uuid.UUID  `json:"video_id"`
+	ReplacedAt       time.Time  `json:"replaced_at"`
+	StoredAt         *time.Time `json:"stored_at"`
+	AspectClass      *string    `json:"aspect_class"`
+	DurationSeconds  *float64   `json:"duration_seconds"`
+	VideoSize        *int64     `json:"video_size"`
+	VideoSHA256      *string    `json:"video_sha256"`
+	StorageClass     *string    `json:"storage_class"`
+	ModerationStatus *string    `json:"moderation_status"`
+	ModerationScore  *float64   `json:"moderation_score"`
+	ScanVerdict      *string    `json:"scan_verdict"`
+	VideoURL         string     `json:"-"`
+	VideoProvider    *string    `json:"-"`
+	VideoBucket      *string    `json:"-"`
+	VideoKey         string     `json:"-"`
+	MediaInfo
+}
+
+const videoFileVersionColumns = `
+		id,
+		video_id,
+		replaced_at,
+		stored_at,
+		aspect_class,
+		duration_seconds,
+		video_size,
+		video_sha256,
+		storage_class,
+		moderation_status,
+		moderation_score,
+		scan_verdict,
+		video_url,
+		video_provider,
+		video_bucket,
+		video_key,
+		video_width,
+		video_height,
+		video_codec,
+		audio_codec,
+		video_bitrate,
+		frame_rate`
+
+func scanVideoFileVersion(row rowScanner) (VideoFileVersion, error) {
+	var version VideoFileVersion
+	err := row.Scan(
+		&version.ID,
+		&version.VideoID,
+		&version.ReplacedAt,
+		&version.StoredAt,
+		&version.AspectClass,
+		&version.DurationSeconds,
+		&version.VideoSize,
+		&version.VideoSHA256,
+		&version.StorageClass,
+		&version.ModerationStatus,
+		&version.ModerationScore,
+		&version.ScanVerdict,
+		&version.VideoURL,
+		&version.VideoProvider,
+		&version.VideoBucket,
+		&version.VideoKey,
+		&version.Width,
+		&version.Height,
+		&version.VideoCodec,
+		&version.AudioCodec,
+		&version.Bitrate,
+		&version.FrameRate,
+	)
+	return version, err
+}
+
+func queryVideoFileVersions(db queryer, query string, args ...any) ([]VideoFileVersion, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []VideoFileVersion{}
+	for rows.Next() {
+		version, err := scanVideoFileVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// insertVideoFileVersion records video's current file as a version. The
+// video must have a playable file.
+func insertVideoFileVersion(db execer, video Video) error {
+	_, err := db.Exec(`
+	INSERT INTO video_file_versions (
+		id,
+		video_id,
+		replaced_at,
+		stored_at,
+		aspect_class,
+		duration_seconds,
+		video_size,
+		video_sha256,
+		storage_class,
+		moderation_status,
+		moderation_score,
+		scan_verdict,
+		video_url,
+		video_provider,
+		video_bucket,
+		video_key,
+		video_width,
+		video_height,
+		video_codec,
+		audio_codec,
+		video_bitrate,
+		frame_rate
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		uuid.New().String(),
+		video.ID.String(),
+		time.Now().UTC(),
+		video.StoredAt,
+		video.AspectClass,
+		video.DurationSeconds,
+		video.VideoSize,
+		video.VideoSHA256,
+		video.StorageClass,
+		video.ModerationStatus,
+		video.ModerationScore,
+		video.ScanVerdict,
+		*video.VideoURL,
+		video.VideoProvider,
+		video.VideoBucket,
+		*video.VideoKey,
+		video.Width,
+		video.Height,
+		video.VideoCodec,
+		video.AudioCodec,
+		video.Bitrate,
+		video.FrameRate,
+	)
+	return err
+}
+
+// AddVideoFileVersion records the file previous had before it was
+// replaced, then drops the oldest versions of the video beyond the
+// newest keep. It returns the versions dropped, whose files the caller
+// should remove from storage.
+func (c Client) AddVideoFileVersion(previous Video, keep int) ([]VideoFileVersion, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := insertVideoFileVersion(tx, previous); err != nil {
+		return nil, err
+	}
+	dropped, err := queryVideoFileVersions(tx, `
+	DELETE FROM video_file_versions
+	WHERE video_id = ? AND id NOT IN (
+		SELECT id FROM video_file_versions
+		WHERE video_id = ?
+		ORDER BY replaced_at DESC
+		LIMIT ?
+	)
+	RETURNING`+videoFileVersionColumns, previous.ID.String(), previous.ID.String(), keep)
+	if err != nil {
+		return nil, err
+	}
+	return dropped, tx.Commit()
+}
+
+// GetVideoFileVersions lists a video's previous files, most recently
+// replaced first.
+func (c Client) GetVideoFileVersions(videoID uuid.UUID) ([]VideoFileVersion, error) {
+	return queryVideoFileVersions(c.db, `
+	SELECT`+videoFileVersionColumns+`
+	FROM video_file_versions
+	WHERE video_id = ?
+	ORDER BY replaced_at DESC
+	`, videoID.String())
+}
+
+func (c Client) GetVideoFileVersion(id uuid.UUID) (VideoFileVersion, error) {
+	version, err := scanVideoFileVersion(c.db.QueryRow(`
+	SELECT`+videoFileVersionColumns+`
+	FROM video_file_versions
+	WHERE id = ?
+	`, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoFileVersion{}, nil
+		}
+		return VideoFileVersion{}, err
+	}
+	return version, nil
+}
+
+// RestoreVideoFileVersion saves video, which should hold the file of the
+// version with versionID, in one transaction with swapping the versions
+// around: the version is removed, and current's file takes its place if
+// it is playable. current is the video as it was loaded; if it has
+// changed since, nothing is saved and ErrVideoModified is returned.
+func (c Client) RestoreVideoFileVersion(versionID uuid.UUID, current, video Video) error {
+	owners := c.videoOwners(video.ID)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM video_file_versions WHERE id = ? AND video_id = ?`, versionID.String(), video.ID.String())
+	if err != nil {
+		return err
+	}
+	if current.VideoURL != nil && current.VideoKey != nil && current.QuarantineKey == nil {
+		if err := insertVideoFileVersion(tx, current); err != nil {
+			return err
+		}
+	}
+	if err := updateVideo(tx, video, current.Version); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.invalidateVideos([]uuid.UUID{video.ID}, owners...)
+	return nil
+}
+
+// DeleteVideoFileVersions forgets every previous file of a video and
+// returns them, so their files can be removed from storage.
+func (c Client) DeleteVideoFileVersions(videoID uuid.UUID) ([]VideoFileVersion, error) {
+	return queryVideoFileVersions(c.db, `
+	DELETE FROM video_file_versions
+	WHERE video_id = ?
+	RETURNING`+videoFileVersionColumns, videoID.String())
+}
+
+// GetVideoFileVersionRefs returns the file references of every kept
+// version, under the ID of the video each belongs to.
+func (c Client) GetVideoFileVersionRefs() ([]VideoFileRef, error) {
+	rows, err := c.db.Query(`
+	SELECT video_id, video_provider, video_bucket, video_key, video_size
+	FROM video_file_versions
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []VideoFileRef{}
+	for rows.Next() {
+		var ref VideoFileRef
+		if err := rows.Scan(&ref.ID, &ref.VideoProvider, &ref.VideoBucket, &ref.VideoKey, &ref.VideoSize); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	GrantAccessView = "view"
+	GrantAccessEdit = "edit"
+)
+
+// VideoGrant gives a user other than the owner access to a video.
+type VideoGrant struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	Access    string    `json:"access"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetVideoGrant returns the grant userID holds on videoID, or an empty
+// VideoGrant if there is none.
+func (c Client) GetVideoGrant(videoID, userID uuid.UUID) (VideoGrant, error) {
+	query := `
+		SELECT g.video_id, g.user_id, u.email, g.access, g.created_at
+		FROM video_grants g
+		JOIN users u ON u.id = g.user_id
+		WHERE g.video_id = ? AND g.user_id = ?
+	`
+	grant, err := scanVideoGrant(c.db.QueryRow(query, videoID.String(), userID.String()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoGrant{}, nil
+	}
+	return grant, err
+}
+
+func (c Client) GetVideoGrants(videoID uuid.UUID) ([]VideoGrant, error) {
+	query := `
+		SELECT g.video_id, g.user_id, u.email, g.access, g.created_at
+		FROM video_grants g
+		JOIN users u ON u.id = g.user_id
+		WHERE g.video_id = ?
+		ORDER BY g.created_at
+	`
+	rows, err := c.db.Query(query, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []VideoGrant{}
+	for rows.Next() {
+		grant, err := scanVideoGrant(rows)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
+
+// PutVideoGrant creates a grant or changes the access of an existing one.
+func (c Client) PutVideoGrant(videoID, userID uuid.UUID, access string) error {
+	query := `
+		INSERT INTO video_grants (video_id, user_id, access, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(video_id, user_id) DO UPDATE SET access = excluded.access
+	`
+	_, err := c.db.Exec(query, videoID.String(), userID.String(), access)
+	return err
+}
+
+func (c Client) DeleteVideoGrant(videoID, userID uuid.UUID) error {
+	query := `
+		DELETE FROM video_grants
+		WHERE video_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, videoID.String(), userID.String())
+	return err
+}
+
+func scanVideoGrant(row rowScanner) (VideoGrant, error) {
+	var grant VideoGrant
+	var videoID, userID string
+	err := row.Scan(&videoID, &userID, &grant.Email, &grant.Access, &grant.CreatedAt)
+	if err != nil {
+		return VideoGrant{}, err
+	}
+	grant.VideoID, err = uuid.Parse(videoID)
+	if err != nil {
+		return VideoGrant{}, err
+	}
+	grant.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return VideoGrant{}, err
+	}
+	return grant, nil
+}
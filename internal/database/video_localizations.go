@@ -0,0 +1,73 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// VideoLocalization is a video's title and description in another
+// language.
+type VideoLocalization struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// SetVideoLocalization sets a video's title and description in locale,
+// replacing any it had.
+func (c Client) SetVideoLocalization(videoID uuid.UUID, locale string, localization VideoLocalization) error {
+	owners := c.videoOwners(videoID)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	INSERT INTO video_localizations (video_id, locale, title, description, updated_at)
+	VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(video_id, locale) DO UPDATE SET
+		title = excluded.title,
+		description = excluded.description,
+		updated_at = excluded.updated_at
+	`, videoID.String(), locale, localization.Title, localization.Description)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE videos SET updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = ?`, videoID.String())
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.invalidateVideos([]uuid.UUID{videoID}, owners...)
+	return nil
+}
+
+// DeleteVideoLocalization removes a video's title and description in
+// locale. It reports whether there were any.
+func (c Client) DeleteVideoLocalization(videoID uuid.UUID, locale string) (bool, error) {
+	owners := c.videoOwners(videoID)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM video_localizations WHERE video_id = ? AND locale = ?`, videoID.String(), locale)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = tx.Exec(`UPDATE videos SET updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = ?`, videoID.String())
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	c.invalidateVideos([]uuid.UUID{videoID}, owners...)
+	return true, nil
+}
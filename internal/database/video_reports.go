@@ -0,0 +1,273 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Report statuses. Reports stay open until a moderator resolves every open
+// report on the video at once.
+const (
+	ReportStatusOpen     = "open"
+	ReportStatusResolved = "resolved"
+)
+
+// VideoReport is a viewer's complaint about a video. ReporterID is nil for
+// anonymous reports, which are told apart by ReporterIP.
+type VideoReport struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	VideoID    uuid.UUID  `json:"video_id"`
+	ReporterID *uuid.UUID `json:"reporter_id"`
+	ReporterIP string     `json:"-"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details"`
+	Status     string     `json:"status"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	ResolvedBy *uuid.UUID `json:"resolved_by"`
+	Resolution *string    `json:"resolution"`
+}
+
+type CreateVideoReportParams struct {
+	VideoID    uuid.UUID
+	ReporterID *uuid.UUID
+	ReporterIP string
+	Reason     string
+	Details    string
+}
+
+// ModerationQueueItem summarizes the open reports on one video.
+type ModerationQueueItem struct {
+	VideoID         uuid.UUID      `json:"video_id"`
+	Title           string         `json:"title"`
+	OwnerID         uuid.UUID      `json:"owner_id"`
+	ReportCount     int            `json:"report_count"`
+	Reasons         map[string]int `json:"reasons"`
+	FirstReportedAt time.Time      `json:"first_reported_at"`
+	LastReportedAt  time.Time      `json:"last_reported_at"`
+}
+
+const videoReportColumns = `
+		id,
+		created_at,
+		video_id,
+		reporter_id,
+		reporter_ip,
+		reason,
+		details,
+		status,
+		resolved_at,
+		resolved_by,
+		resolution`
+
+func scanVideoReport(row rowScanner) (VideoReport, error) {
+	var report VideoReport
+	var id, videoID string
+	var reporterID, resolvedBy *string
+	err := row.Scan(
+		&id,
+		&report.CreatedAt,
+		&videoID,
+		&reporterID,
+		&report.ReporterIP,
+		&report.Reason,
+		&report.Details,
+		&report.Status,
+		&report.ResolvedAt,
+		&resolvedBy,
+		&report.Resolution,
+	)
+	if err != nil {
+		return VideoReport{}, err
+	}
+	report.ID, err = uuid.Parse(id)
+	if err != nil {
+		return VideoReport{}, err
+	}
+	report.VideoID, err = uuid.Parse(videoID)
+	if err != nil {
+		return VideoReport{}, err
+	}
+	if report.ReporterID, err = parseOptionalID(reporterID); err != nil {
+		return VideoReport{}, err
+	}
+	if report.ResolvedBy, err = parseOptionalID(resolvedBy); err != nil {
+		return VideoReport{}, err
+	}
+	return report, nil
+}
+
+func parseOptionalID(s *string) (*uuid.UUID, error) {
+	if s == nil {
+		return nil, nil
+	}
+	id, err := uuid.Parse(*s)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func (c Client) CreateVideoReport(params CreateVideoReportParams) (VideoReport, error) {
+	id := uuid.New()
+	var reporterID *string
+	if params.ReporterID != nil {
+		s := params.ReporterID.String()
+		reporterID = &s
+	}
+	query := `
+	INSERT INTO video_reports (
+		id,
+		created_at,
+		video_id,
+		reporter_id,
+		reporter_ip,
+		reason,
+		details,
+		status
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id.String(), time.Now().UTC(), params.VideoID.String(), reporterID, params.ReporterIP, params.Reason, params.Details, ReportStatusOpen)
+	if err != nil {
+		return VideoReport{}, err
+	}
+	return c.GetVideoReport(id)
+}
+
+func (c Client) GetVideoReport(id uuid.UUID) (VideoReport, error) {
+	query := `
+	SELECT` + videoReportColumns + `
+	FROM video_reports
+	WHERE id = ?
+	`
+	report, err := scanVideoReport(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoReport{}, nil
+		}
+		return VideoReport{}, err
+	}
+	return report, nil
+}
+
+// HasOpenVideoReport reports whether the user, or for anonymous reports
+// the address, already has an open report on the video.
+func (c Client) HasOpenVideoReport(videoID uuid.UUID, reporterID *uuid.UUID, reporterIP string) (bool, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM video_reports
+	WHERE video_id = ? AND status = ? AND reporter_ip = ? AND reporter_id IS NULL
+	`
+	args := []any{videoID.String(), ReportStatusOpen, reporterIP}
+	if reporterID != nil {
+		query = `
+		SELECT COUNT(*)
+		FROM video_reports
+		WHERE video_id = ? AND status = ? AND reporter_id = ?
+		`
+		args = []any{videoID.String(), ReportStatusOpen, reporterID.String()}
+	}
+	var count int
+	err := c.db.QueryRow(query, args...).Scan(&count)
+	return count > 0, err
+}
+
+// GetVideoReports returns every report on a video, newest first.
+func (c Client) GetVideoReports(videoID uuid.UUID) ([]VideoReport, error) {
+	query := `
+	SELECT` + videoReportColumns + `
+	FROM video_reports
+	WHERE video_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []VideoReport{}
+	for rows.Next() {
+		report, err := scanVideoReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// GetModerationQueue lists videos with open reports, most reported first
+// and then longest waiting.
+func (c Client) GetModerationQueue(limit int) ([]ModerationQueueItem, error) {
+	query := `
+	SELECT r.video_id, v.title, v.user_id, r.reason, r.created_at
+	FROM video_reports r
+	JOIN videos v ON v.id = r.video_id
+	WHERE r.status = ? AND v.deleted_at IS NULL
+	ORDER BY r.created_at
+	`
+	rows, err := c.db.Query(query, ReportStatusOpen)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	queue := []ModerationQueueItem{}
+	byVideo := map[string]int{}
+	for rows.Next() {
+		var videoID, title, ownerID, reason string
+		var createdAt time.Time
+		if err := rows.Scan(&videoID, &title, &ownerID, &reason, &createdAt); err != nil {
+			return nil, err
+		}
+
+		i, ok := byVideo[videoID]
+		if !ok {
+			item := ModerationQueueItem{Title: title, Reasons: map[string]int{}, FirstReportedAt: createdAt}
+			if item.VideoID, err = uuid.Parse(videoID); err != nil {
+				return nil, err
+			}
+			if item.OwnerID, err = uuid.Parse(ownerID); err != nil {
+				return nil, err
+			}
+			i = len(queue)
+			byVideo[videoID] = i
+			queue = append(queue, item)
+		}
+		queue[i].ReportCount++
+		queue[i].Reasons[reason]++
+		queue[i].LastReportedAt = createdAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Rows arrive oldest first, so a stable sort keeps ties longest waiting
+	slices.SortStableFunc(queue, func(a, b ModerationQueueItem) int {
+		return b.ReportCount - a.ReportCount
+	})
+	if len(queue) > limit {
+		queue = queue[:limit]
+	}
+	return queue, nil
+}
+
+// ResolveVideoReports closes every open report on a video with the
+// moderator's resolution, returning how many were closed.
+func (c Client) ResolveVideoReports(videoID, resolvedBy uuid.UUID, resolution string) (int64, error) {
+	query := `
+	UPDATE video_reports
+	SET status = ?, resolved_at = ?, resolved_by = ?, resolution = ?
+	WHERE video_id = ? AND status = ?
+	`
+	res, err := c.db.Exec(query, ReportStatusResolved, time.Now().UTC(), resolvedBy.String(), resolution, videoID.String(), ReportStatusOpen)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
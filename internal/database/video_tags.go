@@ -0,0 +1,113 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// SetVideoTags replaces a video's tags, creating any tags that don't exist
+// yet. Tag names should already be normalized.
+func (c Client) SetVideoTags(videoID uuid.UUID, tags []string) error {
+	owners := c.videoOwners(videoID)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setVideoTags(tx, videoID, tags); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE videos SET updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = ?`, videoID.String())
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.invalidateVideos([]uuid.UUID{videoID}, owners...)
+	return nil
+}
+
+// VideoUpdate is a video to save with UpdateVideosWithTags and the tags
+// to set on it, nil to leave them alone. A non-zero IfVersion is the
+// version the video must still be at.
+type VideoUpdate struct {
+	Video     Video
+	Tags      []string
+	IfVersion int64
+}
+
+// UpdateVideosWithTags saves several videos and their tags in one
+// transaction, so either all of them are updated or none are. If any
+// video has moved on from its IfVersion, nothing is saved and
+// ErrVideoModified is returned.
+func (c Client) UpdateVideosWithTags(updates []VideoUpdate) error {
+	ids := make([]uuid.UUID, 0, len(updates))
+	for _, update := range updates {
+		ids = append(ids, update.Video.ID)
+	}
+	owners := c.videoOwners(ids...)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, update := range updates {
+		if err := updateVideo(tx, update.Video, update.IfVersion); err != nil {
+			return err
+		}
+		if update.Tags != nil {
+			if err := setVideoTags(tx, update.Video.ID, update.Tags); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.invalidateVideos(ids, owners...)
+	return nil
+}
+
+func setVideoTags(db execer, videoID uuid.UUID, tags []string) error {
+	_, err := db.Exec(`DELETE FROM video_tags WHERE video_id = ?`, videoID.String())
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		_, err = db.Exec(`INSERT OR IGNORE INTO tags (name) VALUES (?)`, tag)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec(`
+		INSERT OR IGNORE INTO video_tags (video_id, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?
+		`, videoID.String(), tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveVideoTag takes a tag off a video, reporting whether it had it.
+func (c Client) RemoveVideoTag(videoID uuid.UUID, tag string) (bool, error) {
+	owners := c.videoOwners(videoID)
+	result, err := c.db.Exec(`
+	DELETE FROM video_tags
+	WHERE video_id = ? AND tag_id IN (SELECT id FROM tags WHERE name = ?)
+	`, videoID.String(), tag)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		_, err = c.db.Exec(`UPDATE videos SET updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = ?`, videoID.String())
+		c.invalidateVideos([]uuid.UUID{videoID}, owners...)
+	}
+	return n > 0, err
+}
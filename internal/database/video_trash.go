@@ -0,0 +1,99 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashVideo moves a video to the trash, hiding it everywhere while
+// keeping its file, grants and everything else so it can be restored. It
+// returns ErrInvalidVideoTransition if the video is being uploaded or is
+// already gone.
+func (c Client) TrashVideo(id uuid.UUID) error {
+	return c.transitionVideo(id, VideoStatusTrashed,
+		"status = ?, trashed_status = status, deleted_at = CURRENT_TIMESTAMP", VideoStatusTrashed)
+}
+
+// RestoreTrashedVideo takes a video out of the trash, back to the status
+// it had before. It returns ErrInvalidVideoTransition if the video isn't
+// in the trash.
+func (c Client) RestoreTrashedVideo(id uuid.UUID) error {
+	owners := c.videoOwners(id)
+	result, err := c.db.Exec(`
+	UPDATE videos
+	SET status = trashed_status, trashed_status = NULL, deleted_at = NULL,
+		updated_at = CURRENT_TIMESTAMP, version = version + 1
+	WHERE id = ? AND status = ?
+	`, id.String(), VideoStatusTrashed)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidVideoTransition
+	}
+	c.invalidateVideos([]uuid.UUID{id}, owners...)
+	return nil
+}
+
+// GetTrashedVideo returns a video in the trash, or an empty Video if
+// there is no such video in the trash.
+func (c Client) GetTrashedVideo(id uuid.UUID) (Video, error) {
+	videos, err := c.queryVideos(`
+	SELECT`+videoColumns+`
+	FROM videos
+	WHERE id = ? AND status = ?
+	`, id.String(), VideoStatusTrashed)
+	if err != nil || len(videos) == 0 {
+		return Video{}, err
+	}
+	return videos[0], nil
+}
+
+// GetTrashedVideos returns a user's videos in the trash, most recently
+// trashed first.
+func (c Client) GetTrashedVideos(userID uuid.UUID) ([]Video, error) {
+	return c.queryVideos(`
+	SELECT`+videoColumns+`
+	FROM videos
+	WHERE user_id = ? AND status = ?
+	ORDER BY deleted_at DESC
+	`, userID, VideoStatusTrashed)
+}
+
+// GetVideosTrashedBefore returns videos put in the trash before cutoff,
+// longest trashed first.
+func (c Client) GetVideosTrashedBefore(cutoff time.Time, limit int) ([]Video, error) {
+	return c.queryVideos(`
+	SELECT`+videoColumns+`
+	FROM videos
+	WHERE status = ? AND deleted_at < ?
+	ORDER BY deleted_at
+	LIMIT ?
+	`, VideoStatusTrashed, cutoff.UTC().Format(time.DateTime), limit)
+}
+
+// PurgeTrashedVideo deletes a video in the trash for good, along with
+// everything attached to it. It returns ErrInvalidVideoTransition if the
+// video has left the trash, so a video restored meanwhile is kept.
+func (c Client) PurgeTrashedVideo(id uuid.UUID) error {
+	result, err := c.db.Exec(`
+	UPDATE videos SET status = ?, trashed_status = NULL
+	WHERE id = ? AND status = ?
+	`, VideoStatusDeleted, id.String(), VideoStatusTrashed)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidVideoTransition
+	}
+	return c.DeleteVideo(id)
+}
@@ -2,40 +2,320 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID                uuid.UUID `json:"id"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	ThumbnailURL      *string   `json:"thumbnail_url"`
+	ThumbnailKey      *string   `json:"-"`
+	ThumbnailWidth    *int      `json:"thumbnail_width"`
+	ThumbnailHeight   *int      `json:"thumbnail_height"`
+	ThumbnailBlurHash *string   `json:"thumbnail_blurhash"`
+	ThumbnailColor    *string   `json:"thumbnail_color"`
+	ThumbnailPHash    *string   `json:"thumbnail_phash"`
+	// ThumbnailSHA256 is the hex SHA-256 of the stored thumbnail file,
+	// which identical thumbnails share it by
+	ThumbnailSHA256  *string  `json:"thumbnail_sha256"`
+	VideoURL         *string  `json:"video_url"`
+	AspectClass      *string  `json:"aspect_class"`
+	DurationSeconds  *float64 `json:"duration_seconds"`
+	ModerationStatus *string  `json:"moderation_status"`
+	ModerationScore  *float64 `json:"moderation_score"`
+	ModerationReason *string  `json:"moderation_reason"`
+	QuarantineKey    *string  `json:"-"`
+	AllowedCountries []string `json:"allowed_countries"`
+	BlockedCountries []string `json:"blocked_countries"`
+	Visibility       string   `json:"visibility"`
+	VideoSize        *int64   `json:"video_size"`
+	// VideoSHA256 is the hex SHA-256 of the stored video file, nil for
+	// videos uploaded before it was recorded.
+	VideoSHA256 *string `json:"video_sha256"`
+	// ScanVerdict is what the virus scanner made of the uploaded file,
+	// with ScanSignature naming the malware found in an infected one.
+	ScanVerdict   *string `json:"scan_verdict"`
+	ScanSignature *string `json:"scan_signature"`
+	// VideoProvider, VideoBucket and VideoKey say where the file behind
+	// VideoURL is stored, e.g. "s3", "tubely-prod" and
+	// "landscape/abc.mp4". The bucket is nil for stores without one.
+	VideoProvider *string `json:"-"`
+	VideoBucket   *string `json:"-"`
+	VideoKey      *string `json:"-"`
+	// StorageClass is the S3 storage class the video file is kept in,
+	// and StoredAt when it was uploaded. Both are nil for videos stored
+	// before they were tracked.
+	StorageClass *string    `json:"storage_class"`
+	StoredAt     *time.Time `json:"-"`
+	// RestoreRequestedAt and RestoreTier describe the last request to
+	// restore an archived video file.
+	RestoreRequestedAt *time.Time `json:"-"`
+	RestoreTier        *string    `json:"-"`
+	// ViewCount is how many times the video has been played. It is
+	// managed with IncrementVideoViews, and may lag behind while the
+	// video is cached.
+	ViewCount int64 `json:"view_count"`
+	// ExpiresAt is when the video is due to be deleted, nil to keep it
+	// until its owner deletes it.
+	ExpiresAt *time.Time `json:"expires_at"`
+	// ReplicaRegions are the replica regions the video file has been
+	// seen in. It is managed with SetVideoReplicaRegions and reset
+	// whenever VideoURL changes.
+	ReplicaRegions []string `json:"-"`
+	// Tags label the video, in alphabetical order. They are managed with
+	// SetVideoTags and RemoveVideoTag.
+	Tags []string `json:"tags"`
+	// Captions are the languages the video has captions in, in
+	// alphabetical order. They are managed with SetVideoCaption and
+	// DeleteVideoCaption.
+	Captions []string `json:"captions"`
+	// Localizations are the video's title and description in other
+	// languages, keyed by language tag. They are managed with
+	// SetVideoLocalization and DeleteVideoLocalization.
+	Localizations map[string]VideoLocalization `json:"localizations"`
+	// Version counts changes to the video's metadata, starting at 1. It
+	// is served as the video's ETag so clients can avoid overwriting each
+	// other's changes.
+	Version int64 `json:"-"`
+	// CategoryID places the video in the category tree for browsing the
+	// catalog, nil if it is uncategorized.
+	CategoryID *uuid.UUID `json:"category_id"`
+	// Status is where the video is in its lifecycle, one of the
+	// VideoStatus values. It is managed with SetVideoStatus, TrashVideo
+	// and RestoreTrashedVideo.
+	Status string `json:"status"`
+	// DeletedAt is when the video was put in the trash or deleted, nil
+	// while it is neither.
+	DeletedAt *time.Time `json:"-"`
+	MediaInfo
 	CreateVideoParams
 }
 
+// MediaInfo is what ffprobe found in a video file when it was uploaded.
+// Fields are nil for videos uploaded before it was recorded, and for
+// details the file doesn't carry, such as the audio codec of a silent
+// video.
+type MediaInfo struct {
+	Width      *int    `json:"width"`
+	Height     *int    `json:"height"`
+	VideoCodec *string `json:"video_codec"`
+	AudioCodec *string `json:"audio_codec"`
+	// Bitrate is the file's overall bitrate in bits per second.
+	Bitrate   *int64   `json:"bitrate"`
+	FrameRate *float64 `json:"frame_rate"`
+}
+
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	UserID      uuid.UUID  `json:"user_id"`
+	OrgID       *uuid.UUID `json:"org_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
 		thumbnail_url,
+		thumbnail_key,
+		thumbnail_width,
+		thumbnail_height,
+		thumbnail_blurhash,
+		thumbnail_color,
+		thumbnail_phash,
+		thumbnail_sha256,
 		video_url,
-		user_id
+		video_provider,
+		video_bucket,
+		video_key,
+		aspect_class,
+		duration_seconds,
+		video_width,
+		video_height,
+		video_codec,
+		audio_codec,
+		video_bitrate,
+		frame_rate,
+		view_count,
+		moderation_status,
+		moderation_score,
+		moderation_reason,
+		quarantine_key,
+		allowed_countries,
+		blocked_countries,
+		visibility,
+		video_size,
+		video_sha256,
+		scan_verdict,
+		scan_signature,
+		storage_class,
+		stored_at,
+		restore_requested_at,
+		restore_tier,
+		expires_at,
+		replica_regions,
+		category_id,
+		version,
+		status,
+		deleted_at,
+		(
+			SELECT group_concat(t.name, ',')
+			FROM video_tags vt JOIN tags t ON t.id = vt.tag_id
+			WHERE vt.video_id = videos.id
+		),
+		(
+			SELECT group_concat(vc.language, ',')
+			FROM video_captions vc
+			WHERE vc.video_id = videos.id
+		),
+		(
+			SELECT json_group_object(vl.locale, json_object('title', vl.title, 'description', vl.description))
+			FROM video_localizations vl
+			WHERE vl.video_id = videos.id
+		),
+		user_id,
+		org_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	var allowedCountries, blockedCountries, replicaRegions, tags, captions, localizations sql.NullString
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.ThumbnailKey,
+		&video.ThumbnailWidth,
+		&video.ThumbnailHeight,
+		&video.ThumbnailBlurHash,
+		&video.ThumbnailColor,
+		&video.ThumbnailPHash,
+		&video.ThumbnailSHA256,
+		&video.VideoURL,
+		&video.VideoProvider,
+		&video.VideoBucket,
+		&video.VideoKey,
+		&video.AspectClass,
+		&video.DurationSeconds,
+		&video.Width,
+		&video.Height,
+		&video.VideoCodec,
+		&video.AudioCodec,
+		&video.Bitrate,
+		&video.FrameRate,
+		&video.ViewCount,
+		&video.ModerationStatus,
+		&video.ModerationScore,
+		&video.ModerationReason,
+		&video.QuarantineKey,
+		&allowedCountries,
+		&blockedCountries,
+		&video.Visibility,
+		&video.VideoSize,
+		&video.VideoSHA256,
+		&video.ScanVerdict,
+		&video.ScanSignature,
+		&video.StorageClass,
+		&video.StoredAt,
+		&video.RestoreRequestedAt,
+		&video.RestoreTier,
+		&video.ExpiresAt,
+		&replicaRegions,
+		&video.CategoryID,
+		&video.Version,
+		&video.Status,
+		&video.DeletedAt,
+		&tags,
+		&captions,
+		&localizations,
+		&video.UserID,
+		&video.OrgID,
+	)
+	video.AllowedCountries = splitList(allowedCountries.String)
+	video.BlockedCountries = splitList(blockedCountries.String)
+	video.ReplicaRegions = splitList(replicaRegions.String)
+	video.Tags = splitList(tags.String)
+	slices.Sort(video.Tags)
+	video.Captions = splitList(captions.String)
+	slices.Sort(video.Captions)
+	video.Localizations = map[string]VideoLocalization{}
+	if err == nil && localizations.Valid {
+		err = json.Unmarshal([]byte(localizations.String), &video.Localizations)
+	}
+	return video, err
+}
+
+// queryVideos runs a query selecting videoColumns.
+func (c Client) queryVideos(query string, args ...any) ([]Video, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// splitList and joinList store small string sets in a single TEXT column.
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func joinList(list []string) *string {
+	if len(list) == 0 {
+		return nil
+	}
+	s := strings.Join(list, ",")
+	return &s
+}
+
+// utcTime stores times in UTC, so columns compared as text against a
+// time in queries order the same way the times do.
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	var cached []Video
+	if c.cacheGet(videoListCacheKey(userID), &cached) {
+		return cached, nil
+	}
+
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE user_id = ?
+	WHERE user_id = ? AND deleted_at IS NULL
 	ORDER BY created_at DESC
 	`
 
@@ -47,25 +327,333 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
+	c.cacheSet(videoListCacheKey(userID), videos)
 	return videos, nil
 }
 
+// Fields a list of videos can be sorted by.
+const (
+	VideoSortCreatedAt = "created_at"
+	VideoSortTitle     = "title"
+	VideoSortDuration  = "duration"
+	VideoSortViews     = "views"
+)
+
+// VideoSort orders a list of videos. Videos with equal values are ordered
+// by ID in the same direction, so every video has one place in the list.
+type VideoSort struct {
+	// Field is one of the VideoSort values
+	Field     string
+	Ascending bool
+}
+
+// videoSortColumns are the expressions each sort field orders by, and the
+// placeholders a cursor's value is compared with. datetime() compares
+// timestamps written by SQLite and by Go alike, and videos not yet
+// uploaded sort as if they had no duration.
+var videoSortColumns = map[string]struct{ expr, param string }{
+	VideoSortCreatedAt: {"datetime(created_at)", "datetime(?)"},
+	VideoSortTitle:     {"title COLLATE NOCASE", "?"},
+	VideoSortDuration:  {"COALESCE(duration_seconds, 0)", "?"},
+	VideoSortViews:     {"view_count", "?"},
+}
+
+// VideoCursor marks where a page of videos ended, so the next page can
+// start after it.
+type VideoCursor struct {
+	// Value is the sorted field's value for the last video: a time.Time
+	// for created_at, a string for title, a float64 for duration and an
+	// int64 for views
+	Value any
+	ID    uuid.UUID
+}
+
+// NewVideoCursor returns the cursor for a page sorted by field ending
+// with video.
+func NewVideoCursor(video Video, field string) VideoCursor {
+	cursor := VideoCursor{ID: video.ID}
+	switch field {
+	case VideoSortTitle:
+		cursor.Value = video.Title
+	case VideoSortDuration:
+		cursor.Value = 0.0
+		if video.DurationSeconds != nil {
+			cursor.Value = *video.DurationSeconds
+		}
+	case VideoSortViews:
+		cursor.Value = video.ViewCount
+	default:
+		cursor.Value = video.CreatedAt
+	}
+	return cursor
+}
+
+// Lifecycle statuses of a video. A video starts as a draft, and each
+// upload takes it through uploading and processing to ready or failed.
+const (
+	// VideoStatusDraft is a video with no file uploaded yet
+	VideoStatusDraft = "draft"
+	// VideoStatusUploading is a video whose file is being received
+	VideoStatusUploading = "uploading"
+	// VideoStatusProcessing is a video whose file has been received and
+	// is being scanned, remuxed, moderated and stored
+	VideoStatusProcessing = "processing"
+	// VideoStatusReady is a video whose upload was stored
+	VideoStatusReady = "ready"
+	// VideoStatusFailed is a video whose last upload didn't work out and
+	// that has no file to fall back on
+	VideoStatusFailed = "failed"
+	// VideoStatusTrashed is a video in the trash, which can be restored
+	// to the status it had until it is purged
+	VideoStatusTrashed = "trashed"
+	// VideoStatusDeleted is a soft-deleted video
+	VideoStatusDeleted = "deleted"
+)
+
+// VideoStatusQuarantined isn't a status of its own, but videos can be
+// listed by it to find those whose file is held for review.
+const VideoStatusQuarantined = "quarantined"
+
+// videoStatusTransitions lists the statuses a video can move to from each
+// status. A ready video goes back to uploading when its file is
+// replaced, and back to ready if the replacement fails, since its old
+// file is kept until a new one is stored. Videos can't be trashed in the
+// middle of an upload.
+var videoStatusTransitions = map[string][]string{
+	VideoStatusDraft:      {VideoStatusUploading, VideoStatusTrashed, VideoStatusDeleted},
+	VideoStatusUploading:  {VideoStatusProcessing, VideoStatusReady, VideoStatusFailed, VideoStatusDeleted},
+	VideoStatusProcessing: {VideoStatusReady, VideoStatusFailed, VideoStatusDeleted},
+	VideoStatusReady:      {VideoStatusUploading, VideoStatusTrashed, VideoStatusDeleted},
+	VideoStatusFailed:     {VideoStatusUploading, VideoStatusTrashed, VideoStatusDeleted},
+	VideoStatusTrashed:    {VideoStatusDraft, VideoStatusReady, VideoStatusFailed, VideoStatusDeleted},
+}
+
+// ErrInvalidVideoTransition is returned when a video can't move from its
+// status to the one asked for.
+var ErrInvalidVideoTransition = errors.New("invalid video status transition")
+
+// CanTransitionVideo reports whether a video can move from one status to
+// another.
+func CanTransitionVideo(from, to string) bool {
+	return slices.Contains(videoStatusTransitions[from], to)
+}
+
+// SetVideoStatus moves a video to status, returning
+// ErrInvalidVideoTransition if it isn't in a status that can lead there.
+// The check and the change are one statement, so two requests can't both
+// move a video out of the same status.
+func (c Client) SetVideoStatus(id uuid.UUID, status string) error {
+	return c.transitionVideo(id, status, "status = ?", status)
+}
+
+// transitionVideo applies set, with args for its placeholders, to a video
+// whose status can lead to status, bumping its version.
+func (c Client) transitionVideo(id uuid.UUID, status, set string, args ...any) error {
+	from := []string{}
+	for s := range videoStatusTransitions {
+		if CanTransitionVideo(s, status) {
+			from = append(from, s)
+		}
+	}
+	if len(from) == 0 {
+		return ErrInvalidVideoTransition
+	}
+	args = append(args, id.String())
+	for _, s := range from {
+		args = append(args, s)
+	}
+
+	owners := c.videoOwners(id)
+	result, err := c.db.Exec(`
+	UPDATE videos
+	SET `+set+`, updated_at = CURRENT_TIMESTAMP, version = version + 1
+	WHERE id = ? AND status IN (?`+strings.Repeat(", ?", len(from)-1)+`)
+	`, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidVideoTransition
+	}
+	c.invalidateVideos([]uuid.UUID{id}, owners...)
+	return nil
+}
+
+// VideoFilter narrows a list of videos. Zero fields don't filter.
+type VideoFilter struct {
+	// Status is one of the VideoStatus values, or VideoStatusQuarantined
+	Status string
+	// AspectClass is "landscape", "portrait" or "other"
+	AspectClass string
+	// CreatedSince and CreatedUntil bound when videos were created,
+	// inclusive and exclusive respectively
+	CreatedSince *time.Time
+	CreatedUntil *time.Time
+	HasThumbnail *bool
+	// Tag limits the list to videos with this tag
+	Tag string
+	// CategoryID limits the list to videos in this category or any
+	// category below it
+	CategoryID *uuid.UUID
+}
+
+// GetVideosPage returns up to limit of a user's videos matching filter in
+// the order of sort, starting after the video after marks, or from the
+// start if it is nil. Paging by position rather than offset keeps pages
+// from skipping or repeating videos when new ones are added in between.
+func (c Client) GetVideosPage(userID uuid.UUID, filter VideoFilter, sort VideoSort, after *VideoCursor, limit int) ([]Video, error) {
+	return c.getVideosPage([]string{"user_id = ?"}, []any{userID}, filter, sort, after, limit)
+}
+
+// GetCatalogPage is GetVideosPage for the public catalog: every public
+// video with a playable file that hasn't been taken down or quarantined,
+// and may be watched from country ("" if unknown).
+func (c Client) GetCatalogPage(country string, filter VideoFilter, sort VideoSort, after *VideoCursor, limit int) ([]Video, error) {
+	conditions := []string{
+		"visibility = 'public'",
+		"status = 'ready'",
+		"video_url IS NOT NULL",
+		"(moderation_status IS NULL OR moderation_status NOT IN ('removed', 'quarantined'))",
+		"(blocked_countries IS NULL OR instr(',' || blocked_countries || ',', ',' || ? || ',') = 0)",
+		"(allowed_countries IS NULL OR instr(',' || allowed_countries || ',', ',' || ? || ',') > 0)",
+	}
+	return c.getVideosPage(conditions, []any{country, country}, filter, sort, after, limit)
+}
+
+// GetChannelVideos returns up to limit of a user's videos that would be
+// in the public catalog, newest first, wherever they may be watched from.
+func (c Client) GetChannelVideos(userID uuid.UUID, limit int) ([]Video, error) {
+	conditions := []string{
+		"user_id = ?",
+		"visibility = 'public'",
+		"status = 'ready'",
+		"video_url IS NOT NULL",
+		"(moderation_status IS NULL OR moderation_status NOT IN ('removed', 'quarantined'))",
+	}
+	return c.getVideosPage(conditions, []any{userID}, VideoFilter{}, VideoSort{Field: VideoSortCreatedAt}, nil, limit)
+}
+
+// getVideosPage pages through the videos matching conditions, with args
+// for their placeholders, and filter.
+func (c Client) getVideosPage(conditions []string, args []any, filter VideoFilter, sort VideoSort, after *VideoCursor, limit int) ([]Video, error) {
+	conditions = append(conditions, "deleted_at IS NULL")
+	switch filter.Status {
+	case "":
+	case VideoStatusQuarantined:
+		conditions = append(conditions, "quarantine_key IS NOT NULL")
+	default:
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.AspectClass != "" {
+		conditions = append(conditions, "aspect_class = ?")
+		args = append(args, filter.AspectClass)
+	}
+	if filter.CreatedSince != nil {
+		conditions = append(conditions, "datetime(created_at) >= datetime(?)")
+		args = append(args, filter.CreatedSince.UTC().Format(time.DateTime))
+	}
+	if filter.CreatedUntil != nil {
+		conditions = append(conditions, "datetime(created_at) < datetime(?)")
+		args = append(args, filter.CreatedUntil.UTC().Format(time.DateTime))
+	}
+	if filter.HasThumbnail != nil {
+		if *filter.HasThumbnail {
+			conditions = append(conditions, "thumbnail_url IS NOT NULL")
+		} else {
+			conditions = append(conditions, "thumbnail_url IS NULL")
+		}
+	}
+	if filter.Tag != "" {
+		conditions = append(conditions, "id IN (SELECT vt.video_id FROM video_tags vt JOIN tags t ON t.id = vt.tag_id WHERE t.name = ?)")
+		args = append(args, filter.Tag)
+	}
+	if filter.CategoryID != nil {
+		conditions = append(conditions, `category_id IN (
+			WITH RECURSIVE subtree(id) AS (
+				SELECT ?
+				UNION SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id
+			)
+			SELECT id FROM subtree
+		)`)
+		args = append(args, filter.CategoryID.String())
+	}
+
+	column, ok := videoSortColumns[sort.Field]
+	if !ok {
+		column = videoSortColumns[VideoSortCreatedAt]
+	}
+	direction, op := "DESC", "<"
+	if sort.Ascending {
+		direction, op = "ASC", ">"
+	}
+	if after != nil {
+		value := after.Value
+		if t, ok := value.(time.Time); ok {
+			value = t.UTC().Format(time.DateTime)
+		}
+		conditions = append(conditions, fmt.Sprintf("(%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND id %[2]s ?))", column.expr, op, column.param))
+		args = append(args, value, value, after.ID)
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY ` + column.expr + " " + direction + ", id " + direction + `
+	LIMIT ?
+	`
+	return c.queryVideos(query, append(args, limit)...)
+}
+
+// GetAllVideos returns every video that hasn't been deleted, oldest
+// first.
+func (c Client) GetAllVideos() ([]Video, error) {
+	return c.queryVideos(`
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NULL
+	ORDER BY created_at, id
+	`)
+}
+
+// GetOrgVideos returns the videos belonging to an organization.
+func (c Client) GetOrgVideos(orgID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE org_id = ? AND deleted_at IS NULL
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	id := uuid.New()
 	query := `
@@ -75,42 +663,32 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		org_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, params.OrgID)
 	if err != nil {
 		return Video{}, err
 	}
+	c.invalidateVideos(nil, params.UserID)
 
 	return c.GetVideo(id)
 }
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
+	var cached Video
+	if c.cacheGet(videoCacheKey(id), &cached) {
+		return cached, nil
+	}
+
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE id = ?
+	WHERE id = ? AND deleted_at IS NULL
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -118,38 +696,504 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		return Video{}, err
 	}
 
+	c.cacheSet(videoCacheKey(id), video)
 	return video, nil
 }
 
 func (c Client) UpdateVideo(video Video) error {
+	owners := c.videoOwners(video.ID)
+	if err := updateVideo(c.db, video, 0); err != nil {
+		return err
+	}
+	c.invalidateVideos([]uuid.UUID{video.ID}, owners...)
+	return nil
+}
+
+// execer and queryer are satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// ErrVideoModified is returned when a video has changed since the version
+// an update was based on.
+var ErrVideoModified = errors.New("video has been modified")
+
+// updateVideo saves video, bumping its version. With a non-zero
+// ifVersion, it only saves it if it is still at that version, returning
+// ErrVideoModified otherwise.
+func updateVideo(db execer, video Video, ifVersion int64) error {
 	query := `
 	UPDATE videos
 	SET
+		version = version + 1,
+		updated_at = CURRENT_TIMESTAMP,
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_key = ?,
+		thumbnail_width = ?,
+		thumbnail_height = ?,
+		thumbnail_blurhash = ?,
+		thumbnail_color = ?,
+		thumbnail_phash = ?,
+		thumbnail_sha256 = ?,
+		replica_regions = CASE WHEN video_url IS ? THEN replica_regions END,
 		video_url = ?,
-		user_id = ?
-	WHERE id = ?
+		video_provider = ?,
+		video_bucket = ?,
+		video_key = ?,
+		aspect_class = ?,
+		duration_seconds = ?,
+		video_width = ?,
+		video_height = ?,
+		video_codec = ?,
+		audio_codec = ?,
+		video_bitrate = ?,
+		frame_rate = ?,
+		moderation_status = ?,
+		moderation_score = ?,
+		moderation_reason = ?,
+		quarantine_key = ?,
+		allowed_countries = ?,
+		blocked_countries = ?,
+		visibility = ?,
+		video_size = ?,
+		video_sha256 = ?,
+		scan_verdict = ?,
+		scan_signature = ?,
+		storage_class = ?,
+		stored_at = ?,
+		restore_requested_at = ?,
+		restore_tier = ?,
+		expiry_reminded_at = CASE WHEN expires_at IS ? THEN expiry_reminded_at END,
+		expires_at = ?,
+		category_id = ?,
+		user_id = ?,
+		org_id = ?
+	WHERE id = ? AND (? = 0 OR version = ?)
 	`
 
-	_, err := c.db.Exec(
+	result, err := db.Exec(
 		query,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
-		&video.VideoURL,
+		video.ThumbnailKey,
+		video.ThumbnailWidth,
+		video.ThumbnailHeight,
+		video.ThumbnailBlurHash,
+		video.ThumbnailColor,
+		video.ThumbnailPHash,
+		video.ThumbnailSHA256,
+		video.VideoURL,
+		video.VideoURL,
+		video.VideoProvider,
+		video.VideoBucket,
+		video.VideoKey,
+		video.AspectClass,
+		video.DurationSeconds,
+		video.Width,
+		video.Height,
+		video.VideoCodec,
+		video.AudioCodec,
+		video.Bitrate,
+		video.FrameRate,
+		video.ModerationStatus,
+		video.ModerationScore,
+		video.ModerationReason,
+		video.QuarantineKey,
+		joinList(video.AllowedCountries),
+		joinList(video.BlockedCountries),
+		video.Visibility,
+		video.VideoSize,
+		video.VideoSHA256,
+		video.ScanVerdict,
+		video.ScanSignature,
+		video.StorageClass,
+		video.StoredAt,
+		video.RestoreRequestedAt,
+		video.RestoreTier,
+		utcTime(video.ExpiresAt),
+		utcTime(video.ExpiresAt),
+		video.CategoryID,
 		video.UserID,
+		video.OrgID,
 		video.ID,
+		ifVersion,
+		ifVersion,
 	)
+	if err != nil || ifVersion == 0 {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVideoModified
+	}
+	return nil
+}
+
+// IncrementVideoViews counts a play of a video. The cached video isn't
+// invalidated, so popular videos stay cached.
+func (c Client) IncrementVideoViews(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE videos SET view_count = view_count + 1 WHERE id = ?`, id)
 	return err
 }
 
-func (c Client) DeleteVideo(id uuid.UUID) error {
+// GetVideosForStorageTransition returns playable videos whose files were
+// stored before cutoff and aren't in any of skipClasses, oldest first.
+// Videos from before upload times were tracked count from when they were
+// created.
+func (c Client) GetVideosForStorageTransition(cutoff time.Time, skipClasses []string, limit int) ([]Video, error) {
+	args := []any{cutoff.UTC()}
+	placeholders := make([]string, len(skipClasses))
+	for i, class := range skipClasses {
+		placeholders[i] = "?"
+		args = append(args, class)
+	}
+	args = append(args, limit)
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE video_url IS NOT NULL
+		AND COALESCE(stored_at, created_at) < ?
+		AND COALESCE(storage_class, '') NOT IN (` + strings.Join(placeholders, ", ") + `)
+	ORDER BY COALESCE(stored_at, created_at)
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// VideoFileRef is where a video's file is stored: its location, or its
+// object key while quarantined.
+type VideoFileRef struct {
+	ID            uuid.UUID
+	VideoProvider *string
+	VideoBucket   *string
+	VideoKey      *string
+	QuarantineKey *string
+	VideoSize     *int64
+}
+
+// GetVideoFileRefs returns the file references of every video that has
+// an uploaded file.
+func (c Client) GetVideoFileRefs() ([]VideoFileRef, error) {
 	query := `
-	DELETE FROM videos
+	SELECT id, video_provider, video_bucket, video_key, quarantine_key, video_size
+	FROM videos
+	WHERE video_url IS NOT NULL OR quarantine_key IS NOT NULL
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []VideoFileRef{}
+	for rows.Next() {
+		var ref VideoFileRef
+		if err := rows.Scan(&ref.ID, &ref.VideoProvider, &ref.VideoBucket, &ref.VideoKey, &ref.QuarantineKey, &ref.VideoSize); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// BackfillVideoLocations fills in the location columns of videos stored
+// before they existed, whose keys are their video URLs minus urlPrefix.
+// It returns how many videos were updated, and how many still have no
+// location because their URLs don't start with urlPrefix.
+func (c Client) BackfillVideoLocations(urlPrefix, provider string, bucket *string) (int64, int64, error) {
+	res, err := c.db.Exec(`
+	UPDATE videos
+	SET video_key = substr(video_url, ?), video_provider = ?, video_bucket = ?
+	WHERE video_key IS NULL
+		AND video_url IS NOT NULL
+		AND substr(video_url, 1, ?) = ?
+		AND length(video_url) > ?
+	`, len(urlPrefix)+1, provider, bucket, len(urlPrefix), urlPrefix, len(urlPrefix))
+	if err != nil {
+		return 0, 0, err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var unresolved int64
+	err = c.db.QueryRow(`
+	SELECT COUNT(*) FROM videos WHERE video_key IS NULL AND video_url IS NOT NULL
+	`).Scan(&unresolved)
+	return updated, unresolved, err
+}
+
+// VideoStorageProblem is a video whose stored file failed verification.
+type VideoStorageProblem struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Title     string    `json:"title"`
+	Problem   string    `json:"problem"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// SetVideoStorageCheck records the result of verifying a video's stored
+// file. A nil problem means the file checked out.
+func (c Client) SetVideoStorageCheck(id uuid.UUID, problem *string) error {
+	_, err := c.db.Exec(`
+	UPDATE videos
+	SET storage_problem = ?, storage_checked_at = ?
 	WHERE id = ?
+	`, problem, time.Now().UTC(), id)
+	return err
+}
+
+// GetVideoStorageProblems returns the videos whose files failed their
+// last verification, most recently checked first.
+func (c Client) GetVideoStorageProblems() ([]VideoStorageProblem, error) {
+	query := `
+	SELECT id, user_id, title, storage_problem, storage_checked_at
+	FROM videos
+	WHERE storage_problem IS NOT NULL
+	ORDER BY storage_checked_at DESC
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := []VideoStorageProblem{}
+	for rows.Next() {
+		var problem VideoStorageProblem
+		err := rows.Scan(&problem.VideoID, &problem.UserID, &problem.Title, &problem.Problem, &problem.CheckedAt)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, problem)
+	}
+	return problems, rows.Err()
+}
+
+// SetVideoStorageClass records that a video's file has moved to class.
+func (c Client) SetVideoStorageClass(id uuid.UUID, class string) error {
+	_, err := c.db.Exec(`UPDATE videos SET storage_class = ?, updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = ?`, class, id)
+	if err == nil {
+		c.invalidateVideos([]uuid.UUID{id})
+	}
+	return err
+}
+
+// GetVideosPendingReplication returns playable videos whose files haven't
+// been seen in every one of regions yet, least recently checked first.
+func (c Client) GetVideosPendingReplication(regions []string, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE video_url IS NOT NULL
+		AND quarantine_key IS NULL
+		AND COALESCE(replica_regions, '') != ?
+	ORDER BY replica_checked_at IS NOT NULL, replica_checked_at
+	LIMIT ?
 	`
-	_, err := c.db.Exec(query, id)
+
+	rows, err := c.db.Query(query, strings.Join(regions, ","), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// SetVideoReplicaRegions records the replica regions video's file has
+// been seen in. It does nothing if the video's file was replaced since
+// videoURL was read, since the regions describe the old file.
+func (c Client) SetVideoReplicaRegions(id uuid.UUID, videoURL string, regions []string) error {
+	_, err := c.db.Exec(`
+	UPDATE videos
+	SET replica_regions = ?, replica_checked_at = ?
+	WHERE id = ? AND video_url = ?
+	`, joinList(regions), time.Now().UTC(), id, videoURL)
+	if err == nil {
+		c.invalidateVideos([]uuid.UUID{id})
+	}
+	return err
+}
+
+// SetVideoRestoreRequested records a request to restore a video's
+// archived file.
+func (c Client) SetVideoRestoreRequested(id uuid.UUID, tier string) error {
+	_, err := c.db.Exec(`
+	UPDATE videos
+	SET restore_requested_at = ?, restore_tier = ?
+	WHERE id = ?
+	`, time.Now().UTC(), tier, id)
+	if err == nil {
+		c.invalidateVideos([]uuid.UUID{id})
+	}
 	return err
 }
+
+// FindThumbnailDuplicate returns another video whose stored thumbnail
+// file has the given SHA-256, or an empty Video if there is none.
+func (c Client) FindThumbnailDuplicate(excludeID uuid.UUID, sha256 string) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE thumbnail_sha256 = ?
+		AND thumbnail_url IS NOT NULL
+		AND id != ?
+	LIMIT 1
+	`
+
+	video, err := scanVideo(c.db.QueryRow(query, sha256, excludeID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, nil
+		}
+		return Video{}, err
+	}
+	return video, nil
+}
+
+// GetVideosWithThumbnailHash returns every video that has a hashed
+// thumbnail, for duplicate reporting.
+func (c Client) GetVideosWithThumbnailHash() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE thumbnail_phash IS NOT NULL
+	ORDER BY created_at
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// CountVideosWithThumbnailKey reports how many videos reference a stored
+// thumbnail file. Deduplicated thumbnails can be shared between videos.
+func (c Client) CountVideosWithThumbnailKey(key string) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM videos
+	WHERE thumbnail_key = ?
+	`
+	var count int
+	err := c.db.QueryRow(query, key).Scan(&count)
+	return count, err
+}
+
+// CountVideosWithFileKey reports how many videos reference a stored video
+// file, whether playable, quarantined or kept as a previous version.
+// Files with content-addressed keys can be shared between videos.
+func (c Client) CountVideosWithFileKey(key string) (int, error) {
+	query := `
+	SELECT
+		(SELECT COUNT(*) FROM videos WHERE video_key = ? OR quarantine_key = ?) +
+		(SELECT COUNT(*) FROM video_file_versions WHERE video_key = ?)
+	`
+	var count int
+	err := c.db.QueryRow(query, key, key, key).Scan(&count)
+	return count, err
+}
+
+func (c Client) DeleteVideo(id uuid.UUID) error {
+	owners := c.videoOwners(id)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`DELETE FROM video_grants WHERE video_id = ?`,
+		`DELETE FROM guest_uploads WHERE video_id = ?`,
+		`DELETE FROM video_reports WHERE video_id = ?`,
+		`DELETE FROM upload_tokens WHERE video_id = ?`,
+		`DELETE FROM share_links WHERE video_id = ?`,
+		`DELETE FROM video_tags WHERE video_id = ?`,
+		`DELETE FROM playlist_items WHERE video_id = ?`,
+		`DELETE FROM caption_jobs WHERE video_id = ?`,
+		`DELETE FROM video_localizations WHERE video_id = ?`,
+		`DELETE FROM videos WHERE id = ?`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, id.String()); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.invalidateVideos([]uuid.UUID{id}, owners...)
+	return nil
+}
+
+// cachedVideoIDs lists the videos matching where, so bulk deletes can
+// invalidate them afterwards. It returns nil without a cache.
+func (c Client) cachedVideoIDs(where string, args ...any) ([]uuid.UUID, error) {
+	if c.cache == nil {
+		return nil, nil
+	}
+	rows, err := c.db.Query(`SELECT id FROM videos WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
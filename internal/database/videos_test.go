@@ -0,0 +1,53 @@
+package database
+
+import "testing"
+
+func TestDeleteVideo(t *testing.T) {
+	tests := []struct {
+		name    string
+		failing bool // whether deleting the video row itself fails
+	}{
+		{name: "deletes the video and what refers to it"},
+		{name: "keeps everything when the video can't be deleted", failing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			owner := newTestUser(t, c, "owner@example.com")
+			viewer := newTestUser(t, c, "viewer@example.com")
+			video := newTestVideo(t, c, owner.ID, nil)
+			if err := c.PutVideoGrant(video.ID, viewer.ID, "view"); err != nil {
+				t.Fatalf("PutVideoGrant() error = %v", err)
+			}
+			if tt.failing {
+				trigger := `
+				CREATE TRIGGER fail_video_delete BEFORE DELETE ON videos
+				BEGIN SELECT RAISE(ABORT, 'video delete failed'); END
+				`
+				if _, err := c.db.Exec(trigger); err != nil {
+					t.Fatalf("creating trigger: %v", err)
+				}
+			}
+
+			err := c.DeleteVideo(video.ID)
+			if tt.failing != (err != nil) {
+				t.Fatalf("DeleteVideo() error = %v, want an error = %v", err, tt.failing)
+			}
+
+			grant, err := c.GetVideoGrant(video.ID, viewer.ID)
+			if err != nil {
+				t.Fatalf("GetVideoGrant() error = %v", err)
+			}
+			if kept := grant.UserID == viewer.ID; kept != tt.failing {
+				t.Errorf("grant kept = %v, want %v", kept, tt.failing)
+			}
+			got, err := c.GetVideo(video.ID)
+			if err != nil {
+				t.Fatalf("GetVideo() error = %v", err)
+			}
+			if kept := got.ID == video.ID; kept != tt.failing {
+				t.Errorf("video kept = %v, want %v", kept, tt.failing)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+const dominantColorSampleSize = 64
+
+// DominantColor returns the most common color in img. Pixels are bucketed
+// at 4 bits per channel and the winning bucket is averaged, which is
+// stable against noise and compression artifacts while still picking the
+// color a viewer would perceive as "the" color of the image.
+func DominantColor(img image.Image) color.RGBA {
+	src := toRGBA(Fit(img, dominantColorSampleSize, dominantColorSampleSize))
+
+	type bucket struct {
+		r, g, b, count int
+	}
+	var buckets [4096]bucket
+	best := -1
+	for y := 0; y < src.Bounds().Dy(); y++ {
+		for x := 0; x < src.Bounds().Dx(); x++ {
+			p := src.Pix[src.PixOffset(x, y):]
+			// Skip mostly transparent pixels; they aren't visible
+			if p[3] < 128 {
+				continue
+			}
+			i := int(p[0]>>4)<<8 | int(p[1]>>4)<<4 | int(p[2]>>4)
+			b := &buckets[i]
+			b.r += int(p[0])
+			b.g += int(p[1])
+			b.b += int(p[2])
+			b.count++
+			if best < 0 || b.count > buckets[best].count {
+				best = i
+			}
+		}
+	}
+
+	if best < 0 {
+		return color.RGBA{A: 255}
+	}
+	b := buckets[best]
+	return color.RGBA{
+		R: uint8(b.r / b.count),
+		G: uint8(b.g / b.count),
+		B: uint8(b.b / b.count),
+		A: 255,
+	}
+}
+
+// HexColor formats c as a CSS hex color, e.g. "#1a2b3c".
+func HexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,118 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+)
+
+type CropMode string
+
+const (
+	CropCenter    CropMode = "center"
+	CropAttention CropMode = "attention"
+)
+
+// attentionSampleSize bounds the image the energy map is computed from.
+const attentionSampleSize = 128
+
+// CropToAspect crops img to the aspect ratio aspectW:aspectH, keeping as
+// much of the image as possible. With CropAttention the crop window slides
+// to where the image has the most detail (edge energy) instead of staying
+// centered.
+func CropToAspect(img image.Image, aspectW, aspectH int, mode CropMode) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	cw, ch := w, w*aspectH/aspectW
+	if ch > h {
+		cw, ch = h*aspectW/aspectH, h
+	}
+	if cw == w && ch == h {
+		return img
+	}
+
+	offset := 0.5
+	if mode == CropAttention {
+		offset = attentionOffset(img, float64(cw)/float64(w), float64(ch)/float64(h))
+	}
+	x := int(float64(w-cw) * offset)
+	y := int(float64(h-ch) * offset)
+	if cw == w {
+		x = 0
+	} else {
+		y = 0
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, cw, ch))
+	draw.Draw(dst, dst.Bounds(), img, image.Pt(b.Min.X+x, b.Min.Y+y), draw.Src)
+	return dst
+}
+
+// attentionOffset returns the relative position (0 = start, 1 = end) along
+// the cropped axis of the window with the highest gradient energy. fracW
+// and fracH are the fraction of each axis the crop keeps; exactly one of
+// them is less than 1.
+func attentionOffset(img image.Image, fracW, fracH float64) float64 {
+	src := toRGBA(Fit(img, attentionSampleSize, attentionSampleSize))
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w < 3 || h < 3 {
+		return 0.5
+	}
+
+	luma := func(x, y int) int {
+		p := src.Pix[src.PixOffset(x, y):]
+		return (299*int(p[0]) + 587*int(p[1]) + 114*int(p[2])) / 1000
+	}
+
+	// Project the energy map onto the axis being cropped.
+	horizontal := fracW < 1
+	n := h
+	if horizontal {
+		n = w
+	}
+	energy := make([]int, n)
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			gx := luma(x+1, y) - luma(x-1, y)
+			gy := luma(x, y+1) - luma(x, y-1)
+			e := abs(gx) + abs(gy)
+			if horizontal {
+				energy[x] += e
+			} else {
+				energy[y] += e
+			}
+		}
+	}
+
+	frac := fracH
+	if horizontal {
+		frac = fracW
+	}
+	window := max(int(float64(n)*frac), 1)
+	if window >= n {
+		return 0.5
+	}
+
+	sum := 0
+	for i := 0; i < window; i++ {
+		sum += energy[i]
+	}
+	best, bestStart := sum, 0
+	for start := 1; start+window <= n; start++ {
+		sum += energy[start+window-1] - energy[start-1]
+		if sum > best {
+			best, bestStart = sum, start
+		}
+	}
+	if best == 0 {
+		return 0.5
+	}
+	return float64(bestStart) / float64(n-window)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
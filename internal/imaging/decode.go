@@ -0,0 +1,61 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+var (
+	ErrTooManyPixels  = errors.New("image exceeds the maximum pixel count")
+	ErrFormatMismatch = errors.New("image content does not match its declared type")
+)
+
+// formatForMediaType maps a MIME type to the format name reported by the
+// image package's registered decoders.
+var formatForMediaType = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+}
+
+// Decode validates and decodes an image whose claimed type is mediaType.
+// The header is inspected first so that decompression bombs are rejected
+// before any pixel data is allocated. Any EXIF orientation is applied to
+// the returned image.
+func Decode(r io.ReadSeeker, mediaType string, maxPixels int) (image.Image, error) {
+	expectedFormat, ok := formatForMediaType[mediaType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported media type: %s", mediaType)
+	}
+
+	imgCfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read image header: %w", err)
+	}
+	if format != expectedFormat {
+		return nil, ErrFormatMismatch
+	}
+	if imgCfg.Width <= 0 || imgCfg.Height <= 0 {
+		return nil, errors.New("image has no pixels")
+	}
+	if imgCfg.Width*imgCfg.Height > maxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = Orientation(r)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode image: %w", err)
+	}
+	return ApplyOrientation(img, orientation), nil
+}
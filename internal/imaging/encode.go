@@ -0,0 +1,25 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+const jpegQuality = 90
+
+// Encode writes img in the format for mediaType. Only pixel data is
+// written, so any metadata carried by the original upload (EXIF GPS tags,
+// camera serials, embedded previews) is dropped.
+func Encode(w io.Writer, img image.Image, mediaType string) error {
+	switch mediaType {
+	case "image/jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		return png.Encode(w, img)
+	default:
+		return fmt.Errorf("unsupported media type: %s", mediaType)
+	}
+}
@@ -0,0 +1,244 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	// heicConvertTimeout bounds how long ffmpeg may spend on one image
+	heicConvertTimeout = 30 * time.Second
+	// maxHEIFMetaSize caps the meta box read to find an image's size
+	maxHEIFMetaSize = 4 << 20
+)
+
+// heifBrands are the ISO base media "ftyp" brands used by HEIC/HEIF stills.
+var heifBrands = map[string]bool{
+	"heic": true,
+	"heix": true,
+	"heim": true,
+	"heis": true,
+	"mif1": true,
+	"msf1": true,
+}
+
+// IsHEIF reports whether the stream starts with an ftyp box declaring a
+// HEIF brand.
+func IsHEIF(r io.ReadSeeker) bool {
+	defer r.Seek(0, io.SeekStart)
+
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return false
+	}
+	return string(header[4:8]) == "ftyp" && heifBrands[string(header[8:12])]
+}
+
+// ConvertHEIC transcodes a HEIC/HEIF image to PNG using the ffmpeg binary at
+// ffmpegPath so it can be validated and re-encoded with the standard library
+// decoders. Images over maxPixels are refused from the size they declare,
+// before ffmpeg decodes anything, and ffmpeg itself is held to maxPixels
+// and a time limit.
+func ConvertHEIC(ctx context.Context, ffmpegPath string, r io.ReadSeeker, maxPixels int) (*bytes.Reader, error) {
+	if !IsHEIF(r) {
+		return nil, ErrFormatMismatch
+	}
+	width, height, err := heifImageSize(r)
+	if err != nil {
+		return nil, err
+	}
+	if width > maxPixels || height > maxPixels || width*height > maxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	// HEIF containers need a seekable input, so ffmpeg reads from a temp
+	// file rather than stdin.
+	src, err := os.CreateTemp("", "tubely-thumbnail-*.heic")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(src.Name())
+	defer src.Close()
+	if _, err := io.Copy(src, r); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, heicConvertTimeout)
+	defer cancel()
+	// A PNG can't be much larger than its raw pixels
+	stdout := &cappedBuffer{limit: 8*maxPixels + 1<<20}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegPath,
+		"-v", "error",
+		"-max_pixels", strconv.Itoa(maxPixels),
+		"-i", src.Name(),
+		"-frames:v", "1",
+		"-f", "image2pipe",
+		"-c:v", "png",
+		"pipe:1",
+	)
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %s: %s", err, stderr.String())
+	}
+	if stdout.Len() == 0 {
+		return nil, errors.New("ffmpeg produced no image")
+	}
+	return bytes.NewReader(stdout.Bytes()), nil
+}
+
+// heifImageSize returns the largest size declared by the ispe (image
+// spatial extents) properties of a HEIF file. Every image in the file,
+// grids included, must have one, so nothing ffmpeg decodes from it is
+// bigger.
+func heifImageSize(r io.ReadSeeker) (int, int, error) {
+	defer r.Seek(0, io.SeekStart)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return 0, 0, errors.New("HEIF file has no meta box")
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// The box runs to the end of the file
+			size = -1
+		case 1:
+			var large [8]byte
+			if _, err := io.ReadFull(r, large[:]); err != nil {
+				return 0, 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(large[:]))
+			headerSize = 16
+		}
+		if size != -1 && size < headerSize {
+			return 0, 0, errors.New("invalid HEIF box size")
+		}
+
+		if boxType != "meta" {
+			if size == -1 {
+				return 0, 0, errors.New("HEIF file has no meta box")
+			}
+			if _, err := r.Seek(size-headerSize, io.SeekCurrent); err != nil {
+				return 0, 0, err
+			}
+			continue
+		}
+		if size > maxHEIFMetaSize {
+			return 0, 0, errors.New("HEIF meta box is too large")
+		}
+		meta, err := io.ReadAll(io.LimitReader(r, maxHEIFMetaSize))
+		if err != nil {
+			return 0, 0, err
+		}
+		if size != -1 {
+			if int64(len(meta)) < size-headerSize {
+				return 0, 0, io.ErrUnexpectedEOF
+			}
+			meta = meta[:size-headerSize]
+		}
+		return ispeSize(meta)
+	}
+}
+
+// ispeSize finds the largest ispe property in the payload of a meta box,
+// at meta/iprp/ipco/ispe.
+func ispeSize(meta []byte) (int, int, error) {
+	// meta is a full box, starting with its version and flags
+	if len(meta) < 4 {
+		return 0, 0, errors.New("HEIF meta box is truncated")
+	}
+	var width, height int
+	found := false
+	err := eachBox(meta[4:], func(boxType string, iprp []byte) error {
+		if boxType != "iprp" {
+			return nil
+		}
+		return eachBox(iprp, func(boxType string, ipco []byte) error {
+			if boxType != "ipco" {
+				return nil
+			}
+			return eachBox(ipco, func(boxType string, ispe []byte) error {
+				if boxType != "ispe" {
+					return nil
+				}
+				if len(ispe) < 12 {
+					return errors.New("HEIF ispe property is truncated")
+				}
+				w := int(binary.BigEndian.Uint32(ispe[4:8]))
+				h := int(binary.BigEndian.Uint32(ispe[8:12]))
+				if !found || uint64(w)*uint64(h) > uint64(width)*uint64(height) {
+					width, height = w, h
+				}
+				found = true
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		return 0, 0, errors.New("HEIF file doesn't declare its image size")
+	}
+	return width, height, nil
+}
+
+// eachBox calls fn with the type and payload of each box in data.
+func eachBox(data []byte, fn func(boxType string, payload []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return errors.New("HEIF box is truncated")
+		}
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		boxType := string(data[4:8])
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return errors.New("HEIF box is truncated")
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return errors.New("invalid HEIF box size")
+		}
+		if err := fn(boxType, data[headerSize:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// cappedBuffer is a bytes.Buffer that fails writes past limit, stopping a
+// runaway ffmpeg.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, ErrTooManyPixels
+	}
+	return b.Buffer.Write(p)
+}
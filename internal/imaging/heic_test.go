@@ -0,0 +1,76 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func box(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, boxType...), body...)
+}
+
+func ispe(width, height uint32) []byte {
+	payload := make([]byte, 4, 12)
+	payload = binary.BigEndian.AppendUint32(payload, width)
+	payload = binary.BigEndian.AppendUint32(payload, height)
+	return box("ispe", payload)
+}
+
+func heif(properties ...[]byte) []byte {
+	return bytes.Join([][]byte{
+		box("ftyp", []byte("heic"), make([]byte, 4), []byte("mif1heic")),
+		box("meta", make([]byte, 4), box("hdlr", make([]byte, 24)), box("iprp", box("ipco", properties...))),
+		box("mdat", make([]byte, 16)),
+	}, nil)
+}
+
+func TestHEIFImageSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		file          []byte
+		width, height int
+		wantErr       bool
+	}{
+		{name: "single image", file: heif(ispe(640, 480)), width: 640, height: 480},
+		{name: "grid and tiles", file: heif(ispe(512, 512), ispe(65535, 65535), ispe(512, 512)), width: 65535, height: 65535},
+		{name: "no ispe", file: heif(box("colr", make([]byte, 4))), wantErr: true},
+		{name: "truncated", file: heif(ispe(640, 480))[:60], wantErr: true},
+		{name: "no meta", file: box("ftyp", []byte("heic")), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(tt.file)
+			width, height, err := heifImageSize(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("heifImageSize() = %dx%d, want an error", width, height)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("heifImageSize() error = %v", err)
+			}
+			if width != tt.width || height != tt.height {
+				t.Errorf("heifImageSize() = %dx%d, want %dx%d", width, height, tt.width, tt.height)
+			}
+			if pos, _ := r.Seek(0, io.SeekCurrent); pos != 0 {
+				t.Errorf("reader left at %d, want 0", pos)
+			}
+		})
+	}
+}
+
+func TestConvertHEICRejectsLargeImagesBeforeDecoding(t *testing.T) {
+	// ffmpeg isn't run for images over the limit, so a missing binary is
+	// never reached
+	_, err := ConvertHEIC(context.Background(), "/nonexistent/ffmpeg", bytes.NewReader(heif(ispe(20000, 20000))), 40_000_000)
+	if !errors.Is(err, ErrTooManyPixels) {
+		t.Fatalf("ConvertHEIC() error = %v, want ErrTooManyPixels", err)
+	}
+}
@@ -0,0 +1,76 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+const (
+	pHashSampleSize = 32
+	pHashSize       = 8
+)
+
+// PHash computes a 64-bit DCT perceptual hash of img. Visually similar
+// images produce hashes with a small Hamming distance, regardless of
+// scaling or re-compression.
+func PHash(img image.Image) uint64 {
+	src := toRGBA(Resize(img, pHashSampleSize, pHashSampleSize))
+
+	var gray [pHashSampleSize][pHashSampleSize]float64
+	for y := 0; y < pHashSampleSize; y++ {
+		for x := 0; x < pHashSampleSize; x++ {
+			p := src.Pix[src.PixOffset(x, y):]
+			gray[y][x] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+		}
+	}
+
+	// Only the low-frequency top-left block of the 2D DCT is needed.
+	var coeffs [pHashSize * pHashSize]float64
+	for v := 0; v < pHashSize; v++ {
+		for u := 0; u < pHashSize; u++ {
+			var sum float64
+			for y := 0; y < pHashSampleSize; y++ {
+				cy := math.Cos(float64(2*y+1) * float64(v) * math.Pi / (2 * pHashSampleSize))
+				for x := 0; x < pHashSampleSize; x++ {
+					cx := math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * pHashSampleSize))
+					sum += gray[y][x] * cx * cy
+				}
+			}
+			coeffs[v*pHashSize+u] = sum
+		}
+	}
+
+	// The DC term dominates and says nothing about structure, so it is
+	// excluded from the median.
+	sorted := make([]float64, len(coeffs)-1)
+	copy(sorted, coeffs[1:])
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, c := range coeffs {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// FormatPHash renders a hash as a fixed-width hex string for storage.
+func FormatPHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// ParsePHash is the inverse of FormatPHash.
+func ParsePHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// PHashDistance returns the Hamming distance between two hashes.
+func PHashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
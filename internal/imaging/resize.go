@@ -0,0 +1,69 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Fit downscales img so that it fits within maxWidth x maxHeight while
+// preserving its aspect ratio. Images that already fit are returned as-is;
+// images are never upscaled.
+func Fit(img image.Image, maxWidth, maxHeight int) image.Image {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return img
+	}
+
+	dw, dh := maxWidth, h*maxWidth/w
+	if dh > maxHeight {
+		dw, dh = w*maxHeight/h, maxHeight
+	}
+	return Resize(img, max(dw, 1), max(dh, 1))
+}
+
+// Resize scales img down to exactly width x height by averaging the block
+// of source pixels covered by each destination pixel.
+func Resize(img image.Image, width, height int) image.Image {
+	src := toRGBA(img)
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for dy := 0; dy < height; dy++ {
+		sy0 := dy * sh / height
+		sy1 := max((dy+1)*sh/height, sy0+1)
+		for dx := 0; dx < width; dx++ {
+			sx0 := dx * sw / width
+			sx1 := max((dx+1)*sw/width, sx0+1)
+
+			var r, g, b, a, n int
+			for sy := sy0; sy < sy1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := sx0; sx < sx1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i+0] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// toRGBA returns img as a zero-origin *image.RGBA, converting only when
+// necessary.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,132 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// FrameClassifier samples frames from a video with ffmpeg and posts each
+// one (as image/jpeg) to an HTTP classifier endpoint, such as an NSFW model
+// server or a small proxy in front of AWS Rekognition. The endpoint must
+// respond with JSON of the form {"score": 0.93, "label": "explicit"}, where
+// score is the probability the frame is objectionable.
+type FrameClassifier struct {
+	Endpoint            string
+	Frames              int
+	FlagThreshold       float64
+	QuarantineThreshold float64
+	Client              *http.Client
+	// FFmpegPath is the ffmpeg binary frames are sampled with, "ffmpeg"
+	// on PATH if empty.
+	FFmpegPath string
+}
+
+func (c FrameClassifier) Moderate(ctx context.Context, videoPath string) (Result, error) {
+	frameDir, err := os.MkdirTemp("", "tubely-moderation-")
+	if err != nil {
+		return Result{}, err
+	}
+	defer os.RemoveAll(frameDir)
+
+	frames, err := sampleFrames(ctx, c.FFmpegPath, videoPath, frameDir, c.Frames)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Verdict: VerdictApproved}
+	for _, frame := range frames {
+		score, label, err := c.classify(ctx, frame)
+		if err != nil {
+			return Result{}, err
+		}
+		if score > result.Score {
+			result.Score = score
+			result.Reason = label
+		}
+	}
+
+	switch {
+	case result.Score >= c.QuarantineThreshold:
+		result.Verdict = VerdictQuarantined
+	case result.Score >= c.FlagThreshold:
+		result.Verdict = VerdictFlagged
+	default:
+		result.Reason = ""
+	}
+	return result, nil
+}
+
+func (c FrameClassifier) classify(ctx context.Context, framePath string) (float64, string, error) {
+	frame, err := os.ReadFile(framePath)
+	if err != nil {
+		return 0, "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint, bytes.NewReader(frame))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "image/jpeg")
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("classifier request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", fmt.Errorf("classifier responded with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Score float64 `json:"score"`
+		Label string  `json:"label"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, "", fmt.Errorf("couldn't decode classifier response: %w", err)
+	}
+	return body.Score, body.Label, nil
+}
+
+// sampleFrames extracts up to count representative keyframes from the
+// video using ffmpeg's thumbnail filter.
+func sampleFrames(ctx context.Context, ffmpegPath, videoPath, dir string, count int) ([]string, error) {
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegPath,
+		"-v", "error",
+		"-skip_frame", "nokey",
+		"-i", videoPath,
+		"-vf", "thumbnail,scale=512:-2",
+		"-fps_mode", "vfr",
+		"-frames:v", fmt.Sprint(count),
+		filepath.Join(dir, "frame-%03d.jpg"),
+	)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %s: %s", err, stderr.String())
+	}
+
+	frames, err := filepath.Glob(filepath.Join(dir, "frame-*.jpg"))
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("no frames could be sampled from video")
+	}
+	sort.Strings(frames)
+	return frames, nil
+}
@@ -0,0 +1,31 @@
+package moderation
+
+import (
+	"context"
+)
+
+type Verdict string
+
+const (
+	VerdictApproved    Verdict = "approved"
+	VerdictFlagged     Verdict = "flagged"
+	VerdictQuarantined Verdict = "quarantined"
+)
+
+type Result struct {
+	Verdict Verdict
+	Score   float64
+	Reason  string
+}
+
+// Moderator inspects a processed video file before it becomes playable.
+type Moderator interface {
+	Moderate(ctx context.Context, videoPath string) (Result, error)
+}
+
+// Noop approves everything. It is used when no classifier is configured.
+type Noop struct{}
+
+func (Noop) Moderate(ctx context.Context, videoPath string) (Result, error) {
+	return Result{Verdict: VerdictApproved}, nil
+}
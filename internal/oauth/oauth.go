@@ -0,0 +1,159 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Identity is the subset of a provider's user profile needed to provision
+// or link a local account.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// Provider implements the OAuth 2.0 authorization code flow (with PKCE)
+// against a single identity provider.
+type Provider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	RedirectURL  string
+	Scopes       []string
+
+	// fetchIdentity turns an access token into an Identity. It differs
+	// between plain OAuth providers (GitHub) and OIDC providers.
+	fetchIdentity func(ctx context.Context, p *Provider, accessToken string) (Identity, error)
+	client        *http.Client
+}
+
+// RandomToken returns a URL-safe random string for use as a state value
+// or PKCE verifier.
+func RandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL returns the provider URL the user is redirected to.
+func (p *Provider) AuthCodeURL(state, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + v.Encode()
+}
+
+// Exchange trades an authorization code for an access token and resolves
+// the user's identity with it.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := p.doJSON(req, &token); err != nil {
+		return Identity{}, fmt.Errorf("token exchange failed: %w", err)
+	}
+	if token.Error != "" {
+		return Identity{}, fmt.Errorf("token exchange failed: %s: %s", token.Error, token.Description)
+	}
+	if token.AccessToken == "" {
+		return Identity{}, errors.New("token exchange returned no access token")
+	}
+
+	identity, err := p.fetchIdentity(ctx, p, token.AccessToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	if identity.Subject == "" {
+		return Identity{}, errors.New("provider returned no subject")
+	}
+	return identity, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint, accessToken string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	return p.doJSON(req, dst)
+}
+
+func (p *Provider) doJSON(req *http.Request, dst any) error {
+	client := p.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s responded with status %d: %s", req.URL.Host, resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, dst)
+}
+
+// oidcIdentity reads the standard OpenID Connect userinfo claims.
+func oidcIdentity(ctx context.Context, p *Provider, accessToken string) (Identity, error) {
+	var info struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified any    `json:"email_verified"`
+	}
+	if err := p.getJSON(ctx, p.UserInfoURL, accessToken, &info); err != nil {
+		return Identity{}, fmt.Errorf("userinfo request failed: %w", err)
+	}
+	// Some providers send email_verified as the string "true"
+	verified := info.EmailVerified == true || info.EmailVerified == "true"
+	return Identity{Subject: info.Subject, Email: info.Email, EmailVerified: verified}, nil
+}
@@ -0,0 +1,95 @@
+// Package ratelimit implements in-memory token buckets keyed by an
+// arbitrary string, such as an API key ID or a client IP.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is a bucket's refill rate and capacity. The zero Limit is
+// unlimited.
+type Limit struct {
+	// Rate is how many tokens are added per second
+	Rate float64
+	// Burst is the bucket's capacity
+	Burst int
+}
+
+// PerMinute returns the Limit allowing n requests a minute, bursting to n.
+func PerMinute(n int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: n}
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// Result describes the outcome of taking a token.
+type Result struct {
+	Allowed bool
+	// Remaining is how many whole tokens are left in the bucket
+	Remaining int
+	// RetryAfter is how long until a token is available when Allowed is
+	// false
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will have refilled completely
+	full time.Time
+}
+
+// Limiter holds one bucket per key. Buckets that have refilled completely
+// are forgotten, since a new bucket starts full anyway.
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{buckets: map[string]*bucket{}}
+}
+
+// Allow takes a token from key's bucket under limit.
+func (l *Limiter) Allow(key string, limit Limit, now time.Time) Result {
+	if limit.Unlimited() {
+		return Result{Allowed: true, Remaining: math.MaxInt32}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+		return Result{RetryAfter: wait}
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(limit.Burst) - b.tokens) / limit.Rate * float64(time.Second)))
+	return Result{Allowed: true, Remaining: int(b.tokens)}
+}
+
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if now.After(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
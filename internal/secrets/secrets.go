@@ -0,0 +1,143 @@
+// Package secrets reads configuration values kept in AWS Secrets Manager
+// or SSM Parameter Store.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const (
+	secretsManagerPrefix = "secretsmanager:"
+	ssmPrefix            = "ssm:"
+)
+
+// IsRef reports whether v refers to a stored value rather than being one.
+func IsRef(v string) bool {
+	return strings.HasPrefix(v, secretsManagerPrefix) || strings.HasPrefix(v, ssmPrefix)
+}
+
+// Resolver fetches the values references point at.
+type Resolver struct {
+	Region      string
+	Credentials aws.CredentialsProvider
+	Client      *http.Client
+	// Endpoint, when set, replaces the AWS service endpoints, e.g. with
+	// LocalStack's.
+	Endpoint string
+}
+
+// Resolve returns the value ref points at. References look like
+// "secretsmanager:<secret-id>", "secretsmanager:<secret-id>#<key>" for one
+// field of a JSON secret, or "ssm:<parameter-name>". SecureString
+// parameters are decrypted.
+func (r Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	if name, ok := strings.CutPrefix(ref, ssmPrefix); ok {
+		var out struct {
+			Parameter struct {
+				Value string
+			}
+		}
+		err := r.call(ctx, "ssm", "AmazonSSM.GetParameter", map[string]any{
+			"Name":           name,
+			"WithDecryption": true,
+		}, &out)
+		if err != nil {
+			return "", err
+		}
+		return out.Parameter.Value, nil
+	}
+
+	id, ok := strings.CutPrefix(ref, secretsManagerPrefix)
+	if !ok {
+		return "", fmt.Errorf("%q is not a secretsmanager: or ssm: reference", ref)
+	}
+	id, field, hasField := strings.Cut(id, "#")
+	var out struct {
+		SecretString string
+	}
+	err := r.call(ctx, "secretsmanager", "secretsmanager.GetSecretValue", map[string]any{
+		"SecretId": id,
+	}, &out)
+	if err != nil {
+		return "", err
+	}
+	if !hasField {
+		return out.SecretString, nil
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(out.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object: %w", id, err)
+	}
+	switch v := fields[field].(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", fmt.Errorf("secret %s has no %q field", id, field)
+	default:
+		// Numbers and booleans, e.g. a port
+		data, _ := json.Marshal(v)
+		return string(data), nil
+	}
+}
+
+// call makes a signed request to an AWS JSON API. The SDK clients aren't
+// worth two dependencies for one operation each.
+func (r Resolver) call(ctx context.Context, service, target string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	endpoint := r.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + service + "." + r.Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+
+	creds, err := r.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256.Sum256(body)
+	err = v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), service, r.Region, time.Now())
+	if err != nil {
+		return err
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var awsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		json.Unmarshal(data, &awsErr)
+		return fmt.Errorf("%s failed: %s %s", target, awsErr.Type, awsErr.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// ErrUnavailable is returned without contacting the store while its
+// circuit breaker is open.
+var ErrUnavailable = errors.New("storage is temporarily unavailable")
+
+// Breaker stops calls to a store that keeps failing, so requests fail
+// fast during an outage instead of each waiting out its own timeout.
+// After Threshold consecutive failures it rejects calls for Cooldown,
+// then lets a single call through to see whether the store is back. A
+// nil *Breaker allows everything.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	// Name identifies the store in log messages.
+	Name string
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func NewBreaker(name string, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Name: name, Threshold: threshold, Cooldown: cooldown}
+}
+
+// Allow returns ErrUnavailable if a call shouldn't be attempted now.
+// Every allowed call must be followed by Record.
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.Threshold {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return ErrUnavailable
+	}
+	b.probing = true
+	return nil
+}
+
+// Open reports whether calls are currently being rejected.
+func (b *Breaker) Open() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.Threshold && (time.Now().Before(b.openUntil) || b.probing)
+}
+
+// Record notes the outcome of an allowed call. Errors that say nothing
+// about the store's health, like a missing key or the caller giving up,
+// count as successes.
+func (b *Breaker) Record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.failures >= b.Threshold
+	b.probing = false
+	if !isOutage(err) {
+		if wasOpen {
+			log.Printf("Storage %s: circuit closed, calls resumed", b.Name)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.Threshold {
+		b.openUntil = time.Now().Add(b.Cooldown)
+		if !wasOpen {
+			log.Printf("Storage %s: circuit open after %d consecutive failures: %v", b.Name, b.failures, err)
+		}
+	}
+}
+
+// isOutage reports whether err suggests the store is down: a network
+// error, a timeout, or a 5xx or throttling response.
+func isOutage(err error) bool {
+	if err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		// Requests that never got a response report status 0
+		status := respErr.HTTPStatusCode()
+		return status == 0 || status >= 500 || status == 429
+	}
+	var apiErr smithy.APIError
+	return !errors.As(err, &apiErr)
+}
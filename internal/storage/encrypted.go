@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// encryptedMagic starts every object written by EncryptedStore.
+const encryptedMagic = "TBE1"
+
+// encryptedChunkSize is how much plaintext each sealed chunk holds, so
+// objects can be decrypted as a stream rather than all at once.
+const encryptedChunkSize = 64 << 10
+
+var ErrCorruptObject = errors.New("encrypted object is corrupt")
+
+// KeyProvider issues and unwraps per-object data keys.
+type KeyProvider interface {
+	// GenerateDataKey returns a new 256-bit data key and the wrapped form
+	// of it that is stored alongside the object.
+	GenerateDataKey(ctx context.Context) (plaintext, wrapped []byte, err error)
+	DecryptDataKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// EncryptedStore encrypts objects before handing them to Store, using a
+// fresh data key per object (envelope encryption). Objects are laid out
+// as:
+//
+//	"TBE1" | uint16 wrapped key length | wrapped key | 7-byte nonce prefix | chunks
+//
+// where each chunk is up to encryptedChunkSize bytes of plaintext sealed
+// with AES-256-GCM. A chunk's nonce is the prefix, its big-endian index
+// and a byte marking the final chunk, so reordered or truncated objects
+// fail to decrypt. Presigned URLs would expose ciphertext, so objects can
+// only be read back through GetObject.
+type EncryptedStore struct {
+	Store
+	Keys KeyProvider
+}
+
+func (s EncryptedStore) PutObject(ctx context.Context, key string, body io.Reader, opts PutOptions) error {
+	dataKey, wrapped, err := s.Keys.GenerateDataKey(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't generate data key: %w", err)
+	}
+	if len(wrapped) > 0xffff {
+		return fmt.Errorf("wrapped data key is too long")
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	// Stores may need to seek (S3 signs the payload), so the ciphertext
+	// is staged in a temp file rather than streamed
+	tmp, err := os.CreateTemp("", "tubely-encrypted-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	prefix := make([]byte, 7)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	header := make([]byte, 0, len(encryptedMagic)+2+len(wrapped)+len(prefix))
+	header = append(header, encryptedMagic...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	header = append(header, prefix...)
+	// The wrapped store checks the ciphertext, so check the plaintext
+	// here
+	sealedHash := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(tmp, sealedHash))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	plainHash := sha256.New()
+	in := bufio.NewReaderSize(io.TeeReader(body, plainHash), encryptedChunkSize)
+	buf := make([]byte, encryptedChunkSize)
+	sealed := make([]byte, 0, encryptedChunkSize+aead.Overhead())
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(in, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := n < len(buf)
+		if !last {
+			_, peekErr := in.Peek(1)
+			last = peekErr == io.EOF
+		}
+		sealed = aead.Seal(sealed[:0], chunkNonce(prefix, index, last), buf[:n], nil)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			break
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if opts.SHA256 != nil && !bytes.Equal(plainHash.Sum(nil), opts.SHA256) {
+		return ErrChecksumMismatch
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	opts.ContentType = "application/octet-stream"
+	opts.SHA256 = sealedHash.Sum(nil)
+	return s.Store.PutObject(ctx, key, tmp, opts)
+}
+
+func (s EncryptedStore) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.Store.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	in := bufio.NewReaderSize(obj, encryptedChunkSize+aes.BlockSize)
+
+	header := make([]byte, len(encryptedMagic)+2)
+	if _, err := io.ReadFull(in, header); err != nil || string(header[:len(encryptedMagic)]) != encryptedMagic {
+		obj.Close()
+		return nil, ErrCorruptObject
+	}
+	wrapped := make([]byte, binary.BigEndian.Uint16(header[len(encryptedMagic):]))
+	prefix := make([]byte, 7)
+	if _, err := io.ReadFull(in, wrapped); err != nil {
+		obj.Close()
+		return nil, ErrCorruptObject
+	}
+	if _, err := io.ReadFull(in, prefix); err != nil {
+		obj.Close()
+		return nil, ErrCorruptObject
+	}
+
+	dataKey, err := s.Keys.DecryptDataKey(ctx, wrapped)
+	if err != nil {
+		obj.Close()
+		return nil, fmt.Errorf("couldn't decrypt data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return &decryptReader{
+		obj:    obj,
+		in:     in,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, encryptedChunkSize+aead.Overhead()),
+	}, nil
+}
+
+// SetStorageClass moves the ciphertext if the underlying store supports
+// storage classes.
+func (s EncryptedStore) SetStorageClass(ctx context.Context, key, class string) error {
+	mover, ok := s.Store.(ClassMover)
+	if !ok {
+		return fmt.Errorf("store doesn't support storage classes")
+	}
+	return mover.SetStorageClass(ctx, key, class)
+}
+
+// ListObjects lists the stored ciphertexts, so sizes include the
+// encryption overhead.
+func (s EncryptedStore) ListObjects(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	lister, ok := s.Store.(Lister)
+	if !ok {
+		return fmt.Errorf("store doesn't support listing")
+	}
+	return lister.ListObjects(ctx, prefix, fn)
+}
+
+func (s EncryptedStore) RestoreObject(ctx context.Context, key string, days int, tier string) error {
+	restorer, ok := s.Store.(Restorer)
+	if !ok {
+		return fmt.Errorf("store doesn't support archiving")
+	}
+	return restorer.RestoreObject(ctx, key, days, tier)
+}
+
+func (s EncryptedStore) RestoreStatus(ctx context.Context, key string) (RestoreStatus, error) {
+	restorer, ok := s.Store.(Restorer)
+	if !ok {
+		return RestoreStatus{}, fmt.Errorf("store doesn't support archiving")
+	}
+	return restorer.RestoreStatus(ctx, key)
+}
+
+func (s EncryptedStore) Presign(ctx context.Context, key string, expiresIn time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+// decryptReader opens an EncryptedStore object's chunks as they are read.
+type decryptReader struct {
+	obj    io.Closer
+	in     *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	buf    []byte
+	plain  []byte
+	index  uint32
+	done   bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(d.in, d.buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				// The final chunk is always present, even if empty
+				return 0, ErrCorruptObject
+			}
+			return 0, err
+		}
+		last := n < len(d.buf)
+		if !last {
+			_, peekErr := d.in.Peek(1)
+			last = peekErr == io.EOF
+		}
+		d.plain, err = d.aead.Open(d.buf[:0], chunkNonce(d.prefix, d.index, last), d.buf[:n], nil)
+		if err != nil {
+			return 0, ErrCorruptObject
+		}
+		d.index++
+		d.done = last
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.obj.Close()
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, index)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// KMSKeyProvider gets data keys from AWS KMS, so the key that protects
+// them never leaves KMS.
+type KMSKeyProvider struct {
+	KeyID       string
+	Region      string
+	Credentials aws.CredentialsProvider
+	Client      *http.Client
+}
+
+func (k KMSKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	var out struct {
+		CiphertextBlob []byte
+		Plaintext      []byte
+	}
+	err := k.call(ctx, "GenerateDataKey", map[string]string{
+		"KeyId":   k.KeyID,
+		"KeySpec": "AES_256",
+	}, &out)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+func (k KMSKeyProvider) DecryptDataKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var out struct {
+		Plaintext []byte
+	}
+	err := k.call(ctx, "Decrypt", map[string]any{
+		"KeyId":          k.KeyID,
+		"CiphertextBlob": wrapped,
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// call makes a signed request to the KMS JSON API. The SDK's KMS client
+// isn't worth a dependency for two operations.
+func (k KMSKeyProvider) call(ctx context.Context, operation string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://kms."+k.Region+".amazonaws.com/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+operation)
+
+	creds, err := k.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256.Sum256(body)
+	err = v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), "kms", k.Region, time.Now())
+	if err != nil {
+		return err
+	}
+
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var kmsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		json.Unmarshal(data, &kmsErr)
+		return fmt.Errorf("kms %s failed: %s %s", operation, kmsErr.Type, kmsErr.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// StaticKeyProvider wraps data keys with a fixed 256-bit master key. It is
+// meant for development, where there is no KMS to hand.
+type StaticKeyProvider struct {
+	MasterKey []byte
+}
+
+func (k StaticKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	aead, err := newAEAD(k.MasterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey := make([]byte, 32)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return dataKey, aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func (k StaticKeyProvider) DecryptDataKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(k.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped data key is too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+}
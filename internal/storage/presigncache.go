@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// presignCacheSweepSize is how many entries the cache holds before it
+// drops expired ones.
+const presignCacheSweepSize = 10000
+
+// PresignCache reuses presigned URLs while at least half their lifetime
+// remains, so list pages and repeat viewers don't each pay for a fresh
+// signature. Concurrent requests for the same URL share one signing call.
+type PresignCache struct {
+	mu      sync.Mutex
+	entries map[string]presignEntry
+	calls   map[string]*presignCall
+}
+
+type presignEntry struct {
+	url       string
+	expiresAt time.Time
+	reuseBy   time.Time
+}
+
+type presignCall struct {
+	done  chan struct{}
+	entry presignEntry
+	err   error
+}
+
+func NewPresignCache() *PresignCache {
+	return &PresignCache{
+		entries: map[string]presignEntry{},
+		calls:   map[string]*presignCall{},
+	}
+}
+
+// Get returns a cached URL for cacheKey if one is still fresh enough for a
+// caller asking for expiresIn, and otherwise calls sign. The returned
+// expiry is the URL's real one, which may be sooner than expiresIn.
+func (c *PresignCache) Get(cacheKey string, expiresIn time.Duration, sign func() (string, error)) (string, time.Time, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	if entry, ok := c.entries[cacheKey]; ok && now.Before(entry.reuseBy) {
+		c.mu.Unlock()
+		return entry.url, entry.expiresAt, nil
+	}
+	if call, ok := c.calls[cacheKey]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.entry.url, call.entry.expiresAt, call.err
+	}
+	call := &presignCall{done: make(chan struct{})}
+	c.calls[cacheKey] = call
+	c.mu.Unlock()
+
+	url, err := sign()
+	call.entry = presignEntry{
+		url:       url,
+		expiresAt: now.Add(expiresIn).UTC(),
+		reuseBy:   now.Add(expiresIn / 2),
+	}
+	call.err = err
+
+	c.mu.Lock()
+	delete(c.calls, cacheKey)
+	if err == nil {
+		if len(c.entries) >= presignCacheSweepSize {
+			c.sweep(now)
+		}
+		c.entries[cacheKey] = call.entry
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.entry.url, call.entry.expiresAt, err
+}
+
+func (c *PresignCache) sweep(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.reuseBy) {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+var (
+	ErrNotFound           = errors.New("object not found")
+	ErrPresignUnsupported = errors.New("store can't presign URLs")
+	ErrChecksumMismatch   = errors.New("object doesn't match its checksum")
+)
+
+// PutOptions describes how an object should be served once stored.
+type PutOptions struct {
+	ContentType  string
+	CacheControl string
+	// StorageClass is honored by stores that have them, e.g. "STANDARD_IA"
+	// on S3.
+	StorageClass string
+	// SHA256 is the digest of body, if known. Stores reject the upload
+	// with ErrChecksumMismatch if what they received doesn't match.
+	SHA256 []byte
+}
+
+// Store holds uploaded media. Keys are slash-separated paths relative to
+// the store's root, e.g. "landscape/abc.mp4".
+type Store interface {
+	PutObject(ctx context.Context, key string, body io.Reader, opts PutOptions) error
+	// GetObject opens an object for reading. It returns ErrNotFound if
+	// there is no object at key.
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// Presign returns a URL that allows anyone holding it to GET the
+	// object until expiresIn has passed, or ErrPresignUnsupported if
+	// objects can only be read through GetObject.
+	Presign(ctx context.Context, key string, expiresIn time.Duration) (string, error)
+}
+
+// RangeReader is implemented by stores that can read part of an object
+// without fetching the rest of it.
+type RangeReader interface {
+	// GetObjectRange opens length bytes of the object at key, starting at
+	// offset. It returns ErrNotFound if there is no object at key.
+	GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	// ETag is the store's content fingerprint, if it has one.
+	ETag string
+}
+
+// Lister is implemented by stores that can enumerate their objects.
+type Lister interface {
+	// ListObjects calls fn for every object whose key starts with prefix,
+	// stopping at the first error fn returns.
+	ListObjects(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+}
+
+// ClassMover is implemented by stores that can move an existing object to
+// a different storage class.
+type ClassMover interface {
+	SetStorageClass(ctx context.Context, key, class string) error
+}
+
+// RestoreStatus describes the temporary copy of an archived object.
+type RestoreStatus struct {
+	// Ongoing is true while a restore is in progress.
+	Ongoing bool
+	// ExpiresAt is when a finished restore's copy is removed again. It is
+	// zero if there is no restored copy.
+	ExpiresAt time.Time
+}
+
+// Restorer is implemented by stores that archive objects in a class that
+// must be restored before it can be read, like S3 Glacier.
+type Restorer interface {
+	// RestoreObject asks for a temporary copy of an archived object that
+	// stays readable for days. Asking again while a restore is in
+	// progress is not an error.
+	RestoreObject(ctx context.Context, key string, days int, tier string) error
+	RestoreStatus(ctx context.Context, key string) (RestoreStatus, error)
+}
+
+// S3Store keeps objects in a single S3 bucket. It is the default store.
+type S3Store struct {
+	Client *s3.Client
+	Bucket string
+	// SSEKMSKeyID, when set, encrypts new objects at rest with that KMS
+	// key. Reading them back needs SigV4-signed requests, which presigned
+	// URLs always are; the CDN needs kms:Decrypt on the key as well.
+	SSEKMSKeyID string
+	// Breaker, when set, fails calls fast while S3 is having an outage.
+	// Presign is never rejected, since it doesn't contact S3.
+	Breaker *Breaker
+}
+
+func NewS3Store(client *s3.Client, bucket string) S3Store {
+	return S3Store{Client: client, Bucket: bucket}
+}
+
+func (s S3Store) PutObject(ctx context.Context, key string, body io.Reader, opts PutOptions) error {
+	params := s3.PutObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+		Body:   body,
+	}
+	if opts.ContentType != "" {
+		params.ContentType = &opts.ContentType
+	}
+	if opts.CacheControl != "" {
+		params.CacheControl = &opts.CacheControl
+	}
+	if opts.StorageClass != "" {
+		params.StorageClass = types.StorageClass(opts.StorageClass)
+	}
+	if s.SSEKMSKeyID != "" {
+		params.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		params.SSEKMSKeyId = &s.SSEKMSKeyID
+	}
+	if opts.SHA256 != nil {
+		checksum := base64.StdEncoding.EncodeToString(opts.SHA256)
+		params.ChecksumSHA256 = &checksum
+	}
+	if err := s.Breaker.Allow(); err != nil {
+		return err
+	}
+	_, err := s.Client.PutObject(ctx, &params)
+	s.Breaker.Record(err)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "BadDigest" {
+		return fmt.Errorf("%w: %v", ErrChecksumMismatch, err)
+	}
+	return err
+}
+
+// SetStorageClass copies the object onto itself in the new class, which
+// is how S3 changes the class of an existing object. Objects over 5 GB
+// would need a multipart copy and are rejected by S3.
+func (s S3Store) SetStorageClass(ctx context.Context, key, class string) error {
+	source := s.Bucket + "/" + (&url.URL{Path: key}).EscapedPath()
+	params := s3.CopyObjectInput{
+		Bucket:            &s.Bucket,
+		Key:               &key,
+		CopySource:        &source,
+		StorageClass:      types.StorageClass(class),
+		MetadataDirective: types.MetadataDirectiveCopy,
+	}
+	if s.SSEKMSKeyID != "" {
+		params.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		params.SSEKMSKeyId = &s.SSEKMSKeyID
+	}
+	if err := s.Breaker.Allow(); err != nil {
+		return err
+	}
+	_, err := s.Client.CopyObject(ctx, &params)
+	s.Breaker.Record(err)
+	return err
+}
+
+func (s S3Store) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := s.Breaker.Allow(); err != nil {
+		return nil, err
+	}
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+	})
+	s.Breaker.Record(err)
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s S3Store) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if err := s.Breaker.Allow(); err != nil {
+		return nil, err
+	}
+	byteRange := fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+		Range:  &byteRange,
+	})
+	s.Breaker.Record(err)
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s S3Store) RestoreObject(ctx context.Context, key string, days int, tier string) error {
+	if err := s.Breaker.Allow(); err != nil {
+		return err
+	}
+	_, err := s.Client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+		RestoreRequest: &types.RestoreRequest{
+			Days:                 aws.Int32(int32(days)),
+			GlacierJobParameters: &types.GlacierJobParameters{Tier: types.Tier(tier)},
+		},
+	})
+	s.Breaker.Record(err)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	return err
+}
+
+// RestoreStatus reads the object's x-amz-restore header, which looks like
+// `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`.
+func (s S3Store) RestoreStatus(ctx context.Context, key string) (RestoreStatus, error) {
+	if err := s.Breaker.Allow(); err != nil {
+		return RestoreStatus{}, err
+	}
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+	})
+	s.Breaker.Record(err)
+	if err != nil {
+		return RestoreStatus{}, err
+	}
+	if out.Restore == nil {
+		return RestoreStatus{}, nil
+	}
+
+	status := RestoreStatus{Ongoing: strings.Contains(*out.Restore, `ongoing-request="true"`)}
+	if _, expiry, ok := strings.Cut(*out.Restore, `expiry-date="`); ok {
+		expiry, _, _ = strings.Cut(expiry, `"`)
+		if t, err := http.ParseTime(expiry); err == nil {
+			status.ExpiresAt = t
+		}
+	}
+	return status, nil
+}
+
+// StatObject describes the object at key without reading it. It returns
+// ErrNotFound if there is no object at key.
+func (s S3Store) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	if err := s.Breaker.Allow(); err != nil {
+		return ObjectInfo{}, err
+	}
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+	})
+	s.Breaker.Record(err)
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		LastModified: aws.ToTime(out.LastModified),
+		ETag:         strings.Trim(aws.ToString(out.ETag), `"`),
+	}, nil
+}
+
+func (s S3Store) ListObjects(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: &s.Bucket,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		if err := s.Breaker.Allow(); err != nil {
+			return err
+		}
+		page, err := paginator.NextPage(ctx)
+		s.Breaker.Record(err)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			info := ObjectInfo{
+				Key:  aws.ToString(obj.Key),
+				Size: aws.ToInt64(obj.Size),
+				ETag: strings.Trim(aws.ToString(obj.ETag), `"`),
+			}
+			if obj.LastModified != nil {
+				info.LastModified = *obj.LastModified
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s S3Store) Delete(ctx context.Context, key string) error {
+	if err := s.Breaker.Allow(); err != nil {
+		return err
+	}
+	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+	})
+	s.Breaker.Record(err)
+	return err
+}
+
+func (s S3Store) Presign(ctx context.Context, key string, expiresIn time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(s.Client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+	}, s3.WithPresignExpires(expiresIn))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
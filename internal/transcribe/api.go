@@ -0,0 +1,77 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// maxAPIResponseBytes caps how much of a transcription response is read.
+const maxAPIResponseBytes = 10 << 20
+
+// API posts audio to a speech-to-text service speaking the OpenAI
+// transcription API, such as OpenAI itself or a self-hosted server with
+// the same interface, and asks for WebVTT back.
+type API struct {
+	// Endpoint is the transcription URL, e.g.
+	// "https://api.openai.com/v1/audio/transcriptions".
+	Endpoint string
+	APIKey   string
+	Model    string
+	Client   *http.Client
+}
+
+func (a API) Transcribe(ctx context.Context, audioPath, language string) ([]byte, error) {
+	audio, err := os.Open(audioPath)
+	if err != nil {
+		return nil, err
+	}
+	defer audio.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := map[string]string{"model": a.Model, "language": language, "response_format": "vtt"}
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+	part, err := form.CreateFormFile("file", filepath.Base(audioPath))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, audio); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.Endpoint, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if a.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.APIKey)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("transcription request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("transcription service responded with status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxAPIResponseBytes))
+}
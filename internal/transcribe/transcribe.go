@@ -0,0 +1,44 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// Transcriber turns the speech in an audio file into WebVTT captions.
+type Transcriber interface {
+	// Transcribe returns captions for the audio at audioPath, spoken in
+	// language, a tag such as "en".
+	Transcribe(ctx context.Context, audioPath, language string) ([]byte, error)
+}
+
+// AudioExtension is the file extension of the audio ExtractAudio writes.
+const AudioExtension = ".m4a"
+
+// ExtractAudio writes the audio of a video to audioPath as low bitrate
+// mono AAC, which is all speech recognition needs and keeps uploads to
+// transcription APIs small.
+func ExtractAudio(ctx context.Context, ffmpegPath, videoPath, audioPath string) error {
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegPath,
+		"-v", "error",
+		"-y",
+		"-i", videoPath,
+		"-vn",
+		"-ac", "1",
+		"-ar", "16000",
+		"-c:a", "aac",
+		"-b:a", "32k",
+		audioPath,
+	)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg error: %s: %s", err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Whisper runs the openai-whisper command line tool on this machine.
+type Whisper struct {
+	// Path is the whisper binary, "whisper" on PATH if empty.
+	Path string
+	// Model names the model to load, such as "base" or "small".
+	Model string
+}
+
+func (w Whisper) Transcribe(ctx context.Context, audioPath, language string) ([]byte, error) {
+	outDir, err := os.MkdirTemp("", "tubely-whisper-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(outDir)
+
+	path := w.Path
+	if path == "" {
+		path = "whisper"
+	}
+	args := []string{audioPath, "--output_format", "vtt", "--output_dir", outDir, "--language", language, "--verbose", "False"}
+	if w.Model != "" {
+		args = append(args, "--model", w.Model)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("whisper error: %s: %s", err, stderr.String())
+	}
+
+	// whisper names its output after the input file
+	name := strings.TrimSuffix(filepath.Base(audioPath), filepath.Ext(audioPath)) + ".vtt"
+	return os.ReadFile(filepath.Join(outDir, name))
+}
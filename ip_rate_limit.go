@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/subtle"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// Endpoint groups limited by client IP. Each group has its own bucket per
+// address, so heavy playback doesn't lock anyone out of logging in.
+const (
+	ipLimitLogin       = "login"
+	ipLimitSignup      = "signup"
+	ipLimitPlayback    = "playback"
+	ipLimitReport      = "report"
+	ipLimitGuestUpload = "guest_upload"
+)
+
+// limitByIP is middleware applying the group's per-IP rate limit to
+// unauthenticated endpoints. Rejections are counted in the metrics.
+func (cfg *apiConfig) limitByIP(group string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := cfg.ipRateLimits[group]
+		result := cfg.ipLimiter.Allow(group+":"+clientIP(r), limit, time.Now())
+		if !result.Allowed {
+			cfg.ipRateLimitRejections.Inc(group)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			respondWithError(w, http.StatusTooManyRequests, "Too many requests, try again later", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handlerMetrics serves Prometheus metrics, behind METRICS_TOKEN when it
+// is set.
+func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
+	if cfg.metricsToken != "" {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.metricsToken)) != 1 {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate credentials", err)
+			return
+		}
+	}
+	cfg.metrics.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// languageTagPattern matches BCP 47 tags such as "en", "pt-BR" or
+// "zh-Hant-TW".
+var languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8}){0,2}$`)
+
+// normalizeLanguageTag checks a language tag and writes it in its usual
+// case, e.g. "pt-BR".
+func normalizeLanguageTag(tag string) (string, error) {
+	if !languageTagPattern.MatchString(tag) {
+		return "", fmt.Errorf("invalid language %q, expected a tag such as en or pt-BR", tag)
+	}
+	subtags := strings.Split(tag, "-")
+	subtags[0] = strings.ToLower(subtags[0])
+	for i, subtag := range subtags[1:] {
+		switch len(subtag) {
+		case 2:
+			subtags[i+1] = strings.ToUpper(subtag)
+		case 4:
+			subtags[i+1] = strings.ToUpper(subtag[:1]) + strings.ToLower(subtag[1:])
+		default:
+			subtags[i+1] = strings.ToLower(subtag)
+		}
+	}
+	return strings.Join(subtags, "-"), nil
+}
+
+// preferredLanguage picks the language from available that best suits
+// the request's Accept-Language header, or "" if none does. A tag matches
+// itself and its more specific variants, so a viewer asking for "pt" gets
+// "pt-BR" if that is all there is, and one asking for "pt-BR" falls back
+// to "pt".
+func preferredLanguage(r *http.Request, available []string) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	choices := []choice{}
+	for _, item := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			choices = append(choices, choice{tag, q})
+		}
+	}
+	// Stable, so equally weighted tags keep the client's order
+	slices.SortStableFunc(choices, func(a, b choice) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+
+	for _, c := range choices {
+		for tag := c.tag; tag != ""; {
+			for _, language := range available {
+				if strings.ToLower(language) == tag {
+					return language
+				}
+			}
+			for _, language := range available {
+				if strings.HasPrefix(strings.ToLower(language), tag+"-") {
+					return language
+				}
+			}
+			i := strings.LastIndex(tag, "-")
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// loginFailures counts recent failed logins per key: an email for
+// passwords, and an account or MFA token for second factors. Each kind of
+// key has its own prefix, so an email can't be chosen to collide with
+// another kind and spend its failures. Extra checks such as a captcha only
+// bother users after repeated failures.
+type loginFailures struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]loginFailureEntry
+}
+
+type loginFailureEntry struct {
+	count int
+	first time.Time
+}
+
+func emailFailureKey(email string) string {
+	return "email:" + email
+}
+
+func mfaAccountFailureKey(userID uuid.UUID) string {
+	return "mfa-user:" + userID.String()
+}
+
+func mfaTokenFailureKey(tokenID string) string {
+	return "mfa-token:" + tokenID
+}
+
+func newLoginFailures(window time.Duration) *loginFailures {
+	return &loginFailures{window: window, entries: map[string]loginFailureEntry{}}
+}
+
+func (f *loginFailures) record(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key = strings.ToLower(key)
+	now := time.Now()
+	entry := f.entries[key]
+	if now.Sub(entry.first) > f.window {
+		entry = loginFailureEntry{first: now}
+	}
+	entry.count++
+	f.entries[key] = entry
+
+	// Keep the map from growing without bound under credential stuffing
+	if len(f.entries) > 10000 {
+		for k, e := range f.entries {
+			if now.Sub(e.first) > f.window {
+				delete(f.entries, k)
+			}
+		}
+	}
+}
+
+func (f *loginFailures) count(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.entries[strings.ToLower(key)]
+	if !ok || time.Since(entry.first) > f.window {
+		return 0
+	}
+	return entry.count
+}
+
+func (f *loginFailures) reset(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.entries, strings.ToLower(key))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLoginFailureKeysDontCollide(t *testing.T) {
+	userID := uuid.New()
+	tokenID := uuid.NewString()
+	tests := []struct {
+		name  string
+		email string // a login email crafted to look like another key
+		key   string
+	}{
+		{name: "account key", email: "mfa-user:" + userID.String(), key: mfaAccountFailureKey(userID)},
+		{name: "account key, other case", email: "MFA-USER:" + userID.String(), key: mfaAccountFailureKey(userID)},
+		{name: "token key", email: "mfa-token:" + tokenID, key: mfaTokenFailureKey(tokenID)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newLoginFailures(time.Minute)
+			f.record(emailFailureKey(tt.email))
+			if got := f.count(tt.key); got != 0 {
+				t.Errorf("count(%q) = %d after a failed login for %q, want 0", tt.key, got, tt.email)
+			}
+		})
+	}
+}
@@ -2,33 +2,123 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/antivirus"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captcha"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oauth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/rediscache"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
-	jwtSecret        string
-	platform         string
-	filepathRoot     string
-	assetsRoot       string
-	s3Client         *s3.Client
-	s3Bucket         string
-	s3Region         string
-	s3CfDistribution string
-	port             string
+	db                    database.Client
+	jwtKeys               *auth.KeySet
+	sessionDenylist       *auth.Denylist
+	tokenTTLs             tokenTTLs
+	serviceVerifier       *auth.ServiceVerifier
+	adminEmails           []string
+	platform              string
+	filepathRoot          string
+	assetsRoot            string
+	store                 storage.Store
+	storageBreaker        *storage.Breaker
+	videoKeyScheme        string
+	videoFileVersions     int
+	storageClasses        storageClassPolicy
+	storageGC             storageGCPolicy
+	videoExpiry           videoExpiryPolicy
+	videoTrash            videoTrashPolicy
+	cdnPlayback           cdnPlaybackConfig
+	presignCache          *storage.PresignCache
+	outbox                outboxConfig
+	mediaTools            mediaTools
+	replicas              regionReplicas
+	tenants               tenantStorage
+	backups               *backupTarget
+	storagePricing        storagePricing
+	videoCDN              *cdn.Invalidator
+	assetsCDN             *cdn.Invalidator
+	s3CfDistribution      string
+	port                  string
+	videoMaxBytes         int
+	videoAllowedTypes     map[string]bool
+	thumbnailMaxBytes     int
+	thumbnailAllowedTypes map[string]bool
+	thumbnailMaxPixels    int
+	thumbnailMaxWidth     int
+	thumbnailMaxHeight    int
+	thumbnailCrop         string
+	thumbnailCropMode     imaging.CropMode
+	assetsCacheMaxAge     time.Duration
+	moderator             moderation.Moderator
+	scanner               antivirus.Scanner
+	autoCaptions          autoCaptionPolicy
+	virusScanAction       string
+	geoCountryHeader      string
+	trustedProxies        []netip.Prefix
+	baseURL               string
+	oauthProviders        map[string]*oauth.Provider
+	mailer                mailer.Mailer
+	passwordResetTTL      time.Duration
+	captcha               captcha.Verifier
+	// captchaLoginAfterFailures is how many failed logins for an account
+	// within loginFailures' window require a captcha on the next attempt
+	captchaLoginAfterFailures int
+	loginFailures             *loginFailures
+	inviteOnly                bool
+	guestUploads              bool
+	guestUploadTTL            time.Duration
+	storageQuotaBytes         int64
+	guestStorageQuotaBytes    int64
+	// apiKeyRateLimitDefault is the requests per minute an API key may
+	// make unless it has its own limit
+	apiKeyRateLimitDefault int
+	apiKeyLimiter          *ratelimit.Limiter
+	// ipRateLimits are the per-IP limits of each limitByIP group
+	ipRateLimits          map[string]ratelimit.Limit
+	ipLimiter             *ratelimit.Limiter
+	metrics               *metrics.Registry
+	metricsToken          string
+	ipRateLimitRejections *metrics.CounterVec
+	storageOrphans        *metrics.CounterVec
+	// playbackBindings says what playback tokens are bound to, by video
+	// visibility
+	playbackBindings map[string]playbackBinding
+	// playbackURLMaxTTL caps the expires_in a caller may ask for
+	playbackURLMaxTTL time.Duration
+	// playbackProxy streams every video through the app instead of
+	// handing out presigned URLs, for buckets viewers can't reach
+	playbackProxy bool
+	// videoPageSize is how many videos a page of the video list holds
+	// unless the caller asks for up to videoPageMaxSize
+	videoPageSize    int
+	videoPageMaxSize int
 }
 
 func main() {
 	godotenv.Load(".env")
+	secretEnv, err := resolveSecretEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	pathToDB := os.Getenv("DB_PATH")
 	if pathToDB == "" {
@@ -39,11 +129,53 @@ func main() {
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
+	// REDIS_URL puts a cache in front of video lookups. The app keeps
+	// working from the database while Redis is unreachable
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		redis, err := rediscache.New(redisURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := redis.Ping(ctx); err != nil {
+			log.Printf("Warning: couldn't reach Redis, video lookups will use the database until it is back: %v", err)
+		}
+		cancel()
+		db = db.WithVideoCache(redis, envDuration("VIDEO_CACHE_TTL", time.Minute))
+	}
+
+	// ADMIN_EMAILS bootstraps administrators: listed accounts get the admin
+	// role when they sign up with a verified address, and accounts linked
+	// to an identity provider that verified it are promoted at startup
+	adminEmails := envList("ADMIN_EMAILS", nil)
+	if promoted, err := db.PromoteVerifiedUsersByEmail(adminEmails, auth.RoleAdmin); err != nil {
+		log.Fatalf("Couldn't promote admin users: %v", err)
+	} else if promoted > 0 {
+		log.Printf("Promoted %d user(s) from ADMIN_EMAILS to admin", promoted)
+	}
 
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
 		log.Fatal("JWT_SECRET environment variable is not set")
 	}
+	jwtKeys, err := loadJWTKeys(jwtSecret)
+	if err != nil {
+		log.Fatalf("Couldn't load JWT keys: %v", err)
+	}
+	jwtKeys.SetClockSkew(envDuration("JWT_CLOCK_SKEW", auth.DefaultClockSkew))
+	ttls := tokenTTLs{
+		refresh:       envDuration("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL),
+		refreshAccess: envDuration("REFRESH_ACCESS_TOKEN_TTL", defaultRefreshAccessTokenTTL),
+		loginAccess:   envDuration("ACCESS_TOKEN_TTL", defaultLoginAccessTokenTTL),
+	}
+	sessionDenylist, err := loadSessionDenylist(db, ttls)
+	if err != nil {
+		log.Fatalf("Couldn't load revoked sessions: %v", err)
+	}
+	serviceKeys, err := loadServiceKeys()
+	if err != nil {
+		log.Fatalf("Couldn't load service keys: %v", err)
+	}
 
 	platform := os.Getenv("PLATFORM")
 	if platform == "" {
@@ -60,44 +192,416 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	port := os.Getenv("PORT")
+	if port == "" {
+		log.Fatal("PORT environment variable is not set")
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	thumbnailMaxBytes := envInt("THUMBNAIL_MAX_BYTES", 10<<20)
+	thumbnailAllowedTypes := map[string]bool{}
+	for _, mediaType := range envList("THUMBNAIL_ALLOWED_TYPES", defaultThumbnailTypes) {
+		if !supportedThumbnailTypes[mediaType] {
+			log.Fatalf("THUMBNAIL_ALLOWED_TYPES contains unsupported type %q", mediaType)
+		}
+		thumbnailAllowedTypes[mediaType] = true
+	}
+	if len(thumbnailAllowedTypes) == 0 {
+		log.Fatal("THUMBNAIL_ALLOWED_TYPES must allow at least one type")
+	}
+	videoMaxBytes := envInt("VIDEO_MAX_BYTES", 1<<30)
+	videoAllowedTypes := map[string]bool{}
+	for _, mediaType := range envList("VIDEO_ALLOWED_TYPES", defaultVideoTypes) {
+		if !supportedVideoTypes[mediaType] {
+			log.Fatalf("VIDEO_ALLOWED_TYPES contains unsupported type %q", mediaType)
+		}
+		videoAllowedTypes[mediaType] = true
+	}
+	if len(videoAllowedTypes) == 0 {
+		log.Fatal("VIDEO_ALLOWED_TYPES must allow at least one type")
 	}
+	thumbnailMaxPixels := envInt("THUMBNAIL_MAX_PIXELS", 40_000_000)
+	thumbnailMaxWidth := envInt("THUMBNAIL_MAX_WIDTH", 1920)
+	thumbnailMaxHeight := envInt("THUMBNAIL_MAX_HEIGHT", 1080)
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	// THUMBNAIL_CROP is either empty (no cropping), "video" to match the
+	// video's aspect class, or a fixed ratio such as "16:9"
+	thumbnailCrop := os.Getenv("THUMBNAIL_CROP")
+	if thumbnailCrop != "" && thumbnailCrop != "video" {
+		if _, _, err := parseAspectRatio(thumbnailCrop); err != nil {
+			log.Fatalf("THUMBNAIL_CROP is invalid: %v", err)
+		}
+	}
+	thumbnailCropMode := imaging.CropMode(os.Getenv("THUMBNAIL_CROP_MODE"))
+	if thumbnailCropMode == "" {
+		thumbnailCropMode = imaging.CropCenter
+	}
+	if thumbnailCropMode != imaging.CropCenter && thumbnailCropMode != imaging.CropAttention {
+		log.Fatal("THUMBNAIL_CROP_MODE must be center or attention")
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	assetsCacheMaxAge := envDuration("ASSETS_CACHE_MAX_AGE", 24*time.Hour)
+
+	videoKeyScheme := os.Getenv("VIDEO_KEY_SCHEME")
+	if videoKeyScheme == "" {
+		videoKeyScheme = videoKeysRandom
+	}
+	if videoKeyScheme != videoKeysRandom && videoKeyScheme != videoKeysContent {
+		log.Fatal("VIDEO_KEY_SCHEME must be random or content")
+	}
+	videoFileVersions := envNonNegativeInt("VIDEO_FILE_VERSIONS", 3)
+
+	// MEDIA_TOOLS_CHECK=false skips looking for ffmpeg at startup, e.g.
+	// when developing parts of the app that don't touch uploads
+	mediaTools, err := loadMediaTools(envBool("MEDIA_TOOLS_CHECK", true))
+	if err != nil {
+		log.Fatalf("Media tools check failed: %v", err)
+	}
+
+	var moderator moderation.Moderator = moderation.Noop{}
+	if moderationURL := os.Getenv("MODERATION_URL"); moderationURL != "" {
+		flagThreshold := envFloat("MODERATION_FLAG_THRESHOLD", 0.5)
+		quarantineThreshold := envFloat("MODERATION_QUARANTINE_THRESHOLD", 0.85)
+		if flagThreshold > quarantineThreshold {
+			log.Fatal("MODERATION_FLAG_THRESHOLD must not exceed MODERATION_QUARANTINE_THRESHOLD")
+		}
+		moderator = moderation.FrameClassifier{
+			Endpoint:            moderationURL,
+			Frames:              envInt("MODERATION_FRAMES", 5),
+			FlagThreshold:       flagThreshold,
+			QuarantineThreshold: quarantineThreshold,
+			Client:              &http.Client{Timeout: 30 * time.Second},
+			FFmpegPath:          mediaTools.ffmpeg,
+		}
+	}
+
+	// CLAMAV_ADDRESS is clamd's host:port or unix socket path
+	var scanner antivirus.Scanner = antivirus.Noop{}
+	if clamAddress := os.Getenv("CLAMAV_ADDRESS"); clamAddress != "" {
+		scanner = antivirus.ClamAV{
+			Address: clamAddress,
+			Timeout: envDuration("CLAMAV_TIMEOUT", 2*time.Minute),
+		}
+	}
+	autoCaptions, err := loadAutoCaptionPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	virusScanAction := os.Getenv("VIRUS_SCAN_ACTION")
+	if virusScanAction == "" {
+		virusScanAction = virusScanReject
+	}
+	if virusScanAction != virusScanReject && virusScanAction != virusScanQuarantine {
+		log.Fatal("VIRUS_SCAN_ACTION must be reject or quarantine")
+	}
+
+	// BASE_URL is the externally visible origin, used for asset URLs and
+	// OAuth redirect URIs
+	baseURL := strings.TrimSuffix(os.Getenv("BASE_URL"), "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+	}
+
+	oauthProviders := map[string]*oauth.Provider{}
+	oauthRedirect := func(name string) string {
+		return baseURL + "/api/oauth/" + name + "/callback"
+	}
+	if clientID := os.Getenv("OAUTH_GOOGLE_CLIENT_ID"); clientID != "" {
+		oauthProviders["google"] = oauth.Google(clientID, os.Getenv("OAUTH_GOOGLE_CLIENT_SECRET"), oauthRedirect("google"))
+	}
+	if clientID := os.Getenv("OAUTH_GITHUB_CLIENT_ID"); clientID != "" {
+		oauthProviders["github"] = oauth.GitHub(clientID, os.Getenv("OAUTH_GITHUB_CLIENT_SECRET"), oauthRedirect("github"))
+	}
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		name := os.Getenv("OIDC_NAME")
+		if name == "" {
+			name = "oidc"
+		}
+		provider, err := oauth.DiscoverOIDC(context.Background(), name, issuer,
+			os.Getenv("OIDC_CLIENT_ID"), os.Getenv("OIDC_CLIENT_SECRET"), oauthRedirect(name))
+		if err != nil {
+			log.Fatalf("Couldn't configure OIDC provider: %v", err)
+		}
+		oauthProviders[name] = provider
+	}
+
+	var mail mailer.Mailer = mailer.LogMailer{}
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		smtpFrom := os.Getenv("SMTP_FROM")
+		if smtpFrom == "" {
+			log.Fatal("SMTP_FROM must be set when SMTP_ADDR is")
+		}
+		mail = mailer.SMTPMailer{
+			Addr:     smtpAddr,
+			From:     smtpFrom,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	}
+	passwordResetTTL := envDuration("PASSWORD_RESET_TTL", time.Hour)
+
+	var captchaVerifier captcha.Verifier = captcha.Noop{}
+	if provider := os.Getenv("CAPTCHA_PROVIDER"); provider != "" {
+		v, err := captcha.New(provider, os.Getenv("CAPTCHA_SITE_KEY"), os.Getenv("CAPTCHA_SECRET"))
+		if err != nil {
+			log.Fatalf("Couldn't configure captcha: %v", err)
+		}
+		captchaVerifier = v
+	}
+	captchaLoginAfterFailures := envInt("CAPTCHA_LOGIN_AFTER_FAILURES", 3)
+
+	// STORAGE_QUOTA_BYTES is the default per-user limit on stored video;
+	// unset means unlimited
+	storageQuotaBytes := int64(envInt("STORAGE_QUOTA_BYTES", 0))
+	// GUEST_STORAGE_QUOTA_BYTES limits the video stored by all unclaimed
+	// guest uploads together; 0 means unlimited
+	guestStorageQuotaBytes := int64(envNonNegativeInt("GUEST_STORAGE_QUOTA_BYTES", 10<<30))
+
+	playbackBindings := map[string]playbackBinding{
+		videoVisibilityPublic:   parsePlaybackBinding("PLAYBACK_BIND_PUBLIC"),
+		videoVisibilityUnlisted: parsePlaybackBinding("PLAYBACK_BIND_UNLISTED"),
+		videoVisibilityPrivate:  parsePlaybackBinding("PLAYBACK_BIND_PRIVATE"),
+	}
+	videoPageSize := envInt("VIDEO_PAGE_SIZE", 50)
+	videoPageMaxSize := envInt("VIDEO_PAGE_MAX_SIZE", 200)
+	if videoPageSize > videoPageMaxSize {
+		log.Fatal("VIDEO_PAGE_SIZE must not exceed VIDEO_PAGE_MAX_SIZE")
+	}
+	playbackURLMaxTTL := envDuration("PLAYBACK_URL_MAX_TTL", 24*time.Hour)
+	if playbackURLMaxTTL < playbackURLTTL {
+		log.Fatalf("PLAYBACK_URL_MAX_TTL must be at least %s", playbackURLTTL)
+	}
+	playbackProxy := envBool("PLAYBACK_PROXY", false)
+
+	apiKeyRateLimitDefault := envInt("API_KEY_RATE_LIMIT", 600)
+
+	// Per-IP limits for unauthenticated endpoints, in requests per minute;
+	// 0 turns a limit off
+	ipRateLimits := map[string]ratelimit.Limit{
+		ipLimitLogin:       ratelimit.PerMinute(envNonNegativeInt("RATE_LIMIT_LOGIN", 10)),
+		ipLimitSignup:      ratelimit.PerMinute(envNonNegativeInt("RATE_LIMIT_SIGNUP", 5)),
+		ipLimitPlayback:    ratelimit.PerMinute(envNonNegativeInt("RATE_LIMIT_PLAYBACK", 120)),
+		ipLimitReport:      ratelimit.PerMinute(envNonNegativeInt("RATE_LIMIT_REPORT", 10)),
+		ipLimitGuestUpload: ratelimit.PerMinute(envNonNegativeInt("RATE_LIMIT_GUEST_UPLOAD", 5)),
+	}
+	// TRUSTED_PROXIES lists the load balancers whose CLIENT_IP_HEADER and
+	// GEO_COUNTRY_HEADER we believe; requests from anywhere else are
+	// attributed to their peer and have no known country
+	trustedProxies, err := parseTrustedProxies(envList("TRUSTED_PROXIES", nil))
+	if err != nil {
+		log.Fatal(err)
+	}
+	clientIPHeader := os.Getenv("CLIENT_IP_HEADER")
+	if clientIPHeader == "" {
+		clientIPHeader = "X-Forwarded-For"
+	}
+
+	metricsRegistry := metrics.NewRegistry()
+
+	inviteOnly := envBool("INVITE_ONLY", false)
+	guestUploads := envBool("GUEST_UPLOADS", false)
+	guestUploadTTL := envDuration("GUEST_UPLOAD_TTL", 7*24*time.Hour)
+
+	geoCountryHeader := os.Getenv("GEO_COUNTRY_HEADER")
+	if geoCountryHeader == "" {
+		geoCountryHeader = "CloudFront-Viewer-Country"
 	}
 
-	awsCfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+	store, s3CfDistribution, err := loadStore(baseURL, jwtSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Videos from before file locations were recorded are assumed to be
+	// in the current store, under their URL's path
+	provider, bucket := storeLocation(store)
+	backfilled, unresolved, err := db.BackfillVideoLocations(s3CfDistribution+"/", provider, bucket)
 	if err != nil {
-		log.Fatal("error loading aws configuration")
+		log.Fatalf("Couldn't record video file locations: %v", err)
+	}
+	if backfilled > 0 {
+		log.Printf("Recorded the file location of %d video(s)", backfilled)
+	}
+	if unresolved > 0 {
+		log.Printf("Warning: %d video(s) have URLs outside S3_CF_DISTRO, their files can't be managed", unresolved)
+	}
+	tenants, err := loadTenantStorage(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	backups, err := loadBackupTarget(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	storagePricing, err := loadStoragePricing()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if envBool("STORAGE_STARTUP_CHECK", true) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := checkStore(ctx, store)
+		if err != nil {
+			log.Fatalf("Storage check failed: %v", err)
+		}
+		for orgID, location := range tenants.orgs {
+			if err := checkStore(ctx, location.store); err != nil {
+				log.Fatalf("Storage check failed for organization %s: %v", orgID, err)
+			}
+		}
+		cancel()
+	}
+	storageClasses, err := loadStorageClassPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	storageTransitionInterval := envDuration("S3_STORAGE_TRANSITION_INTERVAL", time.Hour)
+	if storageTransitionInterval <= 0 {
+		log.Fatal("S3_STORAGE_TRANSITION_INTERVAL must be positive")
+	}
+	// STORAGE_GC_INTERVAL=0 turns the orphaned object collector off
+	cdnPlayback, err := loadCDNPlayback()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, encrypted := store.(storage.EncryptedStore); encrypted && cdnPlayback.signer != nil {
+		log.Fatal("CloudFront playback can't serve client-side encrypted videos")
+	}
+	if playbackProxy && cdnPlayback.signer != nil {
+		log.Fatal("PLAYBACK_PROXY can't be used with CloudFront playback, which serves videos from the bucket")
+	}
+	outbox := outboxConfig{
+		webhooks:    envList("WEBHOOK_URLS", nil),
+		secret:      os.Getenv("WEBHOOK_SECRET"),
+		interval:    envDuration("OUTBOX_POLL_INTERVAL", 5*time.Second),
+		maxAttempts: envInt("WEBHOOK_MAX_ATTEMPTS", 10),
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+	if outbox.interval <= 0 {
+		log.Fatal("OUTBOX_POLL_INTERVAL must be positive")
 	}
 
-	awsClient := s3.NewFromConfig(awsCfg)
+	replicas, err := loadRegionReplicas()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(replicas.stores) > 0 {
+		// Replicas only change where presigned URLs point
+		if _, s3 := store.(storage.S3Store); !s3 {
+			log.Fatal("S3_REPLICA_BUCKETS needs the s3 storage backend without client-side encryption")
+		}
+		if cdnPlayback.signer != nil {
+			log.Fatal("S3_REPLICA_BUCKETS can't be used with CloudFront playback, which already serves viewers from nearby edges")
+		}
+		if playbackProxy {
+			log.Fatal("S3_REPLICA_BUCKETS can't be used with PLAYBACK_PROXY, which never sends viewers to a bucket")
+		}
+	}
+	videoCDN, assetsCDN, err := loadCDNInvalidators()
+	if err != nil {
+		log.Fatal(err)
+	}
+	invalidationInterval := envDuration("CLOUDFRONT_INVALIDATION_INTERVAL", time.Minute)
+	if invalidationInterval <= 0 {
+		log.Fatal("CLOUDFRONT_INVALIDATION_INTERVAL must be positive")
+	}
+	storageVerifyInterval := envDuration("STORAGE_VERIFY_INTERVAL", 24*time.Hour)
+	videoExpiry := videoExpiryPolicy{
+		interval: envDuration("VIDEO_EXPIRY_INTERVAL", 5*time.Minute),
+		reminder: envDuration("VIDEO_EXPIRY_REMINDER", 24*time.Hour),
+	}
+	if videoExpiry.interval <= 0 {
+		log.Fatal("VIDEO_EXPIRY_INTERVAL must be positive")
+	}
+	videoTrash := videoTrashPolicy{
+		retention: envDuration("VIDEO_TRASH_RETENTION", 30*24*time.Hour),
+		interval:  envDuration("VIDEO_TRASH_PURGE_INTERVAL", time.Hour),
+	}
+	if videoTrash.retention < 0 || videoTrash.interval <= 0 {
+		log.Fatal("VIDEO_TRASH_RETENTION can't be negative and VIDEO_TRASH_PURGE_INTERVAL must be positive")
+	}
+	storageGC := storageGCPolicy{
+		interval: envDuration("STORAGE_GC_INTERVAL", 24*time.Hour),
+		grace:    envDuration("STORAGE_GC_GRACE", 24*time.Hour),
+		delete:   envBool("STORAGE_GC_DELETE", false),
+	}
 
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Client:         awsClient,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
+		db:                        db,
+		jwtKeys:                   jwtKeys,
+		sessionDenylist:           sessionDenylist,
+		tokenTTLs:                 ttls,
+		serviceVerifier:           auth.NewServiceVerifier(serviceKeys),
+		adminEmails:               adminEmails,
+		platform:                  platform,
+		filepathRoot:              filepathRoot,
+		assetsRoot:                assetsRoot,
+		store:                     store,
+		storageBreaker:            storeBreaker(store),
+		videoKeyScheme:            videoKeyScheme,
+		videoFileVersions:         videoFileVersions,
+		storageClasses:            storageClasses,
+		storageGC:                 storageGC,
+		videoExpiry:               videoExpiry,
+		videoTrash:                videoTrash,
+		cdnPlayback:               cdnPlayback,
+		presignCache:              storage.NewPresignCache(),
+		outbox:                    outbox,
+		mediaTools:                mediaTools,
+		replicas:                  replicas,
+		tenants:                   tenants,
+		backups:                   backups,
+		storagePricing:            storagePricing,
+		videoCDN:                  videoCDN,
+		assetsCDN:                 assetsCDN,
+		s3CfDistribution:          s3CfDistribution,
+		port:                      port,
+		videoMaxBytes:             videoMaxBytes,
+		videoAllowedTypes:         videoAllowedTypes,
+		thumbnailMaxBytes:         thumbnailMaxBytes,
+		thumbnailAllowedTypes:     thumbnailAllowedTypes,
+		thumbnailMaxPixels:        thumbnailMaxPixels,
+		thumbnailMaxWidth:         thumbnailMaxWidth,
+		thumbnailMaxHeight:        thumbnailMaxHeight,
+		thumbnailCrop:             thumbnailCrop,
+		thumbnailCropMode:         thumbnailCropMode,
+		assetsCacheMaxAge:         assetsCacheMaxAge,
+		moderator:                 moderator,
+		scanner:                   scanner,
+		autoCaptions:              autoCaptions,
+		virusScanAction:           virusScanAction,
+		geoCountryHeader:          geoCountryHeader,
+		trustedProxies:            trustedProxies,
+		baseURL:                   baseURL,
+		oauthProviders:            oauthProviders,
+		mailer:                    mail,
+		passwordResetTTL:          passwordResetTTL,
+		captcha:                   captchaVerifier,
+		captchaLoginAfterFailures: captchaLoginAfterFailures,
+		loginFailures:             newLoginFailures(15 * time.Minute),
+		inviteOnly:                inviteOnly,
+		guestUploads:              guestUploads,
+		guestUploadTTL:            guestUploadTTL,
+		storageQuotaBytes:         storageQuotaBytes,
+		guestStorageQuotaBytes:    guestStorageQuotaBytes,
+		apiKeyRateLimitDefault:    apiKeyRateLimitDefault,
+		apiKeyLimiter:             ratelimit.New(),
+		ipRateLimits:              ipRateLimits,
+		ipLimiter:                 ratelimit.New(),
+		metrics:                   metricsRegistry,
+		metricsToken:              os.Getenv("METRICS_TOKEN"),
+		ipRateLimitRejections: metricsRegistry.NewCounterVec(
+			"tubely_ip_rate_limit_rejections_total",
+			"Requests rejected by the per-IP rate limiter.",
+			"endpoint",
+		),
+		storageOrphans: metricsRegistry.NewCounterVec(
+			"tubely_storage_orphans_total",
+			"Stored video objects with no owning video, by what was done with them.",
+			"action",
+		),
+		playbackBindings:  playbackBindings,
+		playbackURLMaxTTL: playbackURLMaxTTL,
+		playbackProxy:     playbackProxy,
+		videoPageSize:     videoPageSize,
+		videoPageMaxSize:  videoPageMaxSize,
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -105,33 +609,199 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	if len(storageClasses.transitions) > 0 {
+		if _, ok := store.(storage.ClassMover); !ok {
+			log.Fatal("S3_STORAGE_TRANSITIONS needs the s3 storage backend")
+		}
+		go cfg.runStorageTransitions(context.Background(), storageTransitionInterval)
+	}
+	for _, inv := range []*cdn.Invalidator{videoCDN, assetsCDN} {
+		if inv != nil {
+			go inv.Run(context.Background(), invalidationInterval)
+		}
+	}
+	if len(replicas.stores) > 0 {
+		go cfg.runReplicationCheck(context.Background())
+	}
+	// Events queued for webhooks that have since been removed from
+	// WEBHOOK_URLS are still delivered, so the dispatcher always runs
+	go cfg.runOutboxDispatcher(context.Background())
+	go cfg.runVideoExpiry(context.Background())
+	go cfg.runVideoTrashPurge(context.Background())
+	if autoCaptions.transcriber != nil {
+		go cfg.runAutoCaptions(context.Background())
+	}
+	if lister, ok := store.(storage.Lister); ok {
+		if storageGC.interval > 0 {
+			go cfg.runStorageGC(context.Background(), lister)
+		}
+		if storageVerifyInterval > 0 {
+			go cfg.runStorageVerify(context.Background(), lister, storageVerifyInterval)
+		}
+	}
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
 	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.Handle("/assets/", cacheMiddleware(assetsRoot, assetsCacheMaxAge, assetsHandler))
 
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	if _, ok := store.(storage.LocalStore); ok {
+		mux.HandleFunc("GET "+localMediaPrefix+"/{key...}", cfg.handlerLocalMedia)
+	}
+
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
+	mux.HandleFunc("GET /api/captcha", cfg.handlerCaptchaConfig)
+	mux.HandleFunc("GET /metrics", cfg.handlerMetrics)
+	mux.HandleFunc("POST /api/login", cfg.limitByIP(ipLimitLogin, cfg.handlerLogin))
+	mux.HandleFunc("POST /api/login/2fa", cfg.limitByIP(ipLimitLogin, cfg.handlerLoginSecondFactor))
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("POST /api/2fa/totp", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerTOTPEnroll))
+	mux.HandleFunc("POST /api/2fa/totp/activate", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerTOTPActivate))
+	mux.HandleFunc("POST /api/2fa/totp/disable", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerTOTPDisable))
+	mux.HandleFunc("POST /api/password_reset", cfg.handlerPasswordResetRequest)
+	mux.HandleFunc("POST /api/password_reset/confirm", cfg.handlerPasswordResetConfirm)
+	mux.HandleFunc("GET /api/sessions", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerSessionsList))
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerSessionsRevoke))
+	mux.HandleFunc("POST /api/sessions/revoke_all", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerSessionsRevokeAll))
+	mux.HandleFunc("GET /api/oauth/providers", cfg.handlerOAuthProviders)
+	mux.HandleFunc("GET /api/oauth/{provider}/login", cfg.handlerOAuthLogin)
+	mux.HandleFunc("GET /api/oauth/{provider}/callback", cfg.handlerOAuthCallback)
+	mux.HandleFunc("POST /api/oauth/{provider}/link", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerOAuthLink))
+
+	mux.HandleFunc("POST /api/users", cfg.limitByIP(ipLimitSignup, cfg.handlerUsersCreate))
+	mux.HandleFunc("GET /api/users/{userID}/feed.rss", cfg.handlerChannelFeed)
+	mux.HandleFunc("DELETE /api/users/me", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerUsersDeleteMe))
+	mux.HandleFunc("GET /api/me/storage", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerStorageUsage))
+
+	mux.HandleFunc("POST /api/api_keys", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerAPIKeysCreate))
+	mux.HandleFunc("GET /api/api_keys", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerAPIKeysList))
+	mux.HandleFunc("DELETE /api/api_keys/{keyID}", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerAPIKeysRevoke))
+	mux.HandleFunc("GET /api/api_keys/{keyID}/usage", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerAPIKeyUsage))
 
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("POST /api/orgs", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerOrgsCreate))
+	mux.HandleFunc("GET /api/orgs", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerOrgsList))
+	mux.HandleFunc("GET /api/orgs/{orgID}/members", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerOrgMembersList))
+	mux.HandleFunc("PUT /api/orgs/{orgID}/members", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerOrgMembersPut))
+	mux.HandleFunc("DELETE /api/orgs/{orgID}/members/{userID}", cfg.withPrincipal(auth.ScopeAccount, cfg.handlerOrgMembersDelete))
+	mux.HandleFunc("GET /api/orgs/{orgID}/videos", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerOrgVideosList))
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("POST /api/videos", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoMetaCreate))
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.withPrincipal(auth.ScopeVideoUpload, cfg.handlerUploadThumbnail))
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.withOptionalPrincipal(auth.ScopeVideoUpload, cfg.handlerUploadVideo))
+	mux.HandleFunc("PUT /api/videos/{videoID}/file", cfg.withPrincipal(auth.ScopeVideoUpload, cfg.handlerVideoFileReplace))
+	mux.HandleFunc("GET /api/videos/{videoID}/versions", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerVideoFileVersionsList))
+	mux.HandleFunc("POST /api/videos/{videoID}/versions/{versionID}/restore", cfg.withPrincipal(auth.ScopeVideoUpload, cfg.handlerVideoFileVersionRestore))
+	mux.HandleFunc("POST /api/playlists", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerPlaylistsCreate))
+	mux.HandleFunc("GET /api/playlists", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerPlaylistsList))
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.withOptionalPrincipal(auth.ScopeVideoRead, cfg.handlerPlaylistGet))
+	mux.HandleFunc("PUT /api/playlists/{playlistID}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerPlaylistUpdate))
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerPlaylistDelete))
+	mux.HandleFunc("GET /api/playlists/{playlistID}/videos", cfg.withOptionalPrincipal(auth.ScopeVideoRead, cfg.handlerPlaylistVideos))
+	mux.HandleFunc("POST /api/playlists/{playlistID}/items", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerPlaylistItemAdd))
+	mux.HandleFunc("PUT /api/playlists/{playlistID}/items", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerPlaylistReorder))
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}/items/{videoID}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerPlaylistItemRemove))
+	mux.HandleFunc("GET /oembed", cfg.handlerOEmbed)
+	mux.HandleFunc("GET /embed/{videoID}", cfg.limitByIP(ipLimitPlayback, cfg.handlerEmbed))
+	mux.HandleFunc("GET /api/catalog", cfg.handlerCatalog)
+	mux.HandleFunc("GET /api/categories", cfg.handlerCategoriesList)
+	mux.HandleFunc("POST /api/videos/batch", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoBatchUpdate))
+	mux.HandleFunc("GET /api/videos", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerVideosRetrieve))
+	mux.HandleFunc("GET /api/videos/signed", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerVideosRetrieveSigned))
+	mux.HandleFunc("POST /api/guest_uploads", cfg.limitByIP(ipLimitGuestUpload, cfg.handlerGuestUploadsCreate))
+	mux.HandleFunc("POST /api/guest_uploads/{videoID}/video", cfg.limitByIP(ipLimitGuestUpload, cfg.handlerGuestUploadVideo))
+	mux.HandleFunc("POST /api/guest_uploads/claim", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerGuestUploadsClaim))
+	mux.HandleFunc("GET /api/videos/{videoID}", cfg.withOptionalPrincipal(auth.ScopeVideoRead, cfg.handlerVideoGet))
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoMetaPatch))
+	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoMetaDelete))
+	mux.HandleFunc("GET /api/trash", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerTrashList))
+	mux.HandleFunc("DELETE /api/trash/{videoID}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerTrashDelete))
+	mux.HandleFunc("POST /api/trash/{videoID}/restore", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerTrashRestore))
+	mux.HandleFunc("POST /api/videos/{videoID}/playback_token", cfg.limitByIP(ipLimitPlayback, cfg.withOptionalPrincipal(auth.ScopeVideoRead, cfg.handlerPlaybackToken)))
+	mux.HandleFunc("GET /api/playback/{videoID}", cfg.limitByIP(ipLimitPlayback, cfg.handlerPlaybackURL))
+	mux.HandleFunc("GET /api/playback/{videoID}/stream", cfg.limitByIP(ipLimitPlayback, cfg.handlerPlaybackStream))
+	mux.HandleFunc("GET /api/playback/{videoID}/captions/{language}", cfg.limitByIP(ipLimitPlayback, cfg.handlerPlaybackCaption))
+	mux.HandleFunc("GET /api/videos/{videoID}/download", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerVideoDownload))
+	mux.HandleFunc("GET /api/videos/{videoID}/enclosure", cfg.limitByIP(ipLimitPlayback, cfg.handlerVideoEnclosure))
+	mux.HandleFunc("POST /api/videos/{videoID}/upload_tokens", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerUploadTokensCreate))
+	mux.HandleFunc("GET /api/videos/{videoID}/upload_tokens", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerUploadTokensList))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/upload_tokens/{tokenID}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerUploadTokensRevoke))
+	mux.HandleFunc("POST /api/videos/{videoID}/share_links", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerShareLinksCreate))
+	mux.HandleFunc("GET /api/videos/{videoID}/share_links", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerShareLinksList))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/share_links/{linkID}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerShareLinksRevoke))
+	mux.HandleFunc("GET /api/share/{token}", cfg.limitByIP(ipLimitPlayback, cfg.handlerShareLinkOpen))
+	mux.HandleFunc("POST /api/videos/{videoID}/archive", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoArchive))
+	mux.HandleFunc("POST /api/videos/{videoID}/restore", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoRestore))
+	mux.HandleFunc("PUT /api/videos/{videoID}/geo", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoGeoUpdate))
+	mux.HandleFunc("PUT /api/videos/{videoID}/expiry", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoExpiryUpdate))
+	mux.HandleFunc("PUT /api/videos/{videoID}/tags", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoTagsPut))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/tags/{tag}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoTagDelete))
+	mux.HandleFunc("GET /api/videos/{videoID}/captions", cfg.withOptionalPrincipal(auth.ScopeVideoRead, cfg.handlerVideoCaptionsList))
+	mux.HandleFunc("GET /api/videos/{videoID}/captions/{language}", cfg.withOptionalPrincipal(auth.ScopeVideoRead, cfg.handlerVideoCaptionGet))
+	mux.HandleFunc("POST /api/videos/{videoID}/captions/auto", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerAutoCaptionsCreate))
+	mux.HandleFunc("GET /api/videos/{videoID}/captions/auto", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerAutoCaptionsGet))
+	mux.HandleFunc("PUT /api/videos/{videoID}/captions/{language}", cfg.withPrincipal(auth.ScopeVideoUpload, cfg.handlerVideoCaptionPut))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/captions/{language}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoCaptionDelete))
+	mux.HandleFunc("PUT /api/videos/{videoID}/localizations/{locale}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoLocalizationPut))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/localizations/{locale}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoLocalizationDelete))
+	mux.HandleFunc("PUT /api/videos/{videoID}/category", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoCategoryUpdate))
+	mux.HandleFunc("PUT /api/videos/{videoID}/visibility", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoVisibilityUpdate))
+	mux.HandleFunc("GET /api/videos/{videoID}/grants", cfg.withPrincipal(auth.ScopeVideoRead, cfg.handlerVideoGrantsList))
+	mux.HandleFunc("PUT /api/videos/{videoID}/grants", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoGrantsPut))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/grants/{userID}", cfg.withPrincipal(auth.ScopeVideoWrite, cfg.handlerVideoGrantsDelete))
+	mux.HandleFunc("POST /api/videos/{videoID}/reports", cfg.limitByIP(ipLimitReport, cfg.withOptionalPrincipal(auth.ScopeVideoRead, cfg.handlerVideoReportCreate)))
+	mux.HandleFunc("POST /api/videos/{videoID}/takedown", cfg.requirePermission(auth.ScopeVideoWrite, auth.PermVideoTakedown, cfg.handlerVideoTakedown))
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("GET /admin/storage/usage", cfg.requirePermission(auth.ScopeVideoRead, auth.PermAdminReports, cfg.handlerStorageUsageRollup))
+	mux.HandleFunc("GET /admin/storage/costs", cfg.requirePermission(auth.ScopeVideoRead, auth.PermAdminReports, cfg.handlerStorageCosts))
+	mux.HandleFunc("POST /admin/backups", cfg.requirePermission(auth.ScopeAccount, auth.PermBackupManage, cfg.handlerBackupStart))
+	mux.HandleFunc("GET /admin/backups", cfg.requirePermission(auth.ScopeVideoRead, auth.PermAdminReports, cfg.handlerBackupStatus))
+	mux.HandleFunc("GET /admin/storage/integrity", cfg.requirePermission(auth.ScopeVideoRead, auth.PermAdminReports, cfg.handlerStorageIntegrity))
+	mux.HandleFunc("GET /admin/thumbnails/duplicates", cfg.requirePermission(auth.ScopeVideoRead, auth.PermAdminReports, cfg.handlerThumbnailDuplicates))
+	mux.HandleFunc("DELETE /admin/users/{userID}", cfg.requirePermission(auth.ScopeAccount, auth.PermUserManage, cfg.handlerAdminUserDelete))
+	mux.HandleFunc("POST /admin/users/{userID}/impersonate", cfg.requirePermission(auth.ScopeAccount, auth.PermUserImpersonate, cfg.handlerAdminImpersonate))
+	mux.HandleFunc("PUT /admin/users/{userID}/role", cfg.requirePermission(auth.ScopeAccount, auth.PermUserManage, cfg.handlerUserRoleUpdate))
+	mux.HandleFunc("PUT /admin/users/{userID}/storage_quota", cfg.requirePermission(auth.ScopeAccount, auth.PermUserManage, cfg.handlerUserStorageQuotaUpdate))
+	mux.HandleFunc("PUT /admin/api_keys/{keyID}/rate_limit", cfg.requirePermission(auth.ScopeAccount, auth.PermUserManage, cfg.handlerAPIKeyRateLimitUpdate))
+	mux.HandleFunc("GET /admin/moderation/queue", cfg.requirePermission(auth.ScopeVideoRead, auth.PermVideoTakedown, cfg.handlerModerationQueue))
+	mux.HandleFunc("GET /admin/moderation/videos/{videoID}/reports", cfg.requirePermission(auth.ScopeVideoRead, auth.PermVideoTakedown, cfg.handlerModerationVideoReports))
+	mux.HandleFunc("POST /admin/moderation/videos/{videoID}/resolve", cfg.requirePermission(auth.ScopeVideoWrite, auth.PermVideoTakedown, cfg.handlerModerationResolve))
+	mux.HandleFunc("POST /admin/categories", cfg.requirePermission(auth.ScopeVideoWrite, auth.PermCatalogManage, cfg.handlerCategoryCreate))
+	mux.HandleFunc("PUT /admin/categories/{categoryID}", cfg.requirePermission(auth.ScopeVideoWrite, auth.PermCatalogManage, cfg.handlerCategoryUpdate))
+	mux.HandleFunc("DELETE /admin/categories/{categoryID}", cfg.requirePermission(auth.ScopeVideoWrite, auth.PermCatalogManage, cfg.handlerCategoryDelete))
+	mux.HandleFunc("GET /admin/audit", cfg.requirePermission(auth.ScopeAccount, auth.PermAuditRead, cfg.handlerAuditEvents))
+	mux.HandleFunc("POST /admin/invites", cfg.requirePermission(auth.ScopeAccount, auth.PermUserManage, cfg.handlerInvitesCreate))
+	mux.HandleFunc("GET /admin/invites", cfg.requirePermission(auth.ScopeAccount, auth.PermUserManage, cfg.handlerInvitesList))
+	mux.HandleFunc("DELETE /admin/invites/{inviteID}", cfg.requirePermission(auth.ScopeAccount, auth.PermUserManage, cfg.handlerInvitesRevoke))
 
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: requestIDMiddleware(clientIPMiddleware(trustedProxies, clientIPHeader, mux)),
+	}
+
+	// CONFIG_REFRESH_INTERVAL restarts the server when a setting read
+	// from Secrets Manager or SSM changes, after in-flight requests
+	// finish. It exits with an error so the process manager starts it
+	// again with the new value
+	restarting := make(chan struct{})
+	if interval := envDuration("CONFIG_REFRESH_INTERVAL", 0); interval > 0 && len(secretEnv.refs) > 0 {
+		go secretEnv.watch(context.Background(), interval, func(name string) {
+			log.Printf("%s changed, shutting down to apply it", name)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			srv.Shutdown(ctx)
+			close(restarting)
+		})
 	}
 
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	err = srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		<-restarting
+		log.Fatal("Exiting to apply changed configuration")
+	}
+	log.Fatal(err)
 }
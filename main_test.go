@@ -0,0 +1,60 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// newTestConfig returns a config backed by a fresh database and a local
+// store, both in a temporary directory.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.NewClient(filepath.Join(dir, "tubely.db"))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	keys, err := auth.NewKeySet(auth.NewHMACKey("test-secret"))
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+	store, err := storage.NewLocalStore(filepath.Join(dir, "files"), "http://localhost", []byte("test-secret"))
+	if err != nil {
+		t.Fatalf("NewLocalStore() error = %v", err)
+	}
+	return &apiConfig{
+		db:              db,
+		jwtKeys:         keys,
+		sessionDenylist: auth.NewDenylist(),
+		store:           store,
+		loginFailures:   newLoginFailures(15 * time.Minute),
+	}
+}
+
+func newTestUser(t *testing.T, cfg *apiConfig) *database.User {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{
+		Email:    uuid.NewString() + "@example.com",
+		Password: "unused",
+		Role:     auth.DefaultRole,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	return user
+}
+
+func newTestVideo(t *testing.T, cfg *apiConfig, userID uuid.UUID) database.Video {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "test", UserID: userID})
+	if err != nil {
+		t.Fatalf("CreateVideo() error = %v", err)
+	}
+	return video
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// minFFmpegMajorVersion is the oldest ffmpeg release with everything
+// uploads use, such as -fps_mode for moderation frame sampling.
+const minFFmpegMajorVersion = 5
+
+// ffmpegVersionPattern matches release version lines like "ffmpeg version
+// 6.1.1-3ubuntu5" or "ffprobe version n7.0". Builds from git report
+// "version N-113414-g..." instead and aren't checked.
+var ffmpegVersionPattern = regexp.MustCompile(`^\S+ version n?(\d+)\.`)
+
+// mediaTools are the ffmpeg binaries used to process uploads.
+type mediaTools struct {
+	ffmpeg  string
+	ffprobe string
+}
+
+// loadMediaTools finds ffmpeg and ffprobe, at FFMPEG_PATH and
+// FFPROBE_PATH or on PATH, and makes sure they run and are recent enough.
+// Without check it only reads the settings, for setups that never
+// process uploads.
+func loadMediaTools(check bool) (mediaTools, error) {
+	tools := mediaTools{
+		ffmpeg:  os.Getenv("FFMPEG_PATH"),
+		ffprobe: os.Getenv("FFPROBE_PATH"),
+	}
+	if tools.ffmpeg == "" {
+		tools.ffmpeg = "ffmpeg"
+	}
+	if tools.ffprobe == "" {
+		tools.ffprobe = "ffprobe"
+	}
+	if !check {
+		return tools, nil
+	}
+
+	for _, tool := range []*string{&tools.ffmpeg, &tools.ffprobe} {
+		path, err := exec.LookPath(*tool)
+		if err != nil {
+			return mediaTools{}, fmt.Errorf("couldn't find %s: %w", *tool, err)
+		}
+		*tool = path
+		if err := checkMediaToolVersion(path); err != nil {
+			return mediaTools{}, err
+		}
+	}
+	return tools, nil
+}
+
+func checkMediaToolVersion(path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "-version").Output()
+	if err != nil {
+		return fmt.Errorf("couldn't run %s -version: %w", path, err)
+	}
+
+	firstLine, _, _ := bytes.Cut(out, []byte("\n"))
+	match := ffmpegVersionPattern.FindSubmatch(firstLine)
+	if match == nil {
+		return nil
+	}
+	major, _ := strconv.Atoi(string(match[1]))
+	if major < minFFmpegMajorVersion {
+		return fmt.Errorf("%s is version %d, at least %d is required", path, major, minFFmpegMajorVersion)
+	}
+	return nil
+}
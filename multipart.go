@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+var errUploadTooLarge = errors.New("upload exceeds the maximum size")
+
+// streamFormFile finds the named file part in a multipart request and
+// streams it to a temp file without buffering the form in memory. At most
+// maxBytes of file content are accepted. The caller is responsible for
+// closing and removing the returned file, which is positioned at the
+// start.
+func streamFormFile(w http.ResponseWriter, r *http.Request, field string, maxBytes int64) (*os.File, string, error) {
+	// Leave headroom for the multipart framing around the file content
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1<<20)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, "", fmt.Errorf("missing form field %q", field)
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, "", errUploadTooLarge
+			}
+			return nil, "", err
+		}
+		if part.FormName() != field || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		defer part.Close()
+
+		tempFile, err := os.CreateTemp("", "tubely-"+field+"-*")
+		if err != nil {
+			return nil, "", err
+		}
+		cleanup := func() {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+		}
+
+		written, err := io.Copy(tempFile, io.LimitReader(part, maxBytes+1))
+		if err != nil {
+			cleanup()
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, "", errUploadTooLarge
+			}
+			return nil, "", err
+		}
+		if written > maxBytes {
+			cleanup()
+			return nil, "", errUploadTooLarge
+		}
+		if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+			cleanup()
+			return nil, "", err
+		}
+		return tempFile, part.Header.Get("Content-Type"), nil
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Event types queued in the outbox.
+const (
+	eventVideoUploaded = "video.uploaded"
+)
+
+const (
+	// outboxBatch caps how many events one dispatcher run delivers
+	outboxBatch = 100
+	// outboxMaxBackoff caps the wait between attempts at one event
+	outboxMaxBackoff = time.Hour
+	// webhookSignatureHeader carries the hex HMAC-SHA256 of the request
+	// body, keyed with WEBHOOK_SECRET
+	webhookSignatureHeader = "X-Tubely-Signature"
+	// webhookEventIDHeader lets receivers drop duplicate deliveries,
+	// since an event is sent again if the app dies before recording that
+	// it was delivered
+	webhookEventIDHeader = "X-Tubely-Event-ID"
+)
+
+// outboxConfig is where outbox events are delivered.
+type outboxConfig struct {
+	webhooks    []string
+	secret      string
+	interval    time.Duration
+	maxAttempts int
+	client      *http.Client
+}
+
+// videoEvent is the payload of video events.
+type videoEvent struct {
+	Type      string         `json:"type"`
+	CreatedAt time.Time      `json:"created_at"`
+	Video     database.Video `json:"video"`
+}
+
+// runOutboxDispatcher delivers queued events every interval until ctx is
+// done.
+func (cfg *apiConfig) runOutboxDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(cfg.outbox.interval)
+	defer ticker.Stop()
+	for {
+		cfg.dispatchOutbox(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) dispatchOutbox(ctx context.Context) {
+	events, err := cfg.db.GetDueOutboxEvents(outboxBatch)
+	if err != nil {
+		log.Printf("Outbox: couldn't list events: %v", err)
+		return
+	}
+	for _, event := range events {
+		err := cfg.deliverWebhook(ctx, event)
+		if err == nil {
+			if err := cfg.db.MarkOutboxEventDelivered(event.ID); err != nil {
+				log.Printf("Outbox: couldn't record delivery of event %d: %v", event.ID, err)
+			}
+			continue
+		}
+
+		// Back off exponentially from the poll interval
+		var nextAttempt *time.Time
+		if event.Attempts+1 < cfg.outbox.maxAttempts {
+			backoff := min(cfg.outbox.interval<<min(event.Attempts, 20), outboxMaxBackoff)
+			next := time.Now().Add(backoff)
+			nextAttempt = &next
+		} else {
+			log.Printf("Outbox: giving up on event %d to %s after %d attempts: %v", event.ID, event.Destination, event.Attempts+1, err)
+		}
+		if err := cfg.db.MarkOutboxEventFailed(event.ID, err.Error(), nextAttempt); err != nil {
+			log.Printf("Outbox: couldn't record failed delivery of event %d: %v", event.ID, err)
+		}
+	}
+}
+
+// deliverWebhook posts event to its destination URL. Any 2xx response
+// counts as delivered.
+func (cfg *apiConfig) deliverWebhook(ctx context.Context, event database.OutboxEvent) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, event.Destination, bytes.NewReader(event.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventIDHeader, strconv.FormatInt(event.ID, 10))
+	if cfg.outbox.secret != "" {
+		mac := hmac.New(sha256.New, []byte(cfg.outbox.secret))
+		mac.Write(event.Payload)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := cfg.outbox.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
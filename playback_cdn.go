@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// cdnPlaybackConfig is how playback URLs are signed when videos are
+// served through CloudFront rather than presigned from S3.
+type cdnPlaybackConfig struct {
+	signer *cdn.Signer
+	// cookies grants access with signed cookies instead of signed URLs,
+	// so a player can fetch any file under the video's key (such as HLS
+	// segments) with one grant
+	cookies      bool
+	cookieDomain string
+}
+
+// loadCDNPlayback reads the CloudFront key pair used to sign playback
+// URLs. Without CLOUDFRONT_KEY_PAIR_ID, playback uses store presigning.
+func loadCDNPlayback() (cdnPlaybackConfig, error) {
+	keyPairID := os.Getenv("CLOUDFRONT_KEY_PAIR_ID")
+	if keyPairID == "" {
+		return cdnPlaybackConfig{}, nil
+	}
+	keyFile := os.Getenv("CLOUDFRONT_PRIVATE_KEY_FILE")
+	if keyFile == "" {
+		return cdnPlaybackConfig{}, fmt.Errorf("CLOUDFRONT_PRIVATE_KEY_FILE must be set with CLOUDFRONT_KEY_PAIR_ID")
+	}
+	pemBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return cdnPlaybackConfig{}, fmt.Errorf("couldn't read CloudFront private key: %w", err)
+	}
+	signer, err := cdn.NewSigner(keyPairID, pemBytes)
+	if err != nil {
+		return cdnPlaybackConfig{}, err
+	}
+
+	config := cdnPlaybackConfig{
+		signer:       signer,
+		cookies:      envBool("CLOUDFRONT_SIGNED_COOKIES", false),
+		cookieDomain: os.Getenv("CLOUDFRONT_COOKIE_DOMAIN"),
+	}
+	// The browser only sends the cookies to the CDN if both it and the
+	// app are under the cookie's domain
+	if config.cookies && config.cookieDomain == "" {
+		return cdnPlaybackConfig{}, fmt.Errorf("CLOUDFRONT_COOKIE_DOMAIN must be set with CLOUDFRONT_SIGNED_COOKIES")
+	}
+	return config, nil
+}
+
+// setCDNCookies sets signed cookies granting access to video's file, and
+// anything stored beneath its key, then returns the plain CDN URL for
+// the player. It returns "" if the video has no playable file.
+func (cfg *apiConfig) setCDNCookies(w http.ResponseWriter, video database.Video, expiresIn time.Duration) (string, time.Time, error) {
+	if video.Status != database.VideoStatusReady || video.VideoURL == nil || video.QuarantineKey != nil {
+		return "", time.Time{}, nil
+	}
+	_, key := cfg.videoFile(video)
+	if key == nil {
+		return "", time.Time{}, nil
+	}
+
+	expiresAt := time.Now().UTC().Add(expiresIn)
+	base := strings.TrimSuffix(*key, path.Ext(*key))
+	cookies, err := cfg.cdnPlayback.signer.SignedCookies(cfg.s3CfDistribution+"/"+base+"*", expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	for _, cookie := range cookies {
+		cookie.Domain = cfg.cdnPlayback.cookieDomain
+		cookie.Path = "/"
+		cookie.Expires = expiresAt
+		cookie.Secure = true
+		cookie.HttpOnly = true
+		cookie.SameSite = http.SameSiteNoneMode
+		http.SetCookie(w, cookie)
+	}
+	return cfg.s3CfDistribution + "/" + *key, expiresAt, nil
+}
+
+// loadCDNInvalidators sets up cache invalidation for the CloudFront
+// distributions in front of the video bucket (CLOUDFRONT_DISTRIBUTION_ID)
+// and the app's assets (CLOUDFRONT_ASSETS_DISTRIBUTION_ID). Either is nil
+// if its distribution isn't set.
+func loadCDNInvalidators() (videos, assets *cdn.Invalidator, err error) {
+	videoDistribution := os.Getenv("CLOUDFRONT_DISTRIBUTION_ID")
+	assetsDistribution := os.Getenv("CLOUDFRONT_ASSETS_DISTRIBUTION_ID")
+	if videoDistribution == "" && assetsDistribution == "" {
+		return nil, nil, nil
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading aws configuration: %w", err)
+	}
+	if videoDistribution != "" {
+		videos = cdn.NewInvalidator(videoDistribution, awsCfg.Credentials)
+	}
+	if assetsDistribution != "" {
+		assets = cdn.NewInvalidator(assetsDistribution, awsCfg.Credentials)
+	}
+	return videos, assets, nil
+}
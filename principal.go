@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var (
+	errInvalidAPIKey        = errors.New("invalid API key")
+	errUnknownUser          = errors.New("user no longer exists")
+	errImpersonationRevoked = errors.New("impersonator is no longer allowed to impersonate")
+)
+
+// principal is the authenticated caller of a request: either a user who
+// logged in (and holds every scope) or an API key acting for its owner
+// with a limited set of scopes. Either way the owner's role applies.
+// Trusted internal services are principals too, with no user, the service
+// role, and the scopes configured for them. An admin impersonating a user
+// acts with the user's role but only the video scopes.
+type principal struct {
+	UserID    uuid.UUID
+	Role      string
+	SessionID uuid.UUID
+	APIKeyID  *uuid.UUID
+	// APIKeyRateLimit is the key's own requests-per-minute override
+	APIKeyRateLimit *int
+	Service         string
+	ImpersonatorID  *uuid.UUID
+	Scopes          []string
+}
+
+func (p principal) can(perm auth.Permission) bool {
+	return auth.RoleHasPermission(p.Role, perm)
+}
+
+// canManageVideo reports whether the caller may delete video and control
+// who it is shared with: admins may manage anything, organization owners
+// their organization's videos, and creators their own personal videos.
+func (cfg *apiConfig) canManageVideo(p principal, video database.Video) bool {
+	if p.can(auth.PermVideoManageAny) {
+		return true
+	}
+	if !p.can(auth.PermVideoCreate) {
+		return false
+	}
+	if video.OrgID != nil {
+		return cfg.orgRoleAtLeast(p, *video.OrgID, database.OrgRoleOwner)
+	}
+	return video.UserID == p.UserID
+}
+
+// canEditVideo reports whether the caller may upload to or change the
+// settings of video. Besides managers, organization editors and creators
+// holding an edit grant qualify.
+func (cfg *apiConfig) canEditVideo(p principal, video database.Video) bool {
+	if cfg.canManageVideo(p, video) {
+		return true
+	}
+	if !p.can(auth.PermVideoCreate) {
+		return false
+	}
+	if video.OrgID != nil && cfg.orgRoleAtLeast(p, *video.OrgID, database.OrgRoleEditor) {
+		return true
+	}
+	return cfg.grantAccess(p, video) == database.GrantAccessEdit
+}
+
+// canViewVideo reports whether the caller may see video. Public and
+// unlisted videos are visible to everyone; private ones only to managers,
+// staff, organization members, and users holding a grant.
+func (cfg *apiConfig) canViewVideo(p principal, video database.Video) bool {
+	if video.Visibility != videoVisibilityPrivate {
+		return true
+	}
+	if cfg.canManageVideo(p, video) || p.can(auth.PermVideoTakedown) {
+		return true
+	}
+	if video.OrgID != nil && cfg.orgRoleAtLeast(p, *video.OrgID, database.OrgRoleViewer) {
+		return true
+	}
+	return cfg.grantAccess(p, video) != ""
+}
+
+// managedVideo loads the video named in the path and checks that the
+// caller may manage it, writing an error response and returning false
+// otherwise.
+func (cfg *apiConfig) managedVideo(w http.ResponseWriter, r *http.Request, p principal) (database.Video, bool) {
+	return cfg.pathVideo(w, r, p, cfg.canManageVideo, "You can't manage this video")
+}
+
+// editableVideo is managedVideo for actions that only need canEditVideo,
+// such as uploads.
+func (cfg *apiConfig) editableVideo(w http.ResponseWriter, r *http.Request, p principal) (database.Video, bool) {
+	return cfg.pathVideo(w, r, p, cfg.canEditVideo, "You can't edit this video")
+}
+
+func (cfg *apiConfig) pathVideo(w http.ResponseWriter, r *http.Request, p principal, allowed func(principal, database.Video) bool, denied string) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	if !allowed(p, video) {
+		respondWithError(w, http.StatusForbidden, denied, nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+func (cfg *apiConfig) grantAccess(p principal, video database.Video) string {
+	if p.UserID == uuid.Nil {
+		return ""
+	}
+	grant, err := cfg.db.GetVideoGrant(video.ID, p.UserID)
+	if err != nil {
+		log.Printf("Couldn't look up grant on video %s for user %s: %v", video.ID, p.UserID, err)
+		return ""
+	}
+	return grant.Access
+}
+
+func (p principal) hasScope(scope string) bool {
+	if p.APIKeyID == nil && p.Service == "" && p.ImpersonatorID == nil {
+		return true
+	}
+	return slices.Contains(p.Scopes, scope)
+}
+
+// authenticate resolves the caller from the Authorization header. Both
+// "Bearer <jwt>" and "Bearer <api key>" (or "ApiKey <api key>") are
+// accepted. Requests signed by a trusted service are checked first.
+func (cfg *apiConfig) authenticate(r *http.Request) (principal, error) {
+	if auth.IsServiceRequest(r) {
+		key, err := cfg.serviceVerifier.Verify(r, time.Now())
+		if err != nil {
+			return principal{}, err
+		}
+		return principal{Role: auth.RoleService, Service: key.Name, Scopes: key.Scopes}, nil
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		token, err = auth.GetAPIKey(r.Header)
+		if err != nil {
+			return principal{}, err
+		}
+	}
+
+	if !auth.IsAPIKey(token) {
+		access, err := auth.ValidateJWT(token, cfg.jwtKeys, cfg.sessionDenylist)
+		if err != nil {
+			return principal{}, err
+		}
+		role, err := cfg.userRole(access.UserID)
+		if err != nil {
+			return principal{}, err
+		}
+		p := principal{UserID: access.UserID, Role: role, SessionID: access.SessionID}
+		if access.ImpersonatorID != uuid.Nil {
+			// The impersonator must still be allowed to impersonate
+			adminRole, err := cfg.userRole(access.ImpersonatorID)
+			if err != nil {
+				return principal{}, err
+			}
+			if !auth.RoleHasPermission(adminRole, auth.PermUserImpersonate) {
+				return principal{}, errImpersonationRevoked
+			}
+			p.ImpersonatorID = &access.ImpersonatorID
+			p.Scopes = auth.APIKeyScopes
+		}
+		return p, nil
+	}
+
+	key, err := cfg.db.GetAPIKeyByHash(auth.HashAPIKey(token))
+	if err != nil {
+		return principal{}, err
+	}
+	if key.ID == uuid.Nil {
+		return principal{}, errInvalidAPIKey
+	}
+	role, err := cfg.userRole(key.UserID)
+	if err != nil {
+		return principal{}, err
+	}
+	if err := cfg.db.TouchAPIKey(key.ID); err != nil {
+		log.Printf("Couldn't record use of API key %s: %v", key.ID, err)
+	}
+	return principal{
+		UserID:          key.UserID,
+		Role:            role,
+		APIKeyID:        &key.ID,
+		APIKeyRateLimit: key.RateLimit,
+		Scopes:          key.Scopes,
+	}, nil
+}
+
+type principalKey struct{}
+
+// withPrincipal is middleware that authenticates the request and checks
+// the caller holds scope before calling next. Every authenticated route
+// declares its scope this way when it is registered, and handlers read the
+// caller with contextPrincipal.
+func (cfg *apiConfig) withPrincipal(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := cfg.authenticate(r)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate credentials", err)
+			return
+		}
+		if !p.hasScope(scope) {
+			respondWithError(w, http.StatusForbidden, "Credentials are missing the "+scope+" scope", nil)
+			return
+		}
+		if !cfg.meterAPIKey(w, p) {
+			return
+		}
+		next(w, withContextPrincipal(r, p))
+	}
+}
+
+// withOptionalPrincipal is withPrincipal for routes where credentials are
+// optional. Anonymous callers, and keys without scope, get the zero
+// principal, which holds no role and no grants.
+func (cfg *apiConfig) withOptionalPrincipal(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := cfg.authenticate(r)
+		if err != nil || !p.hasScope(scope) {
+			p = principal{}
+		}
+		if !cfg.meterAPIKey(w, p) {
+			return
+		}
+		next(w, withContextPrincipal(r, p))
+	}
+}
+
+// requirePermission is middleware for routes that need a role permission
+// regardless of which resource is involved, such as the admin reports.
+func (cfg *apiConfig) requirePermission(scope string, perm auth.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.withPrincipal(scope, func(w http.ResponseWriter, r *http.Request) {
+		if !contextPrincipal(r).can(perm) {
+			respondWithError(w, http.StatusForbidden, "Your role doesn't allow this action", nil)
+			return
+		}
+		next(w, r)
+	})
+}
+
+func withContextPrincipal(r *http.Request, p principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// contextPrincipal returns the caller stored by the route's middleware. A
+// route registered without one is a programming error, so it panics rather
+// than treating the request as anonymous.
+func contextPrincipal(r *http.Request) principal {
+	p, ok := r.Context().Value(principalKey{}).(principal)
+	if !ok {
+		panic("contextPrincipal: route " + r.Pattern + " has no principal middleware")
+	}
+	return p
+}
+
+// userRole looks the role up on every request rather than trusting a token
+// claim, so role changes take effect immediately.
+func (cfg *apiConfig) userRole(userID uuid.UUID) (string, error) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		return "", errUnknownUser
+	}
+	return user.Role, nil
+}
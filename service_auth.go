@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// minServiceSecretLength keeps shared service secrets at least as strong
+// as the HMAC they key.
+const minServiceSecretLength = 32
+
+// loadServiceKeys reads the trusted internal services from SERVICE_KEYS,
+// a comma-separated list of name=secret pairs. Each service's scopes come
+// from SERVICE_SCOPES_<NAME> (space-separated) and default to read-only.
+func loadServiceKeys() ([]auth.ServiceKey, error) {
+	keys := []auth.ServiceKey{}
+	for _, entry := range envList("SERVICE_KEYS", nil) {
+		name, secret, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("SERVICE_KEYS entry %q must be name=secret", entry)
+		}
+		if len(secret) < minServiceSecretLength {
+			return nil, fmt.Errorf("SERVICE_KEYS secret for %s must be at least %d characters", name, minServiceSecretLength)
+		}
+
+		scopes := []string{auth.ScopeVideoRead}
+		envName := "SERVICE_SCOPES_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		if v := os.Getenv(envName); v != "" {
+			scopes = strings.Fields(v)
+		}
+		for _, scope := range scopes {
+			if !auth.ValidAPIKeyScope(scope) {
+				return nil, fmt.Errorf("%s contains unknown scope %q", envName, scope)
+			}
+		}
+
+		keys = append(keys, auth.ServiceKey{Name: name, Secret: []byte(secret), Scopes: scopes})
+	}
+	return keys, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// storageCheckPrefix is where checkStore writes its probe objects. It is
+// outside the video prefixes, so the GC never sees them.
+const storageCheckPrefix = "healthcheck/"
+
+// checkStore makes sure the app can use store before it starts serving,
+// so a missing bucket or permission fails startup rather than a user's
+// first upload. It writes a small object, reads it back directly and
+// through a presigned URL, and deletes it again.
+func checkStore(ctx context.Context, store storage.Store) error {
+	key := storageCheckPrefix + uuid.NewString()
+	probe := []byte("tubely storage check " + time.Now().UTC().Format(time.RFC3339))
+
+	err := store.PutObject(ctx, key, bytes.NewReader(probe), storage.PutOptions{ContentType: "text/plain"})
+	if err != nil {
+		return fmt.Errorf("couldn't write to storage: %w", err)
+	}
+	err = checkProbe(ctx, store, key, probe)
+	// Deleting is checked too, since replaced videos need it
+	if deleteErr := store.Delete(ctx, key); err == nil && deleteErr != nil {
+		err = fmt.Errorf("couldn't delete from storage: %w", deleteErr)
+	}
+	return err
+}
+
+// checkProbe reads back the object checkStore wrote.
+func checkProbe(ctx context.Context, store storage.Store, key string, probe []byte) error {
+	obj, err := store.GetObject(ctx, key)
+	if err != nil {
+		return fmt.Errorf("couldn't read from storage: %w", err)
+	}
+	got, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't read from storage: %w", err)
+	}
+	if !bytes.Equal(got, probe) {
+		return fmt.Errorf("storage returned different content than was written")
+	}
+
+	link, err := store.Presign(ctx, key, time.Minute)
+	if errors.Is(err, storage.ErrPresignUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't presign storage URLs: %w", err)
+	}
+	// The local backend serves presigned URLs from this server, which
+	// isn't listening yet
+	if _, local := store.(storage.LocalStore); local {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("couldn't fetch a presigned storage URL: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("presigned storage URL returned %s", resp.Status)
+	}
+	return nil
+}
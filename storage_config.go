@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// localMediaPrefix is where the app serves objects itself when using the
+// local storage backend.
+const localMediaPrefix = "/media"
+
+// loadStore builds the object store named by STORAGE_BACKEND and returns
+// it with the public URL prefix its objects are served under. "s3" (the
+// default) needs the S3_* settings; "local" keeps files under STORAGE_DIR
+// and serves them from this server, so development needs no AWS account.
+func loadStore(baseURL, jwtSecret string) (storage.Store, string, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "s3":
+		s3Bucket := os.Getenv("S3_BUCKET")
+		if s3Bucket == "" {
+			return nil, "", fmt.Errorf("S3_BUCKET environment variable is not set")
+		}
+		s3Region := os.Getenv("S3_REGION")
+		if s3Region == "" {
+			return nil, "", fmt.Errorf("S3_REGION environment variable is not set")
+		}
+		s3CfDistribution := os.Getenv("S3_CF_DISTRO")
+		if s3CfDistribution == "" {
+			return nil, "", fmt.Errorf("S3_CF_DISTRO environment variable is not set")
+		}
+
+		awsCfg, err := loadAWSConfig(s3Region)
+		if err != nil {
+			return nil, "", err
+		}
+		store := storage.NewS3Store(newS3Client(awsCfg), s3Bucket)
+		store.SSEKMSKeyID = os.Getenv("S3_SSE_KMS_KEY_ID")
+		// During an outage, fail fast rather than tying up a goroutine per
+		// request until the SDK gives up
+		if threshold := envNonNegativeInt("S3_BREAKER_THRESHOLD", 5); threshold > 0 {
+			store.Breaker = storage.NewBreaker("s3", threshold, envDuration("S3_BREAKER_COOLDOWN", 30*time.Second))
+		}
+
+		// Client-side encryption keeps media unreadable even to someone
+		// with access to the bucket; playback then goes through the app
+		if keyID := os.Getenv("S3_CLIENT_ENCRYPTION_KMS_KEY_ID"); keyID != "" {
+			return storage.EncryptedStore{
+				Store: store,
+				Keys: storage.KMSKeyProvider{
+					KeyID:       keyID,
+					Region:      s3Region,
+					Credentials: awsCfg.Credentials,
+					Client:      &http.Client{Timeout: 10 * time.Second},
+				},
+			}, s3CfDistribution, nil
+		}
+		return store, s3CfDistribution, nil
+	case "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "./data"
+		}
+		mediaURL := baseURL + localMediaPrefix
+		// Signed media links only need to survive restarts, so derive
+		// their key from the JWT secret rather than adding another one
+		secret := sha256.Sum256([]byte("tubely-media\n" + jwtSecret))
+		store, err := storage.NewLocalStore(dir, mediaURL, secret[:])
+		if err != nil {
+			return nil, "", fmt.Errorf("couldn't create storage directory: %w", err)
+		}
+
+		// STORAGE_ENCRYPTION_KEY lets client-side encryption be tried out
+		// locally without KMS
+		if hexKey := os.Getenv("STORAGE_ENCRYPTION_KEY"); hexKey != "" {
+			masterKey, err := hex.DecodeString(hexKey)
+			if err != nil || len(masterKey) != 32 {
+				return nil, "", fmt.Errorf("STORAGE_ENCRYPTION_KEY must be 64 hex characters")
+			}
+			return storage.EncryptedStore{
+				Store: store,
+				Keys:  storage.StaticKeyProvider{MasterKey: masterKey},
+			}, mediaURL, nil
+		}
+		return store, mediaURL, nil
+	default:
+		return nil, "", fmt.Errorf("STORAGE_BACKEND must be s3 or local, got %q", backend)
+	}
+}
+
+// storeLocation names the provider and bucket of store, recorded with
+// each video so a file can still be found after the configuration
+// changes. Stores without buckets return a nil bucket.
+func storeLocation(store storage.Store) (string, *string) {
+	switch s := store.(type) {
+	case storage.EncryptedStore:
+		return storeLocation(s.Store)
+	case storage.S3Store:
+		return "s3", &s.Bucket
+	case storage.LocalStore:
+		return "local", nil
+	default:
+		return fmt.Sprintf("%T", store), nil
+	}
+}
+
+// storeBreaker returns the circuit breaker guarding store, if any.
+func storeBreaker(store storage.Store) *storage.Breaker {
+	switch s := store.(type) {
+	case storage.EncryptedStore:
+		return storeBreaker(s.Store)
+	case storage.S3Store:
+		return s.Breaker
+	default:
+		return nil
+	}
+}
+
+// loadAWSConfig loads the AWS SDK configuration for region.
+func loadAWSConfig(region string) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+	// Self-signed certificates are common on self-hosted MinIO
+	if envBool("S3_INSECURE_SKIP_VERIFY", false) {
+		log.Println("Warning: S3_INSECURE_SKIP_VERIFY is set, TLS certificates from the S3 endpoint are not verified")
+		opts = append(opts, config.WithHTTPClient(awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = &tls.Config{}
+			}
+			tr.TLSClientConfig.InsecureSkipVerify = true
+		})))
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("error loading aws configuration: %w", err)
+	}
+
+	// S3_ASSUME_ROLE_ARN swaps the default credentials for temporary
+	// ones from STS, e.g. for a bucket in another account. The cache
+	// refreshes them shortly before they expire
+	if roleARN := os.Getenv("S3_ASSUME_ROLE_ARN"); roleARN != "" {
+		sessionName := os.Getenv("S3_ASSUME_ROLE_SESSION_NAME")
+		if sessionName == "" {
+			sessionName = "tubely"
+		}
+		duration := envDuration("S3_ASSUME_ROLE_DURATION", time.Hour)
+		if duration < 15*time.Minute {
+			return aws.Config{}, fmt.Errorf("S3_ASSUME_ROLE_DURATION must be at least 15m")
+		}
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+			o.Duration = duration
+			if externalID := os.Getenv("S3_ASSUME_ROLE_EXTERNAL_ID"); externalID != "" {
+				o.ExternalID = &externalID
+			}
+		})
+		awsCfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return awsCfg, nil
+}
+
+// newS3Client creates an S3 client for awsCfg's region. S3_ENDPOINT
+// points it at an S3-compatible service such as MinIO instead of AWS.
+// Most of those need path-style addressing, since buckets don't get their
+// own hostnames.
+func newS3Client(awsCfg aws.Config) *s3.Client {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	usePathStyle := envBool("S3_USE_PATH_STYLE", endpoint != "")
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = &endpoint
+		}
+		o.UsePathStyle = usePathStyle
+	})
+}
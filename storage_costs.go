@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Sources video is served to viewers from, counted separately since
+// they're billed at different rates.
+const (
+	// egressCDN is downloads through CloudFront
+	egressCDN = "cdn"
+	// egressStorage is downloads straight from the bucket
+	egressStorage = "storage"
+	// egressApp is video streamed by the app itself
+	egressApp = "app"
+)
+
+// bytesPerGB is the gigabyte AWS bills by.
+const bytesPerGB = 1 << 30
+
+// Default prices in USD, from the us-east-1 list prices: per GB-month
+// stored in each S3 storage class, and per GB served from each source.
+var (
+	defaultStoragePrices = map[string]float64{
+		"STANDARD":            0.023,
+		"REDUCED_REDUNDANCY":  0.024,
+		"INTELLIGENT_TIERING": 0.023,
+		"STANDARD_IA":         0.0125,
+		"ONEZONE_IA":          0.01,
+		"GLACIER_IR":          0.004,
+		"GLACIER":             0.0036,
+		"DEEP_ARCHIVE":        0.00099,
+	}
+	defaultEgressPrices = map[string]float64{
+		egressCDN:     0.085,
+		egressStorage: 0.09,
+		egressApp:     0.09,
+	}
+)
+
+// storagePricing is what the cost report prices usage at.
+type storagePricing struct {
+	storage map[string]float64
+	egress  map[string]float64
+}
+
+// loadStoragePricing reads STORAGE_PRICES_PER_GB, a comma-separated list
+// of CLASS:price pairs, and EGRESS_PRICES_PER_GB, a list of source:price
+// pairs, each overriding the defaults for the classes or sources listed.
+func loadStoragePricing() (storagePricing, error) {
+	pricing := storagePricing{
+		storage: maps.Clone(defaultStoragePrices),
+		egress:  maps.Clone(defaultEgressPrices),
+	}
+	for name, prices := range map[string]map[string]float64{
+		"STORAGE_PRICES_PER_GB": pricing.storage,
+		"EGRESS_PRICES_PER_GB":  pricing.egress,
+	} {
+		for _, pair := range envList(name, nil) {
+			item, rawPrice, ok := strings.Cut(pair, ":")
+			price, err := strconv.ParseFloat(rawPrice, 64)
+			if !ok || err != nil || price < 0 {
+				return storagePricing{}, fmt.Errorf("invalid %s entry %q, expected name:price", name, pair)
+			}
+			if _, known := prices[item]; !known {
+				return storagePricing{}, fmt.Errorf("%s lists unknown %q", name, item)
+			}
+			prices[item] = price
+		}
+	}
+	return pricing, nil
+}
+
+// recordEgress counts a playback of video served from source. Presigned
+// and CDN downloads bypass the app, so each counts as one full download
+// of the file.
+func (cfg *apiConfig) recordEgress(video database.Video, source string) {
+	if video.VideoSize == nil {
+		return
+	}
+	cfg.recordVideoEgress(video, source, *video.VideoSize)
+}
+
+// recordVideoEgress counts bytes of video's file served from source. Every
+// playback of a video, proxied or not, is recorded through here.
+func (cfg *apiConfig) recordVideoEgress(video database.Video, source string, bytes int64) {
+	cfg.recordEgressBytes(source, bytes)
+}
+
+func (cfg *apiConfig) recordEgressBytes(source string, bytes int64) {
+	if err := cfg.db.RecordEgress(source, bytes); err != nil {
+		log.Printf("Couldn't record egress: %v", err)
+	}
+}
+
+// linkEgressSource returns where a playback link is served from.
+func (cfg *apiConfig) linkEgressSource(link string) string {
+	switch {
+	case cfg.cdnPlayback.signer != nil && strings.HasPrefix(link, cfg.s3CfDistribution+"/"):
+		return egressCDN
+	case strings.HasPrefix(link, cfg.baseURL+"/"):
+		return egressApp
+	default:
+		return egressStorage
+	}
+}
+
+// handlerStorageCosts estimates a month's storage and transfer costs for
+// finance: storage is priced from what is stored now, egress from the
+// playbacks counted in the month so far. It is JSON by default, or CSV
+// with ?format=csv.
+func (cfg *apiConfig) handlerStorageCosts(w http.ResponseWriter, r *http.Request) {
+	type storageLine struct {
+		database.StorageClassUsage
+		PricePerGBMonth float64 `json:"price_per_gb_month"`
+		EstimatedCost   float64 `json:"estimated_cost"`
+	}
+	type egressLine struct {
+		database.EgressUsage
+		PricePerGB    float64 `json:"price_per_gb"`
+		EstimatedCost float64 `json:"estimated_cost"`
+	}
+	type response struct {
+		Month              string        `json:"month"`
+		Currency           string        `json:"currency"`
+		Storage            []storageLine `json:"storage"`
+		Egress             []egressLine  `json:"egress"`
+		TotalEstimatedCost float64       `json:"total_estimated_cost"`
+	}
+
+	start := time.Now().UTC()
+	start = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if v := r.URL.Query().Get("month"); v != "" {
+		month, err := time.Parse("2006-01", v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "month must be formatted YYYY-MM", err)
+			return
+		}
+		start = month
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		respondWithError(w, http.StatusBadRequest, "format must be json or csv", nil)
+		return
+	}
+
+	stored, err := cfg.db.GetStorageClassUsage()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	egress, err := cfg.db.GetEgressUsage(start, start.AddDate(0, 1, -1))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get egress usage", err)
+		return
+	}
+
+	resp := response{Month: start.Format("2006-01"), Currency: "USD", Storage: []storageLine{}, Egress: []egressLine{}}
+	for _, usage := range stored {
+		class := "STANDARD"
+		if usage.StorageClass != nil {
+			class = *usage.StorageClass
+		}
+		line := storageLine{StorageClassUsage: usage, PricePerGBMonth: cfg.storagePricing.storage[class]}
+		line.EstimatedCost = roundCents(float64(usage.Bytes) / bytesPerGB * line.PricePerGBMonth)
+		resp.Storage = append(resp.Storage, line)
+		resp.TotalEstimatedCost += line.EstimatedCost
+	}
+	for _, usage := range egress {
+		line := egressLine{EgressUsage: usage, PricePerGB: cfg.storagePricing.egress[usage.Source]}
+		line.EstimatedCost = roundCents(float64(usage.Bytes) / bytesPerGB * line.PricePerGB)
+		resp.Egress = append(resp.Egress, line)
+		resp.TotalEstimatedCost += line.EstimatedCost
+	}
+	resp.TotalEstimatedCost = roundCents(resp.TotalEstimatedCost)
+
+	if format != "csv" {
+		respondWithJSON(w, http.StatusOK, resp)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="storage-costs-%s.csv"`, resp.Month))
+	out := csv.NewWriter(w)
+	out.Write([]string{"month", "category", "item", "bytes", "count", "unit_price", "estimated_cost"})
+	for _, line := range resp.Storage {
+		class := "STANDARD"
+		if line.StorageClass != nil {
+			class = *line.StorageClass
+		}
+		out.Write([]string{resp.Month, "storage", class, strconv.FormatInt(line.Bytes, 10), strconv.Itoa(line.VideoCount), formatPrice(line.PricePerGBMonth), formatPrice(line.EstimatedCost)})
+	}
+	for _, line := range resp.Egress {
+		out.Write([]string{resp.Month, "egress", line.Source, strconv.FormatInt(line.Bytes, 10), strconv.FormatInt(line.Requests, 10), formatPrice(line.PricePerGB), formatPrice(line.EstimatedCost)})
+	}
+	out.Write([]string{resp.Month, "total", "", "", "", "", formatPrice(resp.TotalEstimatedCost)})
+	out.Flush()
+}
+
+func roundCents(amount float64) float64 {
+	return float64(int64(amount*100+0.5)) / 100
+}
+
+func formatPrice(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
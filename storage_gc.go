@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// videoKeyPrefixes are the key prefixes video uploads are stored under.
+// Anything else in the bucket isn't ours to clean up.
+var videoKeyPrefixes = []string{"landscape/", "portrait/", "other/", "quarantine/"}
+
+// storageGCPolicy configures the orphaned object collector.
+type storageGCPolicy struct {
+	interval time.Duration
+	// grace keeps objects younger than this, since an upload's object is
+	// written before its video row is updated
+	grace time.Duration
+	// delete removes orphans; otherwise they are only reported
+	delete bool
+}
+
+// listVideoObjects returns every object under prefixes, keyed by object
+// key.
+func listVideoObjects(ctx context.Context, lister storage.Lister, prefixes []string) (map[string]storage.ObjectInfo, error) {
+	objects := map[string]storage.ObjectInfo{}
+	for _, prefix := range prefixes {
+		err := lister.ListObjects(ctx, prefix, func(obj storage.ObjectInfo) error {
+			objects[obj.Key] = obj
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+// runStorageGC collects orphaned objects every interval until ctx is done.
+func (cfg *apiConfig) runStorageGC(ctx context.Context, lister storage.Lister) {
+	ticker := time.NewTicker(cfg.storageGC.interval)
+	defer ticker.Stop()
+	for {
+		cfg.collectOrphanedObjects(ctx, lister)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collectOrphanedObjects finds video objects that no video row refers to,
+// such as those left behind by failed uploads, and reports or deletes
+// them.
+func (cfg *apiConfig) collectOrphanedObjects(ctx context.Context, lister storage.Lister) {
+	refs, err := cfg.db.GetVideoFileRefs()
+	if err != nil {
+		log.Printf("Storage GC: couldn't list video files: %v", err)
+		return
+	}
+	versionRefs, err := cfg.db.GetVideoFileVersionRefs()
+	if err != nil {
+		log.Printf("Storage GC: couldn't list video file versions: %v", err)
+		return
+	}
+	refs = append(refs, versionRefs...)
+	known := map[string]bool{}
+	unresolved := 0
+	for _, ref := range refs {
+		store, key := cfg.videoFile(database.Video{
+			VideoProvider: ref.VideoProvider,
+			VideoBucket:   ref.VideoBucket,
+			VideoKey:      ref.VideoKey,
+			QuarantineKey: ref.QuarantineKey,
+		})
+		if key == nil {
+			unresolved++
+			continue
+		}
+		// Only the primary bucket is collected
+		if cfg.inPrimaryBucket(store) {
+			known[*key] = true
+		}
+	}
+
+	// A URL we can't map back to a key usually means S3_CF_DISTRO
+	// changed, in which case every object would look orphaned
+	deleteOrphans := cfg.storageGC.delete
+	if unresolved > 0 && deleteOrphans {
+		log.Printf("Storage GC: %d video URL(s) don't match S3_CF_DISTRO, reporting orphans without deleting", unresolved)
+		deleteOrphans = false
+	}
+
+	objects, err := listVideoObjects(ctx, lister, cfg.primaryKeyPrefixes())
+	if err != nil {
+		log.Printf("Storage GC: couldn't list objects: %v", err)
+		return
+	}
+
+	cutoff := time.Now().Add(-cfg.storageGC.grace)
+	found, deleted := 0, 0
+	for _, obj := range objects {
+		if known[obj.Key] || obj.LastModified.After(cutoff) {
+			continue
+		}
+		found++
+		cfg.storageOrphans.Inc("found")
+		if !deleteOrphans {
+			log.Printf("Storage GC: orphaned object %s (%d bytes, modified %s)", obj.Key, obj.Size, obj.LastModified.Format(time.RFC3339))
+			continue
+		}
+		if err := cfg.store.Delete(ctx, obj.Key); err != nil {
+			log.Printf("Storage GC: couldn't delete %s: %v", obj.Key, err)
+			continue
+		}
+		deleted++
+		cfg.storageOrphans.Inc("deleted")
+	}
+	if found > 0 {
+		log.Printf("Storage GC: found %d orphaned object(s), deleted %d", found, deleted)
+	}
+}
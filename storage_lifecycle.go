@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// storageTransitionBatch caps how many videos move per transition per run,
+// so a large backlog is worked through over several runs.
+const storageTransitionBatch = 100
+
+// storageClassPolicy is the configured S3 storage class for new uploads
+// and the age-based transitions applied to them afterwards.
+type storageClassPolicy struct {
+	upload      string
+	transitions []storageTransition
+	// archive is the class videos are archived to on request, and
+	// restoreDays how long a restored copy stays readable.
+	archive     string
+	restoreDays int
+}
+
+// storageTransition moves video files to class once they have been
+// stored for at least after.
+type storageTransition struct {
+	class string
+	after time.Duration
+}
+
+// loadStorageClassPolicy reads S3_STORAGE_CLASS and S3_STORAGE_TRANSITIONS.
+// Transitions are a comma-separated list of CLASS:age pairs in increasing
+// age, e.g. "STANDARD_IA:720h,GLACIER_IR:2160h".
+func loadStorageClassPolicy() (storageClassPolicy, error) {
+	policy := storageClassPolicy{
+		upload:      os.Getenv("S3_STORAGE_CLASS"),
+		archive:     os.Getenv("S3_ARCHIVE_STORAGE_CLASS"),
+		restoreDays: envInt("S3_RESTORE_DAYS", 7),
+	}
+	if policy.upload != "" && !validStorageClass(policy.upload) {
+		return storageClassPolicy{}, fmt.Errorf("S3_STORAGE_CLASS %q is not an S3 storage class", policy.upload)
+	}
+	if policy.archive == "" {
+		policy.archive = "GLACIER"
+	}
+	if !isArchiveClass(&policy.archive) {
+		return storageClassPolicy{}, fmt.Errorf("S3_ARCHIVE_STORAGE_CLASS must be GLACIER or DEEP_ARCHIVE")
+	}
+
+	for _, entry := range envList("S3_STORAGE_TRANSITIONS", nil) {
+		class, age, ok := strings.Cut(entry, ":")
+		if !ok || !validStorageClass(class) {
+			return storageClassPolicy{}, fmt.Errorf("S3_STORAGE_TRANSITIONS entry %q must be CLASS:age with an S3 storage class", entry)
+		}
+		after, err := time.ParseDuration(age)
+		if err != nil || after <= 0 {
+			return storageClassPolicy{}, fmt.Errorf("S3_STORAGE_TRANSITIONS entry %q has an invalid age", entry)
+		}
+		if n := len(policy.transitions); n > 0 && after <= policy.transitions[n-1].after {
+			return storageClassPolicy{}, fmt.Errorf("S3_STORAGE_TRANSITIONS must be in increasing age")
+		}
+		policy.transitions = append(policy.transitions, storageTransition{class: class, after: after})
+	}
+	return policy, nil
+}
+
+func validStorageClass(class string) bool {
+	return slices.Contains(types.StorageClass("").Values(), types.StorageClass(class))
+}
+
+// runStorageTransitions applies the storage class transitions every
+// interval until ctx is done.
+func (cfg *apiConfig) runStorageTransitions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cfg.applyStorageTransitions(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyStorageTransitions moves videos old enough for a transition into
+// its class. The oldest transitions go first and a video is never moved
+// back to an earlier class, so each file moves at most once per run.
+func (cfg *apiConfig) applyStorageTransitions(ctx context.Context) {
+	transitions := cfg.storageClasses.transitions
+	for i := len(transitions) - 1; i >= 0; i-- {
+		transition := transitions[i]
+		skip := []string{}
+		for _, later := range transitions[i:] {
+			skip = append(skip, later.class)
+		}
+
+		videos, err := cfg.db.GetVideosForStorageTransition(time.Now().Add(-transition.after), skip, storageTransitionBatch)
+		if err != nil {
+			log.Printf("Couldn't list videos for %s transition: %v", transition.class, err)
+			continue
+		}
+		moved := 0
+		for _, video := range videos {
+			store, key := cfg.videoFile(video)
+			mover, ok := store.(storage.ClassMover)
+			if key == nil || !ok {
+				continue
+			}
+			if err := mover.SetStorageClass(ctx, *key, transition.class); err != nil {
+				log.Printf("Couldn't move video %s to %s: %v", video.ID, transition.class, err)
+				continue
+			}
+			if err := cfg.db.SetVideoStorageClass(video.ID, transition.class); err != nil {
+				log.Printf("Couldn't record storage class of video %s: %v", video.ID, err)
+				continue
+			}
+			moved++
+		}
+		if moved > 0 {
+			log.Printf("Moved %d video(s) to %s", moved, transition.class)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// playbackRegionHeader lets a client that has measured its latency to
+// each region pick the replica it is served from.
+const playbackRegionHeader = "X-Playback-Region"
+
+// replicationCheckBatch caps how many videos are checked per run.
+const replicationCheckBatch = 100
+
+// regionReplicas are copies of the video bucket in other regions, kept in
+// sync by S3 replication, that playback URLs can be presigned from so
+// viewers download from a nearby region.
+type regionReplicas struct {
+	// primary is the region of S3_BUCKET
+	primary string
+	stores  map[string]storage.S3Store
+	// regions lists the keys of stores in order
+	regions []string
+	// countries maps a viewer's country to the region that serves it
+	countries map[string]string
+	// checkInterval is how often videos not yet seen in every replica
+	// are checked again
+	checkInterval time.Duration
+}
+
+// loadRegionReplicas reads S3_REPLICA_BUCKETS, a comma-separated list of
+// region:bucket pairs, and S3_REPLICA_COUNTRIES, a list of country:region
+// pairs choosing the replica for viewers in each country. Viewers from
+// other countries are served from S3_BUCKET.
+func loadRegionReplicas() (regionReplicas, error) {
+	replicas := regionReplicas{
+		primary:       os.Getenv("S3_REGION"),
+		stores:        map[string]storage.S3Store{},
+		countries:     map[string]string{},
+		checkInterval: envDuration("S3_REPLICA_CHECK_INTERVAL", time.Minute),
+	}
+	for _, pair := range envList("S3_REPLICA_BUCKETS", nil) {
+		region, bucket, ok := strings.Cut(pair, ":")
+		if !ok || region == "" || bucket == "" {
+			return regionReplicas{}, fmt.Errorf("invalid S3_REPLICA_BUCKETS entry %q, expected region:bucket", pair)
+		}
+		if region == replicas.primary {
+			return regionReplicas{}, fmt.Errorf("S3_REPLICA_BUCKETS lists %s, the region of S3_BUCKET", region)
+		}
+		awsCfg, err := loadAWSConfig(region)
+		if err != nil {
+			return regionReplicas{}, err
+		}
+		replicas.stores[region] = storage.NewS3Store(newS3Client(awsCfg), bucket)
+		replicas.regions = append(replicas.regions, region)
+	}
+	slices.Sort(replicas.regions)
+	if len(replicas.regions) > 0 && replicas.checkInterval <= 0 {
+		return regionReplicas{}, fmt.Errorf("S3_REPLICA_CHECK_INTERVAL must be positive")
+	}
+
+	for _, pair := range envList("S3_REPLICA_COUNTRIES", nil) {
+		country, region, ok := strings.Cut(pair, ":")
+		country = strings.ToUpper(country)
+		if !ok || len(country) != 2 {
+			return regionReplicas{}, fmt.Errorf("invalid S3_REPLICA_COUNTRIES entry %q, expected country:region", pair)
+		}
+		if _, ok := replicas.stores[region]; !ok {
+			return regionReplicas{}, fmt.Errorf("S3_REPLICA_COUNTRIES maps %s to %q, which isn't in S3_REPLICA_BUCKETS", country, region)
+		}
+		replicas.countries[country] = region
+	}
+	return replicas, nil
+}
+
+// playbackStore returns the store video should be presigned from for
+// the viewer making r, and its region, or "" for the primary bucket. The
+// region header and country are only hints for picking a nearby copy, so
+// a spoofed one just costs the viewer latency.
+func (cfg *apiConfig) playbackStore(r *http.Request, video database.Video) (storage.Store, string) {
+	if len(cfg.replicas.stores) == 0 {
+		return cfg.store, ""
+	}
+
+	region := r.Header.Get(playbackRegionHeader)
+	if region != "" && region == cfg.replicas.primary {
+		return cfg.store, ""
+	}
+	if _, ok := cfg.replicas.stores[region]; !ok {
+		region = cfg.replicas.countries[cfg.requestCountry(r)]
+	}
+	// Replication is asynchronous, so new uploads are served from the
+	// primary bucket until they have been seen in the replica
+	if store, ok := cfg.replicas.stores[region]; ok && slices.Contains(video.ReplicaRegions, region) {
+		return store, region
+	}
+	return cfg.store, ""
+}
+
+// runReplicationCheck looks for pending video files in the replicas
+// every interval until ctx is done.
+func (cfg *apiConfig) runReplicationCheck(ctx context.Context) {
+	ticker := time.NewTicker(cfg.replicas.checkInterval)
+	defer ticker.Stop()
+	for {
+		cfg.checkReplication(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkReplication records which replicas hold each video file that
+// wasn't yet in all of them. Files that never replicate, say because of
+// a replication rule that doesn't cover them, are simply played from the
+// primary bucket.
+func (cfg *apiConfig) checkReplication(ctx context.Context) {
+	videos, err := cfg.db.GetVideosPendingReplication(cfg.replicas.regions, replicationCheckBatch)
+	if err != nil {
+		log.Printf("Replication check: couldn't list videos: %v", err)
+		return
+	}
+	for _, video := range videos {
+		// A file whose key can't be resolved is recorded as in no
+		// replica, so it moves to the back of the queue
+		// Only the primary bucket is replicated
+		store, key := cfg.videoFile(video)
+		if key != nil && !cfg.inPrimaryBucket(store) {
+			key = nil
+		}
+		found := []string{}
+		for _, region := range cfg.replicas.regions {
+			if key == nil {
+				break
+			}
+			_, err := cfg.replicas.stores[region].StatObject(ctx, *key)
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Replication check: couldn't check %s in %s: %v", *key, region, err)
+				continue
+			}
+			found = append(found, region)
+		}
+		if err := cfg.db.SetVideoReplicaRegions(video.ID, *video.VideoURL, found); err != nil {
+			log.Printf("Replication check: couldn't record replicas of video %s: %v", video.ID, err)
+		}
+	}
+}
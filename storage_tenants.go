@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// tenantStorage keeps chosen organizations' video files apart from
+// everyone else's, in their own bucket or under their own key prefix, so
+// a tenant's data can be located, exported or removed on its own.
+type tenantStorage struct {
+	orgs map[uuid.UUID]tenantLocation
+}
+
+// tenantLocation is where an organization's video files are written.
+type tenantLocation struct {
+	store storage.Store
+	// prefix starts the keys of the organization's files, e.g.
+	// "tenants/acme/"
+	prefix string
+}
+
+// loadTenantStorage reads S3_TENANT_BUCKETS, a comma-separated list of
+// orgID:bucket pairs where the bucket may be followed by a key prefix as
+// in "tubely-acme" or "tubely-prod/tenants/acme", and S3_TENANT_KMS_KEYS,
+// a list of orgID:keyID pairs giving the SSE-KMS key used for each
+// organization's files in place of S3_SSE_KMS_KEY_ID. Tenant buckets are
+// reached with the same credentials and region as S3_BUCKET, and aren't
+// behind the CDN, so their files are played through presigned URLs. They
+// are left out of replication, verification and garbage collection.
+func loadTenantStorage(primary storage.Store) (tenantStorage, error) {
+	tenants := tenantStorage{orgs: map[uuid.UUID]tenantLocation{}}
+	buckets := map[uuid.UUID]string{}
+	keys := map[uuid.UUID]string{}
+	for _, setting := range []struct {
+		name   string
+		values map[uuid.UUID]string
+	}{
+		{"S3_TENANT_BUCKETS", buckets},
+		{"S3_TENANT_KMS_KEYS", keys},
+	} {
+		for _, pair := range envList(setting.name, nil) {
+			rawID, value, _ := strings.Cut(pair, ":")
+			orgID, err := uuid.Parse(rawID)
+			if err != nil || value == "" {
+				return tenantStorage{}, fmt.Errorf("invalid %s entry %q, expected orgID:value", setting.name, pair)
+			}
+			setting.values[orgID] = value
+		}
+	}
+
+	primaryBucket := ""
+	if _, bucket := storeLocation(primary); bucket != nil {
+		primaryBucket = *bucket
+	}
+	// A key alone keeps the organization's files in S3_BUCKET
+	for orgID := range keys {
+		if _, ok := buckets[orgID]; !ok {
+			buckets[orgID] = primaryBucket
+		}
+	}
+	for orgID, location := range buckets {
+		bucket, prefix, _ := strings.Cut(location, "/")
+		prefix = strings.Trim(prefix, "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		if bucket == "" {
+			return tenantStorage{}, fmt.Errorf("S3_TENANT_BUCKETS entry for %s has no bucket", orgID)
+		}
+		if bucket == primaryBucket && prefix == "" && keys[orgID] == "" {
+			return tenantStorage{}, fmt.Errorf("S3_TENANT_BUCKETS entry for %s must name another bucket or a prefix", orgID)
+		}
+		store, err := tenantStore(primary, bucket, keys[orgID])
+		if err != nil {
+			return tenantStorage{}, err
+		}
+		tenants.orgs[orgID] = tenantLocation{store: store, prefix: prefix}
+	}
+	return tenants, nil
+}
+
+// tenantStore returns a copy of primary that writes to bucket, encrypting
+// with the SSE-KMS key keyID if it is set.
+func tenantStore(primary storage.Store, bucket, keyID string) (storage.Store, error) {
+	switch s := primary.(type) {
+	case storage.EncryptedStore:
+		inner, err := tenantStore(s.Store, bucket, keyID)
+		if err != nil {
+			return nil, err
+		}
+		s.Store = inner
+		return s, nil
+	case storage.S3Store:
+		s.Bucket = bucket
+		if keyID != "" {
+			s.SSEKMSKeyID = keyID
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("tenant storage needs the s3 storage backend")
+	}
+}
+
+// uploadLocation returns where new files for a video owned by orgID are
+// written.
+func (cfg *apiConfig) uploadLocation(orgID *uuid.UUID) tenantLocation {
+	if orgID != nil {
+		if location, ok := cfg.tenants.orgs[*orgID]; ok {
+			return location
+		}
+	}
+	return tenantLocation{store: cfg.store}
+}
+
+// locationStore returns a store for the files recorded at provider and
+// bucket, or nil if there is none. The store of the owning organization
+// is preferred, so files are rewritten with its encryption key.
+func (cfg *apiConfig) locationStore(orgID *uuid.UUID, provider string, bucket *string) storage.Store {
+	candidates := []storage.Store{cfg.uploadLocation(orgID).store, cfg.store}
+	for _, location := range cfg.tenants.orgs {
+		candidates = append(candidates, location.store)
+	}
+	for _, store := range candidates {
+		if sameLocation(store, provider, bucket) {
+			return store
+		}
+	}
+	return nil
+}
+
+// inPrimaryBucket reports whether store keeps its files in the same
+// place as the primary store.
+func (cfg *apiConfig) inPrimaryBucket(store storage.Store) bool {
+	provider, bucket := storeLocation(cfg.store)
+	return sameLocation(store, provider, bucket)
+}
+
+func sameLocation(store storage.Store, provider string, bucket *string) bool {
+	storeProvider, storeBucket := storeLocation(store)
+	return storeProvider == provider && equalPtr(storeBucket, bucket)
+}
+
+// primaryKeyPrefixes returns the prefixes video files are stored under in
+// the primary bucket, including those of tenants kept there.
+func (cfg *apiConfig) primaryKeyPrefixes() []string {
+	prefixes := slices.Clone(videoKeyPrefixes)
+	for _, location := range cfg.tenants.orgs {
+		if location.prefix == "" || !cfg.inPrimaryBucket(location.store) {
+			continue
+		}
+		for _, prefix := range videoKeyPrefixes {
+			prefixes = append(prefixes, location.prefix+prefix)
+		}
+	}
+	return prefixes
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// Problems recorded against videos whose stored file fails verification.
+const (
+	storageProblemMissing    = "missing"
+	storageProblemSize       = "size_mismatch"
+	storageProblemUnknownKey = "unknown_key"
+)
+
+// runStorageVerify verifies stored video files every interval until ctx
+// is done.
+func (cfg *apiConfig) runStorageVerify(ctx context.Context, lister storage.Lister, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cfg.verifyStoredVideos(ctx, lister)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// verifyStoredVideos is the inverse of collectOrphanedObjects: it checks
+// that every video's file still exists with the size recorded at upload,
+// and marks the videos that don't. Problems clear once a later run finds
+// the file intact.
+func (cfg *apiConfig) verifyStoredVideos(ctx context.Context, lister storage.Lister) {
+	refs, err := cfg.db.GetVideoFileRefs()
+	if err != nil {
+		log.Printf("Storage verify: couldn't list video files: %v", err)
+		return
+	}
+	objects, err := listVideoObjects(ctx, lister, cfg.primaryKeyPrefixes())
+	if err != nil {
+		log.Printf("Storage verify: couldn't list objects: %v", err)
+		return
+	}
+
+	// Encrypted objects are larger than the videos they hold
+	_, encrypted := cfg.store.(storage.EncryptedStore)
+
+	broken := 0
+	for _, ref := range refs {
+		store, key := cfg.videoFile(database.Video{
+			VideoProvider: ref.VideoProvider,
+			VideoBucket:   ref.VideoBucket,
+			VideoKey:      ref.VideoKey,
+			QuarantineKey: ref.QuarantineKey,
+		})
+		// Only the primary bucket is listed, so files in tenant buckets
+		// aren't verified
+		if key != nil && !cfg.inPrimaryBucket(store) {
+			continue
+		}
+		obj, exists := storage.ObjectInfo{}, false
+		if key != nil {
+			obj, exists = objects[*key]
+		}
+		found := ""
+		switch {
+		case key == nil:
+			found = storageProblemUnknownKey
+		case !exists:
+			found = storageProblemMissing
+		case !encrypted && ref.VideoSize != nil && *ref.VideoSize != obj.Size:
+			found = storageProblemSize
+		}
+
+		var problem *string
+		if found != "" {
+			problem = &found
+			broken++
+		}
+		if err := cfg.db.SetVideoStorageCheck(ref.ID, problem); err != nil {
+			log.Printf("Storage verify: couldn't record check of video %s: %v", ref.ID, err)
+		}
+	}
+	if broken > 0 {
+		log.Printf("Storage verify: %d of %d video file(s) failed verification", broken, len(refs))
+	}
+}
+
+// handlerStorageIntegrity lists the videos whose files failed their last
+// verification.
+func (cfg *apiConfig) handlerStorageIntegrity(w http.ResponseWriter, r *http.Request) {
+	problems, err := cfg.db.GetVideoStorageProblems()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve storage problems", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, problems)
+}
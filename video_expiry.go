@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// videoExpiryBatch caps how many videos are reminded about or deleted per
+// run, so a large backlog is worked through over several runs.
+const videoExpiryBatch = 100
+
+// videoExpiryPolicy configures the job deleting expired videos.
+type videoExpiryPolicy struct {
+	// interval is how often expired videos are looked for
+	interval time.Duration
+	// reminder is how long before a video expires its owner is emailed,
+	// 0 to send no reminders
+	reminder time.Duration
+}
+
+// handlerVideoExpiryUpdate sets when a video is deleted, given either as
+// an expires_at time or a ttl from now such as "168h". Sending neither
+// keeps the video until it is deleted by hand.
+func (cfg *apiConfig) handlerVideoExpiryUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+		TTL       string     `json:"ttl"`
+	}
+
+	p := contextPrincipal(r)
+	video, ok := cfg.managedVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	expiresAt := params.ExpiresAt
+	if params.TTL != "" {
+		if expiresAt != nil {
+			respondWithError(w, http.StatusBadRequest, "send either expires_at or ttl, not both", nil)
+			return
+		}
+		ttl, err := time.ParseDuration(params.TTL)
+		if err != nil || ttl <= 0 {
+			respondWithError(w, http.StatusBadRequest, "ttl must be a positive duration such as 168h", err)
+			return
+		}
+		at := time.Now().Add(ttl)
+		expiresAt = &at
+	}
+	if expiresAt != nil {
+		if !expiresAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "expires_at must be in the future", nil)
+			return
+		}
+		at := expiresAt.UTC().Truncate(time.Second)
+		expiresAt = &at
+	}
+
+	video.ExpiresAt = expiresAt
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	expiry := "never"
+	if expiresAt != nil {
+		expiry = expiresAt.Format(time.RFC3339)
+	}
+	cfg.audit(r, p.UserID, auditVideoExpiryChanged, "video", video.ID.String(), map[string]string{"expires_at": expiry})
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// runVideoExpiry reminds owners of videos about to expire and deletes
+// expired ones every interval until ctx is done.
+func (cfg *apiConfig) runVideoExpiry(ctx context.Context) {
+	ticker := time.NewTicker(cfg.videoExpiry.interval)
+	defer ticker.Stop()
+	for {
+		if cfg.videoExpiry.reminder > 0 {
+			cfg.sendExpiryReminders()
+		}
+		cfg.deleteExpiredVideos(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) sendExpiryReminders() {
+	videos, err := cfg.db.GetVideosDueExpiryReminder(time.Now().Add(cfg.videoExpiry.reminder), videoExpiryBatch)
+	if err != nil {
+		log.Printf("Couldn't list videos due an expiry reminder: %v", err)
+		return
+	}
+	for _, video := range videos {
+		// Marked first, so a failing mailer can't send the same reminder
+		// every run
+		if err := cfg.db.SetVideoExpiryReminded(video.ID); err != nil {
+			log.Printf("Couldn't record expiry reminder for video %s: %v", video.ID, err)
+			continue
+		}
+		cfg.notifyVideoOwner(video, "Your video will be deleted soon",
+			fmt.Sprintf("Your video %q is set to expire and will be deleted at %s.\nChange or remove its expiry to keep it.\n",
+				video.Title, video.ExpiresAt.UTC().Format(time.RFC1123)), "")
+	}
+}
+
+// deleteExpiredVideos soft-deletes videos past their expiry and removes
+// their files from storage.
+func (cfg *apiConfig) deleteExpiredVideos(ctx context.Context) {
+	videos, err := cfg.db.GetExpiredVideos(time.Now(), videoExpiryBatch)
+	if err != nil {
+		log.Printf("Couldn't list expired videos: %v", err)
+		return
+	}
+	for _, video := range videos {
+		if err := cfg.db.SoftDeleteVideo(video.ID); err != nil {
+			log.Printf("Couldn't delete expired video %s: %v", video.ID, err)
+			continue
+		}
+		cfg.removeVideoMedia(ctx, video)
+		event := database.AuditEvent{
+			Action:     auditVideoExpired,
+			TargetType: "video",
+			TargetID:   video.ID.String(),
+			Details:    map[string]string{"title": video.Title},
+		}
+		if err := cfg.db.CreateAuditEvent(event); err != nil {
+			log.Printf("Couldn't record audit event %s: %v", auditVideoExpired, err)
+		}
+	}
+	if len(videos) > 0 {
+		log.Printf("Deleted %d expired videos", len(videos))
+	}
+}
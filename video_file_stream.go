@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// serveVideoFile streams video's file to the client, answering Range and
+// conditional requests so interrupted downloads can resume, and records
+// the bytes sent as app egress of the video. Range requests need the
+// file's size, which videos recorded before sizes were don't have, and a
+// store that can read ranges: encrypted ones would have to read up to
+// every range's start. Other files are sent whole with Accept-Ranges:
+// none. The caller checks video has a playable file and sets Content-Type
+// and any Content-Disposition.
+func (cfg *apiConfig) serveVideoFile(w http.ResponseWriter, r *http.Request, video database.Video) {
+	store, key := cfg.videoFile(video)
+	counter := &countingWriter{ResponseWriter: w}
+	defer func() {
+		if counter.n > 0 {
+			cfg.recordVideoEgress(video, egressApp, counter.n)
+		}
+	}()
+
+	rr, ranged := store.(storage.RangeReader)
+	if video.VideoSize == nil || !ranged {
+		obj, err := store.GetObject(r.Context(), *key)
+		if !cfg.storedFileOpened(w, err) {
+			return
+		}
+		defer obj.Close()
+		w.Header().Set("Accept-Ranges", "none")
+		if _, err := io.Copy(counter, obj); err != nil {
+			log.Printf("Couldn't stream %s: %v", *key, err)
+		}
+		return
+	}
+
+	obj := &storeObject{ctx: r.Context(), store: rr, key: *key, size: *video.VideoSize}
+	defer obj.Close()
+	// Opening up front turns a missing file into a 404 rather than a
+	// truncated response. Range requests may start anywhere, so they
+	// open lazily, with the status held back until the first byte is
+	// read to the same end
+	if r.Header.Get("Range") == "" {
+		if !cfg.storedFileOpened(w, obj.open()) {
+			return
+		}
+	} else {
+		counter.holdStatus = true
+	}
+	if video.VideoSHA256 != nil {
+		w.Header().Set("ETag", `"`+*video.VideoSHA256+`"`)
+	}
+	modTime := time.Time{}
+	if video.StoredAt != nil {
+		modTime = *video.StoredAt
+	}
+	http.ServeContent(counter, r, "", modTime, obj)
+	if counter.holdStatus && obj.err != nil {
+		for _, h := range []string{"Accept-Ranges", "Content-Length", "Content-Range", "ETag", "Last-Modified"} {
+			w.Header().Del(h)
+		}
+		cfg.storedFileOpened(w, obj.err)
+		return
+	}
+	counter.sendStatus()
+	if obj.err != nil && !errors.Is(obj.err, context.Canceled) {
+		log.Printf("Couldn't stream %s: %v", *key, obj.err)
+	}
+}
+
+// storedFileOpened writes the response for a file that couldn't be
+// opened, returning false, or returns true if err is nil.
+func (cfg *apiConfig) storedFileOpened(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, storage.ErrNotFound):
+		respondWithError(w, http.StatusNotFound, "Video has no playable file", err)
+	case errors.Is(err, storage.ErrUnavailable):
+		respondStorageUnavailable(w, cfg.storageBreaker, err)
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open video", err)
+	}
+	return false
+}
+
+// storeObject reads an object of known size as an io.ReadSeeker, so
+// http.ServeContent can answer Range requests from it. Reading somewhere
+// other than where the open object is reopens it from there with a range
+// request.
+type storeObject struct {
+	ctx    context.Context
+	store  storage.RangeReader
+	key    string
+	size   int64
+	offset int64
+	// body reads the object from bodyAt on
+	body   io.ReadCloser
+	bodyAt int64
+	// err is the last error opening or reading the object, which
+	// http.ServeContent doesn't report
+	err error
+}
+
+func (o *storeObject) open() error {
+	if o.body != nil && o.bodyAt == o.offset {
+		return nil
+	}
+	o.Close()
+	body, err := o.store.GetObjectRange(o.ctx, o.key, o.offset, o.size-o.offset)
+	if err != nil {
+		o.err = err
+		return err
+	}
+	o.body, o.bodyAt = body, o.offset
+	return nil
+}
+
+func (o *storeObject) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if err := o.open(); err != nil {
+		return 0, err
+	}
+	if remaining := o.size - o.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	o.bodyAt += int64(n)
+	if err != nil && err != io.EOF {
+		o.err = err
+	}
+	return n, err
+}
+
+func (o *storeObject) Seek(offset int64, whence int) (int64, error) {
+	pos := offset
+	switch whence {
+	case io.SeekCurrent:
+		pos += o.offset
+	case io.SeekEnd:
+		pos += o.size
+	}
+	if pos < 0 {
+		return 0, errors.New("seek before start of object")
+	}
+	o.offset = pos
+	return pos, nil
+}
+
+func (o *storeObject) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+// countingWriter counts the bytes of successful response bodies written
+// through it, so error messages aren't taken for video. With holdStatus
+// set, the status is only sent with the first write or by sendStatus.
+type countingWriter struct {
+	http.ResponseWriter
+	n          int64
+	holdStatus bool
+	status     int
+	failed     bool
+}
+
+func (c *countingWriter) WriteHeader(code int) {
+	c.failed = code >= http.StatusMultipleChoices
+	if c.holdStatus {
+		c.status = code
+		return
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+// sendStatus sends any held status and stops holding it.
+func (c *countingWriter) sendStatus() {
+	c.holdStatus = false
+	if c.status != 0 {
+		c.ResponseWriter.WriteHeader(c.status)
+		c.status = 0
+	}
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.sendStatus()
+	n, err := c.ResponseWriter.Write(p)
+	if !c.failed {
+		c.n += int64(n)
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func TestServeVideoFile(t *testing.T) {
+	content := []byte("0123456789abcdef")
+	size := int64(len(content))
+	sha := "deadbeef"
+
+	tests := []struct {
+		name        string
+		encrypted   bool
+		unknownSize bool
+		missing     bool
+		header      http.Header
+		wantStatus  int
+		wantBody    []byte
+		wantRanges  string
+		wantRange   string
+		wantEgress  int64
+	}{
+		{name: "whole file", wantStatus: http.StatusOK, wantBody: content, wantRanges: "bytes", wantEgress: size},
+		{
+			name:       "range",
+			header:     http.Header{"Range": {"bytes=4-7"}},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   content[4:8],
+			wantRanges: "bytes",
+			wantRange:  "bytes 4-7/16",
+			wantEgress: 4,
+		},
+		{
+			name:       "open-ended range",
+			header:     http.Header{"Range": {"bytes=12-"}},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   content[12:],
+			wantRanges: "bytes",
+			wantRange:  "bytes 12-15/16",
+			wantEgress: 4,
+		},
+		{
+			name:       "unsatisfiable range",
+			header:     http.Header{"Range": {"bytes=100-200"}},
+			wantStatus: http.StatusRequestedRangeNotSatisfiable,
+			wantRange:  "bytes */16",
+		},
+		{
+			name:       "matching etag",
+			header:     http.Header{"If-None-Match": {`"` + sha + `"`}},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:        "unknown size is sent whole",
+			unknownSize: true,
+			header:      http.Header{"Range": {"bytes=4-7"}},
+			wantStatus:  http.StatusOK,
+			wantBody:    content,
+			wantRanges:  "none",
+			wantEgress:  size,
+		},
+		{
+			name:       "encrypted store is sent whole",
+			encrypted:  true,
+			header:     http.Header{"Range": {"bytes=4-7"}},
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+			wantRanges: "none",
+			wantEgress: size,
+		},
+		{name: "missing file", missing: true, wantStatus: http.StatusNotFound},
+		{name: "missing file with range", missing: true, header: http.Header{"Range": {"bytes=4-7"}}, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			if tt.encrypted {
+				cfg.store = storage.EncryptedStore{
+					Store: cfg.store,
+					Keys:  storage.StaticKeyProvider{MasterKey: bytes.Repeat([]byte{1}, 32)},
+				}
+			}
+			video := newTestVideo(t, cfg, newTestUser(t, cfg).ID)
+			key, provider := "videos/test.mp4", "local"
+			video.VideoKey = &key
+			video.VideoProvider = &provider
+			video.VideoSHA256 = &sha
+			if !tt.unknownSize {
+				video.VideoSize = &size
+			}
+			if !tt.missing {
+				err := cfg.store.PutObject(context.Background(), key, bytes.NewReader(content), storage.PutOptions{})
+				if err != nil {
+					t.Fatalf("PutObject() error = %v", err)
+				}
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for name, values := range tt.header {
+				r.Header[name] = values
+			}
+			w := httptest.NewRecorder()
+			cfg.serveVideoFile(w, r, video)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantBody != nil && !bytes.Equal(w.Body.Bytes(), tt.wantBody) {
+				t.Errorf("body = %q, want %q", w.Body, tt.wantBody)
+			}
+			if got := w.Header().Get("Accept-Ranges"); got != tt.wantRanges {
+				t.Errorf("Accept-Ranges = %q, want %q", got, tt.wantRanges)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.wantRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantRange)
+			}
+
+			usage, err := cfg.db.GetEgressUsage(time.Now(), time.Now())
+			if err != nil {
+				t.Fatalf("GetEgressUsage() error = %v", err)
+			}
+			var egress int64
+			for _, u := range usage {
+				egress += u.Bytes
+			}
+			if egress != tt.wantEgress {
+				t.Errorf("recorded egress = %d, want %d", egress, tt.wantEgress)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// videoTrashBatch caps how many trashed videos are purged per run.
+const videoTrashBatch = 100
+
+// videoTrashPolicy configures the job purging videos from the trash.
+type videoTrashPolicy struct {
+	// retention is how long a video stays in the trash before it is
+	// deleted for good
+	retention time.Duration
+	// interval is how often the trash is looked through
+	interval time.Duration
+}
+
+// trashedVideo is a video in the trash, along with when it will be
+// purged.
+type trashedVideo struct {
+	database.Video
+	TrashedAt time.Time `json:"trashed_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+// handlerTrashList lists the caller's videos in the trash, most recently
+// deleted first.
+func (cfg *apiConfig) handlerTrashList(w http.ResponseWriter, r *http.Request) {
+	videos, err := cfg.db.GetTrashedVideos(contextPrincipal(r).UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve trash", err)
+		return
+	}
+	trash := make([]trashedVideo, 0, len(videos))
+	for _, video := range videos {
+		trash = append(trash, cfg.trashedVideo(video))
+	}
+	respondWithJSON(w, http.StatusOK, trash)
+}
+
+// handlerTrashRestore takes a video out of the trash, back to where it
+// was before it was deleted.
+func (cfg *apiConfig) handlerTrashRestore(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.managedTrashedVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.RestoreTrashedVideo(video.ID)
+	if errors.Is(err, database.ErrInvalidVideoTransition) {
+		respondWithError(w, http.StatusNotFound, "Video not found in the trash", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditVideoUntrashed, "video", video.ID.String(), nil)
+
+	restored, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, restored)
+}
+
+// handlerTrashDelete deletes a video in the trash for good, along with
+// its files, without waiting for it to be purged.
+func (cfg *apiConfig) handlerTrashDelete(w http.ResponseWriter, r *http.Request) {
+	p := contextPrincipal(r)
+	video, ok := cfg.managedTrashedVideo(w, r, p)
+	if !ok {
+		return
+	}
+
+	err := cfg.purgeVideo(r.Context(), video)
+	if errors.Is(err, database.ErrInvalidVideoTransition) {
+		respondWithError(w, http.StatusNotFound, "Video not found in the trash", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		return
+	}
+	cfg.audit(r, p.UserID, auditVideoDeleted, "video", video.ID.String(), map[string]string{"title": video.Title})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// managedTrashedVideo is managedVideo for videos in the trash.
+func (cfg *apiConfig) managedTrashedVideo(w http.ResponseWriter, r *http.Request, p principal) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetTrashedVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found in the trash", nil)
+		return database.Video{}, false
+	}
+	if !cfg.canManageVideo(p, video) {
+		respondWithError(w, http.StatusForbidden, "You can't manage this video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+func (cfg *apiConfig) trashedVideo(video database.Video) trashedVideo {
+	trashed := trashedVideo{Video: video}
+	if video.DeletedAt != nil {
+		trashed.TrashedAt = *video.DeletedAt
+		trashed.PurgeAt = video.DeletedAt.Add(cfg.videoTrash.retention)
+	}
+	return trashed
+}
+
+// runVideoTrashPurge deletes videos that have been in the trash longer
+// than the retention every interval until ctx is done.
+func (cfg *apiConfig) runVideoTrashPurge(ctx context.Context) {
+	ticker := time.NewTicker(cfg.videoTrash.interval)
+	defer ticker.Stop()
+	for {
+		cfg.purgeTrashedVideos(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) purgeTrashedVideos(ctx context.Context) {
+	videos, err := cfg.db.GetVideosTrashedBefore(time.Now().Add(-cfg.videoTrash.retention), videoTrashBatch)
+	if err != nil {
+		log.Printf("Couldn't list videos due to be purged from the trash: %v", err)
+		return
+	}
+	purged := 0
+	for _, video := range videos {
+		// A video restored since it was listed is skipped
+		err := cfg.purgeVideo(ctx, video)
+		if errors.Is(err, database.ErrInvalidVideoTransition) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Couldn't purge video %s: %v", video.ID, err)
+			continue
+		}
+		purged++
+		event := database.AuditEvent{
+			Action:     auditVideoPurged,
+			TargetType: "video",
+			TargetID:   video.ID.String(),
+			Details:    map[string]string{"title": video.Title},
+		}
+		if err := cfg.db.CreateAuditEvent(event); err != nil {
+			log.Printf("Couldn't record audit event %s: %v", auditVideoPurged, err)
+		}
+	}
+	if purged > 0 {
+		log.Printf("Purged %d videos from the trash", purged)
+	}
+}
+
+// purgeVideo deletes a video in the trash and removes its files, previous
+// versions and thumbnail from storage. It returns
+// database.ErrInvalidVideoTransition if the video has left the trash.
+func (cfg *apiConfig) purgeVideo(ctx context.Context, video database.Video) error {
+	if err := cfg.db.PurgeTrashedVideo(video.ID); err != nil {
+		return err
+	}
+	cfg.removeVideoMedia(ctx, video)
+	return nil
+}
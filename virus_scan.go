@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/antivirus"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// What happens to an upload the virus scanner finds malware in, chosen
+// with VIRUS_SCAN_ACTION.
+const (
+	// virusScanReject refuses the upload and keeps nothing
+	virusScanReject = "reject"
+	// virusScanQuarantine stores the file as uploaded under quarantine/
+	// for review, without processing it
+	virusScanQuarantine = "quarantine"
+)
+
+// handleInfectedUpload deals with an upload the scanner found malware in,
+// rejecting or quarantining it as configured. The file is never given to
+// ffmpeg.
+func (cfg *apiConfig) handleInfectedUpload(w http.ResponseWriter, r *http.Request, actor uuid.UUID, video database.Video, upload *os.File, scan antivirus.Result) bool {
+	cfg.audit(r, actor, auditVideoInfected, "video", video.ID.String(), map[string]string{
+		"signature": scan.Signature,
+		"action":    cfg.virusScanAction,
+	})
+	if cfg.virusScanAction != virusScanQuarantine {
+		respondWithError(w, http.StatusUnprocessableEntity, "video failed virus scan", nil)
+		return false
+	}
+
+	if _, err := upload.Seek(0, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "unable to read video file", err)
+		return false
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, upload)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "unable to read video file", err)
+		return false
+	}
+	if _, err := upload.Seek(0, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "unable to read video file", err)
+		return false
+	}
+	digest := hasher.Sum(nil)
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error randomizing key", err)
+		return false
+	}
+	location := cfg.uploadLocation(video.OrgID)
+	fileKey := location.prefix + "quarantine/infected/" + base64.RawURLEncoding.EncodeToString(key)
+	// Served as an opaque download so a reviewer's browser won't try to
+	// play it
+	err = location.store.PutObject(r.Context(), fileKey, upload, storage.PutOptions{
+		ContentType:  "application/octet-stream",
+		CacheControl: "no-store",
+		SHA256:       digest,
+	})
+	if errors.Is(err, storage.ErrUnavailable) {
+		respondStorageUnavailable(w, cfg.storageBreaker, err)
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "unable to write file to storage", err)
+		return false
+	}
+
+	previous := video
+	previousStore, previousKey := cfg.videoFile(video)
+	verdict, reason := string(moderation.VerdictQuarantined), "malware: "+scan.Signature
+	video.ModerationStatus = &verdict
+	video.ModerationScore = nil
+	video.ModerationReason = &reason
+	scanVerdict := string(scan.Verdict)
+	video.ScanVerdict = &scanVerdict
+	video.ScanSignature = &scan.Signature
+	provider, bucket := storeLocation(location.store)
+	video.VideoProvider = &provider
+	video.VideoBucket = bucket
+	video.VideoURL, video.VideoKey = nil, nil
+	video.QuarantineKey = &fileKey
+	video.AspectClass = nil
+	video.DurationSeconds = nil
+	video.MediaInfo = database.MediaInfo{}
+	video.VideoSize = &size
+	hexDigest := hex.EncodeToString(digest)
+	video.VideoSHA256 = &hexDigest
+	video.StorageClass = nil
+	storedAt := time.Now().UTC()
+	video.StoredAt = &storedAt
+	video.RestoreRequestedAt = nil
+	video.RestoreTier = nil
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return false
+	}
+	// The upload is kept for review, but the video has no file to play
+	if err := cfg.setVideoStatus(&video, database.VideoStatusFailed); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return false
+	}
+	if previousKey != nil {
+		cfg.retireVideoFile(r.Context(), previous, previousStore, *previousKey)
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+	return true
+}